@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -17,6 +18,24 @@ type Config struct {
 	InfluxDBOrg             string
 	InfluxDBBucket          string
 	AllowedOrigins          string
+
+	// WebhookAllowPrivate allows webhooks to loopback and private
+	// addresses, for testing against a local receiver
+	WebhookAllowPrivate bool
+
+	// Strava API application; uploads are disabled without a client ID.
+	// StravaBaseURL can point at a local stand-in for testing.
+	StravaClientID     string
+	StravaClientSecret string
+	StravaRedirectURL  string
+	StravaBaseURL      string
+
+	// Concept2 Logbook API application; syncing is disabled without a
+	// client ID. Concept2BaseURL can point at a local fake for testing.
+	Concept2ClientID     string
+	Concept2ClientSecret string
+	Concept2RedirectURL  string
+	Concept2BaseURL      string
 }
 
 // Load reads configuration from environment variables
@@ -35,11 +54,36 @@ func Load() *Config {
 		InfluxDBOrg:             getEnv("INFLUXDB_ORG", ""),
 		InfluxDBBucket:          getEnv("INFLUXDB_BUCKET", "ergometer-workouts"),
 		AllowedOrigins:          getEnv("ALLOWED_ORIGINS", "http://localhost:5173"),
+		WebhookAllowPrivate:     getEnvBool("WEBHOOK_ALLOW_PRIVATE", false),
+		StravaClientID:          getEnv("STRAVA_CLIENT_ID", ""),
+		StravaClientSecret:      getEnv("STRAVA_CLIENT_SECRET", ""),
+		StravaRedirectURL:       getEnv("STRAVA_REDIRECT_URL", "http://localhost:5173/settings/strava"),
+		StravaBaseURL:           getEnv("STRAVA_BASE_URL", "https://www.strava.com"),
+		Concept2ClientID:        getEnv("CONCEPT2_CLIENT_ID", ""),
+		Concept2ClientSecret:    getEnv("CONCEPT2_CLIENT_SECRET", ""),
+		Concept2RedirectURL:     getEnv("CONCEPT2_REDIRECT_URL", "http://localhost:5173/settings/concept2"),
+		Concept2BaseURL:         getEnv("CONCEPT2_BASE_URL", "https://log.concept2.com"),
 	}
 
 	return config
 }
 
+// getEnvBool reads a boolean environment variable, logging and using the
+// default if it can't be parsed
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid %s %q, using %t", key, value, defaultValue)
+		return defaultValue
+	}
+	return b
+}
+
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+)
+
+const (
+	// defaultLoadDays is the range served when no from is given
+	defaultLoadDays = 90
+
+	// loadWarmupDays of earlier workouts are read so the rolling values
+	// have settled by the first day served
+	loadWarmupDays = 120
+
+	// maxLoadDays bounds the range of one request
+	maxLoadDays = 3 * 366
+
+	// maxCurveBackfill bounds how many workouts saved without a curve have
+	// one built from their stored samples per request
+	maxCurveBackfill = 20
+)
+
+// AnalyticsHandler serves training analytics
+type AnalyticsHandler struct {
+	workouts *services.WorkoutService
+	samples  *services.InfluxDBService // nil when InfluxDB isn't configured
+}
+
+// NewAnalyticsHandler creates a new analytics handler. samples may be nil.
+func NewAnalyticsHandler(workouts *services.WorkoutService, samples *services.InfluxDBService) *AnalyticsHandler {
+	return &AnalyticsHandler{workouts: workouts, samples: samples}
+}
+
+// loadResponse is the daily training load over a range
+type loadResponse struct {
+	Metric string           `json:"metric"`
+	From   string           `json:"from"`
+	To     string           `json:"to"`
+	Days   []models.LoadDay `json:"days"`
+}
+
+// Load handles GET /api/v1/analytics/load
+func (h *AnalyticsHandler) Load(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = models.LoadTSS
+	}
+	if err := models.ValidLoadMetric(metric); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := dateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to.IsZero() {
+		to = time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -defaultLoadDays)
+	}
+	if to.Sub(from) > maxLoadDays*24*time.Hour {
+		http.Error(w, "Range is limited to 3 years", http.StatusBadRequest)
+		return
+	}
+
+	workouts, err := h.workouts.List(r.Context(), uid, from.AddDate(0, 0, -loadWarmupDays), to, 0)
+	if err != nil {
+		log.Printf("Failed to list workouts for %s: %v", uid, err)
+		http.Error(w, "Failed to load workouts", http.StatusInternalServerError)
+		return
+	}
+
+	days := models.TrainingLoad(workouts, metric, from, to)
+	writeJSON(w, http.StatusOK, loadResponse{
+		Metric: metric,
+		From:   from.UTC().Format(time.DateOnly),
+		To:     to.UTC().Format(time.DateOnly),
+		Days:   days,
+	})
+}
+
+// curveResponse is a power and pace duration curve with the critical power
+// fitted to it
+type curveResponse struct {
+	WorkoutID     string                `json:"workout_id,omitempty"`
+	From          string                `json:"from,omitempty"`
+	To            string                `json:"to,omitempty"`
+	Workouts      int                   `json:"workouts"`
+	Curve         []models.CurvePoint   `json:"curve"`
+	CriticalPower *models.CriticalPower `json:"critical_power"`
+}
+
+// Curve handles GET /api/v1/analytics/curve, for one workout when
+// workout_id is given or otherwise every workout in the date range
+func (h *AnalyticsHandler) Curve(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	var response curveResponse
+	var workouts []*models.Workout
+
+	if id := r.URL.Query().Get("workout_id"); id != "" {
+		workout, err := h.workouts.Get(r.Context(), uid, id)
+		if errors.Is(err, services.ErrNotFound) {
+			http.Error(w, "Workout not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get workout for %s: %v", uid, err)
+			http.Error(w, "Failed to get workout", http.StatusInternalServerError)
+			return
+		}
+		response.WorkoutID = id
+		workouts = []*models.Workout{workout}
+	} else {
+		from, to, err := dateRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		workouts, err = h.workouts.List(r.Context(), uid, from, to, 0)
+		if err != nil {
+			log.Printf("Failed to list workouts for %s: %v", uid, err)
+			http.Error(w, "Failed to load workouts", http.StatusInternalServerError)
+			return
+		}
+		if !from.IsZero() {
+			response.From = from.UTC().Format(time.RFC3339)
+		}
+		if !to.IsZero() {
+			response.To = to.UTC().Format(time.RFC3339)
+		}
+	}
+
+	h.backfillCurves(r, uid, workouts)
+
+	response.Workouts = len(workouts)
+	response.Curve = models.BestCurve(workouts)
+	response.CriticalPower = models.EstimateCriticalPower(response.Curve)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// backfillCurves builds curves from stored samples for workouts saved
+// before curves were computed, keeping them for next time
+func (h *AnalyticsHandler) backfillCurves(r *http.Request, uid string, workouts []*models.Workout) {
+	if h.samples == nil {
+		return
+	}
+
+	built := 0
+	for _, workout := range workouts {
+		if workout.Curve != nil {
+			continue
+		}
+		if built == maxCurveBackfill {
+			return
+		}
+		built++
+
+		samples, err := h.samples.ReadSamples(r.Context(), uid, workout)
+		if err != nil {
+			log.Printf("Failed to read samples for workout %s: %v", workout.ID, err)
+			continue
+		}
+
+		workout.Curve = models.BuildCurve(samples)
+		if err := h.workouts.SetCurve(r.Context(), uid, workout.ID, workout.Curve); err != nil {
+			log.Printf("Failed to save curve for workout %s: %v", workout.ID, err)
+		}
+	}
+}
@@ -0,0 +1,472 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+	"github.com/gorilla/mux"
+)
+
+// liveKeepAlive is how often the live leaderboard stream sends a comment
+// so idle connections aren't closed by proxies
+const liveKeepAlive = 30 * time.Second
+
+// ChallengesHandler serves challenges between teams and their leaderboards
+type ChallengesHandler struct {
+	challenges *services.ChallengeService
+	teams      *services.TeamService
+	profiles   *services.ProfileService
+	workouts   *services.WorkoutService
+	dispatcher *services.WebhookDispatcher
+
+	// Closed when the server shuts down to end live leaderboards
+	shutdown <-chan struct{}
+}
+
+// NewChallengesHandler creates a new challenges handler. Live leaderboards
+// end when shutdown is closed.
+func NewChallengesHandler(challenges *services.ChallengeService, teams *services.TeamService, profiles *services.ProfileService, workouts *services.WorkoutService, dispatcher *services.WebhookDispatcher, shutdown <-chan struct{}) *ChallengesHandler {
+	return &ChallengesHandler{
+		challenges: challenges,
+		teams:      teams,
+		profiles:   profiles,
+		workouts:   workouts,
+		dispatcher: dispatcher,
+		shutdown:   shutdown,
+	}
+}
+
+// challengeResponse is a challenge with its status
+type challengeResponse struct {
+	*models.Challenge
+	Status string `json:"status"` // upcoming, active or finished
+}
+
+// enteredChallenge is a challenge a saved workout was entered in
+type enteredChallenge struct {
+	ChallengeID string        `json:"challenge_id"`
+	Name        string        `json:"name"`
+	Entry       *models.Entry `json:"entry"`
+}
+
+// List handles GET /api/v1/challenges?status=active, listing the
+// challenges the user's teams take part in
+func (h *ChallengesHandler) List(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", "upcoming", "active", "finished":
+	default:
+		http.Error(w, "status must be upcoming, active or finished", http.StatusBadRequest)
+		return
+	}
+
+	teamIDs, err := memberTeamIDs(r.Context(), h.teams, uid)
+	if !challengeFound(w, uid, err) {
+		return
+	}
+
+	challenges, err := h.challenges.List(r.Context(), teamIDs)
+	if !challengeFound(w, uid, err) {
+		return
+	}
+
+	now := time.Now()
+	responses := []challengeResponse{}
+	for _, challenge := range challenges {
+		response := challengeResponse{Challenge: challenge, Status: challenge.Status(now)}
+		if status == "" || response.Status == status {
+			responses = append(responses, response)
+		}
+	}
+	writeJSON(w, http.StatusOK, responses)
+}
+
+// Get handles GET /api/v1/challenges/{id}
+func (h *ChallengesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	challenge, ok := h.challenge(w, r, uid)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, challengeResponse{Challenge: challenge, Status: challenge.Status(time.Now())})
+}
+
+// Create handles POST /api/v1/challenges. The user must coach or own
+// every team taking part, the first listed being the host, as their
+// members' workouts are entered and ranked. Workouts already saved within
+// the challenge's dates are entered straight away.
+func (h *ChallengesHandler) Create(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	var challenge models.Challenge
+	if err := readJSON(w, r, &challenge, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := challenge.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	challenge.Teams = make([]models.ChallengeTeam, len(challenge.TeamIDs))
+	for i, id := range challenge.TeamIDs {
+		role, err := h.teams.Role(r.Context(), id, uid)
+		if errors.Is(err, services.ErrNotFound) || (err == nil && role == models.RoleAthlete) {
+			http.Error(w, "Only owners and coaches of every team taking part can create challenges", http.StatusForbidden)
+			return
+		}
+		if !challengeFound(w, uid, err) {
+			return
+		}
+
+		team, err := h.teams.Get(r.Context(), id)
+		if errors.Is(err, services.ErrNotFound) {
+			http.Error(w, "Team "+id+" not found", http.StatusBadRequest)
+			return
+		}
+		if !challengeFound(w, uid, err) {
+			return
+		}
+		challenge.Teams[i] = models.ChallengeTeam{ID: team.ID, Name: team.Name}
+	}
+
+	if err := h.challenges.Create(r.Context(), uid, &challenge); err != nil {
+		log.Printf("Failed to create challenge for %s: %v", uid, err)
+		http.Error(w, "Failed to create challenge", http.StatusInternalServerError)
+		return
+	}
+
+	if challenge.Start.Before(time.Now()) {
+		h.backfill(r.Context(), &challenge)
+	}
+
+	response := challengeResponse{Challenge: &challenge, Status: challenge.Status(time.Now())}
+	writeJSON(w, http.StatusCreated, response)
+
+	go h.dispatcher.Publish(context.WithoutCancel(r.Context()), models.WebhookChallengeCreated, "", challenge.TeamIDs, response)
+}
+
+// Delete handles DELETE /api/v1/challenges/{id}. The challenge's creator
+// or the host team's owner may delete it.
+func (h *ChallengesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	challenge, ok := h.challenge(w, r, uid)
+	if !ok {
+		return
+	}
+
+	if challenge.CreatedBy != uid {
+		role, err := h.teams.Role(r.Context(), challenge.TeamIDs[0], uid)
+		if err != nil || role != models.RoleOwner {
+			http.Error(w, "Only the challenge's creator or the host team's owner can delete it", http.StatusForbidden)
+			return
+		}
+	}
+
+	err := h.challenges.Delete(r.Context(), challenge.ID)
+	if !challengeFound(w, uid, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Leaderboard handles GET /api/v1/challenges/{id}/leaderboard
+func (h *ChallengesHandler) Leaderboard(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	challenge, ok := h.challenge(w, r, uid)
+	if !ok {
+		return
+	}
+	filter, err := leaderboardFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.challenges.Entries(r.Context(), challenge.ID)
+	if !challengeFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, challenge.Leaderboard(entries, filter))
+}
+
+// Live handles GET /api/v1/challenges/{id}/leaderboard/live, streaming the
+// leaderboard as server-sent events each time an entry changes
+func (h *ChallengesHandler) Live(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	challenge, ok := h.challenge(w, r, uid)
+	if !ok {
+		return
+	}
+	filter, err := leaderboardFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline for live leaderboard: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	boards := make(chan *models.Leaderboard, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- h.challenges.Watch(ctx, challenge.ID, func(entries []*models.Entry) error {
+			select {
+			case boards <- challenge.Leaderboard(entries, filter):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	ticker := time.NewTicker(liveKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case board := <-boards:
+			data, err := json.Marshal(board)
+			if err != nil {
+				log.Printf("Failed to marshal leaderboard: %v", err)
+				return
+			}
+			fmt.Fprintf(w, "event: leaderboard\ndata: %s\n\n", data)
+
+		case <-ticker.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+
+		case err := <-done:
+			if ctx.Err() == nil {
+				log.Printf("Live leaderboard for %s ended: %v", challenge.ID, err)
+			}
+			return
+
+		case <-ctx.Done():
+			return
+
+		case <-h.shutdown:
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// challenge fetches the challenge named in the request path, writing a
+// not found response unless one of the user's teams takes part
+func (h *ChallengesHandler) challenge(w http.ResponseWriter, r *http.Request, uid string) (*models.Challenge, bool) {
+	challenge, err := h.challenges.Get(r.Context(), mux.Vars(r)["id"])
+	if !challengeFound(w, uid, err) {
+		return nil, false
+	}
+
+	teamIDs, err := memberTeamIDs(r.Context(), h.teams, uid)
+	if !challengeFound(w, uid, err) {
+		return nil, false
+	}
+	if challenge.TeamFor(teamIDs) == "" {
+		challengeFound(w, uid, services.ErrNotFound)
+		return nil, false
+	}
+	return challenge, true
+}
+
+// backfill enters the workouts members of a new challenge's teams have
+// already saved within its dates. Failures are logged; those workouts
+// just miss out.
+func (h *ChallengesHandler) backfill(ctx context.Context, challenge *models.Challenge) {
+	entered := make(map[string]bool)
+	for _, teamID := range challenge.TeamIDs {
+		members, err := h.teams.Members(ctx, teamID)
+		if err != nil {
+			log.Printf("Failed to list members of %s for challenge %s: %v", teamID, challenge.ID, err)
+			continue
+		}
+
+		for _, member := range members {
+			// Members of several teams enter for the first
+			if entered[member.UserID] {
+				continue
+			}
+			entered[member.UserID] = true
+
+			workouts, err := h.workouts.List(ctx, member.UserID, challenge.Start, challenge.End, 0)
+			if err != nil {
+				log.Printf("Failed to list workouts of %s for challenge %s: %v", member.UserID, challenge.ID, err)
+				continue
+			}
+			if len(workouts) == 0 {
+				continue
+			}
+
+			profile, err := h.profiles.Get(ctx, member.UserID)
+			if err != nil && !errors.Is(err, services.ErrNotFound) {
+				log.Printf("Failed to get profile of %s for challenge %s: %v", member.UserID, challenge.ID, err)
+			}
+			entrant := newEntrant(member.UserID, teamID, profile)
+			if entrant.DisplayName == "" {
+				entrant.DisplayName = member.DisplayName
+			}
+
+			if _, err := h.challenges.Enter(ctx, challenge, entrant, workouts...); err != nil {
+				log.Printf("Failed to enter %s in challenge %s: %v", member.UserID, challenge.ID, err)
+			}
+		}
+	}
+}
+
+// enterChallenges enters a newly saved workout in the challenges teamIDs
+// take part in that cover it. Failures are logged; the workout is already
+// saved.
+func enterChallenges(ctx context.Context, challenges *services.ChallengeService, teamIDs []string, uid string, profile *models.Profile, workout *models.Workout) []enteredChallenge {
+	entered := []enteredChallenge{}
+	if len(teamIDs) == 0 {
+		return entered
+	}
+
+	candidates, err := challenges.List(ctx, teamIDs)
+	if err != nil {
+		log.Printf("Failed to list challenges for %s: %v", uid, err)
+		return entered
+	}
+
+	for _, challenge := range candidates {
+		if !challenge.Covers(workout.StartedAt) {
+			continue
+		}
+
+		entrant := newEntrant(uid, challenge.TeamFor(teamIDs), profile)
+		entry, err := challenges.Enter(ctx, challenge, entrant, workout)
+		if err != nil {
+			log.Printf("Failed to enter workout %s in challenge %s: %v", workout.ID, challenge.ID, err)
+			continue
+		}
+		if entry != nil {
+			entered = append(entered, enteredChallenge{ChallengeID: challenge.ID, Name: challenge.Name, Entry: entry})
+		}
+	}
+	return entered
+}
+
+// memberTeamIDs returns the IDs of the teams a user belongs to
+func memberTeamIDs(ctx context.Context, teams *services.TeamService, uid string) ([]string, error) {
+	userTeams, err := teams.List(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(userTeams))
+	for i, team := range userTeams {
+		ids[i] = team.ID
+	}
+	return ids, nil
+}
+
+// newEntrant describes a user entering a challenge for a team. profile
+// may be nil.
+func newEntrant(uid, teamID string, profile *models.Profile) services.Entrant {
+	entrant := services.Entrant{UserID: uid, TeamID: teamID}
+	if profile != nil {
+		entrant.DisplayName = profile.DisplayName
+		entrant.WeightClass = profile.Class()
+		entrant.BirthYear = profile.BirthYear
+	}
+	return entrant
+}
+
+// leaderboardFilter reads the weight_class, min_age, max_age, team_id and
+// adjusted query parameters
+func leaderboardFilter(r *http.Request) (models.LeaderboardFilter, error) {
+	query := r.URL.Query()
+	filter := models.LeaderboardFilter{
+		WeightClass: query.Get("weight_class"),
+		TeamID:      query.Get("team_id"),
+	}
+
+	switch filter.WeightClass {
+	case "", "heavyweight", "lightweight":
+	default:
+		return filter, errors.New("weight_class must be heavyweight or lightweight")
+	}
+
+	var err error
+	if filter.MinAge, err = intParam(r, "min_age", 0); err != nil {
+		return filter, err
+	}
+	if filter.MaxAge, err = intParam(r, "max_age", 0); err != nil {
+		return filter, err
+	}
+	if value := query.Get("adjusted"); value != "" {
+		if filter.Adjusted, err = strconv.ParseBool(value); err != nil {
+			return filter, errors.New("adjusted must be true or false")
+		}
+	}
+	return filter, nil
+}
+
+// challengeFound writes an error response for err, reporting whether
+// there was none
+func challengeFound(w http.ResponseWriter, uid string, err error) bool {
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Challenge not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		log.Printf("Challenge request failed for %s: %v", uid, err)
+		http.Error(w, "Challenge request failed", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+)
+
+const (
+	// concept2Name names the Logbook in responses
+	concept2Name = "Concept2 Logbook"
+
+	// maxImportDays bounds the dates one import covers
+	maxImportDays = 366
+)
+
+// Concept2Handler links users' Concept2 Online Logbooks, pushing their
+// workouts and importing past results
+type Concept2Handler struct {
+	concept2     *services.Concept2Service
+	integrations *services.IntegrationService
+	workouts     *services.WorkoutService
+}
+
+// NewConcept2Handler creates a new Concept2 Logbook handler
+func NewConcept2Handler(concept2 *services.Concept2Service, integrations *services.IntegrationService, workouts *services.WorkoutService) *Concept2Handler {
+	return &Concept2Handler{
+		concept2:     concept2,
+		integrations: integrations,
+		workouts:     workouts,
+	}
+}
+
+// importRequest is the body of POST /api/v1/concept2/import. to defaults
+// to now.
+type importRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Get handles GET /api/v1/concept2, returning the user's connection and
+// their latest import
+func (h *Concept2Handler) Get(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := h.integrations.Connection(r.Context(), uid, models.ProviderConcept2)
+	if !connectionFound(w, uid, concept2Name, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, conn)
+}
+
+// Authorize handles POST /api/v1/concept2/authorize, returning the
+// Logbook page to send the user to
+func (h *Concept2Handler) Authorize(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	authorizeURL, err := h.concept2.AuthorizeURL(r.Context(), uid)
+	if err != nil {
+		log.Printf("Failed to start Concept2 authorization for %s: %v", uid, err)
+		http.Error(w, "Failed to start Concept2 Logbook authorization", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"url": authorizeURL})
+}
+
+// Connect handles POST /api/v1/concept2/connect, finishing the link with
+// what the Logbook redirected back with
+func (h *Concept2Handler) Connect(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	req, ok := readConnect(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := h.concept2.Connect(r.Context(), uid, req.State, req.Code)
+	if !connected(w, uid, concept2Name, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, conn)
+}
+
+// Update handles PUT /api/v1/concept2, changing the connection's settings
+func (h *Concept2Handler) Update(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	var req integrationSettingsRequest
+	if err := readJSON(w, r, &req, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := h.concept2.SetAutoUpload(r.Context(), uid, req.AutoUpload)
+	if !connectionFound(w, uid, concept2Name, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, conn)
+}
+
+// Delete handles DELETE /api/v1/concept2, unlinking the user's Logbook
+func (h *Concept2Handler) Delete(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.concept2.Disconnect(r.Context(), uid)
+	if !connectionFound(w, uid, concept2Name, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Import handles POST /api/v1/concept2/import, copying past Logbook
+// results into the user's workouts in the background
+func (h *Concept2Handler) Import(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	var req importRequest
+	if err := readJSON(w, r, &req, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, err := importRange(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	imp, err := h.concept2.Import(r.Context(), uid, from, to)
+	if errors.Is(err, services.ErrRunning) {
+		http.Error(w, "An import is already running", http.StatusConflict)
+		return
+	}
+	if !connectionFound(w, uid, concept2Name, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, imp)
+}
+
+// Upload handles POST /api/v1/workouts/{id}/concept2, pushing a saved
+// workout or retrying a failed push
+func (h *Concept2Handler) Upload(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	workout, ok := uploadable(w, r, h.integrations, h.workouts, uid, models.ProviderConcept2, concept2Name)
+	if !ok {
+		return
+	}
+
+	upload, err := h.concept2.Upload(r.Context(), uid, workout)
+	if !connectionFound(w, uid, concept2Name, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, upload)
+}
+
+// UploadStatus handles GET /api/v1/workouts/{id}/concept2
+func (h *Concept2Handler) UploadStatus(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	writeUpload(w, r, h.integrations, uid, models.ProviderConcept2, concept2Name)
+}
+
+// importRange parses and checks the dates of an import request
+func importRange(req importRequest) (from, to time.Time, err error) {
+	if req.From == "" {
+		return from, to, errors.New("from is required")
+	}
+	if from, err = parseTime(req.From); err != nil {
+		return from, to, fmt.Errorf("invalid from: %q", req.From)
+	}
+
+	to = time.Now().UTC()
+	if req.To != "" {
+		if to, err = parseTime(req.To); err != nil {
+			return from, to, fmt.Errorf("invalid to: %q", req.To)
+		}
+	}
+
+	if !to.After(from) {
+		return from, to, errors.New("to must be after from")
+	}
+	if to.Sub(from) > maxImportDays*24*time.Hour {
+		return from, to, fmt.Errorf("imports can cover at most %d days", maxImportDays)
+	}
+	return from, to, nil
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+	"github.com/gorilla/mux"
+)
+
+// DashboardsHandler serves users' dashboards and dashboards shared by link
+type DashboardsHandler struct {
+	dashboards *services.DashboardService
+}
+
+// NewDashboardsHandler creates a new dashboards handler
+func NewDashboardsHandler(dashboards *services.DashboardService) *DashboardsHandler {
+	return &DashboardsHandler{dashboards: dashboards}
+}
+
+// shareResponse is returned when a dashboard is shared
+type shareResponse struct {
+	ShareToken string `json:"share_token"`
+	Path       string `json:"path"` // API path teammates open the dashboard at
+}
+
+// List handles GET /api/v1/dashboards
+func (h *DashboardsHandler) List(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	dashboards, err := h.dashboards.List(r.Context(), uid)
+	if err != nil {
+		log.Printf("Failed to list dashboards for %s: %v", uid, err)
+		http.Error(w, "Failed to list dashboards", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, dashboards)
+}
+
+// Get handles GET /api/v1/dashboards/{id}
+func (h *DashboardsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	dashboard, err := h.dashboards.Get(r.Context(), uid, mux.Vars(r)["id"])
+	if !dashboardFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, dashboard)
+}
+
+// Create handles POST /api/v1/dashboards
+func (h *DashboardsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	dashboard, ok := readDashboard(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.dashboards.Create(r.Context(), uid, dashboard); err != nil {
+		log.Printf("Failed to save dashboard for %s: %v", uid, err)
+		http.Error(w, "Failed to save dashboard", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, dashboard)
+}
+
+// Update handles PUT /api/v1/dashboards/{id}
+func (h *DashboardsHandler) Update(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	dashboard, ok := readDashboard(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.dashboards.Update(r.Context(), uid, mux.Vars(r)["id"], dashboard)
+	if !dashboardFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, dashboard)
+}
+
+// Delete handles DELETE /api/v1/dashboards/{id}
+func (h *DashboardsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.dashboards.Delete(r.Context(), uid, mux.Vars(r)["id"])
+	if !dashboardFound(w, uid, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Share handles POST /api/v1/dashboards/{id}/share
+func (h *DashboardsHandler) Share(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := h.dashboards.Share(r.Context(), uid, mux.Vars(r)["id"])
+	if !dashboardFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, shareResponse{
+		ShareToken: token,
+		Path:       "/api/v1/shared/dashboards/" + token,
+	})
+}
+
+// Unshare handles DELETE /api/v1/dashboards/{id}/share
+func (h *DashboardsHandler) Unshare(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.dashboards.Unshare(r.Context(), uid, mux.Vars(r)["id"])
+	if !dashboardFound(w, uid, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Shared handles GET /api/v1/shared/dashboards/{token}, opening a
+// dashboard shared by link to any signed-in user
+func (h *DashboardsHandler) Shared(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	dashboard, err := h.dashboards.GetShared(r.Context(), mux.Vars(r)["token"])
+	if !dashboardFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, dashboard)
+}
+
+// dashboardFound writes an error response for err, reporting whether
+// there was none
+func dashboardFound(w http.ResponseWriter, uid string, err error) bool {
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Dashboard not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		log.Printf("Dashboard request failed for %s: %v", uid, err)
+		http.Error(w, "Dashboard request failed", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// readDashboard decodes and validates a dashboard request body, writing
+// an error response if it's invalid
+func readDashboard(w http.ResponseWriter, r *http.Request) (*models.Dashboard, bool) {
+	var dashboard models.Dashboard
+	if err := readJSON(w, r, &dashboard, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := dashboard.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return &dashboard, true
+}
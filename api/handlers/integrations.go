@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+	"github.com/gorilla/mux"
+)
+
+// staleUploadAge is how long an upload can go without progress before it
+// may be retried, as when the server restarted mid-upload
+const staleUploadAge = 10 * time.Minute
+
+// connectRequest carries the query parameters a provider redirected back
+// with
+type connectRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+	Scope string `json:"scope"`
+}
+
+// integrationSettingsRequest is the body of PUT requests for a connection
+type integrationSettingsRequest struct {
+	AutoUpload bool `json:"auto_upload"`
+}
+
+// readConnect decodes a connect request body, writing an error response if
+// it's invalid
+func readConnect(w http.ResponseWriter, r *http.Request) (*connectRequest, bool) {
+	var req connectRequest
+	if err := readJSON(w, r, &req, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if req.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return nil, false
+	}
+	return &req, true
+}
+
+// connected writes the error response for a failed connect request,
+// reporting whether there was no error. name is the provider's name for
+// messages.
+func connected(w http.ResponseWriter, uid, name string, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrNotFound), errors.Is(err, services.ErrExpired):
+		http.Error(w, "Authorization has expired or is invalid; start again", http.StatusBadRequest)
+		return false
+	case errors.Is(err, services.ErrNotGranted):
+		http.Error(w, "Permission to upload activities is required", http.StatusBadRequest)
+		return false
+	case err != nil:
+		log.Printf("Failed to connect %s for %s: %v", name, uid, err)
+		http.Error(w, "Failed to connect "+name+": "+err.Error(), http.StatusBadGateway)
+		return false
+	}
+	return true
+}
+
+// uploadable fetches the workout named in the request path, writing an
+// error response unless the user is connected to the provider and the
+// workout hasn't been sent or isn't being sent
+func uploadable(w http.ResponseWriter, r *http.Request, integrations *services.IntegrationService, workouts *services.WorkoutService, uid, provider, name string) (*models.Workout, bool) {
+	conn, err := integrations.Connection(r.Context(), uid, provider)
+	if !connectionFound(w, uid, name, err) {
+		return nil, false
+	}
+	if conn.Error != "" {
+		http.Error(w, conn.Error, http.StatusConflict)
+		return nil, false
+	}
+
+	workout, err := workouts.Get(r.Context(), uid, mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Workout not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !connectionFound(w, uid, name, err) {
+		return nil, false
+	}
+
+	current, err := integrations.Upload(r.Context(), uid, workout.ID, provider)
+	if err == nil && !current.Done() && time.Since(current.UpdatedAt) < staleUploadAge {
+		http.Error(w, "Workout is already being uploaded", http.StatusConflict)
+		return nil, false
+	}
+	if err == nil && current.Status == models.UploadComplete {
+		http.Error(w, "Workout has already been uploaded", http.StatusConflict)
+		return nil, false
+	}
+	return workout, true
+}
+
+// writeUpload writes the status of the workout named in the request path
+// sent to a provider
+func writeUpload(w http.ResponseWriter, r *http.Request, integrations *services.IntegrationService, uid, provider, name string) {
+	upload, err := integrations.Upload(r.Context(), uid, mux.Vars(r)["id"], provider)
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Workout has not been uploaded", http.StatusNotFound)
+		return
+	}
+	if !connectionFound(w, uid, name, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, upload)
+}
+
+// connectionFound writes an error response for err from a provider's
+// endpoints, reporting whether there was none
+func connectionFound(w http.ResponseWriter, uid, name string, err error) bool {
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, name+" is not connected", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		log.Printf("%s request failed for %s: %v", name, uid, err)
+		http.Error(w, name+" request failed", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+	"github.com/gorilla/mux"
+)
+
+// PlansHandler serves training plans, the sessions due each day and how
+// closely plans have been followed
+type PlansHandler struct {
+	plans     *services.PlanService
+	templates *services.TemplateService
+	workouts  *services.WorkoutService
+}
+
+// NewPlansHandler creates a new plans handler
+func NewPlansHandler(plans *services.PlanService, templates *services.TemplateService, workouts *services.WorkoutService) *PlansHandler {
+	return &PlansHandler{
+		plans:     plans,
+		templates: templates,
+		workouts:  workouts,
+	}
+}
+
+// TodaySession is a session due today with the template to start it from
+type TodaySession struct {
+	PlanID   string `json:"plan_id"`
+	PlanName string `json:"plan_name"`
+	models.SessionStatus
+	Template *models.Template `json:"template"` // nil if the template has been deleted
+}
+
+// List handles GET /api/v1/plans
+func (h *PlansHandler) List(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	plans, err := h.plans.List(r.Context(), uid)
+	if err != nil {
+		log.Printf("Failed to list plans for %s: %v", uid, err)
+		http.Error(w, "Failed to list plans", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, plans)
+}
+
+// Get handles GET /api/v1/plans/{id}
+func (h *PlansHandler) Get(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	plan, ok := h.getPlan(w, r, uid)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// Create handles POST /api/v1/plans
+func (h *PlansHandler) Create(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	plan, ok := h.readPlan(w, r, uid)
+	if !ok {
+		return
+	}
+
+	if err := h.plans.Create(r.Context(), uid, plan); err != nil {
+		log.Printf("Failed to save plan for %s: %v", uid, err)
+		http.Error(w, "Failed to save plan", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, plan)
+}
+
+// Update handles PUT /api/v1/plans/{id}
+func (h *PlansHandler) Update(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	plan, ok := h.readPlan(w, r, uid)
+	if !ok {
+		return
+	}
+
+	err := h.plans.Update(r.Context(), uid, mux.Vars(r)["id"], plan)
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update plan for %s: %v", uid, err)
+		http.Error(w, "Failed to update plan", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// Delete handles DELETE /api/v1/plans/{id}
+func (h *PlansHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.plans.Delete(r.Context(), uid, mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete plan for %s: %v", uid, err)
+		http.Error(w, "Failed to delete plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Today handles GET /api/v1/plans/today. Each plan's day is taken in its
+// own time zone unless a date is given.
+func (h *PlansHandler) Today(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	date := r.URL.Query().Get("date")
+	if date != "" {
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			http.Error(w, fmt.Sprintf("invalid date: %q", date), http.StatusBadRequest)
+			return
+		}
+	}
+
+	plans, err := h.plans.List(r.Context(), uid)
+	if err != nil {
+		log.Printf("Failed to list plans for %s: %v", uid, err)
+		http.Error(w, "Failed to list plans", http.StatusInternalServerError)
+		return
+	}
+
+	// Narrow each plan to the day's sessions, noting the span of time
+	// whose workouts could complete them
+	var due []*models.Plan
+	var from, to time.Time
+	var templateIDs []string
+	for _, plan := range plans {
+		day := date
+		if day == "" {
+			day = plan.Today()
+		}
+
+		today := *plan
+		today.Sessions = nil
+		for _, session := range plan.Sessions {
+			if session.Date == day {
+				today.Sessions = append(today.Sessions, session)
+				templateIDs = append(templateIDs, session.TemplateID)
+			}
+		}
+		if len(today.Sessions) == 0 {
+			continue
+		}
+		due = append(due, &today)
+
+		start, end, err := today.DayRange(day, day)
+		if err != nil {
+			continue
+		}
+		if from.IsZero() || start.Before(from) {
+			from = start
+		}
+		if end.After(to) {
+			to = end
+		}
+	}
+
+	sessions := []TodaySession{}
+	if len(due) == 0 {
+		writeJSON(w, http.StatusOK, sessions)
+		return
+	}
+
+	templates, err := h.templates.GetMany(r.Context(), uid, templateIDs)
+	if err != nil {
+		log.Printf("Failed to get templates for %s: %v", uid, err)
+		http.Error(w, "Failed to get templates", http.StatusInternalServerError)
+		return
+	}
+	workouts, err := h.workouts.List(r.Context(), uid, from, to, 0)
+	if err != nil {
+		log.Printf("Failed to list workouts for %s: %v", uid, err)
+		http.Error(w, "Failed to list workouts", http.StatusInternalServerError)
+		return
+	}
+
+	for _, plan := range due {
+		day := date
+		if day == "" {
+			day = plan.Today()
+		}
+		for _, status := range plan.MatchSessions(templates, workouts, day) {
+			sessions = append(sessions, TodaySession{
+				PlanID:        plan.ID,
+				PlanName:      plan.Name,
+				SessionStatus: status,
+				Template:      templates[status.TemplateID],
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// Compliance handles GET /api/v1/plans/{id}/compliance, reporting the
+// plan's sessions up to through (default today) against the workouts
+// rowed
+func (h *PlansHandler) Compliance(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	plan, ok := h.getPlan(w, r, uid)
+	if !ok {
+		return
+	}
+
+	through := r.URL.Query().Get("through")
+	if through == "" {
+		through = plan.Today()
+	} else if _, err := time.Parse(time.DateOnly, through); err != nil {
+		http.Error(w, fmt.Sprintf("invalid through: %q", through), http.StatusBadRequest)
+		return
+	}
+
+	templates, err := h.templates.GetMany(r.Context(), uid, plan.TemplateIDs())
+	if err != nil {
+		log.Printf("Failed to get templates for %s: %v", uid, err)
+		http.Error(w, "Failed to get templates", http.StatusInternalServerError)
+		return
+	}
+
+	workouts := []*models.Workout{}
+	if len(plan.Sessions) > 0 {
+		first, last := plan.Sessions[0].Date, plan.Sessions[len(plan.Sessions)-1].Date
+		from, to, err := plan.DayRange(first, last)
+		if err == nil {
+			workouts, err = h.workouts.List(r.Context(), uid, from, to, 0)
+		}
+		if err != nil {
+			log.Printf("Failed to list workouts for %s: %v", uid, err)
+			http.Error(w, "Failed to list workouts", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	statuses := plan.MatchSessions(templates, workouts, through)
+	writeJSON(w, http.StatusOK, models.NewCompliance(plan.ID, through, statuses))
+}
+
+// getPlan loads the plan named in the path, writing an error response if
+// it can't
+func (h *PlansHandler) getPlan(w http.ResponseWriter, r *http.Request, uid string) (*models.Plan, bool) {
+	plan, err := h.plans.Get(r.Context(), uid, mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to get plan for %s: %v", uid, err)
+		http.Error(w, "Failed to get plan", http.StatusInternalServerError)
+		return nil, false
+	}
+	return plan, true
+}
+
+// readPlan decodes and validates a plan request body, checking every
+// session's template exists. It writes an error response if the plan is
+// invalid.
+func (h *PlansHandler) readPlan(w http.ResponseWriter, r *http.Request, uid string) (*models.Plan, bool) {
+	var plan models.Plan
+	if err := readJSON(w, r, &plan, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := plan.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	ids := plan.TemplateIDs()
+	templates, err := h.templates.GetMany(r.Context(), uid, ids)
+	if err != nil {
+		log.Printf("Failed to get templates for %s: %v", uid, err)
+		http.Error(w, "Failed to check templates", http.StatusInternalServerError)
+		return nil, false
+	}
+	for _, id := range ids {
+		if templates[id] == nil {
+			http.Error(w, fmt.Sprintf("Template %q not found", id), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	return &plan, true
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+)
+
+// ProfileHandler serves user profiles, creating them from the sign-in
+// provider's record the first time a user is seen
+type ProfileHandler struct {
+	profiles   *services.ProfileService
+	firebase   *services.FirebaseService
+	dashboards *services.DashboardService
+}
+
+// NewProfileHandler creates a new profile handler
+func NewProfileHandler(profiles *services.ProfileService, firebase *services.FirebaseService, dashboards *services.DashboardService) *ProfileHandler {
+	return &ProfileHandler{
+		profiles:   profiles,
+		firebase:   firebase,
+		dashboards: dashboards,
+	}
+}
+
+// verifyResponse is returned by POST /api/v1/auth/verify
+type verifyResponse struct {
+	UID      string          `json:"uid"`
+	Verified bool            `json:"verified"`
+	Created  bool            `json:"created"` // the profile was created by this request
+	Profile  *models.Profile `json:"profile"`
+}
+
+// Verify handles POST /api/v1/auth/verify. The auth middleware has already
+// checked the token; new users get a profile and the default dashboards.
+func (h *ProfileHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	profile, created, ok := h.ensure(w, r, uid)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, verifyResponse{
+		UID:      uid,
+		Verified: true,
+		Created:  created,
+		Profile:  profile,
+	})
+}
+
+// Get handles GET /api/v1/profile
+func (h *ProfileHandler) Get(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	profile, _, ok := h.ensure(w, r, uid)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// Put handles PUT /api/v1/profile
+func (h *ProfileHandler) Put(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	var profile models.Profile
+	if err := readJSON(w, r, &profile, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := profile.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, _, ok := h.ensure(w, r, uid); !ok {
+		return
+	}
+
+	err := h.profiles.Put(r.Context(), uid, &profile)
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save profile for %s: %v", uid, err)
+		http.Error(w, "Failed to save profile", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &profile)
+}
+
+// ensure returns the user's profile, creating it from their Firebase user
+// record and seeding the default dashboards if needed. It writes an error
+// response if it can't.
+func (h *ProfileHandler) ensure(w http.ResponseWriter, r *http.Request, uid string) (*models.Profile, bool, bool) {
+	profile, created, err := h.profiles.Ensure(r.Context(), uid, func() (*models.Profile, error) {
+		user, err := h.firebase.GetUser(r.Context(), uid)
+		if err != nil {
+			return nil, err
+		}
+
+		name := user.DisplayName
+		if name == "" {
+			name, _, _ = strings.Cut(user.Email, "@")
+		}
+		if name == "" {
+			name = "Rower"
+		}
+		return &models.Profile{
+			DisplayName: name,
+			Email:       user.Email,
+			PhotoURL:    user.PhotoURL,
+		}, nil
+	})
+	if err != nil {
+		log.Printf("Failed to load profile for %s: %v", uid, err)
+		http.Error(w, "Failed to load profile", http.StatusInternalServerError)
+		return nil, false, false
+	}
+
+	// Whichever request creates the profile gives the user their dashboards
+	if created {
+		if err := h.dashboards.Seed(r.Context(), uid); err != nil {
+			log.Printf("Failed to seed dashboards for %s: %v", uid, err)
+		}
+	}
+	return profile, created, true
+}
+
+// profileFor returns the user's profile, or nil if they have none or it
+// can't be read
+func profileFor(ctx context.Context, profiles *services.ProfileService, uid string) *models.Profile {
+	profile, err := profiles.Get(ctx, uid)
+	if err != nil {
+		if !errors.Is(err, services.ErrNotFound) {
+			log.Printf("Failed to get profile for %s: %v", uid, err)
+		}
+		return nil
+	}
+	return profile
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/danhigham/ergometer.live/api/services"
+)
+
+// RecordsHandler serves personal bests
+type RecordsHandler struct {
+	records *services.RecordService
+}
+
+// NewRecordsHandler creates a new records handler
+func NewRecordsHandler(records *services.RecordService) *RecordsHandler {
+	return &RecordsHandler{records: records}
+}
+
+// List handles GET /api/v1/records
+func (h *RecordsHandler) List(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	records, err := h.records.List(r.Context(), uid)
+	if err != nil {
+		log.Printf("Failed to list records for %s: %v", uid, err)
+		http.Error(w, "Failed to list records", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, records)
+}
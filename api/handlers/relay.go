@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// relayWriteWait bounds each write to a publishing socket server
+	relayWriteWait = 10 * time.Second
+
+	// relayPongWait is how long a publishing socket server may go without
+	// answering a ping
+	relayPongWait = 60 * time.Second
+
+	// relayPingPeriod is how often publishing socket servers are pinged;
+	// it must be less than relayPongWait
+	relayPingPeriod = relayPongWait * 9 / 10
+
+	// relayMaxMessageSize bounds each message a socket server publishes
+	relayMaxMessageSize = 64 << 10
+)
+
+// relayUpgrader upgrades socket server connections. Socket servers aren't
+// browsers, so they send no Origin for the default check to reject.
+var relayUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// RelayHandler serves the keys socket servers relay live sessions with,
+// the connections they publish on and the streams viewers watch
+type RelayHandler struct {
+	relay *services.RelayService
+	hub   *services.RelayHub
+
+	// Closed when the server shuts down to end live streams
+	shutdown <-chan struct{}
+}
+
+// NewRelayHandler creates a new relay handler. Live streams end when
+// shutdown is closed.
+func NewRelayHandler(relay *services.RelayService, hub *services.RelayHub, shutdown <-chan struct{}) *RelayHandler {
+	return &RelayHandler{relay: relay, hub: hub, shutdown: shutdown}
+}
+
+// ListKeys handles GET /api/v1/relay/keys
+func (h *RelayHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := h.relay.List(r.Context(), uid)
+	if err != nil {
+		log.Printf("Failed to list relay keys for %s: %v", uid, err)
+		http.Error(w, "Failed to list relay keys", http.StatusInternalServerError)
+		return
+	}
+
+	connected := h.hub.Connected(uid)
+	for _, key := range keys {
+		key.Connected = connected[key.ID]
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// CreateKey handles POST /api/v1/relay/keys. The key is only returned
+// here.
+func (h *RelayHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	var key models.RelayKey
+	if err := readJSON(w, r, &key, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := key.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.relay.Create(r.Context(), uid, &key); err != nil {
+		log.Printf("Failed to create relay key for %s: %v", uid, err)
+		http.Error(w, "Failed to create relay key", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, key)
+}
+
+// DeleteKey handles DELETE /api/v1/relay/keys/{id}, disconnecting any
+// socket server publishing with it
+func (h *RelayHandler) DeleteKey(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	err := h.relay.Delete(r.Context(), uid, id)
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Relay key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete relay key %s for %s: %v", id, uid, err)
+		http.Error(w, "Failed to delete relay key", http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.Disconnect(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Publish handles GET /api/v1/relay/publish, the WebSocket a socket server
+// publishes its hub's messages on. It needs no sign-in; the socket server
+// presents a relay key as a bearer token instead.
+func (h *RelayHandler) Publish(w http.ResponseWriter, r *http.Request) {
+	secret, ok := bearerToken(r)
+	if !ok {
+		http.Error(w, "Missing relay key", http.StatusUnauthorized)
+		return
+	}
+
+	key, err := h.relay.Authenticate(r.Context(), secret)
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Invalid relay key", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to authenticate relay key: %v", err)
+		http.Error(w, "Failed to authenticate relay key", http.StatusInternalServerError)
+		return
+	}
+
+	source := h.hub.Join(key.UserID, key.ID, key.Name)
+	if source == nil {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer source.Leave()
+
+	conn, err := relayUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already written an error response
+		return
+	}
+	defer conn.Close()
+
+	log.Printf("Relay %q connected for %s", key.Name, key.UserID)
+	defer log.Printf("Relay %q disconnected for %s", key.Name, key.UserID)
+
+	conn.SetReadLimit(relayMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(relayPongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(relayPongWait))
+		return nil
+	})
+
+	// Pings keep the connection alive, and the hub can end it when the key
+	// is deleted or the server shuts down
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		ticker := time.NewTicker(relayPingPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(relayWriteWait)); err != nil {
+					conn.Close()
+					return
+				}
+			case <-source.Done():
+				message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "relay ended")
+				conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(relayWriteWait))
+				conn.Close()
+				return
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Relay %q for %s failed: %v", key.Name, key.UserID, err)
+			}
+			return
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+
+		// Socket server hubs may batch several messages per frame
+		for _, line := range bytes.Split(message, []byte{'\n'}) {
+			if json.Valid(line) {
+				source.Publish(line)
+			}
+		}
+	}
+}
+
+// Live handles GET /api/v1/relay/live, streaming the user's relayed live
+// sessions as server-sent events
+func (h *RelayHandler) Live(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	streamRelay(w, r, h.hub, uid, h.shutdown)
+}
+
+// bearerToken returns the bearer token a request presents, reporting
+// whether there is one
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
+
+// relaySourcesEvent is the data of a sources event
+type relaySourcesEvent struct {
+	Sources []string `json:"sources"`
+}
+
+// relayMessageEvent is the data of a message event
+type relayMessageEvent struct {
+	Source  string          `json:"source"`
+	Message json.RawMessage `json:"message"`
+}
+
+// streamRelay writes a user's relayed stream as server-sent events until
+// the viewer goes away, the hub shuts down or shutdown is closed
+func streamRelay(w http.ResponseWriter, r *http.Request, hub *services.RelayHub, uid string, shutdown <-chan struct{}) {
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline for relayed stream: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	events, cancel := hub.Watch(uid)
+	defer cancel()
+
+	ticker := time.NewTicker(liveKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			var data interface{} = relayMessageEvent{Source: event.Source, Message: event.Message}
+			if event.Type == models.RelayEventSources {
+				data = relaySourcesEvent{Sources: event.Sources}
+			}
+			encoded, err := json.Marshal(data)
+			if err != nil {
+				log.Printf("Failed to marshal relay event: %v", err)
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, encoded)
+
+		case <-ticker.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+
+		case <-r.Context().Done():
+			return
+
+		case <-shutdown:
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
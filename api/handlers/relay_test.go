@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/middleware"
+	"github.com/danhigham/ergometer.live/api/services"
+)
+
+func TestPublishRequiresRelayKey(t *testing.T) {
+	h := NewRelayHandler(services.NewRelayService(nil), services.NewRelayHub(), nil)
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          string
+	}{
+		{name: "no key", want: "Missing relay key"},
+		{name: "not a bearer token", authorization: "Basic cmVsYXk=", want: "Missing relay key"},
+		{name: "empty bearer token", authorization: "Bearer ", want: "Missing relay key"},
+		{name: "not a relay key", authorization: "Bearer firebase-id-token", want: "Invalid relay key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/relay/publish", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			h.Publish(w, r)
+
+			if w.Code != http.StatusUnauthorized || strings.TrimSpace(w.Body.String()) != tt.want {
+				t.Errorf("got %d %q, want 401 %q", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLiveStreamsServerSentEvents(t *testing.T) {
+	hub := services.NewRelayHub()
+	h := NewRelayHandler(services.NewRelayService(nil), hub, nil)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), middleware.UserIDKey, "athlete")
+		h.Live(w, r.WithContext(ctx))
+	}))
+	defer srv.Close()
+	defer hub.Shutdown()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type is %q", got)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control is %q", got)
+	}
+
+	// Events are read in a goroutine so a missing one fails rather than
+	// hanging the test
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	readEvent := func() string {
+		t.Helper()
+
+		var event []string
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatal("stream ended")
+				}
+				if line == "" {
+					return strings.Join(event, "\n")
+				}
+				event = append(event, line)
+			case <-time.After(2 * time.Second):
+				t.Fatal("no event received")
+			}
+		}
+	}
+
+	if got, want := readEvent(), "event: sources\ndata: {\"sources\":[]}"; got != want {
+		t.Errorf("first event is %q, want %q", got, want)
+	}
+
+	source := hub.Join("athlete", "key-1", "Garage erg")
+	defer source.Leave()
+	if got, want := readEvent(), "event: sources\ndata: {\"sources\":[\"Garage erg\"]}"; got != want {
+		t.Errorf("event after a socket server connected is %q, want %q", got, want)
+	}
+
+	source.Publish([]byte(`{"type":"workout_stats","data":{"distance":250}}`))
+	want := "event: message\ndata: {\"source\":\"Garage erg\",\"message\":{\"type\":\"workout_stats\",\"data\":{\"distance\":250}}}"
+	if got := readEvent(); got != want {
+		t.Errorf("message event is %q, want %q", got, want)
+	}
+}
+
+func TestLiveEndsOnShutdown(t *testing.T) {
+	hub := services.NewRelayHub()
+	defer hub.Shutdown()
+	shutdown := make(chan struct{})
+	h := NewRelayHandler(services.NewRelayService(nil), hub, shutdown)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), middleware.UserIDKey, "athlete")
+		h.Live(w, r.WithContext(ctx))
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The viewer is still connected, but the server is going away
+	ended := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, resp.Body)
+		close(ended)
+	}()
+	close(shutdown)
+
+	select {
+	case <-ended:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream still open after shutdown")
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/middleware"
+)
+
+const (
+	// maxBodySize bounds request bodies
+	maxBodySize = 1 << 20
+
+	// maxWorkoutBodySize bounds workout uploads, which carry every sample
+	maxWorkoutBodySize = 32 << 20
+)
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// readJSON decodes a JSON request body of at most limit bytes into v,
+// rejecting unknown fields
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}, limit int64) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// userID returns the authenticated user, writing an error response if
+// there isn't one
+func userID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	uid, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+	}
+	return uid, ok
+}
+
+// parseTime accepts an RFC 3339 timestamp or a date
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// dateRange reads the optional from and to query parameters
+func dateRange(r *http.Request) (from, to time.Time, err error) {
+	query := r.URL.Query()
+
+	if value := query.Get("from"); value != "" {
+		if from, err = parseTime(value); err != nil {
+			return from, to, fmt.Errorf("invalid from: %q", value)
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if to, err = parseTime(value); err != nil {
+			return from, to, fmt.Errorf("invalid to: %q", value)
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		return from, to, fmt.Errorf("to must be after from")
+	}
+	return from, to, nil
+}
+
+// intParam reads an optional non-negative integer query parameter
+func intParam(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return n, nil
+}
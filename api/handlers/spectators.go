@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+	"github.com/gorilla/mux"
+)
+
+// SpectatorsHandler serves the links users share their live stats by, and
+// lets socket servers check them
+type SpectatorsHandler struct {
+	spectators *services.SpectatorService
+	relay      *services.RelayService
+}
+
+// NewSpectatorsHandler creates a new spectators handler
+func NewSpectatorsHandler(spectators *services.SpectatorService, relay *services.RelayService) *SpectatorsHandler {
+	return &SpectatorsHandler{spectators: spectators, relay: relay}
+}
+
+// spectatorShareResponse is a share with the socket server path
+// spectators connect to
+type spectatorShareResponse struct {
+	*models.SpectatorShare
+	Path string `json:"path"` // socket server WebSocket path spectators open
+}
+
+// newSpectatorShareResponse builds the response for a share
+func newSpectatorShareResponse(share *models.SpectatorShare) spectatorShareResponse {
+	return spectatorShareResponse{SpectatorShare: share, Path: "/ws?share=" + share.Token}
+}
+
+// List handles GET /api/v1/spectator-shares
+func (h *SpectatorsHandler) List(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	shares, err := h.spectators.List(r.Context(), uid)
+	if err != nil {
+		log.Printf("Failed to list spectator shares for %s: %v", uid, err)
+		http.Error(w, "Failed to list spectator shares", http.StatusInternalServerError)
+		return
+	}
+
+	responses := make([]spectatorShareResponse, len(shares))
+	for i, share := range shares {
+		responses[i] = newSpectatorShareResponse(share)
+	}
+	writeJSON(w, http.StatusOK, responses)
+}
+
+// Create handles POST /api/v1/spectator-shares
+func (h *SpectatorsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	var share models.SpectatorShare
+	if err := readJSON(w, r, &share, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := share.Validate(time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.spectators.Create(r.Context(), uid, &share); err != nil {
+		log.Printf("Failed to create spectator share for %s: %v", uid, err)
+		http.Error(w, "Failed to create spectator share", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newSpectatorShareResponse(&share))
+}
+
+// Revoke handles DELETE /api/v1/spectator-shares/{token}
+func (h *SpectatorsHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.spectators.Revoke(r.Context(), uid, mux.Vars(r)["token"])
+	if !spectatorShareFound(w, uid, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Resolve handles GET /api/v1/spectate/{token}, which needs no sign-in.
+// Socket servers call it to check the share links spectators connect
+// with, presenting their relay key as a bearer token so only shares of
+// the key owner's ergs are accepted.
+func (h *SpectatorsHandler) Resolve(w http.ResponseWriter, r *http.Request) {
+	secret, ok := bearerToken(r)
+	if !ok {
+		http.Error(w, "Missing relay key", http.StatusUnauthorized)
+		return
+	}
+
+	key, err := h.relay.Lookup(r.Context(), secret)
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Invalid relay key", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up relay key: %v", err)
+		http.Error(w, "Failed to look up relay key", http.StatusInternalServerError)
+		return
+	}
+
+	share, err := h.spectators.Resolve(r.Context(), mux.Vars(r)["token"], key.UserID)
+	if !spectatorShareFound(w, "a spectator", err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, share)
+}
+
+// spectatorShareFound writes an error response for err, reporting whether
+// there was none
+func spectatorShareFound(w http.ResponseWriter, uid string, err error) bool {
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Spectator share not found", http.StatusNotFound)
+		return false
+	}
+	if errors.Is(err, services.ErrExpired) {
+		http.Error(w, "Spectator share has expired", http.StatusGone)
+		return false
+	}
+	if err != nil {
+		log.Printf("Spectator share request failed for %s: %v", uid, err)
+		http.Error(w, "Spectator share request failed", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
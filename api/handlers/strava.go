@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+)
+
+// stravaName names Strava in responses
+const stravaName = "Strava"
+
+// StravaHandler connects users' Strava accounts and uploads their workouts
+type StravaHandler struct {
+	strava       *services.StravaService
+	integrations *services.IntegrationService
+	workouts     *services.WorkoutService
+}
+
+// NewStravaHandler creates a new Strava handler
+func NewStravaHandler(strava *services.StravaService, integrations *services.IntegrationService, workouts *services.WorkoutService) *StravaHandler {
+	return &StravaHandler{
+		strava:       strava,
+		integrations: integrations,
+		workouts:     workouts,
+	}
+}
+
+// Get handles GET /api/v1/strava, returning the user's connection
+func (h *StravaHandler) Get(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := h.integrations.Connection(r.Context(), uid, models.ProviderStrava)
+	if !connectionFound(w, uid, stravaName, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, conn)
+}
+
+// Authorize handles POST /api/v1/strava/authorize, returning the Strava
+// page to send the user to
+func (h *StravaHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	authorizeURL, err := h.strava.AuthorizeURL(r.Context(), uid)
+	if err != nil {
+		log.Printf("Failed to start Strava authorization for %s: %v", uid, err)
+		http.Error(w, "Failed to start Strava authorization", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"url": authorizeURL})
+}
+
+// Connect handles POST /api/v1/strava/connect, finishing the connection
+// with what Strava redirected back with
+func (h *StravaHandler) Connect(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	req, ok := readConnect(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := h.strava.Connect(r.Context(), uid, req.State, req.Code, req.Scope)
+	if !connected(w, uid, stravaName, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, conn)
+}
+
+// Update handles PUT /api/v1/strava, changing the connection's settings
+func (h *StravaHandler) Update(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	var req integrationSettingsRequest
+	if err := readJSON(w, r, &req, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := h.strava.SetAutoUpload(r.Context(), uid, req.AutoUpload)
+	if !connectionFound(w, uid, stravaName, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, conn)
+}
+
+// Delete handles DELETE /api/v1/strava, disconnecting the user's account
+func (h *StravaHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.strava.Disconnect(r.Context(), uid)
+	if !connectionFound(w, uid, stravaName, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Upload handles POST /api/v1/workouts/{id}/strava, uploading a saved
+// workout or retrying a failed upload
+func (h *StravaHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	workout, ok := uploadable(w, r, h.integrations, h.workouts, uid, models.ProviderStrava, stravaName)
+	if !ok {
+		return
+	}
+
+	upload, err := h.strava.Upload(r.Context(), uid, workout)
+	if !connectionFound(w, uid, stravaName, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, upload)
+}
+
+// UploadStatus handles GET /api/v1/workouts/{id}/strava
+func (h *StravaHandler) UploadStatus(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	writeUpload(w, r, h.integrations, uid, models.ProviderStrava, stravaName)
+}
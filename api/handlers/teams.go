@@ -0,0 +1,571 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+	"github.com/gorilla/mux"
+)
+
+// TeamsHandler serves teams, their members and invites, and lets coaches
+// read their athletes' workouts
+type TeamsHandler struct {
+	teams    *services.TeamService
+	profiles *services.ProfileService
+	workouts *services.WorkoutService
+	relay    *services.RelayHub
+
+	// Closed when the server shuts down to end coaches' live streams
+	shutdown <-chan struct{}
+}
+
+// NewTeamsHandler creates a new teams handler. Members' live streams end
+// when shutdown is closed.
+func NewTeamsHandler(teams *services.TeamService, profiles *services.ProfileService, workouts *services.WorkoutService, relay *services.RelayHub, shutdown <-chan struct{}) *TeamsHandler {
+	return &TeamsHandler{
+		teams:    teams,
+		profiles: profiles,
+		workouts: workouts,
+		relay:    relay,
+		shutdown: shutdown,
+	}
+}
+
+// teamResponse is a team with its members
+type teamResponse struct {
+	*models.Team
+	Members []*models.Member `json:"members"`
+}
+
+// inviteResponse is an invite with the path it is accepted at
+type inviteResponse struct {
+	*models.Invite
+	Path string `json:"path"` // API path the invite is accepted at
+}
+
+// roleRequest is the body of PUT /api/v1/teams/{id}/members/{uid}
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+// List handles GET /api/v1/teams
+func (h *TeamsHandler) List(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	teams, err := h.teams.List(r.Context(), uid)
+	if err != nil {
+		log.Printf("Failed to list teams for %s: %v", uid, err)
+		http.Error(w, "Failed to list teams", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, teams)
+}
+
+// Get handles GET /api/v1/teams/{id}
+func (h *TeamsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	teamID := mux.Vars(r)["id"]
+	role, ok := h.role(w, r, uid, teamID)
+	if !ok {
+		return
+	}
+
+	team, err := h.teams.Get(r.Context(), teamID)
+	if !teamFound(w, uid, err) {
+		return
+	}
+	team.Role = role
+
+	members, err := h.teams.Members(r.Context(), teamID)
+	if !teamFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, teamResponse{Team: team, Members: members})
+}
+
+// Create handles POST /api/v1/teams
+func (h *TeamsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	team, ok := readTeam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.teams.Create(r.Context(), uid, h.displayName(r, uid), team); err != nil {
+		log.Printf("Failed to create team for %s: %v", uid, err)
+		http.Error(w, "Failed to create team", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, team)
+}
+
+// Update handles PUT /api/v1/teams/{id}. Only the owner may update a team.
+func (h *TeamsHandler) Update(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	teamID := mux.Vars(r)["id"]
+	if !h.require(w, r, uid, teamID, models.RoleOwner) {
+		return
+	}
+
+	team, ok := readTeam(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.teams.Update(r.Context(), teamID, team)
+	if !teamFound(w, uid, err) {
+		return
+	}
+	team.Role = models.RoleOwner
+
+	writeJSON(w, http.StatusOK, team)
+}
+
+// Delete handles DELETE /api/v1/teams/{id}. Only the owner may delete a
+// team.
+func (h *TeamsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	teamID := mux.Vars(r)["id"]
+	if !h.require(w, r, uid, teamID, models.RoleOwner) {
+		return
+	}
+
+	err := h.teams.Delete(r.Context(), teamID)
+	if !teamFound(w, uid, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetRole handles PUT /api/v1/teams/{id}/members/{uid}. Only the owner may
+// change roles, and only between coach and athlete.
+func (h *TeamsHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	teamID, memberID := vars["id"], vars["uid"]
+	if !h.require(w, r, uid, teamID, models.RoleOwner) {
+		return
+	}
+
+	var req roleRequest
+	if err := readJSON(w, r, &req, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !models.ValidRole(req.Role) {
+		http.Error(w, "role must be coach or athlete", http.StatusBadRequest)
+		return
+	}
+	if memberID == uid {
+		http.Error(w, "The owner's role can't be changed", http.StatusBadRequest)
+		return
+	}
+
+	err := h.teams.SetRole(r.Context(), teamID, memberID, req.Role)
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, services.ErrOwnerRole) {
+		http.Error(w, "The owner's role can't be changed", http.StatusBadRequest)
+		return
+	}
+	if !teamFound(w, uid, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveMember handles DELETE /api/v1/teams/{id}/members/{uid}. The owner
+// may remove anyone else, and other members may leave.
+func (h *TeamsHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	teamID, memberID := vars["id"], vars["uid"]
+	role, ok := h.role(w, r, uid, teamID)
+	if !ok {
+		return
+	}
+
+	switch {
+	case memberID == uid && role == models.RoleOwner:
+		http.Error(w, "The owner can't leave; delete the team instead", http.StatusBadRequest)
+		return
+	case memberID != uid && role != models.RoleOwner:
+		http.Error(w, "Only the owner can remove members", http.StatusForbidden)
+		return
+	}
+
+	err := h.teams.RemoveMember(r.Context(), teamID, memberID)
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if !teamFound(w, uid, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListInvites handles GET /api/v1/teams/{id}/invites
+func (h *TeamsHandler) ListInvites(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	teamID := mux.Vars(r)["id"]
+	if !h.require(w, r, uid, teamID, models.RoleOwner, models.RoleCoach) {
+		return
+	}
+
+	invites, err := h.teams.Invites(r.Context(), teamID)
+	if !teamFound(w, uid, err) {
+		return
+	}
+
+	responses := make([]inviteResponse, len(invites))
+	for i, invite := range invites {
+		responses[i] = newInviteResponse(invite)
+	}
+	writeJSON(w, http.StatusOK, responses)
+}
+
+// CreateInvite handles POST /api/v1/teams/{id}/invites. Owners may invite
+// coaches and athletes, coaches only athletes.
+func (h *TeamsHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	teamID := mux.Vars(r)["id"]
+	role, ok := h.role(w, r, uid, teamID)
+	if !ok {
+		return
+	}
+
+	var invite models.Invite
+	if err := readJSON(w, r, &invite, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := invite.Validate(time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !models.CanInvite(role, invite.Role) {
+		http.Error(w, "You can't invite members as "+invite.Role, http.StatusForbidden)
+		return
+	}
+
+	team, err := h.teams.Get(r.Context(), teamID)
+	if !teamFound(w, uid, err) {
+		return
+	}
+
+	if err := h.teams.CreateInvite(r.Context(), team, uid, &invite); err != nil {
+		log.Printf("Failed to create invite for %s: %v", uid, err)
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newInviteResponse(&invite))
+}
+
+// DeleteInvite handles DELETE /api/v1/teams/{id}/invites/{code}
+func (h *TeamsHandler) DeleteInvite(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	teamID := vars["id"]
+	if !h.require(w, r, uid, teamID, models.RoleOwner, models.RoleCoach) {
+		return
+	}
+
+	err := h.teams.DeleteInvite(r.Context(), teamID, vars["code"])
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+	if !teamFound(w, uid, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetInvite handles GET /api/v1/invites/{code}, showing any signed-in user
+// the team and role an invite is for before they accept it
+func (h *TeamsHandler) GetInvite(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	invite, err := h.teams.GetInvite(r.Context(), mux.Vars(r)["code"])
+	if !inviteFound(w, uid, err) {
+		return
+	}
+	if !invite.Usable(time.Now()) {
+		inviteFound(w, uid, services.ErrExpired)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newInviteResponse(invite))
+}
+
+// AcceptInvite handles POST /api/v1/invites/{code}/accept
+func (h *TeamsHandler) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	team, err := h.teams.Join(r.Context(), mux.Vars(r)["code"], uid, h.displayName(r, uid))
+	if errors.Is(err, services.ErrExists) {
+		http.Error(w, "You are already a member of this team", http.StatusConflict)
+		return
+	}
+	if !inviteFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, team)
+}
+
+// MemberWorkouts handles GET /api/v1/teams/{id}/members/{uid}/workouts,
+// listing an athlete's workouts for their coaches. It takes the same
+// parameters as GET /api/v1/workouts.
+func (h *TeamsHandler) MemberWorkouts(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	memberID, ok := h.coached(w, r, uid)
+	if !ok {
+		return
+	}
+
+	from, to, err := dateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := intParam(r, "limit", defaultWorkoutLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	workouts, err := h.workouts.List(r.Context(), memberID, from, to, limit)
+	if err != nil {
+		log.Printf("Failed to list workouts of %s for %s: %v", memberID, uid, err)
+		http.Error(w, "Failed to list workouts", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, workouts)
+}
+
+// MemberWorkout handles
+// GET /api/v1/teams/{id}/members/{uid}/workouts/{workout_id}
+func (h *TeamsHandler) MemberWorkout(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	memberID, ok := h.coached(w, r, uid)
+	if !ok {
+		return
+	}
+
+	workout, err := h.workouts.Get(r.Context(), memberID, mux.Vars(r)["workout_id"])
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Workout not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get workout of %s for %s: %v", memberID, uid, err)
+		http.Error(w, "Failed to get workout", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, workout)
+}
+
+// MemberLive handles GET /api/v1/teams/{id}/members/{uid}/live, streaming
+// an athlete's relayed live sessions to their coach as server-sent events
+func (h *TeamsHandler) MemberLive(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	memberID, ok := h.coached(w, r, uid)
+	if !ok {
+		return
+	}
+
+	streamRelay(w, r, h.relay, memberID, h.shutdown)
+}
+
+// role returns the user's role in a team, writing a not found response if
+// they aren't a member so teams they don't belong to aren't revealed
+func (h *TeamsHandler) role(w http.ResponseWriter, r *http.Request, uid, teamID string) (string, bool) {
+	role, err := h.teams.Role(r.Context(), teamID, uid)
+	if !teamFound(w, uid, err) {
+		return "", false
+	}
+	return role, true
+}
+
+// require checks the user has one of roles in a team, writing an error
+// response if not
+func (h *TeamsHandler) require(w http.ResponseWriter, r *http.Request, uid, teamID string, roles ...string) bool {
+	role, ok := h.role(w, r, uid, teamID)
+	if !ok {
+		return false
+	}
+	for _, allowed := range roles {
+		if role == allowed {
+			return true
+		}
+	}
+	http.Error(w, "Your role in this team doesn't allow this", http.StatusForbidden)
+	return false
+}
+
+// coached checks the user coaches the member named in the request path,
+// returning the member's ID
+func (h *TeamsHandler) coached(w http.ResponseWriter, r *http.Request, uid string) (string, bool) {
+	vars := mux.Vars(r)
+	teamID, memberID := vars["id"], vars["uid"]
+
+	role, ok := h.role(w, r, uid, teamID)
+	if !ok {
+		return "", false
+	}
+
+	memberRole, err := h.teams.Role(r.Context(), teamID, memberID)
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return "", false
+	}
+	if !teamFound(w, uid, err) {
+		return "", false
+	}
+
+	if !models.CanCoach(role, memberRole) {
+		http.Error(w, "Only owners and coaches can see athletes' workouts and live sessions", http.StatusForbidden)
+		return "", false
+	}
+	return memberID, true
+}
+
+// displayName returns the name a user is shown by in teams
+func (h *TeamsHandler) displayName(r *http.Request, uid string) string {
+	if profile := profileFor(r.Context(), h.profiles, uid); profile != nil {
+		return profile.DisplayName
+	}
+	return ""
+}
+
+// newInviteResponse pairs an invite with the path it is accepted at
+func newInviteResponse(invite *models.Invite) inviteResponse {
+	return inviteResponse{
+		Invite: invite,
+		Path:   "/api/v1/invites/" + invite.Code + "/accept",
+	}
+}
+
+// teamFound writes an error response for err, reporting whether there was
+// none
+func teamFound(w http.ResponseWriter, uid string, err error) bool {
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Team not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		log.Printf("Team request failed for %s: %v", uid, err)
+		http.Error(w, "Team request failed", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// inviteFound writes an error response for err, reporting whether there
+// was none
+func inviteFound(w http.ResponseWriter, uid string, err error) bool {
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return false
+	}
+	if errors.Is(err, services.ErrExpired) {
+		http.Error(w, "Invite has expired", http.StatusGone)
+		return false
+	}
+	if err != nil {
+		log.Printf("Invite request failed for %s: %v", uid, err)
+		http.Error(w, "Invite request failed", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// readTeam decodes and validates a team request body, writing an error
+// response if it's invalid
+func readTeam(w http.ResponseWriter, r *http.Request) (*models.Team, bool) {
+	var team models.Team
+	if err := readJSON(w, r, &team, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := team.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return &team, true
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+	"github.com/gorilla/mux"
+)
+
+// TemplatesHandler serves users' workout templates and those shared with
+// their teams
+type TemplatesHandler struct {
+	templates *services.TemplateService
+	teams     *services.TeamService
+}
+
+// NewTemplatesHandler creates a new templates handler
+func NewTemplatesHandler(templates *services.TemplateService, teams *services.TeamService) *TemplatesHandler {
+	return &TemplatesHandler{templates: templates, teams: teams}
+}
+
+// List handles GET /api/v1/templates
+func (h *TemplatesHandler) List(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	templates, err := h.templates.List(r.Context(), uid)
+	if err != nil {
+		log.Printf("Failed to list templates for %s: %v", uid, err)
+		http.Error(w, "Failed to list templates", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, templates)
+}
+
+// Get handles GET /api/v1/templates/{id}
+func (h *TemplatesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	template, err := h.templates.Get(r.Context(), uid, mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get template for %s: %v", uid, err)
+		http.Error(w, "Failed to get template", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, template)
+}
+
+// Create handles POST /api/v1/templates
+func (h *TemplatesHandler) Create(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	template, ok := readTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.templates.Create(r.Context(), uid, template); err != nil {
+		log.Printf("Failed to save template for %s: %v", uid, err)
+		http.Error(w, "Failed to save template", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, template)
+}
+
+// Update handles PUT /api/v1/templates/{id}
+func (h *TemplatesHandler) Update(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	template, ok := readTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.templates.Update(r.Context(), uid, mux.Vars(r)["id"], template)
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update template for %s: %v", uid, err)
+		http.Error(w, "Failed to update template", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, template)
+}
+
+// Delete handles DELETE /api/v1/templates/{id}
+func (h *TemplatesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.templates.Delete(r.Context(), uid, mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete template for %s: %v", uid, err)
+		http.Error(w, "Failed to delete template", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListForTeam handles GET /api/v1/teams/{id}/templates. Every member can
+// read a team's templates.
+func (h *TemplatesHandler) ListForTeam(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	teamID := mux.Vars(r)["id"]
+	if _, ok := h.teamRole(w, r, uid, teamID); !ok {
+		return
+	}
+
+	templates, err := h.templates.ListForTeam(r.Context(), teamID)
+	if !templateFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, templates)
+}
+
+// GetForTeam handles GET /api/v1/teams/{id}/templates/{template_id}
+func (h *TemplatesHandler) GetForTeam(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	if _, ok := h.teamRole(w, r, uid, vars["id"]); !ok {
+		return
+	}
+
+	template, err := h.templates.GetForTeam(r.Context(), vars["id"], vars["template_id"])
+	if !templateFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, template)
+}
+
+// CreateForTeam handles POST /api/v1/teams/{id}/templates. Team owners and
+// coaches manage a team's templates.
+func (h *TemplatesHandler) CreateForTeam(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	teamID := mux.Vars(r)["id"]
+	if !h.managesTemplates(w, r, uid, teamID) {
+		return
+	}
+
+	template, ok := readTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.templates.CreateForTeam(r.Context(), teamID, template)
+	if !templateFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, template)
+}
+
+// UpdateForTeam handles PUT /api/v1/teams/{id}/templates/{template_id}
+func (h *TemplatesHandler) UpdateForTeam(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	if !h.managesTemplates(w, r, uid, vars["id"]) {
+		return
+	}
+
+	template, ok := readTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.templates.UpdateForTeam(r.Context(), vars["id"], vars["template_id"], template)
+	if !templateFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, template)
+}
+
+// DeleteForTeam handles DELETE /api/v1/teams/{id}/templates/{template_id}
+func (h *TemplatesHandler) DeleteForTeam(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	if !h.managesTemplates(w, r, uid, vars["id"]) {
+		return
+	}
+
+	err := h.templates.DeleteForTeam(r.Context(), vars["id"], vars["template_id"])
+	if !templateFound(w, uid, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// teamRole returns the user's role in a team, writing a not found
+// response if they aren't a member
+func (h *TemplatesHandler) teamRole(w http.ResponseWriter, r *http.Request, uid, teamID string) (string, bool) {
+	role, err := h.teams.Role(r.Context(), teamID, uid)
+	if !teamFound(w, uid, err) {
+		return "", false
+	}
+	return role, true
+}
+
+// managesTemplates checks the user may manage a team's templates, writing
+// an error response if not
+func (h *TemplatesHandler) managesTemplates(w http.ResponseWriter, r *http.Request, uid, teamID string) bool {
+	role, ok := h.teamRole(w, r, uid, teamID)
+	if !ok {
+		return false
+	}
+	if !models.CanManageTemplates(role) {
+		http.Error(w, "Only owners and coaches can manage a team's templates", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// templateFound writes an error response for err, reporting whether there
+// was none
+func templateFound(w http.ResponseWriter, uid string, err error) bool {
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		log.Printf("Template request failed for %s: %v", uid, err)
+		http.Error(w, "Template request failed", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// readTemplate decodes and validates a template request body, writing an
+// error response if it's invalid
+func readTemplate(w http.ResponseWriter, r *http.Request) (*models.Template, bool) {
+	var template models.Template
+	if err := readJSON(w, r, &template, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := template.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	template.TeamID = ""
+	return &template, true
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/danhigham/ergometer.live/api/services"
+)
+
+// TicketsHandler issues the tickets browsers open live streams with
+type TicketsHandler struct {
+	tickets *services.TicketService
+}
+
+// NewTicketsHandler creates a new tickets handler
+func NewTicketsHandler(tickets *services.TicketService) *TicketsHandler {
+	return &TicketsHandler{tickets: tickets}
+}
+
+// Create handles POST /api/v1/stream-tickets
+func (h *TicketsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	ticket, err := h.tickets.Issue(uid)
+	if err != nil {
+		log.Printf("Failed to issue stream ticket for %s: %v", uid, err)
+		http.Error(w, "Failed to issue stream ticket", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, ticket)
+}
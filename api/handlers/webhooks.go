@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+	"github.com/gorilla/mux"
+)
+
+// WebhooksHandler serves users' and teams' webhooks and their delivery logs
+type WebhooksHandler struct {
+	webhooks   *services.WebhookService
+	dispatcher *services.WebhookDispatcher
+	teams      *services.TeamService
+}
+
+// NewWebhooksHandler creates a new webhooks handler
+func NewWebhooksHandler(webhooks *services.WebhookService, dispatcher *services.WebhookDispatcher, teams *services.TeamService) *WebhooksHandler {
+	return &WebhooksHandler{
+		webhooks:   webhooks,
+		dispatcher: dispatcher,
+		teams:      teams,
+	}
+}
+
+// webhookRequest is the body of POST and PUT requests. Webhooks are active
+// unless active is false.
+type webhookRequest struct {
+	URL         string   `json:"url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"`
+	Active      *bool    `json:"active"`
+}
+
+// List handles GET /api/v1/webhooks, listing the user's own webhooks
+func (h *WebhooksHandler) List(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	webhooks, err := h.webhooks.ListForUser(r.Context(), uid)
+	if !webhookFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, withoutSecrets(webhooks))
+}
+
+// Create handles POST /api/v1/webhooks. The response is the only one that
+// includes the signing secret.
+func (h *WebhooksHandler) Create(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	webhook, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+	webhook.UserID = uid
+
+	h.create(w, r, uid, webhook)
+}
+
+// ListForTeam handles GET /api/v1/teams/{id}/webhooks
+func (h *WebhooksHandler) ListForTeam(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	teamID := mux.Vars(r)["id"]
+	if !h.managesTeam(w, r, uid, teamID) {
+		return
+	}
+
+	webhooks, err := h.webhooks.ListForTeam(r.Context(), teamID)
+	if !webhookFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, withoutSecrets(webhooks))
+}
+
+// CreateForTeam handles POST /api/v1/teams/{id}/webhooks. Team owners and
+// coaches manage a team's webhooks.
+func (h *WebhooksHandler) CreateForTeam(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	teamID := mux.Vars(r)["id"]
+	if !h.managesTeam(w, r, uid, teamID) {
+		return
+	}
+
+	webhook, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+	webhook.TeamID = teamID
+
+	h.create(w, r, uid, webhook)
+}
+
+// Get handles GET /api/v1/webhooks/{id}
+func (h *WebhooksHandler) Get(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	webhook, ok := h.webhook(w, r, uid)
+	if !ok {
+		return
+	}
+
+	webhook.Secret = ""
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+// Update handles PUT /api/v1/webhooks/{id}
+func (h *WebhooksHandler) Update(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	if _, ok := h.webhook(w, r, uid); !ok {
+		return
+	}
+	webhook, ok := readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.webhooks.Update(r.Context(), mux.Vars(r)["id"], webhook)
+	if !webhookFound(w, uid, err) {
+		return
+	}
+
+	webhook.Secret = ""
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+// Delete handles DELETE /api/v1/webhooks/{id}
+func (h *WebhooksHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	webhook, ok := h.webhook(w, r, uid)
+	if !ok {
+		return
+	}
+
+	err := h.webhooks.Delete(r.Context(), webhook.ID)
+	if !webhookFound(w, uid, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Ping handles POST /api/v1/webhooks/{id}/ping, sending a ping event to
+// test the receiver
+func (h *WebhooksHandler) Ping(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	webhook, ok := h.webhook(w, r, uid)
+	if !ok {
+		return
+	}
+	if !webhook.Active {
+		http.Error(w, "Webhook is disabled", http.StatusConflict)
+		return
+	}
+
+	delivery, err := h.dispatcher.Send(r.Context(), webhook, models.WebhookPing, uid, map[string]string{
+		"webhook_id": webhook.ID,
+	})
+	if !webhookFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, delivery)
+}
+
+// Deliveries handles GET /api/v1/webhooks/{id}/deliveries
+func (h *WebhooksHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	webhook, ok := h.webhook(w, r, uid)
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.webhooks.Deliveries(r.Context(), webhook.ID)
+	if !webhookFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// Delivery handles GET /api/v1/webhooks/{id}/deliveries/{delivery_id},
+// including the payload sent
+func (h *WebhooksHandler) Delivery(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	webhook, ok := h.webhook(w, r, uid)
+	if !ok {
+		return
+	}
+
+	delivery, err := h.webhooks.GetDelivery(r.Context(), webhook.ID, mux.Vars(r)["delivery_id"])
+	if !deliveryFound(w, uid, err) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+// Redeliver handles
+// POST /api/v1/webhooks/{id}/deliveries/{delivery_id}/redeliver, sending
+// a past delivery's payload again as a new delivery
+func (h *WebhooksHandler) Redeliver(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	webhook, ok := h.webhook(w, r, uid)
+	if !ok {
+		return
+	}
+	if !webhook.Active {
+		http.Error(w, "Webhook is disabled", http.StatusConflict)
+		return
+	}
+
+	original, err := h.webhooks.GetDelivery(r.Context(), webhook.ID, mux.Vars(r)["delivery_id"])
+	if !deliveryFound(w, uid, err) {
+		return
+	}
+
+	delivery, err := h.dispatcher.Redeliver(r.Context(), webhook, original)
+	if !deliveryFound(w, uid, err) {
+		return
+	}
+
+	delivery.Payload = ""
+	writeJSON(w, http.StatusAccepted, delivery)
+}
+
+// create stores a webhook, responding with it and its secret
+func (h *WebhooksHandler) create(w http.ResponseWriter, r *http.Request, uid string, webhook *models.Webhook) {
+	if err := h.webhooks.Create(r.Context(), uid, webhook); err != nil {
+		log.Printf("Failed to create webhook for %s: %v", uid, err)
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, webhook)
+}
+
+// webhook fetches the webhook named in the request path, writing a not
+// found response unless it is the user's or belongs to a team they own or
+// coach
+func (h *WebhooksHandler) webhook(w http.ResponseWriter, r *http.Request, uid string) (*models.Webhook, bool) {
+	webhook, err := h.webhooks.Get(r.Context(), mux.Vars(r)["id"])
+	if !webhookFound(w, uid, err) {
+		return nil, false
+	}
+
+	if webhook.UserID == uid {
+		return webhook, true
+	}
+	if webhook.TeamID != "" {
+		role, err := h.teams.Role(r.Context(), webhook.TeamID, uid)
+		if err == nil && (role == models.RoleOwner || role == models.RoleCoach) {
+			return webhook, true
+		}
+		if err != nil && !errors.Is(err, services.ErrNotFound) {
+			webhookFound(w, uid, err)
+			return nil, false
+		}
+	}
+
+	webhookFound(w, uid, services.ErrNotFound)
+	return nil, false
+}
+
+// managesTeam checks the user owns or coaches a team, writing an error
+// response if not
+func (h *WebhooksHandler) managesTeam(w http.ResponseWriter, r *http.Request, uid, teamID string) bool {
+	role, err := h.teams.Role(r.Context(), teamID, uid)
+	if !teamFound(w, uid, err) {
+		return false
+	}
+	if role != models.RoleOwner && role != models.RoleCoach {
+		http.Error(w, "Only owners and coaches can manage a team's webhooks", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// withoutSecrets clears webhooks' signing secrets for listing
+func withoutSecrets(webhooks []*models.Webhook) []*models.Webhook {
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+	}
+	return webhooks
+}
+
+// webhookFound writes an error response for err, reporting whether there
+// was none
+func webhookFound(w http.ResponseWriter, uid string, err error) bool {
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		log.Printf("Webhook request failed for %s: %v", uid, err)
+		http.Error(w, "Webhook request failed", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// deliveryFound writes an error response for err, reporting whether there
+// was none
+func deliveryFound(w http.ResponseWriter, uid string, err error) bool {
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return false
+	}
+	return webhookFound(w, uid, err)
+}
+
+// readWebhook decodes and validates a webhook request body, writing an
+// error response if it's invalid
+func readWebhook(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	var req webhookRequest
+	if err := readJSON(w, r, &req, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	webhook := &models.Webhook{
+		URL:         req.URL,
+		Description: req.Description,
+		Events:      req.Events,
+		Active:      req.Active == nil || *req.Active,
+	}
+	if err := webhook.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return webhook, true
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+	"github.com/gorilla/mux"
+)
+
+// defaultWorkoutLimit is the number of workouts listed when no limit is
+// given
+const defaultWorkoutLimit = 50
+
+// WorkoutsHandler stores and serves workouts
+type WorkoutsHandler struct {
+	workouts   *services.WorkoutService
+	records    *services.RecordService
+	zones      *services.ZoneService
+	profiles   *services.ProfileService
+	teams      *services.TeamService
+	challenges *services.ChallengeService
+	dispatcher *services.WebhookDispatcher
+	strava     *services.StravaService   // nil when Strava isn't configured
+	concept2   *services.Concept2Service // nil when the Logbook isn't configured
+	samples    *services.InfluxDBService // nil when InfluxDB isn't configured
+}
+
+// NewWorkoutsHandler creates a new workouts handler. strava, concept2 and
+// samples may be nil.
+func NewWorkoutsHandler(workouts *services.WorkoutService, records *services.RecordService, zones *services.ZoneService, profiles *services.ProfileService, teams *services.TeamService, challenges *services.ChallengeService, dispatcher *services.WebhookDispatcher, strava *services.StravaService, concept2 *services.Concept2Service, samples *services.InfluxDBService) *WorkoutsHandler {
+	return &WorkoutsHandler{
+		workouts:   workouts,
+		records:    records,
+		zones:      zones,
+		profiles:   profiles,
+		teams:      teams,
+		challenges: challenges,
+		dispatcher: dispatcher,
+		strava:     strava,
+		concept2:   concept2,
+		samples:    samples,
+	}
+}
+
+// createWorkoutResponse is returned when a workout is saved
+type createWorkoutResponse struct {
+	Workout       *models.Workout    `json:"workout"`
+	PersonalBests []*models.Record   `json:"personal_bests"`
+	Challenges    []enteredChallenge `json:"challenges"`
+}
+
+// Create handles POST /api/v1/workouts
+func (h *WorkoutsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	var workout models.Workout
+	if err := readJSON(w, r, &workout, maxWorkoutBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := workout.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	workout.Summarize()
+
+	// Training stress, calories and weight-adjusted scores depend on the
+	// user's thresholds and profile; the workout is still worth saving
+	// without them
+	settings, err := h.zones.Get(r.Context(), uid)
+	if err != nil {
+		log.Printf("Failed to get zones for %s: %v", uid, err)
+	}
+	profile := profileFor(r.Context(), h.profiles, uid)
+	workout.ComputeLoad(settings.WithProfile(profile))
+	workout.Calories = workout.EstimateCalories(profile)
+	if profile != nil {
+		workout.AdjustForWeight(profile.Weight)
+	}
+
+	err = h.workouts.Create(r.Context(), uid, &workout)
+	if errors.Is(err, services.ErrExists) {
+		http.Error(w, "Workout has already been saved", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save workout for %s: %v", uid, err)
+		http.Error(w, "Failed to save workout", http.StatusInternalServerError)
+		return
+	}
+
+	// The summary is already stored, so a failure here only loses the
+	// detailed samples
+	if h.samples != nil {
+		if err := h.samples.WriteSamples(r.Context(), uid, &workout); err != nil {
+			log.Printf("Failed to store samples for workout %s: %v", workout.ID, err)
+		}
+	}
+
+	records, err := h.records.Update(r.Context(), uid, &workout)
+	if err != nil {
+		log.Printf("Failed to update records for %s: %v", uid, err)
+		records = nil
+	}
+	if records == nil {
+		records = []*models.Record{}
+	}
+
+	teamIDs, err := memberTeamIDs(r.Context(), h.teams, uid)
+	if err != nil {
+		log.Printf("Failed to list teams for %s: %v", uid, err)
+	}
+	entered := enterChallenges(r.Context(), h.challenges, teamIDs, uid, profile, &workout)
+
+	// Uploads are built from the samples, which the response leaves out
+	uploaded := workout
+	if h.strava != nil {
+		go h.strava.AutoUpload(context.WithoutCancel(r.Context()), uid, &uploaded)
+	}
+	if h.concept2 != nil {
+		go h.concept2.AutoUpload(context.WithoutCancel(r.Context()), uid, &uploaded)
+	}
+
+	workout.Samples = nil
+	writeJSON(w, http.StatusCreated, createWorkoutResponse{
+		Workout:       &workout,
+		PersonalBests: records,
+		Challenges:    entered,
+	})
+
+	go h.publish(context.WithoutCancel(r.Context()), uid, teamIDs, &workout, records, entered)
+}
+
+// publish sends webhook events for a newly saved workout to the user's
+// and their teams' webhooks
+func (h *WorkoutsHandler) publish(ctx context.Context, uid string, teamIDs []string, workout *models.Workout, records []*models.Record, entered []enteredChallenge) {
+	h.dispatcher.Publish(ctx, models.WebhookWorkoutCompleted, uid, teamIDs, workout)
+	for _, record := range records {
+		h.dispatcher.Publish(ctx, models.WebhookPersonalBest, uid, teamIDs, record)
+	}
+	for _, challenge := range entered {
+		h.dispatcher.Publish(ctx, models.WebhookChallengeEntry, uid, teamIDs, challenge)
+	}
+}
+
+// Imported follows up a workout saved by a Concept2 Logbook import as
+// Create does, entering it in challenges and sending webhooks. It isn't
+// uploaded, having come from the Logbook.
+func (h *WorkoutsHandler) Imported(ctx context.Context, uid string, workout *models.Workout, records []*models.Record) {
+	teamIDs, err := memberTeamIDs(ctx, h.teams, uid)
+	if err != nil {
+		log.Printf("Failed to list teams for %s: %v", uid, err)
+	}
+	entered := enterChallenges(ctx, h.challenges, teamIDs, uid, profileFor(ctx, h.profiles, uid), workout)
+
+	published := *workout
+	published.Samples = nil
+	h.publish(ctx, uid, teamIDs, &published, records, entered)
+}
+
+// List handles GET /api/v1/workouts
+func (h *WorkoutsHandler) List(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	from, to, err := dateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := intParam(r, "limit", defaultWorkoutLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	workouts, err := h.workouts.List(r.Context(), uid, from, to, limit)
+	if err != nil {
+		log.Printf("Failed to list workouts for %s: %v", uid, err)
+		http.Error(w, "Failed to list workouts", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, workouts)
+}
+
+// Get handles GET /api/v1/workouts/{id}
+func (h *WorkoutsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	workout, err := h.workouts.Get(r.Context(), uid, mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrNotFound) {
+		http.Error(w, "Workout not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get workout for %s: %v", uid, err)
+		http.Error(w, "Failed to get workout", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, workout)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/danhigham/ergometer.live/api/models"
+	"github.com/danhigham/ergometer.live/api/services"
+)
+
+// ZonesHandler serves a user's training zones
+type ZonesHandler struct {
+	zones    *services.ZoneService
+	profiles *services.ProfileService
+}
+
+// NewZonesHandler creates a new zones handler
+func NewZonesHandler(zones *services.ZoneService, profiles *services.ProfileService) *ZonesHandler {
+	return &ZonesHandler{zones: zones, profiles: profiles}
+}
+
+// zonesResponse pairs the stored settings with the zones derived from
+// them. Zones use the profile's max heart rate when the settings have none.
+type zonesResponse struct {
+	Settings *models.ZoneSettings `json:"settings"`
+	Zones    models.Zones         `json:"zones"`
+}
+
+// Get handles GET /api/v1/zones
+func (h *ZonesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	settings, err := h.zones.Get(r.Context(), uid)
+	if err != nil {
+		log.Printf("Failed to get zones for %s: %v", uid, err)
+		http.Error(w, "Failed to get zones", http.StatusInternalServerError)
+		return
+	}
+
+	profile := profileFor(r.Context(), h.profiles, uid)
+	writeJSON(w, http.StatusOK, zonesResponse{Settings: settings, Zones: settings.WithProfile(profile).Zones()})
+}
+
+// Put handles PUT /api/v1/zones
+func (h *ZonesHandler) Put(w http.ResponseWriter, r *http.Request) {
+	uid, ok := userID(w, r)
+	if !ok {
+		return
+	}
+
+	var settings models.ZoneSettings
+	if err := readJSON(w, r, &settings, maxBodySize); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := settings.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.zones.Put(r.Context(), uid, &settings); err != nil {
+		log.Printf("Failed to save zones for %s: %v", uid, err)
+		http.Error(w, "Failed to save zones", http.StatusInternalServerError)
+		return
+	}
+
+	profile := profileFor(r.Context(), h.profiles, uid)
+	writeJSON(w, http.StatusOK, zonesResponse{Settings: &settings, Zones: settings.WithProfile(profile).Zones()})
+}
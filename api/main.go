@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"syscall"
 
 	"github.com/danhigham/ergometer.live/api/config"
+	"github.com/danhigham/ergometer.live/api/handlers"
 	"github.com/danhigham/ergometer.live/api/middleware"
 	"github.com/danhigham/ergometer.live/api/services"
 	"github.com/gorilla/mux"
@@ -27,6 +29,81 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to initialize Firebase service: %v", err)
 	}
+	defer firebaseService.Close()
+
+	// Initialize data services
+	zoneService := services.NewZoneService(firebaseService.Firestore())
+	workoutService := services.NewWorkoutService(firebaseService.Firestore())
+	recordService := services.NewRecordService(firebaseService.Firestore())
+	templateService := services.NewTemplateService(firebaseService.Firestore())
+	planService := services.NewPlanService(firebaseService.Firestore())
+	dashboardService := services.NewDashboardService(firebaseService.Firestore())
+	profileService := services.NewProfileService(firebaseService.Firestore())
+	teamService := services.NewTeamService(firebaseService.Firestore())
+	challengeService := services.NewChallengeService(firebaseService.Firestore())
+	webhookService := services.NewWebhookService(firebaseService.Firestore())
+	spectatorService := services.NewSpectatorService(firebaseService.Firestore())
+	relayService := services.NewRelayService(firebaseService.Firestore())
+
+	// Live sessions socket servers relay are fanned out in memory
+	relayHub := services.NewRelayHub()
+	ticketService := services.NewTicketService()
+
+	// Webhook deliveries run in the background until shutdown
+	dispatcher := services.NewWebhookDispatcher(webhookService, cfg.WebhookAllowPrivate)
+	dispatcher.Start()
+
+	// Per-snapshot workout data is only kept when InfluxDB is configured
+	var influxService *services.InfluxDBService
+	if cfg.InfluxDBURL != "" {
+		influxService = services.NewInfluxDBService(cfg.InfluxDBURL, cfg.InfluxDBToken, cfg.InfluxDBOrg, cfg.InfluxDBBucket)
+		defer influxService.Close()
+	} else {
+		log.Println("InfluxDB not configured, workout samples will not be stored")
+	}
+
+	// Strava uploads and Logbook syncing run in the background until
+	// shutdown
+	integrationService := services.NewIntegrationService(firebaseService.Firestore())
+	var stravaService *services.StravaService
+	if cfg.StravaClientID != "" {
+		stravaService = services.NewStravaService(integrationService, influxService, cfg.StravaBaseURL, cfg.StravaClientID, cfg.StravaClientSecret, cfg.StravaRedirectURL)
+	} else {
+		log.Println("Strava not configured, workouts will not be uploaded")
+	}
+	var concept2Service *services.Concept2Service
+	if cfg.Concept2ClientID != "" {
+		concept2Service = services.NewConcept2Service(integrationService, workoutService, recordService, profileService, influxService, cfg.Concept2BaseURL, cfg.Concept2ClientID, cfg.Concept2ClientSecret, cfg.Concept2RedirectURL)
+	} else {
+		log.Println("Concept2 Logbook not configured, workouts will not be synced")
+	}
+
+	// Closed on shutdown to end server-sent event streams, which would
+	// otherwise hold the server open until the shutdown timeout
+	streams := make(chan struct{})
+
+	// Initialize handlers
+	profileHandler := handlers.NewProfileHandler(profileService, firebaseService, dashboardService)
+	zonesHandler := handlers.NewZonesHandler(zoneService, profileService)
+	workoutsHandler := handlers.NewWorkoutsHandler(workoutService, recordService, zoneService, profileService, teamService, challengeService, dispatcher, stravaService, concept2Service, influxService)
+	if concept2Service != nil {
+		// Logbook imports are entered in challenges and sent to webhooks
+		// like workouts saved through the API
+		concept2Service.SetSavedHandler(workoutsHandler.Imported)
+	}
+	recordsHandler := handlers.NewRecordsHandler(recordService)
+	analyticsHandler := handlers.NewAnalyticsHandler(workoutService, influxService)
+	templatesHandler := handlers.NewTemplatesHandler(templateService, teamService)
+	plansHandler := handlers.NewPlansHandler(planService, templateService, workoutService)
+	dashboardsHandler := handlers.NewDashboardsHandler(dashboardService)
+	teamsHandler := handlers.NewTeamsHandler(teamService, profileService, workoutService, relayHub, streams)
+	challengesHandler := handlers.NewChallengesHandler(challengeService, teamService, profileService, workoutService, dispatcher, streams)
+	webhooksHandler := handlers.NewWebhooksHandler(webhookService, dispatcher, teamService)
+	stravaHandler := handlers.NewStravaHandler(stravaService, integrationService, workoutService)
+	concept2Handler := handlers.NewConcept2Handler(concept2Service, integrationService, workoutService)
+	spectatorsHandler := handlers.NewSpectatorsHandler(spectatorService, relayService)
+	relayHandler := handlers.NewRelayHandler(relayService, relayHub, streams)
+	ticketsHandler := handlers.NewTicketsHandler(ticketService)
 
 	// Create router
 	router := mux.NewRouter()
@@ -40,7 +117,116 @@ func main() {
 	// Auth verification endpoint (requires auth)
 	authRouter := apiV1.PathPrefix("/auth").Subrouter()
 	authRouter.Use(middleware.Auth(firebaseService))
-	authRouter.HandleFunc("/verify", verifyHandler).Methods("POST")
+	authRouter.HandleFunc("/verify", profileHandler.Verify).Methods("POST")
+
+	// Spectator share lookups by socket servers (no auth required)
+	apiV1.HandleFunc("/spectate/{token}", spectatorsHandler.Resolve).Methods("GET")
+
+	// Socket servers publish live sessions with a relay key (no sign-in)
+	apiV1.HandleFunc("/relay/publish", relayHandler.Publish).Methods("GET")
+
+	// Live streams also accept a stream ticket, since browsers can't send
+	// them an Authorization header
+	streamRouter := apiV1.NewRoute().Subrouter()
+	streamRouter.Use(middleware.StreamAuth(firebaseService, ticketService))
+	streamRouter.HandleFunc("/teams/{id}/members/{uid}/live", teamsHandler.MemberLive).Methods("GET")
+	streamRouter.HandleFunc("/challenges/{id}/leaderboard/live", challengesHandler.Live).Methods("GET")
+	streamRouter.HandleFunc("/relay/live", relayHandler.Live).Methods("GET")
+
+	// Authenticated user data
+	userRouter := apiV1.NewRoute().Subrouter()
+	userRouter.Use(middleware.Auth(firebaseService))
+	userRouter.HandleFunc("/profile", profileHandler.Get).Methods("GET")
+	userRouter.HandleFunc("/profile", profileHandler.Put).Methods("PUT")
+	userRouter.HandleFunc("/zones", zonesHandler.Get).Methods("GET")
+	userRouter.HandleFunc("/zones", zonesHandler.Put).Methods("PUT")
+	userRouter.HandleFunc("/workouts", workoutsHandler.List).Methods("GET")
+	userRouter.HandleFunc("/workouts", workoutsHandler.Create).Methods("POST")
+	userRouter.HandleFunc("/workouts/{id}", workoutsHandler.Get).Methods("GET")
+	userRouter.HandleFunc("/records", recordsHandler.List).Methods("GET")
+	userRouter.HandleFunc("/analytics/load", analyticsHandler.Load).Methods("GET")
+	userRouter.HandleFunc("/analytics/curve", analyticsHandler.Curve).Methods("GET")
+	userRouter.HandleFunc("/templates", templatesHandler.List).Methods("GET")
+	userRouter.HandleFunc("/templates", templatesHandler.Create).Methods("POST")
+	userRouter.HandleFunc("/templates/{id}", templatesHandler.Get).Methods("GET")
+	userRouter.HandleFunc("/templates/{id}", templatesHandler.Update).Methods("PUT")
+	userRouter.HandleFunc("/templates/{id}", templatesHandler.Delete).Methods("DELETE")
+	userRouter.HandleFunc("/plans", plansHandler.List).Methods("GET")
+	userRouter.HandleFunc("/plans", plansHandler.Create).Methods("POST")
+	userRouter.HandleFunc("/plans/today", plansHandler.Today).Methods("GET")
+	userRouter.HandleFunc("/plans/{id}", plansHandler.Get).Methods("GET")
+	userRouter.HandleFunc("/plans/{id}", plansHandler.Update).Methods("PUT")
+	userRouter.HandleFunc("/plans/{id}", plansHandler.Delete).Methods("DELETE")
+	userRouter.HandleFunc("/plans/{id}/compliance", plansHandler.Compliance).Methods("GET")
+	userRouter.HandleFunc("/dashboards", dashboardsHandler.List).Methods("GET")
+	userRouter.HandleFunc("/dashboards", dashboardsHandler.Create).Methods("POST")
+	userRouter.HandleFunc("/dashboards/{id}", dashboardsHandler.Get).Methods("GET")
+	userRouter.HandleFunc("/dashboards/{id}", dashboardsHandler.Update).Methods("PUT")
+	userRouter.HandleFunc("/dashboards/{id}", dashboardsHandler.Delete).Methods("DELETE")
+	userRouter.HandleFunc("/dashboards/{id}/share", dashboardsHandler.Share).Methods("POST")
+	userRouter.HandleFunc("/dashboards/{id}/share", dashboardsHandler.Unshare).Methods("DELETE")
+	userRouter.HandleFunc("/shared/dashboards/{token}", dashboardsHandler.Shared).Methods("GET")
+	userRouter.HandleFunc("/teams", teamsHandler.List).Methods("GET")
+	userRouter.HandleFunc("/teams", teamsHandler.Create).Methods("POST")
+	userRouter.HandleFunc("/teams/{id}", teamsHandler.Get).Methods("GET")
+	userRouter.HandleFunc("/teams/{id}", teamsHandler.Update).Methods("PUT")
+	userRouter.HandleFunc("/teams/{id}", teamsHandler.Delete).Methods("DELETE")
+	userRouter.HandleFunc("/teams/{id}/members/{uid}", teamsHandler.SetRole).Methods("PUT")
+	userRouter.HandleFunc("/teams/{id}/members/{uid}", teamsHandler.RemoveMember).Methods("DELETE")
+	userRouter.HandleFunc("/teams/{id}/members/{uid}/workouts", teamsHandler.MemberWorkouts).Methods("GET")
+	userRouter.HandleFunc("/teams/{id}/members/{uid}/workouts/{workout_id}", teamsHandler.MemberWorkout).Methods("GET")
+	userRouter.HandleFunc("/teams/{id}/templates", templatesHandler.ListForTeam).Methods("GET")
+	userRouter.HandleFunc("/teams/{id}/templates", templatesHandler.CreateForTeam).Methods("POST")
+	userRouter.HandleFunc("/teams/{id}/templates/{template_id}", templatesHandler.GetForTeam).Methods("GET")
+	userRouter.HandleFunc("/teams/{id}/templates/{template_id}", templatesHandler.UpdateForTeam).Methods("PUT")
+	userRouter.HandleFunc("/teams/{id}/templates/{template_id}", templatesHandler.DeleteForTeam).Methods("DELETE")
+	userRouter.HandleFunc("/teams/{id}/invites", teamsHandler.ListInvites).Methods("GET")
+	userRouter.HandleFunc("/teams/{id}/invites", teamsHandler.CreateInvite).Methods("POST")
+	userRouter.HandleFunc("/teams/{id}/invites/{code}", teamsHandler.DeleteInvite).Methods("DELETE")
+	userRouter.HandleFunc("/invites/{code}", teamsHandler.GetInvite).Methods("GET")
+	userRouter.HandleFunc("/invites/{code}/accept", teamsHandler.AcceptInvite).Methods("POST")
+	userRouter.HandleFunc("/challenges", challengesHandler.List).Methods("GET")
+	userRouter.HandleFunc("/challenges", challengesHandler.Create).Methods("POST")
+	userRouter.HandleFunc("/challenges/{id}", challengesHandler.Get).Methods("GET")
+	userRouter.HandleFunc("/challenges/{id}", challengesHandler.Delete).Methods("DELETE")
+	userRouter.HandleFunc("/challenges/{id}/leaderboard", challengesHandler.Leaderboard).Methods("GET")
+	userRouter.HandleFunc("/webhooks", webhooksHandler.List).Methods("GET")
+	userRouter.HandleFunc("/webhooks", webhooksHandler.Create).Methods("POST")
+	userRouter.HandleFunc("/teams/{id}/webhooks", webhooksHandler.ListForTeam).Methods("GET")
+	userRouter.HandleFunc("/teams/{id}/webhooks", webhooksHandler.CreateForTeam).Methods("POST")
+	userRouter.HandleFunc("/webhooks/{id}", webhooksHandler.Get).Methods("GET")
+	userRouter.HandleFunc("/webhooks/{id}", webhooksHandler.Update).Methods("PUT")
+	userRouter.HandleFunc("/webhooks/{id}", webhooksHandler.Delete).Methods("DELETE")
+	userRouter.HandleFunc("/webhooks/{id}/ping", webhooksHandler.Ping).Methods("POST")
+	userRouter.HandleFunc("/webhooks/{id}/deliveries", webhooksHandler.Deliveries).Methods("GET")
+	userRouter.HandleFunc("/webhooks/{id}/deliveries/{delivery_id}", webhooksHandler.Delivery).Methods("GET")
+	userRouter.HandleFunc("/webhooks/{id}/deliveries/{delivery_id}/redeliver", webhooksHandler.Redeliver).Methods("POST")
+	userRouter.HandleFunc("/spectator-shares", spectatorsHandler.List).Methods("GET")
+	userRouter.HandleFunc("/spectator-shares", spectatorsHandler.Create).Methods("POST")
+	userRouter.HandleFunc("/spectator-shares/{token}", spectatorsHandler.Revoke).Methods("DELETE")
+	userRouter.HandleFunc("/relay/keys", relayHandler.ListKeys).Methods("GET")
+	userRouter.HandleFunc("/relay/keys", relayHandler.CreateKey).Methods("POST")
+	userRouter.HandleFunc("/relay/keys/{id}", relayHandler.DeleteKey).Methods("DELETE")
+	userRouter.HandleFunc("/stream-tickets", ticketsHandler.Create).Methods("POST")
+	if stravaService != nil {
+		userRouter.HandleFunc("/strava", stravaHandler.Get).Methods("GET")
+		userRouter.HandleFunc("/strava", stravaHandler.Update).Methods("PUT")
+		userRouter.HandleFunc("/strava", stravaHandler.Delete).Methods("DELETE")
+		userRouter.HandleFunc("/strava/authorize", stravaHandler.Authorize).Methods("POST")
+		userRouter.HandleFunc("/strava/connect", stravaHandler.Connect).Methods("POST")
+		userRouter.HandleFunc("/workouts/{id}/strava", stravaHandler.UploadStatus).Methods("GET")
+		userRouter.HandleFunc("/workouts/{id}/strava", stravaHandler.Upload).Methods("POST")
+	}
+	if concept2Service != nil {
+		userRouter.HandleFunc("/concept2", concept2Handler.Get).Methods("GET")
+		userRouter.HandleFunc("/concept2", concept2Handler.Update).Methods("PUT")
+		userRouter.HandleFunc("/concept2", concept2Handler.Delete).Methods("DELETE")
+		userRouter.HandleFunc("/concept2/authorize", concept2Handler.Authorize).Methods("POST")
+		userRouter.HandleFunc("/concept2/connect", concept2Handler.Connect).Methods("POST")
+		userRouter.HandleFunc("/concept2/import", concept2Handler.Import).Methods("POST")
+		userRouter.HandleFunc("/workouts/{id}/concept2", concept2Handler.UploadStatus).Methods("GET")
+		userRouter.HandleFunc("/workouts/{id}/concept2", concept2Handler.Upload).Methods("POST")
+	}
 
 	// Apply middleware
 	corsMiddleware := middleware.NewCORS(cfg.AllowedOrigins)
@@ -49,9 +235,13 @@ func main() {
 
 	// Start server
 	addr := ":" + cfg.Port
-	server := &http.Server{
-		Addr:    addr,
-		Handler: handler,
+	srv := newServer(addr, handler, streams, relayHub)
+	srv.addBackground("Webhook dispatcher", dispatcher.Shutdown)
+	if stravaService != nil {
+		srv.addBackground("Strava", stravaService.Shutdown)
+	}
+	if concept2Service != nil {
+		srv.addBackground("Concept2", concept2Service.Shutdown)
 	}
 
 	// Graceful shutdown
@@ -60,13 +250,21 @@ func main() {
 
 	go func() {
 		log.Printf("Server starting on %s", addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := srv.ListenAndServe(); err != nil {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
 
 	<-sigChan
 	log.Println("\nReceived shutdown signal...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown error: %v", err)
+	}
+
 	log.Println("Server stopped")
 }
 
@@ -76,17 +274,3 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"status":"healthy"}`))
 }
-
-// verifyHandler handles auth verification requests
-func verifyHandler(w http.ResponseWriter, r *http.Request) {
-	// If we reach here, the token was valid (auth middleware passed)
-	uid, ok := middleware.GetUserID(r.Context())
-	if !ok {
-		http.Error(w, "User ID not found in context", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"uid":"` + uid + `","verified":true}`))
-}
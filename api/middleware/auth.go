@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -20,36 +21,74 @@ const (
 func Auth(firebaseService *services.FirebaseService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Extract token from Authorization header
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				http.Error(w, "Missing authorization header", http.StatusUnauthorized)
+			uid, ok := verifyIDToken(w, r, firebaseService)
+			if !ok {
 				return
 			}
 
-			// Expected format: "Bearer <token>"
-			parts := strings.Split(authHeader, " ")
-			if len(parts) != 2 || parts[0] != "Bearer" {
-				http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+			// Add user ID to request context
+			ctx := context.WithValue(r.Context(), UserIDKey, uid)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// StreamAuth middleware validates Firebase ID tokens like Auth, or a
+// ticket from tickets in the ticket query parameter, since browsers'
+// EventSource can't send an Authorization header
+func StreamAuth(firebaseService *services.FirebaseService, tickets *services.TicketService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ticket := r.URL.Query().Get("ticket")
+			if ticket == "" {
+				Auth(firebaseService)(next).ServeHTTP(w, r)
 				return
 			}
 
-			idToken := parts[1]
-
-			// Verify the ID token
-			uid, err := firebaseService.VerifyIDToken(r.Context(), idToken)
+			uid, err := tickets.Redeem(ticket)
+			if errors.Is(err, services.ErrNotFound) || errors.Is(err, services.ErrExpired) {
+				http.Error(w, "Invalid or expired ticket", http.StatusUnauthorized)
+				return
+			}
 			if err != nil {
-				http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+				http.Error(w, "Failed to redeem ticket", http.StatusInternalServerError)
 				return
 			}
 
-			// Add user ID to request context
 			ctx := context.WithValue(r.Context(), UserIDKey, uid)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// verifyIDToken checks the Firebase ID token in a request's Authorization
+// header, writing an error response if it's missing or invalid
+func verifyIDToken(w http.ResponseWriter, r *http.Request, firebaseService *services.FirebaseService) (string, bool) {
+	// Extract token from Authorization header
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, "Missing authorization header", http.StatusUnauthorized)
+		return "", false
+	}
+
+	// Expected format: "Bearer <token>"
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return "", false
+	}
+
+	idToken := parts[1]
+
+	// Verify the ID token
+	uid, err := firebaseService.VerifyIDToken(r.Context(), idToken)
+	if err != nil {
+		http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	return uid, true
+}
+
 // GetUserID extracts the user ID from the request context
 func GetUserID(ctx context.Context) (string, bool) {
 	uid, ok := ctx.Value(UserIDKey).(string)
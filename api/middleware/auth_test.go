@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danhigham/ergometer.live/api/services"
+)
+
+func TestStreamAuthAcceptsTickets(t *testing.T) {
+	tickets := services.NewTicketService()
+	ticket, err := tickets.Issue("athlete")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The ticket stands in for a Firebase token, so none is needed here
+	handler := StreamAuth(nil, tickets)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, _ := GetUserID(r.Context())
+		w.Write([]byte(uid))
+	}))
+	stream := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/relay/live"+query, nil))
+		return w
+	}
+
+	if w := stream("?ticket=" + ticket.Ticket); w.Code != http.StatusOK || w.Body.String() != "athlete" {
+		t.Errorf("stream with a ticket got %d %q", w.Code, w.Body.String())
+	}
+
+	// Each ticket opens a single stream
+	if w := stream("?ticket=" + ticket.Ticket); w.Code != http.StatusUnauthorized {
+		t.Errorf("stream reusing a ticket got %d, want 401", w.Code)
+	}
+	if w := stream("?ticket=unknown"); w.Code != http.StatusUnauthorized {
+		t.Errorf("stream with an unknown ticket got %d, want 401", w.Code)
+	}
+
+	// Without a ticket an Authorization header is needed as usual
+	if w := stream(""); w.Code != http.StatusUnauthorized {
+		t.Errorf("stream without a ticket or token got %d, want 401", w.Code)
+	}
+}
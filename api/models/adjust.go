@@ -0,0 +1,63 @@
+package models
+
+import "math"
+
+// poundsPerKilogram converts weights for Concept2's adjustment formula,
+// which is defined in pounds
+const poundsPerKilogram = 2.20462
+
+// WeightFactor is Concept2's weight correction factor,
+// (weight in lb / 270) ^ 0.222. Times are multiplied by it and distances
+// divided by it, so a lighter rower's adjusted time is faster.
+func WeightFactor(kg float64) float64 {
+	return math.Pow(kg*poundsPerKilogram/270, 0.222)
+}
+
+// PaceWatts converts a pace in seconds per 500m to watts with Concept2's
+// formula, watts = 2.80 / (seconds per meter)³
+func PaceWatts(pace float64) float64 {
+	if pace <= 0 {
+		return 0
+	}
+	return 2.80 / math.Pow(pace/500, 3)
+}
+
+// AdjustForWeight sets the effort's weight-adjusted time or distance and
+// watts per kilogram for a rower of weight kg. A weight of 0 clears them.
+func (e *Effort) AdjustForWeight(kg float64) {
+	e.AdjustedTime, e.AdjustedDistance, e.WattsPerKg = 0, 0, 0
+	if kg <= 0 {
+		return
+	}
+
+	factor := WeightFactor(kg)
+	if e.Kind == EventTime {
+		e.AdjustedDistance = round1(e.Distance / factor)
+	} else {
+		e.AdjustedTime = round1(e.Time * factor)
+	}
+	e.WattsPerKg = round2(PaceWatts(e.Pace) / kg)
+}
+
+// AdjustForWeight records the rower's weight on the workout and sets its
+// weight-adjusted time and distance, watts per kilogram and the same for
+// each best effort. A weight of 0 clears them.
+func (w *Workout) AdjustForWeight(kg float64) {
+	w.Weight, w.AdjustedTime, w.AdjustedDistance, w.WattsPerKg = 0, 0, 0, 0
+	if kg > 0 {
+		factor := WeightFactor(kg)
+		w.Weight = kg
+		w.AdjustedTime = round1(w.ElapsedTime * factor)
+		w.AdjustedDistance = round1(w.Distance / factor)
+		w.WattsPerKg = round2(w.AvgPower / kg)
+	}
+
+	for i := range w.BestEfforts {
+		w.BestEfforts[i].AdjustForWeight(kg)
+	}
+}
+
+// round2 rounds to two decimal places
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWeightFactor(t *testing.T) {
+	tests := []struct {
+		kg   float64
+		want float64
+	}{
+		// 270lb is the reference weight
+		{270 / poundsPerKilogram, 1},
+		{80, 0.9098},
+		{60, 0.8535},
+		{150, 1.0461},
+	}
+	for _, tt := range tests {
+		if got := WeightFactor(tt.kg); math.Abs(got-tt.want) > 0.0001 {
+			t.Errorf("WeightFactor(%.2f) = %.4f, want %.4f", tt.kg, got, tt.want)
+		}
+	}
+}
+
+func TestPaceWatts(t *testing.T) {
+	tests := []struct {
+		pace float64
+		want float64
+	}{
+		{100, 350},     // 1:40/500m
+		{120, 202.546}, // 2:00/500m
+		{0, 0},
+		{-1, 0},
+	}
+	for _, tt := range tests {
+		if got := PaceWatts(tt.pace); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("PaceWatts(%v) = %.3f, want %v", tt.pace, got, tt.want)
+		}
+	}
+}
+
+func TestEffortAdjustForWeight(t *testing.T) {
+	tests := []struct {
+		name   string
+		effort Effort
+		kg     float64
+		want   Effort
+	}{
+		{
+			"2k at 80kg",
+			Effort{Kind: EventDistance, Distance: 2000, Time: 420, Pace: 105},
+			80,
+			Effort{Kind: EventDistance, Distance: 2000, Time: 420, Pace: 105, AdjustedTime: 382.1, WattsPerKg: 3.78},
+		},
+		{
+			"4min at 60kg",
+			Effort{Kind: EventTime, Distance: 1000, Time: 240, Pace: 120},
+			60,
+			Effort{Kind: EventTime, Distance: 1000, Time: 240, Pace: 120, AdjustedDistance: 1171.6, WattsPerKg: 3.38},
+		},
+		{
+			"unknown weight clears",
+			Effort{Kind: EventDistance, Distance: 2000, Time: 420, Pace: 105, AdjustedTime: 382.1, WattsPerKg: 3.78},
+			0,
+			Effort{Kind: EventDistance, Distance: 2000, Time: 420, Pace: 105},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			effort := tt.effort
+			effort.AdjustForWeight(tt.kg)
+			if effort != tt.want {
+				t.Errorf("got %+v, want %+v", effort, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkoutAdjustForWeight(t *testing.T) {
+	w := &Workout{
+		Distance:    2000,
+		ElapsedTime: 420,
+		AvgPower:    304,
+		BestEfforts: []Effort{{Event: "2k", Kind: EventDistance, Distance: 2000, Time: 420, Pace: 105}},
+	}
+
+	w.AdjustForWeight(80)
+	if w.Weight != 80 || w.AdjustedTime != 382.1 || w.AdjustedDistance != 2198.3 || w.WattsPerKg != 3.8 {
+		t.Errorf("workout adjusted to %v s, %v m and %v W/kg at %v kg",
+			w.AdjustedTime, w.AdjustedDistance, w.WattsPerKg, w.Weight)
+	}
+	if effort := w.BestEfforts[0]; effort.AdjustedTime != 382.1 || effort.WattsPerKg != 3.78 {
+		t.Errorf("best effort %+v not adjusted", effort)
+	}
+
+	w.AdjustForWeight(0)
+	if w.Weight != 0 || w.AdjustedTime != 0 || w.AdjustedDistance != 0 || w.WattsPerKg != 0 || w.BestEfforts[0].AdjustedTime != 0 {
+		t.Errorf("workout %+v not cleared", w)
+	}
+}
@@ -0,0 +1,365 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Challenge kinds
+const (
+	ChallengeDistance = "distance" // most meters rowed
+	ChallengeEvent    = "event"    // best effort for an event
+)
+
+// Challenge scoring
+const (
+	ScoringIndividual = "individual"
+	ScoringTeam       = "team" // members' distances summed per team
+)
+
+const (
+	// maxChallengeTeams bounds the teams taking part in a challenge. It is
+	// also Firestore's limit for array-contains-any queries.
+	maxChallengeTeams = 30
+
+	// maxChallengeLength bounds how long a challenge runs
+	maxChallengeLength = 366 * 24 * time.Hour
+)
+
+// ChallengeTeam is a team taking part in a challenge
+type ChallengeTeam struct {
+	ID   string `json:"id" firestore:"id"`
+	Name string `json:"name" firestore:"name"`
+}
+
+// Challenge is a time-boxed competition between the members of one or more
+// teams. Workouts started between Start and End are entered automatically
+// when they are saved.
+type Challenge struct {
+	ID          string          `json:"id" firestore:"-"`
+	Name        string          `json:"name" firestore:"name"`
+	Description string          `json:"description,omitempty" firestore:"description"`
+	Kind        string          `json:"kind" firestore:"kind"`
+	Event       string          `json:"event,omitempty" firestore:"event"` // for event challenges
+	Scoring     string          `json:"scoring" firestore:"scoring"`
+	TeamIDs     []string        `json:"team_ids" firestore:"team_ids"` // the first is the host
+	Teams       []ChallengeTeam `json:"teams" firestore:"teams"`       // set by the server
+	Start       time.Time       `json:"start" firestore:"start"`
+	End         time.Time       `json:"end" firestore:"end"`
+	CreatedBy   string          `json:"created_by" firestore:"created_by"`
+	CreatedAt   time.Time       `json:"created_at" firestore:"created_at"`
+}
+
+// Entry is a user's standing in a challenge, built up from each workout
+// they enter. Adjusted values are Concept2 weight-adjusted, falling back to
+// the raw values for workouts saved without a weight.
+type Entry struct {
+	UserID           string    `json:"user_id" firestore:"-"`
+	DisplayName      string    `json:"display_name" firestore:"display_name"`
+	TeamID           string    `json:"team_id" firestore:"team_id"`
+	WeightClass      string    `json:"weight_class,omitempty" firestore:"weight_class"`
+	BirthYear        int       `json:"-" firestore:"birth_year"`
+	Age              int       `json:"age,omitempty" firestore:"-"`                     // in the year the challenge ends
+	Distance         float64   `json:"distance" firestore:"distance"`                   // meters
+	Time             float64   `json:"time,omitempty" firestore:"time"`                 // seconds, for distance events
+	AdjustedDistance float64   `json:"adjusted_distance" firestore:"adjusted_distance"` // meters
+	AdjustedTime     float64   `json:"adjusted_time,omitempty" firestore:"adjusted_time"`
+	Workouts         int       `json:"workouts" firestore:"workouts"`
+	WorkoutIDs       []string  `json:"-" firestore:"workout_ids"`                             // the workouts counted
+	BestWorkoutID    string    `json:"best_workout_id,omitempty" firestore:"best_workout_id"` // for event challenges
+	UpdatedAt        time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// LeaderboardFilter narrows and orders a leaderboard. Zero values don't
+// filter.
+type LeaderboardFilter struct {
+	WeightClass string // heavyweight or lightweight
+	MinAge      int
+	MaxAge      int
+	TeamID      string
+	Adjusted    bool // rank by weight-adjusted values
+}
+
+// RankedEntry is an entry's place on a leaderboard. Entries that tie share
+// a rank.
+type RankedEntry struct {
+	Rank int `json:"rank"`
+	*Entry
+}
+
+// TeamStanding is a team's total on a team-scored leaderboard
+type TeamStanding struct {
+	Rank     int     `json:"rank"`
+	TeamID   string  `json:"team_id"`
+	Name     string  `json:"name"`
+	Distance float64 `json:"distance"` // meters, adjusted when ranking by adjusted values
+	Members  int     `json:"members"`  // members with an entry
+}
+
+// Leaderboard is a challenge's rankings
+type Leaderboard struct {
+	Challenge *Challenge     `json:"challenge"`
+	Adjusted  bool           `json:"adjusted"`
+	Entries   []RankedEntry  `json:"entries"`
+	Teams     []TeamStanding `json:"teams,omitempty"` // for team scoring
+}
+
+// Validate checks a challenge submitted for creation, defaulting its
+// scoring to individual
+func (c *Challenge) Validate() error {
+	var errs []error
+
+	if c.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if len(c.Name) > maxTemplateNameLength {
+		errs = append(errs, fmt.Errorf("name must be at most %d characters", maxTemplateNameLength))
+	}
+	if len(c.Description) > maxTeamDescriptionLength {
+		errs = append(errs, fmt.Errorf("description must be at most %d characters", maxTeamDescriptionLength))
+	}
+
+	switch c.Kind {
+	case ChallengeDistance:
+		if c.Event != "" {
+			errs = append(errs, errors.New("event is only allowed for event challenges"))
+		}
+	case ChallengeEvent:
+		if c.event() == nil {
+			errs = append(errs, fmt.Errorf("unknown event %q", c.Event))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("kind must be distance or event, got %q", c.Kind))
+	}
+
+	switch c.Scoring {
+	case "":
+		c.Scoring = ScoringIndividual
+	case ScoringIndividual:
+	case ScoringTeam:
+		if c.Kind != ChallengeDistance {
+			errs = append(errs, errors.New("team scoring is only allowed for distance challenges"))
+		}
+		if len(c.TeamIDs) < 2 {
+			errs = append(errs, errors.New("team scoring needs at least two teams"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("scoring must be individual or team, got %q", c.Scoring))
+	}
+
+	if len(c.TeamIDs) == 0 {
+		errs = append(errs, errors.New("at least one team is required"))
+	}
+	if len(c.TeamIDs) > maxChallengeTeams {
+		errs = append(errs, fmt.Errorf("at most %d teams are allowed", maxChallengeTeams))
+	}
+	for i, id := range c.TeamIDs {
+		if contains(c.TeamIDs[:i], id) {
+			errs = append(errs, fmt.Errorf("team %s is listed twice", id))
+		}
+	}
+
+	if c.Start.IsZero() || c.End.IsZero() {
+		errs = append(errs, errors.New("start and end are required"))
+	} else if !c.End.After(c.Start) {
+		errs = append(errs, errors.New("end must be after start"))
+	} else if c.End.Sub(c.Start) > maxChallengeLength {
+		errs = append(errs, errors.New("a challenge can run for at most a year"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Status returns whether the challenge is upcoming, active or finished at
+// now
+func (c *Challenge) Status(now time.Time) string {
+	switch {
+	case now.Before(c.Start):
+		return "upcoming"
+	case now.Before(c.End):
+		return "active"
+	default:
+		return "finished"
+	}
+}
+
+// Covers reports whether a workout started at t counts for the challenge
+func (c *Challenge) Covers(t time.Time) bool {
+	return !t.Before(c.Start) && t.Before(c.End)
+}
+
+// TeamFor returns the first of the challenge's teams among teamIDs, or ""
+// if none is
+func (c *Challenge) TeamFor(teamIDs []string) string {
+	for _, id := range c.TeamIDs {
+		if contains(teamIDs, id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// Apply adds a workout to an entry, reporting whether it changed the
+// entry. Distance challenges total every workout; event challenges keep
+// the best effort. Workouts the entry already counts are skipped, so a
+// workout entered twice only counts once.
+func (c *Challenge) Apply(entry *Entry, workout *Workout) bool {
+	if !c.Covers(workout.StartedAt) || contains(entry.WorkoutIDs, workout.ID) {
+		return false
+	}
+
+	if c.Kind == ChallengeDistance {
+		entry.WorkoutIDs = append(entry.WorkoutIDs, workout.ID)
+		entry.Distance = round1(entry.Distance + workout.Distance)
+		adjusted := workout.AdjustedDistance
+		if adjusted == 0 {
+			adjusted = workout.Distance
+		}
+		entry.AdjustedDistance = round1(entry.AdjustedDistance + adjusted)
+		entry.Workouts++
+		return true
+	}
+
+	event := c.event()
+	for _, effort := range workout.BestEfforts {
+		if effort.Event != event.Name {
+			continue
+		}
+
+		entry.Workouts++
+		entry.WorkoutIDs = append(entry.WorkoutIDs, workout.ID)
+		adjustedTime, adjustedDistance := effort.AdjustedTime, effort.AdjustedDistance
+		if adjustedTime == 0 {
+			adjustedTime = effort.Time
+		}
+		if adjustedDistance == 0 {
+			adjustedDistance = effort.Distance
+		}
+
+		better := entry.BestWorkoutID == ""
+		if event.Kind == EventTime {
+			better = better || effort.Distance > entry.Distance
+		} else {
+			better = better || effort.Time < entry.Time
+		}
+		if better {
+			entry.Distance = effort.Distance
+			entry.Time = effort.Time
+			entry.AdjustedDistance = adjustedDistance
+			entry.AdjustedTime = adjustedTime
+			entry.BestWorkoutID = workout.ID
+		}
+		return true
+	}
+	return false
+}
+
+// Leaderboard ranks entries after filtering them
+func (c *Challenge) Leaderboard(entries []*Entry, filter LeaderboardFilter) *Leaderboard {
+	board := &Leaderboard{Challenge: c, Adjusted: filter.Adjusted, Entries: []RankedEntry{}}
+
+	for _, entry := range entries {
+		if entry.BirthYear > 0 {
+			entry.Age = c.End.Year() - entry.BirthYear
+		}
+		if !filter.matches(entry) {
+			continue
+		}
+		board.Entries = append(board.Entries, RankedEntry{Entry: entry})
+	}
+
+	score := c.score(filter.Adjusted)
+	sort.SliceStable(board.Entries, func(i, j int) bool {
+		return score(board.Entries[i].Entry) > score(board.Entries[j].Entry)
+	})
+	for i := range board.Entries {
+		board.Entries[i].Rank = i + 1
+		if i > 0 && score(board.Entries[i].Entry) == score(board.Entries[i-1].Entry) {
+			board.Entries[i].Rank = board.Entries[i-1].Rank
+		}
+	}
+
+	if c.Scoring == ScoringTeam {
+		board.Teams = c.teamStandings(board.Entries, score)
+	}
+	return board
+}
+
+// teamStandings totals ranked entries by team
+func (c *Challenge) teamStandings(entries []RankedEntry, score func(*Entry) float64) []TeamStanding {
+	standings := make([]TeamStanding, len(c.Teams))
+	index := make(map[string]int, len(c.Teams))
+	for i, team := range c.Teams {
+		standings[i] = TeamStanding{TeamID: team.ID, Name: team.Name}
+		index[team.ID] = i
+	}
+
+	for _, entry := range entries {
+		i, ok := index[entry.TeamID]
+		if !ok {
+			continue
+		}
+		standings[i].Distance = round1(standings[i].Distance + score(entry.Entry))
+		standings[i].Members++
+	}
+
+	sort.SliceStable(standings, func(i, j int) bool {
+		return standings[i].Distance > standings[j].Distance
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+		if i > 0 && standings[i].Distance == standings[i-1].Distance {
+			standings[i].Rank = standings[i-1].Rank
+		}
+	}
+	return standings
+}
+
+// score returns a function giving an entry's score, higher being better
+func (c *Challenge) score(adjusted bool) func(*Entry) float64 {
+	if c.Kind == ChallengeEvent {
+		if event := c.event(); event != nil && event.Kind == EventDistance {
+			return func(e *Entry) float64 {
+				if adjusted {
+					return -e.AdjustedTime
+				}
+				return -e.Time
+			}
+		}
+	}
+	return func(e *Entry) float64 {
+		if adjusted {
+			return e.AdjustedDistance
+		}
+		return e.Distance
+	}
+}
+
+// event returns the challenge's event, or nil if it isn't one
+func (c *Challenge) event() *Event {
+	for i := range Events {
+		if Events[i].Name == c.Event {
+			return &Events[i]
+		}
+	}
+	return nil
+}
+
+// matches reports whether an entry passes the filter
+func (f LeaderboardFilter) matches(entry *Entry) bool {
+	if f.WeightClass != "" && entry.WeightClass != f.WeightClass {
+		return false
+	}
+	if (f.MinAge > 0 || f.MaxAge > 0) && entry.Age == 0 {
+		return false
+	}
+	if f.MinAge > 0 && entry.Age < f.MinAge {
+		return false
+	}
+	if f.MaxAge > 0 && entry.Age > f.MaxAge {
+		return false
+	}
+	return f.TeamID == "" || entry.TeamID == f.TeamID
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	challengeStart = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	challengeEnd   = time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// newTestChallenge returns a challenge running through March 2026
+func newTestChallenge(kind, event string) *Challenge {
+	return &Challenge{
+		Kind:    kind,
+		Event:   event,
+		Scoring: ScoringIndividual,
+		TeamIDs: []string{"host", "guest"},
+		Teams:   []ChallengeTeam{{ID: "host", Name: "Host"}, {ID: "guest", Name: "Guest"}},
+		Start:   challengeStart,
+		End:     challengeEnd,
+	}
+}
+
+func TestApplyDistance(t *testing.T) {
+	c := newTestChallenge(ChallengeDistance, "")
+	entry := &Entry{}
+
+	first := &Workout{ID: "w1", StartedAt: challengeStart.Add(time.Hour), Distance: 5000, AdjustedDistance: 5100}
+	second := &Workout{ID: "w2", StartedAt: challengeStart.Add(48 * time.Hour), Distance: 2000}
+
+	if !c.Apply(entry, first) || !c.Apply(entry, second) {
+		t.Fatal("workouts within the challenge didn't count")
+	}
+	if entry.Distance != 7000 || entry.AdjustedDistance != 7100 || entry.Workouts != 2 {
+		t.Errorf("entry is %.0fm (%.0fm adjusted) over %d workouts, want 7000m (7100m) over 2", entry.Distance, entry.AdjustedDistance, entry.Workouts)
+	}
+
+	// Entering a workout again doesn't count it twice
+	if c.Apply(entry, first) {
+		t.Error("a workout already counted changed the entry")
+	}
+	if entry.Distance != 7000 || entry.Workouts != 2 {
+		t.Errorf("entry is %.0fm over %d workouts after a repeat, want 7000m over 2", entry.Distance, entry.Workouts)
+	}
+
+	// Nor do workouts outside the challenge's dates
+	for _, startedAt := range []time.Time{challengeStart.Add(-time.Second), challengeEnd} {
+		if c.Apply(entry, &Workout{ID: startedAt.String(), StartedAt: startedAt, Distance: 1000}) {
+			t.Errorf("workout started %s counted", startedAt)
+		}
+	}
+}
+
+func TestApplyEvent(t *testing.T) {
+	c := newTestChallenge(ChallengeEvent, "2k")
+	entry := &Entry{}
+
+	twoK := func(id string, seconds float64) *Workout {
+		return &Workout{ID: id, StartedAt: challengeStart.Add(time.Hour), BestEfforts: []Effort{
+			{Event: "500m", Kind: EventDistance, Distance: 500, Time: seconds / 4.2},
+			{Event: "2k", Kind: EventDistance, Distance: 2000, Time: seconds},
+		}}
+	}
+
+	tests := []struct {
+		workout  *Workout
+		counts   bool
+		wantBest string
+		wantTime float64
+		workouts int
+	}{
+		{twoK("slow", 420), true, "slow", 420, 1},
+		{twoK("fast", 400), true, "fast", 400, 2},
+		{twoK("slower", 410), true, "fast", 400, 3},
+		{twoK("slow", 420), false, "fast", 400, 3}, // already counted
+		{&Workout{ID: "short", StartedAt: challengeStart.Add(time.Hour), BestEfforts: []Effort{
+			{Event: "1k", Kind: EventDistance, Distance: 1000, Time: 190},
+		}}, false, "fast", 400, 3},
+	}
+
+	for _, tt := range tests {
+		if got := c.Apply(entry, tt.workout); got != tt.counts {
+			t.Errorf("Apply(%s) = %v, want %v", tt.workout.ID, got, tt.counts)
+		}
+		if entry.BestWorkoutID != tt.wantBest || entry.Time != tt.wantTime || entry.Workouts != tt.workouts {
+			t.Errorf("after %s the best is %s in %.0fs over %d workouts, want %s in %.0fs over %d",
+				tt.workout.ID, entry.BestWorkoutID, entry.Time, entry.Workouts, tt.wantBest, tt.wantTime, tt.workouts)
+		}
+	}
+
+	// Without a weight the adjusted time is the raw one
+	if entry.AdjustedTime != 400 {
+		t.Errorf("adjusted time is %.0fs, want the raw 400s", entry.AdjustedTime)
+	}
+}
+
+// ranks returns the user IDs and ranks of a leaderboard's entries
+func ranks(board *Leaderboard) map[string]int {
+	ranks := make(map[string]int, len(board.Entries))
+	for _, entry := range board.Entries {
+		ranks[entry.UserID] = entry.Rank
+	}
+	return ranks
+}
+
+func TestLeaderboardRanking(t *testing.T) {
+	c := newTestChallenge(ChallengeDistance, "")
+	entries := []*Entry{
+		{UserID: "a", TeamID: "host", Distance: 8000, AdjustedDistance: 7600},
+		{UserID: "b", TeamID: "guest", Distance: 10000, AdjustedDistance: 9000},
+		{UserID: "c", TeamID: "host", Distance: 8000, AdjustedDistance: 9500},
+	}
+
+	board := c.Leaderboard(entries, LeaderboardFilter{})
+	want := map[string]int{"b": 1, "a": 2, "c": 2}
+	for uid, rank := range ranks(board) {
+		if want[uid] != rank {
+			t.Errorf("%s ranked %d, want %d", uid, rank, want[uid])
+		}
+	}
+	if board.Entries[0].UserID != "b" {
+		t.Errorf("leaderboard led by %s, want b", board.Entries[0].UserID)
+	}
+
+	board = c.Leaderboard(entries, LeaderboardFilter{Adjusted: true})
+	got := []string{board.Entries[0].UserID, board.Entries[1].UserID, board.Entries[2].UserID}
+	if got[0] != "c" || got[1] != "b" || got[2] != "a" {
+		t.Errorf("adjusted order is %v, want [c b a]", got)
+	}
+}
+
+func TestLeaderboardRanksDistanceEventsByTime(t *testing.T) {
+	c := newTestChallenge(ChallengeEvent, "2k")
+	entries := []*Entry{
+		{UserID: "slow", Distance: 2000, Time: 430},
+		{UserID: "fast", Distance: 2000, Time: 395},
+	}
+
+	board := c.Leaderboard(entries, LeaderboardFilter{})
+	if board.Entries[0].UserID != "fast" || board.Entries[0].Rank != 1 {
+		t.Errorf("leaderboard led by %s, want the fastest 2k", board.Entries[0].UserID)
+	}
+}
+
+func TestLeaderboardTeamStandings(t *testing.T) {
+	c := newTestChallenge(ChallengeDistance, "")
+	c.Scoring = ScoringTeam
+	entries := []*Entry{
+		{UserID: "a", TeamID: "host", Distance: 5000},
+		{UserID: "b", TeamID: "guest", Distance: 12000},
+		{UserID: "c", TeamID: "host", Distance: 6000},
+	}
+
+	board := c.Leaderboard(entries, LeaderboardFilter{})
+	if len(board.Teams) != 2 {
+		t.Fatalf("got %d team standings, want 2", len(board.Teams))
+	}
+	if got := board.Teams[0]; got.TeamID != "guest" || got.Distance != 12000 || got.Members != 1 {
+		t.Errorf("first team is %+v, want guest with 12000m from 1 member", got)
+	}
+	if got := board.Teams[1]; got.TeamID != "host" || got.Distance != 11000 || got.Members != 2 || got.Rank != 2 {
+		t.Errorf("second team is %+v, want host ranked 2 with 11000m from 2 members", got)
+	}
+}
+
+func TestLeaderboardFilters(t *testing.T) {
+	c := newTestChallenge(ChallengeDistance, "")
+
+	tests := []struct {
+		name   string
+		filter LeaderboardFilter
+		want   []string
+	}{
+		{"none", LeaderboardFilter{}, []string{"junior", "master", "unknown"}},
+		{"weight class", LeaderboardFilter{WeightClass: "lightweight"}, []string{"junior"}},
+		{"minimum age", LeaderboardFilter{MinAge: 40}, []string{"master"}},
+		{"maximum age", LeaderboardFilter{MaxAge: 20}, []string{"junior"}},
+		{"age range excludes unknown ages", LeaderboardFilter{MinAge: 18, MaxAge: 100}, []string{"junior", "master"}},
+		{"team", LeaderboardFilter{TeamID: "guest"}, []string{"unknown"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The challenge ends in 2026, giving ages of 19 and 46
+			entries := []*Entry{
+				{UserID: "junior", TeamID: "host", WeightClass: "lightweight", BirthYear: 2007, Distance: 3000},
+				{UserID: "master", TeamID: "host", WeightClass: "heavyweight", BirthYear: 1980, Distance: 2000},
+				{UserID: "unknown", TeamID: "guest", Distance: 1000},
+			}
+
+			board := c.Leaderboard(entries, tt.filter)
+			if len(board.Entries) != len(tt.want) {
+				t.Fatalf("got %d entries, want %v", len(board.Entries), tt.want)
+			}
+			for i, uid := range tt.want {
+				if board.Entries[i].UserID != uid {
+					t.Errorf("entry %d is %s, want %s", i, board.Entries[i].UserID, uid)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"math"
+)
+
+// CurveDurations are the durations, in seconds, curves are built for
+var CurveDurations = []int{
+	5, 10, 15, 30, 60, 120, 180, 240, 300, 360, 480, 600, 720, 900, 1200, 1800, 2400, 3000, 3600,
+}
+
+// Durations critical power is fitted over. Shorter efforts draw too much
+// on anaerobic capacity and longer ones fall off the two-parameter model.
+const (
+	cpMinDuration = 120  // seconds
+	cpMaxDuration = 1200 // seconds
+	cpMinPoints   = 3
+)
+
+// CurvePoint is the best average power and pace held for a duration.
+// Combined curves name the workout each best came from.
+type CurvePoint struct {
+	Duration       int     `json:"duration" firestore:"duration"` // seconds
+	Power          float64 `json:"power" firestore:"power"`       // mean-maximal watts
+	Pace           float64 `json:"pace" firestore:"pace"`         // best seconds per 500m
+	Distance       float64 `json:"distance" firestore:"distance"` // meters rowed at that pace
+	PowerWorkoutID string  `json:"power_workout_id,omitempty" firestore:"-"`
+	PaceWorkoutID  string  `json:"pace_workout_id,omitempty" firestore:"-"`
+}
+
+// CriticalPower is the two-parameter critical power model fitted to a
+// curve: the work done in t seconds is CP × t + W'
+type CriticalPower struct {
+	CP     float64 `json:"cp"`      // watts sustainable without drawing on W'
+	WPrime float64 `json:"w_prime"` // joules available above CP
+	R2     float64 `json:"r2"`      // fit of the model to the points used
+	Points int     `json:"points"`  // curve points the model was fitted to
+}
+
+// BuildCurve finds the best power and pace for every curve duration the
+// samples cover
+func BuildCurve(samples []WorkoutSample) []CurvePoint {
+	curve := []CurvePoint{}
+	if len(samples) < 2 {
+		return curve
+	}
+
+	watts := powerSeries(samples)
+	for _, duration := range CurveDurations {
+		if duration > len(watts) {
+			break
+		}
+
+		point := CurvePoint{Duration: duration, Power: round1(meanMaximal(watts, duration))}
+		if effort := furthestIn(samples, float64(duration)); effort != nil && effort.Distance > 0 {
+			point.Distance = effort.Distance
+			point.Pace = round1(float64(duration) / effort.Distance * 500)
+		}
+		curve = append(curve, point)
+	}
+	return curve
+}
+
+// meanMaximal is the highest average of any window of n values
+func meanMaximal(values []float64, n int) float64 {
+	var sum, best float64
+	for i, v := range values {
+		sum += v
+		if i >= n {
+			sum -= values[i-n]
+		}
+		if i >= n-1 && sum > best {
+			best = sum
+		}
+	}
+	return best / float64(n)
+}
+
+// BestCurve combines the curves of several workouts, keeping the best
+// power and pace for each duration
+func BestCurve(workouts []*Workout) []CurvePoint {
+	best := make(map[int]*CurvePoint)
+	for _, w := range workouts {
+		for _, point := range w.Curve {
+			current, ok := best[point.Duration]
+			if !ok {
+				current = &CurvePoint{Duration: point.Duration}
+				best[point.Duration] = current
+			}
+			if point.Power > current.Power {
+				current.Power = point.Power
+				current.PowerWorkoutID = w.ID
+			}
+			if point.Pace > 0 && (current.Pace == 0 || point.Pace < current.Pace) {
+				current.Pace = point.Pace
+				current.Distance = point.Distance
+				current.PaceWorkoutID = w.ID
+			}
+		}
+	}
+
+	curve := []CurvePoint{}
+	for _, duration := range CurveDurations {
+		if point, ok := best[duration]; ok {
+			curve = append(curve, *point)
+		}
+	}
+	return curve
+}
+
+// EstimateCriticalPower fits the critical power model to the curve's
+// points between 2 and 20 minutes by least squares of work against time.
+// It returns nil when there are too few points or the fit is implausible.
+func EstimateCriticalPower(curve []CurvePoint) *CriticalPower {
+	var xs, ys []float64
+	for _, point := range curve {
+		if point.Duration < cpMinDuration || point.Duration > cpMaxDuration || point.Power <= 0 {
+			continue
+		}
+		xs = append(xs, float64(point.Duration))
+		ys = append(ys, point.Power*float64(point.Duration))
+	}
+	if len(xs) < cpMinPoints {
+		return nil
+	}
+
+	n := float64(len(xs))
+	var sumX, sumY, sumXY, sumXX float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+		sumXY += xs[i] * ys[i]
+		sumXX += xs[i] * xs[i]
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return nil
+	}
+	cp := (n*sumXY - sumX*sumY) / denominator
+	wPrime := (sumY - cp*sumX) / n
+	if cp <= 0 || wPrime < 0 {
+		return nil
+	}
+
+	meanY := sumY / n
+	var residual, total float64
+	for i := range xs {
+		predicted := cp*xs[i] + wPrime
+		residual += (ys[i] - predicted) * (ys[i] - predicted)
+		total += (ys[i] - meanY) * (ys[i] - meanY)
+	}
+	r2 := 1.0
+	if total > 0 {
+		r2 = 1 - residual/total
+	}
+
+	return &CriticalPower{
+		CP:     round1(cp),
+		WPrime: math.Round(wPrime),
+		R2:     math.Round(r2*1000) / 1000,
+		Points: len(xs),
+	}
+}
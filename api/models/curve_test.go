@@ -0,0 +1,91 @@
+package models
+
+import "testing"
+
+// modelCurve returns a curve whose power at every duration follows the
+// critical power model exactly
+func modelCurve(cp, wPrime float64) []CurvePoint {
+	curve := []CurvePoint{}
+	for _, duration := range CurveDurations {
+		curve = append(curve, CurvePoint{Duration: duration, Power: cp + wPrime/float64(duration)})
+	}
+	return curve
+}
+
+func TestEstimateCriticalPower(t *testing.T) {
+	got := EstimateCriticalPower(modelCurve(250, 20000))
+	// Only the 10 points from 2 to 20 minutes are fitted
+	want := CriticalPower{CP: 250, WPrime: 20000, R2: 1, Points: 10}
+	if got == nil || *got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// Points outside the fitted durations don't move the estimate
+	curve := modelCurve(250, 20000)
+	curve[0].Power = 2000
+	curve[len(curve)-1].Power = 50
+	if got := EstimateCriticalPower(curve); got == nil || *got != want {
+		t.Errorf("with outliers outside the window got %+v, want %+v", got, want)
+	}
+}
+
+func TestEstimateCriticalPowerRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		curve []CurvePoint
+	}{
+		{"no points", nil},
+		{"too few points", []CurvePoint{{Duration: 120, Power: 400}, {Duration: 300, Power: 320}}},
+		{"too few in the window", []CurvePoint{
+			{Duration: 60, Power: 500}, {Duration: 120, Power: 400}, {Duration: 300, Power: 320}, {Duration: 1800, Power: 240},
+		}},
+		{"missing power", []CurvePoint{{Duration: 120, Power: 400}, {Duration: 300, Power: 320}, {Duration: 600}}},
+		// Power rising with duration would need negative W'
+		{"implausible", []CurvePoint{{Duration: 120, Power: 200}, {Duration: 300, Power: 250}, {Duration: 600, Power: 300}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateCriticalPower(tt.curve); got != nil {
+				t.Errorf("got %+v, want no estimate", got)
+			}
+		})
+	}
+}
+
+func TestBuildCurve(t *testing.T) {
+	// Ten minutes at 200W and 2:00/500m with a minute at 400W and 1:40 in
+	// the middle
+	var samples []WorkoutSample
+	var distance float64
+	for i := 0; i <= 600; i++ {
+		watts, pace := uint32(200), 120.0
+		if i > 300 && i <= 360 {
+			watts, pace = 400, 100
+		}
+		if i > 0 {
+			distance += 500 / pace
+		}
+		samples = append(samples, WorkoutSample{Time: float64(i), Distance: distance, Power: watts, Pace: pace})
+	}
+
+	curve := BuildCurve(samples)
+	if last := curve[len(curve)-1]; last.Duration != 600 {
+		t.Fatalf("curve ends at %ds, want 600s", last.Duration)
+	}
+
+	want := map[int]CurvePoint{
+		5:   {Duration: 5, Power: 400, Pace: 100, Distance: 25},
+		60:  {Duration: 60, Power: 400, Pace: 100, Distance: 300},
+		120: {Duration: 120, Power: 300, Pace: 109.1, Distance: 550},
+		600: {Duration: 600, Power: 220, Pace: 117.6, Distance: 2550},
+	}
+	for _, point := range curve {
+		if w, ok := want[point.Duration]; ok && point != w {
+			t.Errorf("%ds: got %+v, want %+v", point.Duration, point, w)
+		}
+	}
+
+	if got := BuildCurve(samples[:1]); got == nil || len(got) != 0 {
+		t.Errorf("curve of one sample %v, want empty", got)
+	}
+}
@@ -0,0 +1,217 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LayoutVersion is the dashboard layout schema the server accepts. Layouts
+// saved by older clients must be migrated before they are saved again.
+const LayoutVersion = 1
+
+const (
+	// Grid bounds for widget positions
+	maxLayoutColumns = 24
+	maxWidgetHeight  = 24
+	maxWidgets       = 50
+
+	// maxWidgetTitleLength bounds a widget's title
+	maxWidgetTitleLength = 60
+)
+
+// Widget types and whether they display a bound metric
+var widgetTypes = map[string]bool{
+	"metric":    true,  // a single live value
+	"chart":     true,  // a value over the workout
+	"gauge":     true,  // a value against its zones
+	"splits":    false, // split table
+	"pace_boat": false, // position against the pace boat
+	"zones":     false, // time in zone
+	"race":      false, // race lanes
+	"crew":      false, // coach crew feed
+}
+
+// metricUnits lists the units each metric can be shown in, the first being
+// the default
+var metricUnits = map[string][]string{
+	"pace":            {"per_500m", "per_km", "per_mile"},
+	"avg_pace":        {"per_500m", "per_km", "per_mile"},
+	"power":           {"W"},
+	"avg_power":       {"W"},
+	"stroke_rate":     {"spm"},
+	"avg_stroke_rate": {"spm"},
+	"heart_rate":      {"bpm", "percent_max"},
+	"avg_heart_rate":  {"bpm", "percent_max"},
+	"distance":        {"m", "km", "mi"},
+	"elapsed_time":    {"hms", "s"},
+	"calories":        {"kcal"},
+	"heart_rate_zone": {"zone"},
+	"power_zone":      {"zone"},
+}
+
+// Widget is a dashboard tile placed on a grid
+type Widget struct {
+	ID     string `json:"id" firestore:"id"`
+	Type   string `json:"type" firestore:"type"`
+	Title  string `json:"title,omitempty" firestore:"title"`
+	X      int    `json:"x" firestore:"x"` // column, from 0
+	Y      int    `json:"y" firestore:"y"` // row, from 0
+	W      int    `json:"w" firestore:"w"` // columns spanned
+	H      int    `json:"h" firestore:"h"` // rows spanned
+	Metric string `json:"metric,omitempty" firestore:"metric"`
+	Unit   string `json:"unit,omitempty" firestore:"unit"`
+}
+
+// Layout is a versioned arrangement of widgets
+type Layout struct {
+	Version int      `json:"version" firestore:"version"`
+	Columns int      `json:"columns" firestore:"columns"`
+	Widgets []Widget `json:"widgets" firestore:"widgets"`
+}
+
+// Dashboard is a user's named widget layout
+type Dashboard struct {
+	ID         string    `json:"id" firestore:"-"`
+	Name       string    `json:"name" firestore:"name"`
+	Layout     Layout    `json:"layout" firestore:"layout"`
+	ShareToken string    `json:"share_token,omitempty" firestore:"share_token"` // set while shared by link
+	CreatedAt  time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// Validate checks the dashboard against the layout schema, filling in
+// default units
+func (d *Dashboard) Validate() error {
+	var errs []error
+
+	if d.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if len(d.Name) > maxTemplateNameLength {
+		errs = append(errs, fmt.Errorf("name must be at most %d characters", maxTemplateNameLength))
+	}
+	if err := d.Layout.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the layout against the schema, filling in default units
+func (l *Layout) Validate() error {
+	if l.Version != LayoutVersion {
+		return fmt.Errorf("layout version %d is not supported, expected %d", l.Version, LayoutVersion)
+	}
+	if l.Columns < 1 || l.Columns > maxLayoutColumns {
+		return fmt.Errorf("layout columns must be between 1 and %d", maxLayoutColumns)
+	}
+	if len(l.Widgets) > maxWidgets {
+		return fmt.Errorf("at most %d widgets are allowed", maxWidgets)
+	}
+
+	var errs []error
+	ids := make(map[string]bool, len(l.Widgets))
+	for i := range l.Widgets {
+		widget := &l.Widgets[i]
+		if err := widget.validate(l.Columns); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ids[widget.ID] {
+			errs = append(errs, fmt.Errorf("widget id %q is used more than once", widget.ID))
+		}
+		ids[widget.ID] = true
+
+		for j := 0; j < i; j++ {
+			if widget.overlaps(&l.Widgets[j]) {
+				errs = append(errs, fmt.Errorf("widget %q overlaps widget %q", widget.ID, l.Widgets[j].ID))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks a widget's type, binding and position
+func (w *Widget) validate(columns int) error {
+	if w.ID == "" {
+		return errors.New("every widget needs an id")
+	}
+
+	bound, ok := widgetTypes[w.Type]
+	if !ok {
+		return fmt.Errorf("widget %q has unknown type %q", w.ID, w.Type)
+	}
+	if len(w.Title) > maxWidgetTitleLength {
+		return fmt.Errorf("widget %q title must be at most %d characters", w.ID, maxWidgetTitleLength)
+	}
+
+	if bound {
+		units, ok := metricUnits[w.Metric]
+		if !ok {
+			return fmt.Errorf("widget %q has unknown metric %q", w.ID, w.Metric)
+		}
+		if w.Unit == "" {
+			w.Unit = units[0]
+		} else if !contains(units, w.Unit) {
+			return fmt.Errorf("widget %q can't show %s in %q", w.ID, w.Metric, w.Unit)
+		}
+	} else if w.Metric != "" || w.Unit != "" {
+		return fmt.Errorf("widget %q of type %s doesn't take a metric", w.ID, w.Type)
+	}
+
+	if w.X < 0 || w.Y < 0 || w.W < 1 || w.H < 1 || w.H > maxWidgetHeight || w.X+w.W > columns {
+		return fmt.Errorf("widget %q doesn't fit the %d column grid", w.ID, columns)
+	}
+	return nil
+}
+
+// overlaps reports whether two widgets share a grid cell
+func (w *Widget) overlaps(other *Widget) bool {
+	return w.X < other.X+other.W && other.X < w.X+w.W &&
+		w.Y < other.Y+other.H && other.Y < w.Y+w.H
+}
+
+// contains reports whether values includes value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultDashboards are given to new users
+func DefaultDashboards() []*Dashboard {
+	return []*Dashboard{
+		{
+			Name: "Live",
+			Layout: Layout{
+				Version: LayoutVersion,
+				Columns: 12,
+				Widgets: []Widget{
+					{ID: "pace", Type: "metric", X: 0, Y: 0, W: 6, H: 2, Metric: "pace", Unit: "per_500m"},
+					{ID: "power", Type: "metric", X: 6, Y: 0, W: 3, H: 2, Metric: "power", Unit: "W"},
+					{ID: "rate", Type: "metric", X: 9, Y: 0, W: 3, H: 2, Metric: "stroke_rate", Unit: "spm"},
+					{ID: "distance", Type: "metric", X: 0, Y: 2, W: 4, H: 1, Metric: "distance", Unit: "m"},
+					{ID: "time", Type: "metric", X: 4, Y: 2, W: 4, H: 1, Metric: "elapsed_time", Unit: "hms"},
+					{ID: "heart-rate", Type: "metric", X: 8, Y: 2, W: 4, H: 1, Metric: "heart_rate", Unit: "bpm"},
+					{ID: "pace-chart", Type: "chart", X: 0, Y: 3, W: 12, H: 3, Metric: "pace", Unit: "per_500m"},
+				},
+			},
+		},
+		{
+			Name: "Training Zones",
+			Layout: Layout{
+				Version: LayoutVersion,
+				Columns: 12,
+				Widgets: []Widget{
+					{ID: "heart-rate", Type: "gauge", X: 0, Y: 0, W: 6, H: 3, Metric: "heart_rate", Unit: "bpm"},
+					{ID: "power", Type: "gauge", X: 6, Y: 0, W: 6, H: 3, Metric: "power", Unit: "W"},
+					{ID: "zones", Type: "zones", X: 0, Y: 3, W: 12, H: 2},
+					{ID: "splits", Type: "splits", X: 0, Y: 5, W: 12, H: 3},
+				},
+			},
+		},
+	}
+}
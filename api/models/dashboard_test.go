@@ -0,0 +1,105 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+// testDashboard returns a dashboard that passes validation
+func testDashboard() *Dashboard {
+	return &Dashboard{
+		Name: "Intervals",
+		Layout: Layout{
+			Version: LayoutVersion,
+			Columns: 12,
+			Widgets: []Widget{
+				{ID: "pace", Type: "metric", X: 0, Y: 0, W: 6, H: 2, Metric: "pace"},
+				{ID: "hr", Type: "gauge", X: 6, Y: 0, W: 6, H: 2, Metric: "heart_rate", Unit: "percent_max"},
+				{ID: "splits", Type: "splits", X: 0, Y: 2, W: 12, H: 4},
+			},
+		},
+	}
+}
+
+func TestDashboardValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(d *Dashboard)
+		error  string // substring of the expected error, "" if valid
+	}{
+		{"valid", func(d *Dashboard) {}, ""},
+		{"no name", func(d *Dashboard) { d.Name = "" }, "name is required"},
+		{"long name", func(d *Dashboard) { d.Name = strings.Repeat("a", maxTemplateNameLength+1) }, "name must be at most"},
+		{"old version", func(d *Dashboard) { d.Layout.Version = 0 }, "layout version 0 is not supported"},
+		{"no columns", func(d *Dashboard) { d.Layout.Columns = 0 }, "columns must be between"},
+		{"too many columns", func(d *Dashboard) { d.Layout.Columns = maxLayoutColumns + 1 }, "columns must be between"},
+		{"too many widgets", func(d *Dashboard) { d.Layout.Widgets = make([]Widget, maxWidgets+1) }, "at most 50 widgets"},
+		{"no id", func(d *Dashboard) { d.Layout.Widgets[0].ID = "" }, "needs an id"},
+		{"duplicate id", func(d *Dashboard) { d.Layout.Widgets[2].ID = "pace" }, `widget id "pace" is used more than once`},
+		{"unknown type", func(d *Dashboard) { d.Layout.Widgets[2].Type = "clock" }, `unknown type "clock"`},
+		{"long title", func(d *Dashboard) { d.Layout.Widgets[0].Title = strings.Repeat("a", maxWidgetTitleLength+1) }, "title must be at most"},
+		{"unknown metric", func(d *Dashboard) { d.Layout.Widgets[0].Metric = "speed" }, `unknown metric "speed"`},
+		{"no metric", func(d *Dashboard) { d.Layout.Widgets[0].Metric = "" }, `unknown metric ""`},
+		{"wrong unit", func(d *Dashboard) { d.Layout.Widgets[1].Unit = "W" }, `can't show heart_rate in "W"`},
+		{"metric on an unbound widget", func(d *Dashboard) { d.Layout.Widgets[2].Metric = "pace" }, "doesn't take a metric"},
+		{"off the left", func(d *Dashboard) { d.Layout.Widgets[0].X = -1 }, "doesn't fit the 12 column grid"},
+		{"off the right", func(d *Dashboard) { d.Layout.Widgets[1].X = 7 }, "doesn't fit"},
+		{"no width", func(d *Dashboard) { d.Layout.Widgets[0].W = 0 }, "doesn't fit"},
+		{"too tall", func(d *Dashboard) { d.Layout.Widgets[2].H = maxWidgetHeight + 1 }, "doesn't fit"},
+		{"overlapping", func(d *Dashboard) { d.Layout.Widgets[2].Y = 1 }, `widget "splits" overlaps widget "pace"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := testDashboard()
+			tt.modify(d)
+			err := d.Validate()
+			if tt.error == "" {
+				if err != nil {
+					t.Fatalf("got %v, want valid", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.error)
+			}
+		})
+	}
+}
+
+func TestDashboardValidateReportsEveryWidget(t *testing.T) {
+	d := testDashboard()
+	d.Name = ""
+	d.Layout.Widgets[0].Type = "clock"
+	d.Layout.Widgets[1].Unit = "W"
+
+	err := d.Validate()
+	if err == nil {
+		t.Fatal("got valid")
+	}
+	for _, want := range []string{"name is required", `"pace" has unknown type`, `"hr" can't show`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't report %q", err, want)
+		}
+	}
+}
+
+func TestWidgetDefaultUnit(t *testing.T) {
+	d := testDashboard()
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if unit := d.Layout.Widgets[0].Unit; unit != "per_500m" {
+		t.Errorf("pace widget unit %q, want the default per_500m", unit)
+	}
+	if unit := d.Layout.Widgets[1].Unit; unit != "percent_max" {
+		t.Errorf("heart rate widget unit %q, want the one chosen", unit)
+	}
+}
+
+func TestDefaultDashboardsValid(t *testing.T) {
+	for _, d := range DefaultDashboards() {
+		if err := d.Validate(); err != nil {
+			t.Errorf("default dashboard %q: %v", d.Name, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// Integration providers
+const (
+	ProviderStrava   = "strava"
+	ProviderConcept2 = "concept2"
+)
+
+// Upload statuses
+const (
+	UploadPending    = "pending"    // waiting to be sent
+	UploadProcessing = "processing" // sent, waiting for the provider
+	UploadComplete   = "complete"
+	UploadFailed     = "failed"
+)
+
+// Import statuses
+const (
+	ImportRunning  = "running"
+	ImportComplete = "complete"
+	ImportFailed   = "failed"
+)
+
+// tokenExpiryMargin is how long before expiry an access token is refreshed
+const tokenExpiryMargin = time.Minute
+
+// Connection links a user to their account with a provider. Tokens are
+// never returned by the API.
+type Connection struct {
+	Provider     string    `json:"provider" firestore:"-"`
+	AthleteID    string    `json:"athlete_id" firestore:"athlete_id"`
+	AthleteName  string    `json:"athlete_name,omitempty" firestore:"athlete_name"`
+	Scope        string    `json:"scope,omitempty" firestore:"scope"`
+	AutoUpload   bool      `json:"auto_upload" firestore:"auto_upload"`
+	AccessToken  string    `json:"-" firestore:"access_token"`
+	RefreshToken string    `json:"-" firestore:"refresh_token"`
+	ExpiresAt    time.Time `json:"-" firestore:"expires_at"`
+	Error        string    `json:"error,omitempty" firestore:"error"` // set when the provider rejects the tokens; reconnect to clear
+	ConnectedAt  time.Time `json:"connected_at" firestore:"connected_at"`
+
+	// The latest import of past results, for providers that have them
+	Import *Import `json:"import,omitempty" firestore:"import,omitempty"`
+}
+
+// Import tracks copying a user's past results from a provider
+type Import struct {
+	Status     string    `json:"status" firestore:"status"`
+	From       time.Time `json:"from" firestore:"from"`
+	To         time.Time `json:"to" firestore:"to"`
+	Imported   int       `json:"imported" firestore:"imported"`
+	Skipped    int       `json:"skipped" firestore:"skipped"` // already saved here
+	Error      string    `json:"error,omitempty" firestore:"error"`
+	StartedAt  time.Time `json:"started_at" firestore:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty" firestore:"finished_at"`
+}
+
+// Expired reports whether the access token should be refreshed before use
+func (c *Connection) Expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && now.Add(tokenExpiryMargin).After(c.ExpiresAt)
+}
+
+// Upload tracks sending a workout to a provider
+type Upload struct {
+	WorkoutID  string    `json:"workout_id" firestore:"-"`
+	Provider   string    `json:"provider" firestore:"provider"`
+	Status     string    `json:"status" firestore:"status"`
+	UploadID   string    `json:"upload_id,omitempty" firestore:"upload_id"`     // the provider's ID while processing
+	ActivityID string    `json:"activity_id,omitempty" firestore:"activity_id"` // the provider's ID once complete
+	URL        string    `json:"url,omitempty" firestore:"url"`                 // where to view the activity
+	Duplicate  bool      `json:"duplicate,omitempty" firestore:"duplicate"`     // the provider already had the workout, so it was linked instead
+	Error      string    `json:"error,omitempty" firestore:"error"`
+	Attempts   int       `json:"attempts" firestore:"attempts"`
+	CreatedAt  time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// Done reports whether the upload has finished, successfully or not
+func (u *Upload) Done() bool {
+	return u.Status == UploadComplete || u.Status == UploadFailed
+}
@@ -0,0 +1,241 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Load metrics
+const (
+	LoadTSS   = "tss"   // power-based training stress score
+	LoadTRIMP = "trimp" // heart rate training impulse
+)
+
+const (
+	// npWindow is the rolling average normalized power is built from
+	npWindow = 30 // seconds
+
+	// maxSampleGap bounds the time credited to one sample, so a stalled
+	// recording isn't counted as time at the last value seen
+	maxSampleGap = 5.0 // seconds
+
+	// Rolling windows for acute and chronic load
+	acuteDays   = 7
+	chronicDays = 28
+
+	// Time constants of the fitness and fatigue curves
+	fitnessDays = 42
+	fatigueDays = 7
+)
+
+// LoadDay is one day of a user's training load. Acute and chronic load are
+// rolling averages of daily load; fitness and fatigue are exponentially
+// weighted. Form is fitness less fatigue going into the day, before its
+// load, as training stress balance is usually given.
+type LoadDay struct {
+	Date     string  `json:"date"` // YYYY-MM-DD, UTC
+	Workouts int     `json:"workouts"`
+	Load     float64 `json:"load"`
+	Acute    float64 `json:"acute"`
+	Chronic  float64 `json:"chronic"`
+	Ratio    float64 `json:"ratio"` // acute:chronic, 0 without chronic load
+	Fitness  float64 `json:"fitness"`
+	Fatigue  float64 `json:"fatigue"`
+	Form     float64 `json:"form"`
+}
+
+// ValidLoadMetric reports whether metric is a known load metric
+func ValidLoadMetric(metric string) error {
+	if metric != LoadTSS && metric != LoadTRIMP {
+		return fmt.Errorf("metric must be %s or %s, got %q", LoadTSS, LoadTRIMP, metric)
+	}
+	return nil
+}
+
+// ComputeLoad sets the workout's training stress from its samples. TSS
+// needs the user's FTP and TRIMP their heart rate zones, so either may be
+// left at zero.
+func (w *Workout) ComputeLoad(settings *ZoneSettings) {
+	w.NormalizedPower, w.IntensityFactor, w.TSS, w.TRIMP = 0, 0, 0, 0
+	if len(w.Samples) < 2 || settings == nil {
+		return
+	}
+
+	if settings.FTP > 0 {
+		w.NormalizedPower = round1(normalizedPower(w.Samples))
+		if w.NormalizedPower > 0 {
+			intensity := w.NormalizedPower / float64(settings.FTP)
+			hours := (w.Samples[len(w.Samples)-1].Time - w.Samples[0].Time) / 3600
+			w.IntensityFactor = math.Round(intensity*1000) / 1000
+			w.TSS = round1(hours * intensity * intensity * 100)
+		}
+	}
+
+	if zones := settings.Zones().HeartRate; len(zones) > 0 {
+		w.TRIMP = round1(trimp(w.Samples, zones))
+	}
+}
+
+// normalizedPower is the fourth root of the mean fourth power of the 30
+// second rolling average power. Pieces shorter than the window fall back
+// to their average power.
+func normalizedPower(samples []WorkoutSample) float64 {
+	watts := powerSeries(samples)
+	seconds := len(watts)
+	if seconds == 0 {
+		return 0
+	}
+
+	if seconds < npWindow {
+		var total float64
+		for _, p := range watts {
+			total += p
+		}
+		return total / float64(seconds)
+	}
+
+	var sum, fourth float64
+	for i, p := range watts {
+		sum += p
+		if i >= npWindow {
+			sum -= watts[i-npWindow]
+		}
+		if i >= npWindow-1 {
+			avg := sum / npWindow
+			fourth += avg * avg * avg * avg
+		}
+	}
+	return math.Pow(fourth/float64(seconds-npWindow+1), 0.25)
+}
+
+// powerSeries resamples power to one value per second, holding each
+// sample's power until the next. Samples read back from InfluxDB haven't
+// been validated, so the series stops at maxWorkoutTime.
+func powerSeries(samples []WorkoutSample) []float64 {
+	start := samples[0].Time
+	seconds := samples[len(samples)-1].Time - start
+	if seconds < 0 {
+		seconds = 0
+	}
+	if seconds > maxWorkoutTime {
+		seconds = maxWorkoutTime
+	}
+	watts := make([]float64, int(seconds))
+
+	next := 0
+	for i := range watts {
+		t := start + float64(i) + 1
+		for next+1 < len(samples) && samples[next+1].Time <= t {
+			next++
+		}
+		watts[i] = float64(samples[next].Power)
+	}
+	return watts
+}
+
+// trimp is Edwards' training impulse: minutes in each heart rate zone
+// weighted by the zone number
+func trimp(samples []WorkoutSample, zones []Zone) float64 {
+	var total float64
+	for i := 1; i < len(samples); i++ {
+		dt := samples[i].Time - samples[i-1].Time
+		if dt <= 0 || dt > maxSampleGap {
+			continue
+		}
+
+		hr := int(samples[i].HeartRate)
+		if hr == 0 {
+			continue
+		}
+		for _, zone := range zones {
+			if hr >= zone.Min && (zone.Max == 0 || hr < zone.Max) {
+				total += float64(zone.Zone) * dt / 60
+				break
+			}
+		}
+	}
+	return total
+}
+
+// workoutLoad returns a workout's load by metric
+func workoutLoad(w *Workout, metric string) float64 {
+	if metric == LoadTRIMP {
+		return w.TRIMP
+	}
+	return w.TSS
+}
+
+// TrainingLoad builds daily load from workouts for the days in [from, to).
+// Workouts before from still count towards the rolling values, so callers
+// should include enough history for them to settle.
+func TrainingLoad(workouts []*Workout, metric string, from, to time.Time) []LoadDay {
+	from = day(from)
+	to = day(to.Add(-time.Nanosecond)).AddDate(0, 0, 1)
+
+	// Start from the earliest workout so its load carries forward
+	start := from
+	daily := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, w := range workouts {
+		d := day(w.StartedAt)
+		if !d.Before(to) {
+			continue
+		}
+		if d.Before(start) {
+			start = d
+		}
+		key := d.Format(time.DateOnly)
+		daily[key] += workoutLoad(w, metric)
+		counts[key]++
+	}
+
+	days := []LoadDay{}
+	var history []float64
+	var fitness, fatigue float64
+	for d := start; d.Before(to); d = d.AddDate(0, 0, 1) {
+		key := d.Format(time.DateOnly)
+		load := daily[key]
+		history = append(history, load)
+
+		form := fitness - fatigue
+		fitness += (load - fitness) / fitnessDays
+		fatigue += (load - fatigue) / fatigueDays
+		if d.Before(from) {
+			continue
+		}
+
+		acute := trailingSum(history, acuteDays) / acuteDays
+		chronic := trailingSum(history, chronicDays) / chronicDays
+		entry := LoadDay{
+			Date:     key,
+			Workouts: counts[key],
+			Load:     round1(load),
+			Acute:    round1(acute),
+			Chronic:  round1(chronic),
+			Fitness:  round1(fitness),
+			Fatigue:  round1(fatigue),
+			Form:     round1(form),
+		}
+		if chronic > 0 {
+			entry.Ratio = math.Round(acute/chronic*100) / 100
+		}
+		days = append(days, entry)
+	}
+	return days
+}
+
+// trailingSum adds the last n values
+func trailingSum(values []float64, n int) float64 {
+	var sum float64
+	for i := max(0, len(values)-n); i < len(values); i++ {
+		sum += values[i]
+	}
+	return sum
+}
+
+// day truncates t to the start of its UTC day
+func day(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
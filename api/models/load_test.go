@@ -0,0 +1,152 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPowerSeriesBounded(t *testing.T) {
+	// Samples read back from InfluxDB may be far apart
+	samples := []WorkoutSample{{Time: 0, Power: 200}, {Time: 1e12, Power: 250}}
+
+	if got := len(powerSeries(samples)); got != int(maxWorkoutTime) {
+		t.Fatalf("got %d seconds of power, want %d", got, int(maxWorkoutTime))
+	}
+
+	// Out of order samples give an empty series
+	samples = []WorkoutSample{{Time: 10, Power: 200}, {Time: 5, Power: 250}}
+	if got := len(powerSeries(samples)); got != 0 {
+		t.Fatalf("got %d seconds of power from samples going backwards", got)
+	}
+}
+
+// steadyWorkout returns a workout with a sample a second for seconds at
+// watts and heart rate hr
+func steadyWorkout(seconds int, watts uint32, hr byte) *Workout {
+	w := &Workout{}
+	for i := 0; i <= seconds; i++ {
+		w.Samples = append(w.Samples, WorkoutSample{Time: float64(i), Power: watts, HeartRate: hr})
+	}
+	return w
+}
+
+func TestComputeLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		workout  *Workout
+		settings *ZoneSettings
+		np       float64
+		factor   float64
+		tss      float64
+		trimp    float64
+	}{
+		{"an hour at FTP", steadyWorkout(3600, 200, 0), &ZoneSettings{FTP: 200}, 200, 1, 100, 0},
+		{"an hour under FTP", steadyWorkout(3600, 200, 0), &ZoneSettings{FTP: 250}, 200, 0.8, 64, 0},
+		{"half an hour over FTP", steadyWorkout(1800, 300, 0), &ZoneSettings{FTP: 250}, 300, 1.2, 72, 0},
+		// Zone 3 of a 200 bpm maximum is 140 to 160 bpm
+		{"an hour in zone 3", steadyWorkout(3600, 200, 150), &ZoneSettings{MaxHR: 200}, 0, 0, 0, 180},
+		{"both", steadyWorkout(1800, 200, 185), &ZoneSettings{FTP: 200, MaxHR: 200}, 200, 1, 50, 150},
+		{"below the zones", steadyWorkout(600, 200, 90), &ZoneSettings{MaxHR: 200}, 0, 0, 0, 0},
+		{"no settings", steadyWorkout(600, 200, 150), nil, 0, 0, 0, 0},
+		{"one sample", steadyWorkout(0, 200, 150), &ZoneSettings{FTP: 200, MaxHR: 200}, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tt.workout
+			w.TSS = 999
+			w.ComputeLoad(tt.settings)
+			if w.NormalizedPower != tt.np || w.IntensityFactor != tt.factor || w.TSS != tt.tss || w.TRIMP != tt.trimp {
+				t.Errorf("got NP %v, IF %v, TSS %v, TRIMP %v, want %v, %v, %v, %v",
+					w.NormalizedPower, w.IntensityFactor, w.TSS, w.TRIMP, tt.np, tt.factor, tt.tss, tt.trimp)
+			}
+		})
+	}
+}
+
+func TestNormalizedPower(t *testing.T) {
+	// Shorter than the window: the average, each second taking the power
+	// reported at its end, so 9s at 100W and 11s at 300W
+	short := []WorkoutSample{{Time: 0, Power: 100}, {Time: 10, Power: 300}, {Time: 20, Power: 300}}
+	if got := normalizedPower(short); got != 210 {
+		t.Errorf("NP of a 20s piece = %v, want its average 210", got)
+	}
+
+	// Alternating minutes at 100W and 300W average 200W but weigh the hard
+	// minutes more
+	var samples []WorkoutSample
+	for i := 0; i <= 1200; i++ {
+		watts := uint32(100)
+		if (i/60)%2 == 1 {
+			watts = 300
+		}
+		samples = append(samples, WorkoutSample{Time: float64(i), Power: watts})
+	}
+	if np := normalizedPower(samples); np <= 210 || np >= 300 {
+		t.Errorf("NP of alternating minutes = %.1f, want well above the 200W average", np)
+	}
+}
+
+func TestTRIMPSkipsGaps(t *testing.T) {
+	zones := (&ZoneSettings{MaxHR: 200}).Zones().HeartRate
+	samples := []WorkoutSample{
+		{Time: 0, HeartRate: 150},
+		{Time: 60, HeartRate: 150}, // stalled: not credited
+		{Time: 63, HeartRate: 150}, // 3s in zone 3
+		{Time: 66, HeartRate: 0},   // no heart rate
+		{Time: 69, HeartRate: 170}, // 3s in zone 4
+	}
+	if got, want := trimp(samples, zones), (3*3+3*4)/60.0; got != want {
+		t.Errorf("TRIMP = %v, want %v", got, want)
+	}
+}
+
+func TestTrainingLoad(t *testing.T) {
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	workouts := []*Workout{
+		{StartedAt: from.Add(7 * time.Hour), TSS: 50, TRIMP: 80},
+		{StartedAt: from.Add(18 * time.Hour), TSS: 20, TRIMP: 30},
+		// After the range
+		{StartedAt: from.AddDate(0, 0, 8), TSS: 100},
+	}
+
+	days := TrainingLoad(workouts, LoadTSS, from, from.AddDate(0, 0, 8))
+	if len(days) != 8 {
+		t.Fatalf("got %d days, want 8", len(days))
+	}
+
+	tests := []struct {
+		day  int
+		want LoadDay
+	}{
+		// 70 of load: a seventh of it acute and a 28th chronic. Form is
+		// from before the day's load.
+		{0, LoadDay{Date: "2026-10-01", Workouts: 2, Load: 70, Acute: 10, Chronic: 2.5, Ratio: 4, Fitness: 1.7, Fatigue: 10, Form: 0}},
+		// Fitness and fatigue decay; form shows the day before's load
+		{1, LoadDay{Date: "2026-10-02", Acute: 10, Chronic: 2.5, Ratio: 4, Fitness: 1.6, Fatigue: 8.6, Form: -8.3}},
+		// The load has left the acute window but not the chronic one
+		{7, LoadDay{Date: "2026-10-08", Acute: 0, Chronic: 2.5, Ratio: 0, Fitness: 1.4, Fatigue: 3.4, Form: -2.5}},
+	}
+	for _, tt := range tests {
+		if days[tt.day] != tt.want {
+			t.Errorf("day %d = %+v, want %+v", tt.day, days[tt.day], tt.want)
+		}
+	}
+
+	if trimpDays := TrainingLoad(workouts, LoadTRIMP, from, from.AddDate(0, 0, 1)); trimpDays[0].Load != 110 {
+		t.Errorf("TRIMP load %v, want 110", trimpDays[0].Load)
+	}
+}
+
+func TestTrainingLoadCarriesHistory(t *testing.T) {
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	workouts := []*Workout{{StartedAt: from.AddDate(0, 0, -3), TSS: 70}}
+
+	days := TrainingLoad(workouts, LoadTSS, from, from.AddDate(0, 0, 1))
+	if len(days) != 1 || days[0].Date != "2026-10-01" {
+		t.Fatalf("days %+v, want only the range", days)
+	}
+	// Fatigue after the workout and three empty days: 10 * (6/7)^3
+	if got := days[0]; got.Load != 0 || got.Acute != 10 || got.Chronic != 2.5 || got.Fatigue != 6.3 {
+		t.Errorf("day %+v, want the earlier workout carried forward", got)
+	}
+}
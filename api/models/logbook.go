@@ -0,0 +1,291 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// logbookDateLayout is the format of Logbook result dates, local to the
+	// result's timezone
+	logbookDateLayout = "2006-01-02 15:04:05"
+
+	// logbookSplitDistance is the length of the splits pushed with a result
+	logbookSplitDistance = 500.0 // meters
+
+	// logbookMatchWindow is how far apart a workout and a Logbook result
+	// can start and still be the same piece, allowing for results dated
+	// when they finished
+	logbookMatchWindow = 5 * time.Minute
+)
+
+// logbookWorkoutTypes maps the socket server's workout types to the
+// Logbook's. Workouts recorded from a PM5 already use the Logbook's names.
+var logbookWorkoutTypes = map[string]string{
+	"just_row":       "JustRow",
+	"fixed_distance": "FixedDistanceSplits",
+	"fixed_time":     "FixedTimeSplits",
+	"intervals":      "VariableInterval",
+}
+
+// logbookKnownTypes are the Logbook workout types passed through unchanged
+var logbookKnownTypes = []string{
+	"JustRow", "FixedDistanceSplits", "FixedTimeSplits", "FixedCalorie",
+	"FixedTimeInterval", "FixedDistanceInterval", "FixedCalsInterval",
+	"VariableInterval", "VariableIntervalUndefinedRest",
+}
+
+// LogbookResult is a result in the Concept2 Online Logbook, in the
+// Logbook API's format
+type LogbookResult struct {
+	ID          int64             `json:"id,omitempty"`
+	Type        string            `json:"type"`
+	Date        string            `json:"date"`
+	Timezone    string            `json:"timezone,omitempty"`
+	Distance    int               `json:"distance"`     // meters
+	Time        int               `json:"time"`         // tenths of a second
+	WeightClass string            `json:"weight_class"` // H or L
+	WorkoutType string            `json:"workout_type,omitempty"`
+	StrokeRate  int               `json:"stroke_rate,omitempty"`
+	HeartRate   *LogbookHeartRate `json:"heart_rate,omitempty"`
+	Calories    int               `json:"calories_total,omitempty"`
+	Comments    string            `json:"comments,omitempty"`
+	Detail      *LogbookWorkout   `json:"workout,omitempty"` // splits
+	Strokes     []LogbookStroke   `json:"stroke_data,omitempty"`
+}
+
+// LogbookHeartRate is heart rate in a result or split
+type LogbookHeartRate struct {
+	Average int `json:"average,omitempty"`
+	Max     int `json:"max,omitempty"`
+	Ending  int `json:"ending,omitempty"`
+}
+
+// LogbookWorkout holds a result's splits
+type LogbookWorkout struct {
+	Splits []LogbookSplit `json:"splits"`
+}
+
+// LogbookSplit is one split of a result
+type LogbookSplit struct {
+	Distance   int               `json:"distance"` // meters
+	Time       int               `json:"time"`     // tenths of a second
+	StrokeRate int               `json:"stroke_rate,omitempty"`
+	HeartRate  *LogbookHeartRate `json:"heart_rate,omitempty"`
+}
+
+// LogbookStroke is one stroke of a result's stroke data
+type LogbookStroke struct {
+	Time       int `json:"t"`   // tenths of a second
+	Distance   int `json:"d"`   // decimeters
+	Pace       int `json:"p"`   // tenths of a second per 500m
+	StrokeRate int `json:"spm"` // strokes per minute
+	HeartRate  int `json:"hr"`  // bpm
+}
+
+// LogbookResult converts the workout to a Logbook result with 500m
+// splits and a point per stroke. weightClass is the rower's class,
+// heavyweight if unknown.
+func (w *Workout) LogbookResult(weightClass string) *LogbookResult {
+	result := &LogbookResult{
+		Type:        "rower",
+		Date:        w.StartedAt.UTC().Format(logbookDateLayout),
+		Timezone:    "UTC",
+		Distance:    int(math.Round(w.Distance)),
+		Time:        tenths(w.ElapsedTime),
+		WeightClass: "H",
+		WorkoutType: "JustRow",
+		StrokeRate:  int(math.Round(w.AvgStrokeRate)),
+		Calories:    int(math.Round(w.Calories)),
+		Comments:    "Recorded with Ergometer.Live",
+	}
+	if weightClass == "lightweight" {
+		result.WeightClass = "L"
+	}
+	if t, ok := logbookWorkoutTypes[w.WorkoutType]; ok {
+		result.WorkoutType = t
+	} else if contains(logbookKnownTypes, w.WorkoutType) {
+		result.WorkoutType = w.WorkoutType
+	}
+	if w.AvgHeartRate > 0 {
+		result.HeartRate = &LogbookHeartRate{Average: int(math.Round(w.AvgHeartRate)), Max: w.MaxHeartRate}
+	}
+
+	if len(w.Samples) < 2 {
+		return result
+	}
+	result.Detail = &LogbookWorkout{Splits: logbookSplits(w.Samples)}
+
+	// The samples are far denser than strokes, so keep one per stroke
+	// period
+	last := math.Inf(-1)
+	for i, s := range w.Samples {
+		period := 2.0
+		if s.StrokeRate > 0 {
+			period = 60 / float64(s.StrokeRate)
+		}
+		if s.Time-last < period && i < len(w.Samples)-1 {
+			continue
+		}
+		last = s.Time
+
+		result.Strokes = append(result.Strokes, LogbookStroke{
+			Time:       tenths(s.Time),
+			Distance:   int(math.Round(s.Distance * 10)),
+			Pace:       tenths(s.Pace),
+			StrokeRate: int(s.StrokeRate),
+			HeartRate:  int(s.HeartRate),
+		})
+	}
+	return result
+}
+
+// logbookSplits divides samples into 500m splits, the last one possibly
+// shorter
+func logbookSplits(samples []WorkoutSample) []LogbookSplit {
+	var splits []LogbookSplit
+	start := samples[0]
+	var rate, heartRate, hrTime float64
+
+	for i := 1; i < len(samples); i++ {
+		s := samples[i]
+		dt := s.Time - samples[i-1].Time
+		rate += float64(s.StrokeRate) * dt
+		if s.HeartRate > 0 {
+			heartRate += float64(s.HeartRate) * dt
+			hrTime += dt
+		}
+
+		if s.Distance-start.Distance < logbookSplitDistance && i < len(samples)-1 {
+			continue
+		}
+
+		duration := s.Time - start.Time
+		if duration <= 0 {
+			continue
+		}
+		split := LogbookSplit{
+			Distance:   int(math.Round(s.Distance - start.Distance)),
+			Time:       tenths(duration),
+			StrokeRate: int(math.Round(rate / duration)),
+		}
+		if hrTime > 0 {
+			split.HeartRate = &LogbookHeartRate{Average: int(math.Round(heartRate / hrTime)), Ending: int(s.HeartRate)}
+		}
+		splits = append(splits, split)
+
+		start = s
+		rate, heartRate, hrTime = 0, 0, 0
+	}
+	return splits
+}
+
+// StartedAt returns when the result was rowed
+func (r *LogbookResult) StartedAt() (time.Time, error) {
+	loc := time.UTC
+	if r.Timezone != "" {
+		if l, err := time.LoadLocation(r.Timezone); err == nil {
+			loc = l
+		}
+	}
+
+	t, err := time.ParseInLocation(logbookDateLayout, r.Date, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid result date %q", r.Date)
+	}
+	return t.UTC(), nil
+}
+
+// Matches reports whether the result is the same piece as a workout: it
+// started at about the same time, and its distance and time are within a
+// percent of the workout's
+func (r *LogbookResult) Matches(w *Workout) bool {
+	started, err := r.StartedAt()
+	if err != nil {
+		return false
+	}
+
+	window := logbookMatchWindow + time.Duration(w.ElapsedTime*float64(time.Second))
+	if d := started.Sub(w.StartedAt); d < -window || d > window {
+		return false
+	}
+	return near(float64(r.Distance), w.Distance, 1) && near(float64(r.Time)/10, w.ElapsedTime, 1)
+}
+
+// Workout converts the result to a workout, with samples from its stroke
+// data if it has any. Without stroke data, the workout only has best
+// efforts if the whole result was a ranked event.
+func (r *LogbookResult) Workout(strokes []LogbookStroke) (*Workout, error) {
+	started, err := r.StartedAt()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Workout{
+		SessionID:     fmt.Sprintf("concept2-%d", r.ID),
+		WorkoutType:   r.WorkoutType,
+		StartedAt:     started,
+		Distance:      float64(r.Distance),
+		ElapsedTime:   float64(r.Time) / 10,
+		AvgStrokeRate: float64(r.StrokeRate),
+		Calories:      float64(r.Calories),
+		BestEfforts:   []Effort{},
+	}
+	if w.Distance > 0 {
+		w.AvgPace = round1(w.ElapsedTime / w.Distance * 500)
+		w.AvgPower = round1(PaceWatts(w.AvgPace))
+	}
+	if r.HeartRate != nil {
+		w.AvgHeartRate = float64(r.HeartRate.Average)
+		w.MaxHeartRate = r.HeartRate.Max
+	}
+
+	if len(strokes) >= 2 {
+		for _, s := range strokes {
+			pace := float64(s.Pace) / 10
+			w.Samples = append(w.Samples, WorkoutSample{
+				Time:       float64(s.Time) / 10,
+				Distance:   float64(s.Distance) / 10,
+				Pace:       pace,
+				Power:      uint32(math.Round(PaceWatts(pace))),
+				StrokeRate: byte(s.StrokeRate),
+				HeartRate:  byte(s.HeartRate),
+			})
+		}
+
+		// Stroke data can stop short of the result, whose totals are
+		// authoritative
+		distance, elapsed, pace := w.Distance, w.ElapsedTime, w.AvgPace
+		w.Summarize()
+		if distance > 0 && elapsed > 0 {
+			w.Distance, w.ElapsedTime, w.AvgPace = distance, elapsed, pace
+		}
+		return w, nil
+	}
+
+	for _, event := range Events {
+		if (event.Kind == EventDistance && event.Distance == w.Distance) ||
+			(event.Kind == EventTime && event.Time == w.ElapsedTime) {
+			w.BestEfforts = append(w.BestEfforts, Effort{
+				Event:    event.Name,
+				Kind:     event.Kind,
+				Distance: w.Distance,
+				Time:     w.ElapsedTime,
+				Pace:     w.AvgPace,
+				Whole:    true,
+			})
+		}
+	}
+	return w, nil
+}
+
+// tenths converts seconds to whole tenths of a second
+func tenths(seconds float64) int {
+	return int(math.Round(seconds * 10))
+}
+
+// near reports whether a is within percent of b
+func near(a, b, percent float64) bool {
+	return math.Abs(a-b) <= math.Max(b*percent/100, 1)
+}
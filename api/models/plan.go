@@ -0,0 +1,272 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// maxPlanSessions bounds the sessions in one plan: a year of doubles
+	maxPlanSessions = 730
+
+	// Tolerances when matching a workout to a planned piece, covering a
+	// piece stopped a stroke early or counted a stroke over
+	matchDistanceTolerance = 10.0 // meters
+	matchTimeTolerance     = 5.0  // seconds
+)
+
+// plannedWorkoutTypes are the workout types that can row a piece of each
+// template type, by the socket server's names and the monitor's
+var plannedWorkoutTypes = map[string][]string{
+	"fixed_distance": {"fixed_distance", "FixedDistanceSplits"},
+	"fixed_time":     {"fixed_time", "FixedTimeSplits"},
+	"intervals": {
+		"intervals", "FixedTimeInterval", "FixedDistanceInterval",
+		"VariableInterval", "VariableIntervalUndefinedRest",
+	},
+}
+
+// Planned session statuses
+const (
+	SessionCompleted = "completed" // every piece was rowed
+	SessionPartial   = "partial"   // some pieces were rowed
+	SessionMissed    = "missed"    // nothing was rowed and the day has passed
+	SessionPending   = "pending"   // due today and not yet rowed
+	SessionUpcoming  = "upcoming"  // in the future
+)
+
+// PlannedSession is a workout template scheduled on a day
+type PlannedSession struct {
+	Date       string `json:"date" firestore:"date"` // YYYY-MM-DD in the plan's time zone
+	TemplateID string `json:"template_id" firestore:"template_id"`
+	Notes      string `json:"notes,omitempty" firestore:"notes"`
+}
+
+// Plan is a multi-week training plan
+type Plan struct {
+	ID          string           `json:"id" firestore:"-"`
+	Name        string           `json:"name" firestore:"name"`
+	Description string           `json:"description,omitempty" firestore:"description"`
+	TimeZone    string           `json:"time_zone" firestore:"time_zone"` // IANA name; dates are days in this zone
+	Sessions    []PlannedSession `json:"sessions" firestore:"sessions"`
+	CreatedAt   time.Time        `json:"created_at" firestore:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at" firestore:"updated_at"`
+}
+
+// SessionStatus is a planned session and the workouts matched to it
+type SessionStatus struct {
+	PlannedSession
+	Status     string   `json:"status"`
+	WorkoutIDs []string `json:"workout_ids"`
+}
+
+// Compliance summarises how closely a plan has been followed up to a day
+type Compliance struct {
+	PlanID    string          `json:"plan_id"`
+	Through   string          `json:"through"`
+	Due       int             `json:"due"` // sessions on or before through, less today's unrowed ones
+	Completed int             `json:"completed"`
+	Partial   int             `json:"partial"`
+	Missed    int             `json:"missed"`
+	Rate      float64         `json:"rate"` // completed / due
+	Sessions  []SessionStatus `json:"sessions"`
+}
+
+// Validate checks the plan and sorts its sessions by date
+func (p *Plan) Validate() error {
+	var errs []error
+
+	if p.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if len(p.Name) > maxTemplateNameLength {
+		errs = append(errs, fmt.Errorf("name must be at most %d characters", maxTemplateNameLength))
+	}
+	if p.TimeZone == "" {
+		p.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(p.TimeZone); err != nil {
+		errs = append(errs, fmt.Errorf("unknown time_zone: %q", p.TimeZone))
+	}
+	if len(p.Sessions) == 0 {
+		errs = append(errs, errors.New("at least one session is required"))
+	}
+	if len(p.Sessions) > maxPlanSessions {
+		errs = append(errs, fmt.Errorf("at most %d sessions are allowed", maxPlanSessions))
+	}
+
+	for i, session := range p.Sessions {
+		if _, err := time.Parse(time.DateOnly, session.Date); err != nil {
+			errs = append(errs, fmt.Errorf("session %d has an invalid date: %q", i+1, session.Date))
+		}
+		if session.TemplateID == "" {
+			errs = append(errs, fmt.Errorf("session %d needs a template_id", i+1))
+		}
+	}
+
+	sort.SliceStable(p.Sessions, func(i, j int) bool {
+		return p.Sessions[i].Date < p.Sessions[j].Date
+	})
+	return errors.Join(errs...)
+}
+
+// Location returns the plan's time zone, UTC if it can't be loaded
+func (p *Plan) Location() *time.Location {
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Today returns the current date in the plan's time zone
+func (p *Plan) Today() string {
+	return time.Now().In(p.Location()).Format(time.DateOnly)
+}
+
+// DayRange returns the start of the first day and the end of the last in
+// the plan's time zone
+func (p *Plan) DayRange(first, last string) (from, to time.Time, err error) {
+	loc := p.Location()
+	if from, err = time.ParseInLocation(time.DateOnly, first, loc); err != nil {
+		return from, to, err
+	}
+	if to, err = time.ParseInLocation(time.DateOnly, last, loc); err != nil {
+		return from, to, err
+	}
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// TemplateIDs returns the distinct templates the plan uses
+func (p *Plan) TemplateIDs() []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, session := range p.Sessions {
+		if !seen[session.TemplateID] {
+			seen[session.TemplateID] = true
+			ids = append(ids, session.TemplateID)
+		}
+	}
+	return ids
+}
+
+// piece is one part of a planned session a workout can satisfy. Zero
+// distance and time match a workout of any length, and no types a workout
+// of any type.
+type piece struct {
+	types    []string
+	distance uint32
+	time     uint32
+}
+
+// pieces lists what a template expects to be rowed. A template that has
+// since been deleted matches any workout.
+func pieces(template *Template) []piece {
+	if template == nil {
+		return []piece{{}}
+	}
+
+	types := plannedWorkoutTypes[template.WorkoutType]
+	switch template.WorkoutType {
+	case "fixed_distance":
+		return []piece{{types: types, distance: template.Distance}}
+	case "fixed_time":
+		return []piece{{types: types, time: template.Time}}
+	case "intervals":
+		parts := make([]piece, len(template.Intervals))
+		for i, interval := range template.Intervals {
+			parts[i] = piece{types: types, distance: interval.Distance, time: interval.Time}
+		}
+		return parts
+	}
+	return []piece{{}}
+}
+
+// satisfies reports whether a workout rows a piece: it is of the piece's
+// type and its length, within the tolerances. Workouts saved without a
+// type are matched on length alone.
+func (p piece) satisfies(w *Workout) bool {
+	if len(p.types) > 0 && w.WorkoutType != "" && !contains(p.types, w.WorkoutType) {
+		return false
+	}
+	if p.distance > 0 && math.Abs(w.Distance-float64(p.distance)) > matchDistanceTolerance {
+		return false
+	}
+	if p.time > 0 && math.Abs(w.ElapsedTime-float64(p.time)) > matchTimeTolerance {
+		return false
+	}
+	return true
+}
+
+// MatchSessions matches workouts to the plan's sessions as of today. Each
+// workout counts towards at most one piece of one session on the day it
+// was rowed, taken in the order rowed.
+func (p *Plan) MatchSessions(templates map[string]*Template, workouts []*Workout, today string) []SessionStatus {
+	loc := p.Location()
+
+	byDay := make(map[string][]*Workout)
+	for _, w := range workouts {
+		d := w.StartedAt.In(loc).Format(time.DateOnly)
+		byDay[d] = append(byDay[d], w)
+	}
+	for _, day := range byDay {
+		sort.Slice(day, func(i, j int) bool { return day[i].StartedAt.Before(day[j].StartedAt) })
+	}
+
+	used := make(map[*Workout]bool)
+	statuses := make([]SessionStatus, len(p.Sessions))
+	for i, session := range p.Sessions {
+		status := SessionStatus{PlannedSession: session, WorkoutIDs: []string{}}
+
+		parts := pieces(templates[session.TemplateID])
+		for _, part := range parts {
+			for _, w := range byDay[session.Date] {
+				if !used[w] && part.satisfies(w) {
+					used[w] = true
+					status.WorkoutIDs = append(status.WorkoutIDs, w.ID)
+					break
+				}
+			}
+		}
+
+		switch {
+		case len(status.WorkoutIDs) == len(parts):
+			status.Status = SessionCompleted
+		case len(status.WorkoutIDs) > 0:
+			status.Status = SessionPartial
+		case session.Date > today:
+			status.Status = SessionUpcoming
+		case session.Date == today:
+			status.Status = SessionPending
+		default:
+			status.Status = SessionMissed
+		}
+		statuses[i] = status
+	}
+	return statuses
+}
+
+// NewCompliance summarises session statuses as of today
+func NewCompliance(planID, today string, statuses []SessionStatus) *Compliance {
+	compliance := &Compliance{PlanID: planID, Through: today, Sessions: statuses}
+	for _, status := range statuses {
+		switch status.Status {
+		case SessionCompleted:
+			compliance.Completed++
+		case SessionPartial:
+			compliance.Partial++
+		case SessionMissed:
+			compliance.Missed++
+		default:
+			continue
+		}
+		compliance.Due++
+	}
+	if compliance.Due > 0 {
+		compliance.Rate = math.Round(float64(compliance.Completed)/float64(compliance.Due)*1000) / 1000
+	}
+	return compliance
+}
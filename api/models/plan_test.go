@@ -0,0 +1,109 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchSessions(t *testing.T) {
+	plan := &Plan{
+		TimeZone: "UTC",
+		Sessions: []PlannedSession{
+			{Date: "2026-10-12", TemplateID: "2k"},
+			{Date: "2026-10-13", TemplateID: "3x1k"},
+			{Date: "2026-10-14", TemplateID: "30min"},
+			{Date: "2026-10-15", TemplateID: "2k"},
+			{Date: "2026-10-16", TemplateID: "3x1k"},
+			{Date: "2026-10-18", TemplateID: "2k"},
+			{Date: "2026-10-18", TemplateID: "steady"},
+			{Date: "2026-10-20", TemplateID: "2k"},
+		},
+	}
+	templates := map[string]*Template{
+		"2k":     {WorkoutType: "fixed_distance", Distance: 2000},
+		"30min":  {WorkoutType: "fixed_time", Time: 1800},
+		"steady": {WorkoutType: "just_row"},
+		"3x1k": {WorkoutType: "intervals", Intervals: []TemplateInterval{
+			{Distance: 1000, Rest: 120}, {Distance: 1000, Rest: 120}, {Distance: 1000},
+		}},
+	}
+
+	rowed := func(id, date, workoutType string, distance, elapsed float64) *Workout {
+		started, _ := time.Parse(time.DateOnly, date)
+		return &Workout{ID: id, WorkoutType: workoutType, StartedAt: started.Add(7 * time.Hour), Distance: distance, ElapsedTime: elapsed}
+	}
+	workouts := []*Workout{
+		// A 2k stopped a stroke short
+		rowed("a", "2026-10-12", "FixedDistanceSplits", 1992, 420),
+		// All three pieces
+		rowed("b1", "2026-10-13", "FixedDistanceInterval", 1000, 205),
+		rowed("b2", "2026-10-13", "FixedDistanceInterval", 1000, 206),
+		rowed("b3", "2026-10-13", "FixedDistanceInterval", 1000, 204),
+		// Half an hour saved without a type
+		rowed("c", "2026-10-14", "", 7400, 1802),
+		// A 5k isn't a 2k, nor is a just row of the right length
+		rowed("d1", "2026-10-15", "FixedDistanceSplits", 5000, 1100),
+		rowed("d2", "2026-10-15", "JustRow", 2000, 430),
+		// Two of the three pieces
+		rowed("e1", "2026-10-16", "FixedDistanceInterval", 1000, 205),
+		rowed("e2", "2026-10-16", "FixedDistanceInterval", 1000, 206),
+		// Today's just row, but not yet the 2k
+		rowed("f", "2026-10-18", "JustRow", 3000, 900),
+	}
+
+	statuses := plan.MatchSessions(templates, workouts, "2026-10-18")
+	want := []struct {
+		status   string
+		workouts int
+	}{
+		{SessionCompleted, 1},
+		{SessionCompleted, 3},
+		{SessionCompleted, 1},
+		{SessionMissed, 0},
+		{SessionPartial, 2},
+		{SessionPending, 0},
+		{SessionCompleted, 1},
+		{SessionUpcoming, 0},
+	}
+	for i, w := range want {
+		got := statuses[i]
+		if got.Status != w.status || len(got.WorkoutIDs) != w.workouts {
+			t.Errorf("session %d (%s %s): %s with %v, want %s with %d workouts",
+				i, got.Date, got.TemplateID, got.Status, got.WorkoutIDs, w.status, w.workouts)
+		}
+	}
+
+	compliance := NewCompliance("plan", "2026-10-18", statuses)
+	if compliance.Due != 6 || compliance.Completed != 4 || compliance.Partial != 1 || compliance.Missed != 1 || compliance.Rate != 0.667 {
+		t.Errorf("compliance %+v, want 4 of 6 completed, 1 partial and 1 missed", compliance)
+	}
+}
+
+func TestPieceSatisfies(t *testing.T) {
+	twoK := pieces(&Template{WorkoutType: "fixed_distance", Distance: 2000})[0]
+
+	tests := []struct {
+		name    string
+		workout Workout
+		want    bool
+	}{
+		{"exact", Workout{WorkoutType: "FixedDistanceSplits", Distance: 2000}, true},
+		{"the socket server's name", Workout{WorkoutType: "fixed_distance", Distance: 2000}, true},
+		{"just short", Workout{WorkoutType: "FixedDistanceSplits", Distance: 2000 - matchDistanceTolerance}, true},
+		{"too short", Workout{WorkoutType: "FixedDistanceSplits", Distance: 1989}, false},
+		{"just over", Workout{WorkoutType: "FixedDistanceSplits", Distance: 2000 + matchDistanceTolerance}, true},
+		{"too long", Workout{WorkoutType: "FixedDistanceSplits", Distance: 2011}, false},
+		{"another type", Workout{WorkoutType: "FixedTimeSplits", Distance: 2000}, false},
+		{"no type", Workout{Distance: 2000}, true},
+	}
+	for _, tt := range tests {
+		if got := twoK.satisfies(&tt.workout); got != tt.want {
+			t.Errorf("%s: satisfies = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// A deleted template is rowed by anything
+	if parts := pieces(nil); len(parts) != 1 || !parts[0].satisfies(&Workout{WorkoutType: "JustRow", Distance: 12}) {
+		t.Errorf("pieces of a deleted template %+v", parts)
+	}
+}
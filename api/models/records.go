@@ -0,0 +1,205 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// Event kinds
+const (
+	EventDistance = "distance" // fastest time over a distance
+	EventTime     = "time"     // furthest distance in a time
+)
+
+// distanceTolerance allows a piece to count for a distance event when its
+// last sample falls just short of the distance
+const distanceTolerance = 0.5 // meters
+
+// Event is a Concept2 rankable distance or time
+type Event struct {
+	Name     string  `json:"name"`
+	Kind     string  `json:"kind"`
+	Distance float64 `json:"distance,omitempty"` // meters, for distance events
+	Time     float64 `json:"time,omitempty"`     // seconds, for time events
+}
+
+// Events are the rankable events records are kept for
+var Events = []Event{
+	{Name: "500m", Kind: EventDistance, Distance: 500},
+	{Name: "1k", Kind: EventDistance, Distance: 1000},
+	{Name: "2k", Kind: EventDistance, Distance: 2000},
+	{Name: "5k", Kind: EventDistance, Distance: 5000},
+	{Name: "6k", Kind: EventDistance, Distance: 6000},
+	{Name: "10k", Kind: EventDistance, Distance: 10000},
+	{Name: "half_marathon", Kind: EventDistance, Distance: 21097},
+	{Name: "marathon", Kind: EventDistance, Distance: 42195},
+	{Name: "1min", Kind: EventTime, Time: 60},
+	{Name: "4min", Kind: EventTime, Time: 240},
+	{Name: "30min", Kind: EventTime, Time: 1800},
+	{Name: "60min", Kind: EventTime, Time: 3600},
+}
+
+// Effort is the best performance for an event within one workout
+type Effort struct {
+	Event    string  `json:"event" firestore:"event"`
+	Kind     string  `json:"kind" firestore:"kind"`
+	Distance float64 `json:"distance" firestore:"distance"` // meters
+	Time     float64 `json:"time" firestore:"time"`         // seconds
+	Pace     float64 `json:"pace" firestore:"pace"`         // seconds per 500m
+	Offset   float64 `json:"offset" firestore:"offset"`     // seconds into the workout the effort started
+	Whole    bool    `json:"whole" firestore:"whole"`       // the effort is the whole workout
+
+	// Concept2 weight-adjusted time (distance events) or distance (time
+	// events) and watts per kilogram, 0 when the rower's weight is unknown
+	AdjustedTime     float64 `json:"adjusted_time,omitempty" firestore:"adjusted_time"`         // seconds
+	AdjustedDistance float64 `json:"adjusted_distance,omitempty" firestore:"adjusted_distance"` // meters
+	WattsPerKg       float64 `json:"watts_per_kg,omitempty" firestore:"watts_per_kg"`
+}
+
+// Record is a user's best effort for an event
+type Record struct {
+	Effort
+	WorkoutID string    `json:"workout_id" firestore:"workout_id"`
+	RowedAt   time.Time `json:"rowed_at" firestore:"rowed_at"` // when the workout started
+	SetAt     time.Time `json:"set_at" firestore:"set_at"`     // when the record was saved
+
+	// The record this one replaced, if any
+	Previous *Effort `json:"previous,omitempty" firestore:"previous,omitempty"`
+}
+
+// Beats reports whether e is better than other for the same event
+func (e *Effort) Beats(other *Effort) bool {
+	if other == nil {
+		return true
+	}
+	if e.Kind == EventTime {
+		return e.Distance > other.Distance
+	}
+	return e.Time < other.Time
+}
+
+// BestEfforts finds the best effort for every event the samples cover,
+// including efforts within longer pieces
+func BestEfforts(samples []WorkoutSample) []Effort {
+	efforts := []Effort{}
+	if len(samples) < 2 {
+		return efforts
+	}
+
+	last := samples[len(samples)-1]
+	for _, event := range Events {
+		var effort *Effort
+		switch event.Kind {
+		case EventDistance:
+			if last.Distance+distanceTolerance < event.Distance {
+				continue
+			}
+			effort = fastestOver(samples, event.Distance)
+		case EventTime:
+			if last.Time < event.Time {
+				continue
+			}
+			effort = furthestIn(samples, event.Time)
+		}
+
+		if effort == nil {
+			continue
+		}
+		effort.Event = event.Name
+		effort.Kind = event.Kind
+		if effort.Distance > 0 {
+			effort.Pace = round1(effort.Time / effort.Distance * 500)
+		}
+		efforts = append(efforts, *effort)
+	}
+	return efforts
+}
+
+// fastestOver finds the shortest time to row distance meters. Each sample
+// is tried as the end of the effort, with the start interpolated between
+// samples.
+func fastestOver(samples []WorkoutSample, distance float64) *Effort {
+	last := samples[len(samples)-1]
+
+	// A piece that ends just short of the distance is the whole effort
+	if last.Distance < distance {
+		return &Effort{
+			Distance: distance,
+			Time:     round1(last.Time - samples[0].Time),
+			Offset:   samples[0].Time,
+			Whole:    true,
+		}
+	}
+
+	var best *Effort
+	start := 0
+	for end := range samples {
+		target := samples[end].Distance - distance
+		if target < samples[0].Distance {
+			continue
+		}
+
+		// Move the start to the last sample at or before the target
+		for start+1 < end && samples[start+1].Distance <= target {
+			start++
+		}
+
+		startTime := interpolate(samples[start].Distance, samples[start].Time,
+			samples[start+1].Distance, samples[start+1].Time, target)
+		elapsed := samples[end].Time - startTime
+
+		if best == nil || elapsed < best.Time {
+			best = &Effort{Distance: distance, Time: elapsed, Offset: startTime}
+		}
+	}
+
+	if best != nil {
+		best.Time = round1(best.Time)
+		best.Offset = round1(best.Offset)
+		best.Whole = best.Offset <= samples[0].Time && math.Abs(last.Distance-distance) <= distanceTolerance
+	}
+	return best
+}
+
+// furthestIn finds the longest distance rowed in seconds. Each sample is
+// tried as the end of the effort, with the start interpolated between
+// samples.
+func furthestIn(samples []WorkoutSample, seconds float64) *Effort {
+	last := samples[len(samples)-1]
+
+	var best *Effort
+	start := 0
+	for end := range samples {
+		target := samples[end].Time - seconds
+		if target < samples[0].Time {
+			continue
+		}
+
+		for start+1 < end && samples[start+1].Time <= target {
+			start++
+		}
+
+		startDistance := interpolate(samples[start].Time, samples[start].Distance,
+			samples[start+1].Time, samples[start+1].Distance, target)
+		rowed := samples[end].Distance - startDistance
+
+		if best == nil || rowed > best.Distance {
+			best = &Effort{Distance: rowed, Time: seconds, Offset: target}
+		}
+	}
+
+	if best != nil {
+		best.Distance = round1(best.Distance)
+		best.Offset = round1(best.Offset)
+		best.Whole = best.Offset <= samples[0].Time && last.Time-seconds < 1
+	}
+	return best
+}
+
+// interpolate returns the y at x on the line through (x0, y0) and (x1, y1)
+func interpolate(x0, y0, x1, y1, x float64) float64 {
+	if x1 == x0 {
+		return y0
+	}
+	return y0 + (y1-y0)*(x-x0)/(x1-x0)
+}
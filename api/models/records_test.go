@@ -0,0 +1,107 @@
+package models
+
+import "testing"
+
+// rowSteady appends a sample a second for seconds of steady rowing at pace
+// (seconds per 500m), continuing from the last sample
+func rowSteady(samples []WorkoutSample, pace, seconds float64) []WorkoutSample {
+	if len(samples) == 0 {
+		samples = []WorkoutSample{{}}
+	}
+	start := samples[len(samples)-1]
+	speed := 500 / pace
+	for i := 1; i <= int(seconds); i++ {
+		samples = append(samples, WorkoutSample{
+			Time:     start.Time + float64(i),
+			Distance: start.Distance + speed*float64(i),
+			Pace:     pace,
+		})
+	}
+	return samples
+}
+
+// findEffort returns the effort for event, or nil if there is none
+func findEffort(efforts []Effort, event string) *Effort {
+	for i := range efforts {
+		if efforts[i].Event == event {
+			return &efforts[i]
+		}
+	}
+	return nil
+}
+
+func TestBestEfforts(t *testing.T) {
+	// A 2k at 2:00/500m
+	whole2k := rowSteady(nil, 120, 480)
+
+	// 1000m at 2:10, a 500m burst at 1:40, then 500m at 2:10
+	burst := rowSteady(nil, 130, 260)
+	burst = rowSteady(burst, 100, 100)
+	burst = rowSteady(burst, 130, 130)
+
+	// A 2k whose last sample falls just short of the distance
+	short := rowSteady(nil, 120, 480)
+	short[len(short)-1].Distance = 2000 - distanceTolerance
+
+	// One that falls too far short
+	tooShort := rowSteady(nil, 120, 480)
+	tooShort[len(tooShort)-1].Distance = 2000 - distanceTolerance - 0.1
+
+	tests := []struct {
+		name      string
+		samples   []WorkoutSample
+		event     string
+		want      *Effort // nil when the samples don't cover the event
+		anyOffset bool    // a steady piece has no single best start
+	}{
+		{"whole piece", whole2k, "2k", &Effort{Kind: EventDistance, Distance: 2000, Time: 480, Pace: 120, Whole: true}, false},
+		{"shorter distance in a piece", whole2k, "500m", &Effort{Kind: EventDistance, Distance: 500, Time: 120, Pace: 120}, true},
+		{"time in a piece", whole2k, "4min", &Effort{Kind: EventTime, Distance: 1000, Time: 240, Pace: 120}, true},
+		{"longer than the piece", whole2k, "5k", nil, false},
+		{"longer time than the piece", whole2k, "30min", nil, false},
+		{"burst in a longer piece", burst, "500m", &Effort{Kind: EventDistance, Distance: 500, Time: 100, Pace: 100, Offset: 260}, false},
+		{"burst time in a longer piece", burst, "1min", &Effort{Kind: EventTime, Distance: 300, Time: 60, Pace: 100, Offset: 260}, false},
+		{"slower around a burst", burst, "2k", &Effort{Kind: EventDistance, Distance: 2000, Time: 490, Pace: 122.5, Whole: true}, false},
+		{"just short", short, "2k", &Effort{Kind: EventDistance, Distance: 2000, Time: 480, Pace: 120, Whole: true}, false},
+		{"too short", tooShort, "2k", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := findEffort(BestEfforts(tt.samples), tt.event)
+			if tt.want == nil {
+				if got != nil {
+					t.Fatalf("got %+v, want no %s effort", got, tt.event)
+				}
+				return
+			}
+			want := *tt.want
+			want.Event = tt.event
+			if got != nil && tt.anyOffset {
+				want.Offset = got.Offset
+			}
+			if got == nil || *got != want {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestBestEffortsWholeTimePiece(t *testing.T) {
+	efforts := BestEfforts(rowSteady(nil, 100, 60))
+
+	effort := findEffort(efforts, "1min")
+	if effort == nil || effort.Distance != 300 || !effort.Whole {
+		t.Fatalf("1min effort %+v, want the whole 300m piece", effort)
+	}
+	if effort := findEffort(efforts, "500m"); effort != nil {
+		t.Errorf("500m effort %+v from a 300m piece", effort)
+	}
+}
+
+func TestBestEffortsTooFewSamples(t *testing.T) {
+	for _, samples := range [][]WorkoutSample{nil, {{Time: 0, Distance: 0}}} {
+		if efforts := BestEfforts(samples); efforts == nil || len(efforts) != 0 {
+			t.Errorf("BestEfforts of %d samples = %v, want none", len(samples), efforts)
+		}
+	}
+}
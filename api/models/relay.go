@@ -0,0 +1,42 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Relay event types sent to viewers of a user's relayed stream
+const (
+	RelayEventSources = "sources" // the socket servers connected
+	RelayEventMessage = "message" // a message from a socket server
+)
+
+// RelayKey lets a socket server publish its live stream to the API, which
+// relays it to the user's remote viewers and coaches
+type RelayKey struct {
+	ID              string     `json:"id" firestore:"-"`
+	UserID          string     `json:"-" firestore:"user_id"`
+	Name            string     `json:"name" firestore:"name"`
+	Key             string     `json:"key,omitempty" firestore:"-"` // only returned when created
+	CreatedAt       time.Time  `json:"created_at" firestore:"created_at"`
+	LastConnectedAt *time.Time `json:"last_connected_at,omitempty" firestore:"last_connected_at"`
+
+	// Whether a socket server is publishing with the key, set when keys
+	// are listed
+	Connected bool `json:"connected" firestore:"-"`
+}
+
+// Validate checks a relay key submitted for creation
+func (k *RelayKey) Validate() error {
+	var errs []error
+
+	if k.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if len(k.Name) > maxTemplateNameLength {
+		errs = append(errs, fmt.Errorf("name must be at most %d characters", maxTemplateNameLength))
+	}
+
+	return errors.Join(errs...)
+}
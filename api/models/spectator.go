@@ -0,0 +1,54 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Spectator share lifetimes
+const (
+	defaultSpectatorShareLifetime = 3 * time.Hour
+	maxSpectatorShareLifetime     = 7 * 24 * time.Hour
+)
+
+// SpectatorShare lets anyone with its token watch the live stats of one of
+// the user's ergs through the socket server it's attached to. Spectators
+// see stats only; they can't control the erg or see its device details.
+type SpectatorShare struct {
+	Token     string    `json:"token" firestore:"-"`
+	UserID    string    `json:"-" firestore:"user_id"`
+	Serial    string    `json:"serial" firestore:"serial"` // the PM5 shared
+	Label     string    `json:"label,omitempty" firestore:"label"`
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" firestore:"expires_at"`
+}
+
+// Validate checks a share submitted for creation at now, defaulting it to
+// three hours' lifetime
+func (s *SpectatorShare) Validate(now time.Time) error {
+	var errs []error
+
+	if s.Serial == "" {
+		errs = append(errs, errors.New("serial is required"))
+	}
+	if len(s.Label) > maxTemplateNameLength {
+		errs = append(errs, fmt.Errorf("label must be at most %d characters", maxTemplateNameLength))
+	}
+
+	switch {
+	case s.ExpiresAt.IsZero():
+		s.ExpiresAt = now.Add(defaultSpectatorShareLifetime)
+	case !s.ExpiresAt.After(now):
+		errs = append(errs, errors.New("expires_at must be in the future"))
+	case s.ExpiresAt.Sub(now) > maxSpectatorShareLifetime:
+		errs = append(errs, fmt.Errorf("expires_at must be within %d days", int(maxSpectatorShareLifetime.Hours()/24)))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Active reports whether the share can still be watched at now
+func (s *SpectatorShare) Active(now time.Time) bool {
+	return now.Before(s.ExpiresAt)
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"encoding/xml"
+	"math"
+	"time"
+)
+
+// tcxInterval is the least time between trackpoints; the samples are much
+// denser than activity sites need
+const tcxInterval = 1.0 // seconds
+
+// tcxDatabase is a Garmin Training Center file holding one activity
+type tcxDatabase struct {
+	XMLName  xml.Name    `xml:"http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2 TrainingCenterDatabase"`
+	Activity tcxActivity `xml:"Activities>Activity"`
+}
+
+type tcxActivity struct {
+	Sport string `xml:"Sport,attr"`
+	ID    string `xml:"Id"`
+	Lap   tcxLap `xml:"Lap"`
+}
+
+type tcxLap struct {
+	StartTime     string          `xml:"StartTime,attr"`
+	TotalTime     float64         `xml:"TotalTimeSeconds"`
+	Distance      float64         `xml:"DistanceMeters"`
+	Calories      int             `xml:"Calories"`
+	AvgHeartRate  *tcxHeartRate   `xml:"AverageHeartRateBpm,omitempty"`
+	MaxHeartRate  *tcxHeartRate   `xml:"MaximumHeartRateBpm,omitempty"`
+	Intensity     string          `xml:"Intensity"`
+	Cadence       int             `xml:"Cadence,omitempty"`
+	TriggerMethod string          `xml:"TriggerMethod"`
+	Trackpoints   []tcxTrackpoint `xml:"Track>Trackpoint"`
+	Extensions    *tcxLapExt      `xml:"Extensions,omitempty"`
+}
+
+type tcxHeartRate struct {
+	Value int `xml:"Value"`
+}
+
+type tcxTrackpoint struct {
+	Time       string        `xml:"Time"`
+	Distance   float64       `xml:"DistanceMeters"`
+	HeartRate  *tcxHeartRate `xml:"HeartRateBpm,omitempty"`
+	Cadence    int           `xml:"Cadence,omitempty"`
+	Extensions *tcxPointExt  `xml:"Extensions,omitempty"`
+}
+
+type tcxPointExt struct {
+	TPX struct {
+		Watts uint32 `xml:"Watts"`
+	} `xml:"http://www.garmin.com/xmlschemas/ActivityExtension/v2 TPX"`
+}
+
+type tcxLapExt struct {
+	LX struct {
+		AvgWatts float64 `xml:"AvgWatts"`
+	} `xml:"http://www.garmin.com/xmlschemas/ActivityExtension/v2 LX"`
+}
+
+// TCX encodes the workout as a Training Center file with distance, heart
+// rate, stroke rate as cadence and power at each second. Without samples
+// the file has the start and end points only.
+func (w *Workout) TCX() ([]byte, error) {
+	samples := w.Samples
+	if len(samples) < 2 {
+		samples = []WorkoutSample{
+			{},
+			{Time: w.ElapsedTime, Distance: w.Distance, Power: uint32(w.AvgPower), StrokeRate: byte(w.AvgStrokeRate), HeartRate: byte(w.AvgHeartRate)},
+		}
+	}
+
+	start := w.StartedAt.UTC()
+	lap := tcxLap{
+		StartTime:     tcxTime(start, 0),
+		TotalTime:     w.ElapsedTime,
+		Distance:      w.Distance,
+		Calories:      int(math.Round(w.Calories)),
+		Intensity:     "Active",
+		Cadence:       int(math.Round(w.AvgStrokeRate)),
+		TriggerMethod: "Manual",
+		Extensions:    &tcxLapExt{},
+	}
+	lap.Extensions.LX.AvgWatts = w.AvgPower
+	if w.AvgHeartRate > 0 {
+		lap.AvgHeartRate = &tcxHeartRate{Value: int(math.Round(w.AvgHeartRate))}
+		lap.MaxHeartRate = &tcxHeartRate{Value: w.MaxHeartRate}
+	}
+
+	last := math.Inf(-1)
+	for i, s := range samples {
+		if s.Time-last < tcxInterval && i < len(samples)-1 {
+			continue
+		}
+		last = s.Time
+
+		point := tcxTrackpoint{
+			Time:       tcxTime(start, s.Time),
+			Distance:   s.Distance,
+			Cadence:    int(s.StrokeRate),
+			Extensions: &tcxPointExt{},
+		}
+		point.Extensions.TPX.Watts = s.Power
+		if s.HeartRate > 0 {
+			point.HeartRate = &tcxHeartRate{Value: int(s.HeartRate)}
+		}
+		lap.Trackpoints = append(lap.Trackpoints, point)
+	}
+
+	file := tcxDatabase{Activity: tcxActivity{Sport: "Other", ID: tcxTime(start, 0), Lap: lap}}
+	body, err := xml.MarshalIndent(file, "", " ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// tcxTime formats the time offset seconds after start
+func tcxTime(start time.Time, offset float64) string {
+	return start.Add(time.Duration(offset * float64(time.Second))).Format("2006-01-02T15:04:05.000Z")
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+// tcxFile is the parts of a Training Center file activity sites read
+type tcxFile struct {
+	XMLName  xml.Name
+	Activity struct {
+		Sport string `xml:"Sport,attr"`
+		ID    string `xml:"Id"`
+		Lap   struct {
+			StartTime    string  `xml:"StartTime,attr"`
+			TotalTime    float64 `xml:"TotalTimeSeconds"`
+			Distance     float64 `xml:"DistanceMeters"`
+			AvgHeartRate int     `xml:"AverageHeartRateBpm>Value"`
+			MaxHeartRate int     `xml:"MaximumHeartRateBpm>Value"`
+			Cadence      int     `xml:"Cadence"`
+			AvgWatts     float64 `xml:"Extensions>LX>AvgWatts"`
+			Trackpoints  []struct {
+				Time      string  `xml:"Time"`
+				Distance  float64 `xml:"DistanceMeters"`
+				HeartRate int     `xml:"HeartRateBpm>Value"`
+				Cadence   int     `xml:"Cadence"`
+				Watts     uint32  `xml:"Extensions>TPX>Watts"`
+			} `xml:"Track>Trackpoint"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+func decodeTCX(t *testing.T, w *Workout) tcxFile {
+	t.Helper()
+
+	data, err := w.TCX()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(xml.Header)) {
+		t.Fatalf("TCX has no XML declaration: %.60s", data)
+	}
+
+	var file tcxFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		t.Fatalf("TCX doesn't parse: %v", err)
+	}
+	if file.XMLName.Space != "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2" ||
+		file.XMLName.Local != "TrainingCenterDatabase" {
+		t.Fatalf("TCX root element %+v", file.XMLName)
+	}
+	return file
+}
+
+func TestWorkoutTCX(t *testing.T) {
+	w := &Workout{
+		StartedAt:     time.Date(2026, 10, 18, 8, 15, 2, 0, time.FixedZone("BST", 3600)),
+		Distance:      12,
+		ElapsedTime:   2.2,
+		AvgPower:      215.5,
+		AvgStrokeRate: 30.4,
+		AvgHeartRate:  141.6,
+		MaxHeartRate:  150,
+		Samples: []WorkoutSample{
+			{Time: 0, Distance: 0, Power: 0, StrokeRate: 0, HeartRate: 0},
+			{Time: 0.5, Distance: 2, Power: 200, StrokeRate: 30, HeartRate: 130},
+			{Time: 1.0, Distance: 5, Power: 210, StrokeRate: 30, HeartRate: 135},
+			{Time: 1.5, Distance: 8, Power: 220, StrokeRate: 31, HeartRate: 140},
+			{Time: 2.0, Distance: 11, Power: 225, StrokeRate: 31, HeartRate: 145},
+			{Time: 2.2, Distance: 12, Power: 230, StrokeRate: 31, HeartRate: 150},
+		},
+	}
+
+	file := decodeTCX(t, w)
+	lap := file.Activity.Lap
+
+	if file.Activity.ID != "2026-10-18T07:15:02.000Z" || lap.StartTime != file.Activity.ID {
+		t.Errorf("activity id %q and lap start %q, want the UTC start", file.Activity.ID, lap.StartTime)
+	}
+	if lap.TotalTime != 2.2 || lap.Distance != 12 || lap.Cadence != 30 || lap.AvgWatts != 215.5 {
+		t.Errorf("lap summary %+v", lap)
+	}
+	if lap.AvgHeartRate != 142 || lap.MaxHeartRate != 150 {
+		t.Errorf("lap heart rate %d avg, %d max", lap.AvgHeartRate, lap.MaxHeartRate)
+	}
+
+	// One trackpoint a second, always keeping the last sample
+	want := []struct {
+		time     string
+		distance float64
+		hr       int
+		watts    uint32
+	}{
+		{"2026-10-18T07:15:02.000Z", 0, 0, 0},
+		{"2026-10-18T07:15:03.000Z", 5, 135, 210},
+		{"2026-10-18T07:15:04.000Z", 11, 145, 225},
+		{"2026-10-18T07:15:04.200Z", 12, 150, 230},
+	}
+	if len(lap.Trackpoints) != len(want) {
+		t.Fatalf("got %d trackpoints, want %d", len(lap.Trackpoints), len(want))
+	}
+	for i, point := range lap.Trackpoints {
+		if point.Time != want[i].time || point.Distance != want[i].distance ||
+			point.HeartRate != want[i].hr || point.Watts != want[i].watts {
+			t.Errorf("trackpoint %d is %+v, want %+v", i, point, want[i])
+		}
+	}
+}
+
+func TestWorkoutTCXSummaryOnly(t *testing.T) {
+	w := &Workout{
+		StartedAt:     time.Date(2026, 10, 18, 7, 0, 0, 0, time.UTC),
+		Distance:      2000,
+		ElapsedTime:   420,
+		AvgPower:      250,
+		AvgStrokeRate: 28,
+	}
+
+	lap := decodeTCX(t, w).Activity.Lap
+	if len(lap.Trackpoints) != 2 {
+		t.Fatalf("got %d trackpoints, want start and end", len(lap.Trackpoints))
+	}
+	end := lap.Trackpoints[1]
+	if end.Time != "2026-10-18T07:07:00.000Z" || end.Distance != 2000 || end.Watts != 250 || end.Cadence != 28 {
+		t.Errorf("end trackpoint %+v", end)
+	}
+	if lap.AvgHeartRate != 0 || end.HeartRate != 0 {
+		t.Error("heart rate written without any data")
+	}
+}
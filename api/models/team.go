@@ -0,0 +1,150 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Team roles. Each team has one owner; coaches can see their athletes'
+// workouts and invite athletes.
+const (
+	RoleOwner   = "owner"
+	RoleCoach   = "coach"
+	RoleAthlete = "athlete"
+)
+
+const (
+	// maxTeamDescriptionLength bounds a team's description
+	maxTeamDescriptionLength = 1000
+
+	// Invite lifetimes
+	defaultInviteLifetime = 7 * 24 * time.Hour
+	maxInviteLifetime     = 30 * 24 * time.Hour
+)
+
+// Team is a team or club of users
+type Team struct {
+	ID          string    `json:"id" firestore:"-"`
+	Name        string    `json:"name" firestore:"name"`
+	Description string    `json:"description,omitempty" firestore:"description"`
+	Kind        string    `json:"kind" firestore:"kind"` // team or club
+	OwnerID     string    `json:"owner_id" firestore:"owner_id"`
+	CreatedAt   time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" firestore:"updated_at"`
+
+	// The requesting user's role, set when a user's teams are listed
+	Role string `json:"role,omitempty" firestore:"-"`
+}
+
+// Member is a user's membership of a team
+type Member struct {
+	UserID      string    `json:"user_id" firestore:"-"`
+	DisplayName string    `json:"display_name" firestore:"display_name"`
+	Role        string    `json:"role" firestore:"role"`
+	JoinedAt    time.Time `json:"joined_at" firestore:"joined_at"`
+}
+
+// Invite lets whoever has its code join a team with a role. A MaxUses of
+// zero allows any number of uses until it expires.
+type Invite struct {
+	Code      string    `json:"code" firestore:"-"`
+	TeamID    string    `json:"team_id" firestore:"team_id"`
+	TeamName  string    `json:"team_name" firestore:"team_name"`
+	Role      string    `json:"role" firestore:"role"`
+	MaxUses   int       `json:"max_uses" firestore:"max_uses"`
+	Uses      int       `json:"uses" firestore:"uses"`
+	CreatedBy string    `json:"created_by" firestore:"created_by"`
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" firestore:"expires_at"`
+}
+
+// Validate checks a team submitted for saving, defaulting its kind to team
+func (t *Team) Validate() error {
+	var errs []error
+
+	if t.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if len(t.Name) > maxTemplateNameLength {
+		errs = append(errs, fmt.Errorf("name must be at most %d characters", maxTemplateNameLength))
+	}
+	if len(t.Description) > maxTeamDescriptionLength {
+		errs = append(errs, fmt.Errorf("description must be at most %d characters", maxTeamDescriptionLength))
+	}
+
+	switch t.Kind {
+	case "":
+		t.Kind = "team"
+	case "team", "club":
+	default:
+		errs = append(errs, fmt.Errorf("kind must be team or club, got %q", t.Kind))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Validate checks an invite submitted for creation at now, defaulting it
+// to athletes and a week's lifetime
+func (i *Invite) Validate(now time.Time) error {
+	var errs []error
+
+	switch i.Role {
+	case "":
+		i.Role = RoleAthlete
+	case RoleCoach, RoleAthlete:
+	default:
+		errs = append(errs, fmt.Errorf("role must be coach or athlete, got %q", i.Role))
+	}
+
+	if i.MaxUses < 0 {
+		errs = append(errs, errors.New("max_uses must not be negative"))
+	}
+
+	switch {
+	case i.ExpiresAt.IsZero():
+		i.ExpiresAt = now.Add(defaultInviteLifetime)
+	case !i.ExpiresAt.After(now):
+		errs = append(errs, errors.New("expires_at must be in the future"))
+	case i.ExpiresAt.Sub(now) > maxInviteLifetime:
+		errs = append(errs, fmt.Errorf("expires_at must be within %d days", int(maxInviteLifetime.Hours()/24)))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Usable reports whether the invite can still be accepted at now
+func (i *Invite) Usable(now time.Time) bool {
+	return now.Before(i.ExpiresAt) && (i.MaxUses == 0 || i.Uses < i.MaxUses)
+}
+
+// ValidRole reports whether role can be assigned to a member. Ownership
+// isn't assignable.
+func ValidRole(role string) bool {
+	return role == RoleCoach || role == RoleAthlete
+}
+
+// CanInvite reports whether a member with role may invite others with
+// inviteRole: owners may invite anyone, coaches only athletes
+func CanInvite(role, inviteRole string) bool {
+	switch role {
+	case RoleOwner:
+		return true
+	case RoleCoach:
+		return inviteRole == RoleAthlete
+	}
+	return false
+}
+
+// CanManageTemplates reports whether a member with role may share, change
+// and remove a team's templates: owners and coaches. Every member can read
+// them.
+func CanManageTemplates(role string) bool {
+	return role == RoleOwner || role == RoleCoach
+}
+
+// CanCoach reports whether a member with role may see the workouts and
+// live sessions of a member with memberRole
+func CanCoach(role, memberRole string) bool {
+	return (role == RoleOwner || role == RoleCoach) && memberRole == RoleAthlete
+}
@@ -0,0 +1,72 @@
+package models
+
+import "testing"
+
+func TestCanManageTemplates(t *testing.T) {
+	for role, want := range map[string]bool{
+		RoleOwner:   true,
+		RoleCoach:   true,
+		RoleAthlete: false,
+		"":          false,
+	} {
+		if got := CanManageTemplates(role); got != want {
+			t.Errorf("CanManageTemplates(%q) = %v, want %v", role, got, want)
+		}
+	}
+}
+
+func TestValidRole(t *testing.T) {
+	// Members can be made coaches or athletes, but ownership isn't
+	// assignable
+	for role, want := range map[string]bool{
+		RoleOwner:   false,
+		RoleCoach:   true,
+		RoleAthlete: true,
+		"":          false,
+		"admin":     false,
+	} {
+		if got := ValidRole(role); got != want {
+			t.Errorf("ValidRole(%q) = %v, want %v", role, got, want)
+		}
+	}
+}
+
+func TestCanInvite(t *testing.T) {
+	tests := []struct {
+		role, inviteRole string
+		want             bool
+	}{
+		{RoleOwner, RoleCoach, true},
+		{RoleOwner, RoleAthlete, true},
+		{RoleCoach, RoleCoach, false},
+		{RoleCoach, RoleAthlete, true},
+		{RoleAthlete, RoleAthlete, false},
+		{RoleAthlete, RoleCoach, false},
+		{"", RoleAthlete, false},
+	}
+	for _, tt := range tests {
+		if got := CanInvite(tt.role, tt.inviteRole); got != tt.want {
+			t.Errorf("CanInvite(%q, %q) = %v, want %v", tt.role, tt.inviteRole, got, tt.want)
+		}
+	}
+}
+
+func TestCanCoach(t *testing.T) {
+	tests := []struct {
+		role, memberRole string
+		want             bool
+	}{
+		{RoleOwner, RoleAthlete, true},
+		{RoleOwner, RoleCoach, false},
+		{RoleCoach, RoleAthlete, true},
+		{RoleCoach, RoleCoach, false},
+		{RoleCoach, RoleOwner, false},
+		{RoleAthlete, RoleAthlete, false},
+		{"", RoleAthlete, false},
+	}
+	for _, tt := range tests {
+		if got := CanCoach(tt.role, tt.memberRole); got != tt.want {
+			t.Errorf("CanCoach(%q, %q) = %v, want %v", tt.role, tt.memberRole, got, tt.want)
+		}
+	}
+}
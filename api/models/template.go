@@ -0,0 +1,103 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// maxTemplateNameLength bounds a template's name
+	maxTemplateNameLength = 100
+
+	// maxTemplateIntervals matches the socket server's limit on pieces in
+	// an interval workout
+	maxTemplateIntervals = 50
+)
+
+// TemplateInterval is one piece of an interval workout and the rest after
+// it. Set one of distance or time.
+type TemplateInterval struct {
+	Distance uint32 `json:"distance,omitempty" firestore:"distance"` // meters
+	Time     uint32 `json:"time,omitempty" firestore:"time"`         // seconds
+	Rest     uint32 `json:"rest" firestore:"rest"`                   // seconds
+}
+
+// TemplatePaceBoat races a workout against a recorded session or a target
+// pace
+type TemplatePaceBoat struct {
+	SessionID  string  `json:"session_id,omitempty" firestore:"session_id"`
+	TargetPace float64 `json:"target_pace,omitempty" firestore:"target_pace"` // seconds per 500m
+}
+
+// Template is a named, reusable workout. Its body matches the socket
+// server's start_workout parameters so a template can be started as is.
+// Templates belong to a user or are shared with a team.
+type Template struct {
+	ID          string `json:"id" firestore:"-"`
+	TeamID      string `json:"team_id,omitempty" firestore:"-"` // set for templates shared with a team
+	Name        string `json:"name" firestore:"name"`
+	Description string `json:"description,omitempty" firestore:"description"`
+
+	WorkoutType   string             `json:"workout_type" firestore:"workout_type"` // just_row, fixed_distance, fixed_time, intervals
+	Distance      uint32             `json:"distance,omitempty" firestore:"distance"`
+	Time          uint32             `json:"time,omitempty" firestore:"time"`
+	SplitDistance uint32             `json:"split_distance,omitempty" firestore:"split_distance"`
+	SplitTime     uint32             `json:"split_time,omitempty" firestore:"split_time"`
+	Intervals     []TemplateInterval `json:"intervals,omitempty" firestore:"intervals"`
+	PaceBoat      *TemplatePaceBoat  `json:"pace_boat,omitempty" firestore:"pace_boat"`
+
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// Validate checks the template describes a workout the socket server can
+// start
+func (t *Template) Validate() error {
+	var errs []error
+
+	if t.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if len(t.Name) > maxTemplateNameLength {
+		errs = append(errs, fmt.Errorf("name must be at most %d characters", maxTemplateNameLength))
+	}
+
+	switch t.WorkoutType {
+	case "just_row":
+	case "fixed_distance":
+		if t.Distance == 0 {
+			errs = append(errs, errors.New("distance is required for fixed_distance workouts"))
+		}
+	case "fixed_time":
+		if t.Time == 0 {
+			errs = append(errs, errors.New("time is required for fixed_time workouts"))
+		}
+	case "intervals":
+		if len(t.Intervals) == 0 {
+			errs = append(errs, errors.New("intervals are required for intervals workouts"))
+		}
+		if len(t.Intervals) > maxTemplateIntervals {
+			errs = append(errs, fmt.Errorf("at most %d intervals are allowed", maxTemplateIntervals))
+		}
+		for i, interval := range t.Intervals {
+			if (interval.Distance == 0) == (interval.Time == 0) {
+				errs = append(errs, fmt.Errorf("interval %d needs either distance or time", i+1))
+			}
+		}
+		if t.PaceBoat != nil {
+			errs = append(errs, errors.New("pace_boat is not supported for intervals workouts"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown workout_type: %q", t.WorkoutType))
+	}
+
+	if t.WorkoutType != "intervals" && len(t.Intervals) > 0 {
+		errs = append(errs, errors.New("intervals are only allowed for intervals workouts"))
+	}
+	if t.PaceBoat != nil && t.PaceBoat.SessionID == "" && t.PaceBoat.TargetPace <= 0 {
+		errs = append(errs, errors.New("pace_boat requires session_id or target_pace"))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// StreamTicket lets a browser open a server-sent event stream, which
+// EventSource can't send an Authorization header for
+type StreamTicket struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Lightweight limits for rowing, in kilograms
+const (
+	lightweightMaleLimit   = 75.0
+	lightweightFemaleLimit = 61.5
+)
+
+// Profile is a user's details and physiology. Values other than the
+// display name may be zero if unknown.
+type Profile struct {
+	DisplayName string    `json:"display_name" firestore:"display_name"`
+	Email       string    `json:"email,omitempty" firestore:"email"`
+	PhotoURL    string    `json:"photo_url,omitempty" firestore:"photo_url"`
+	Weight      float64   `json:"weight" firestore:"weight"` // kg
+	Height      float64   `json:"height" firestore:"height"` // cm
+	BirthYear   int       `json:"birth_year" firestore:"birth_year"`
+	Sex         string    `json:"sex" firestore:"sex"`               // female, male or empty
+	MaxHR       int       `json:"max_hr" firestore:"max_hr"`         // bpm
+	RestingHR   int       `json:"resting_hr" firestore:"resting_hr"` // bpm
+	Units       string    `json:"units" firestore:"units"`           // metric or imperial
+	WeightClass string    `json:"weight_class" firestore:"weight_class"`
+	ErgType     string    `json:"erg_type" firestore:"erg_type"` // rower, skierg or bikeerg
+	CreatedAt   time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// Validate checks the profile is plausible, filling in defaults for the
+// units and erg type
+func (p *Profile) Validate() error {
+	var errs []error
+
+	if p.DisplayName == "" {
+		errs = append(errs, errors.New("display_name is required"))
+	}
+	if len(p.DisplayName) > maxTemplateNameLength {
+		errs = append(errs, fmt.Errorf("display_name must be at most %d characters", maxTemplateNameLength))
+	}
+	if p.Weight != 0 && (p.Weight < 25 || p.Weight > 250) {
+		errs = append(errs, fmt.Errorf("weight must be between 25 and 250 kg, got %g", p.Weight))
+	}
+	if p.Height != 0 && (p.Height < 100 || p.Height > 250) {
+		errs = append(errs, fmt.Errorf("height must be between 100 and 250 cm, got %g", p.Height))
+	}
+	if year := time.Now().Year(); p.BirthYear != 0 && (p.BirthYear < year-110 || p.BirthYear > year-5) {
+		errs = append(errs, fmt.Errorf("birth_year must be between %d and %d, got %d", year-110, year-5, p.BirthYear))
+	}
+	if p.Sex != "" && p.Sex != "female" && p.Sex != "male" {
+		errs = append(errs, fmt.Errorf("sex must be female, male or empty, got %q", p.Sex))
+	}
+	if p.MaxHR != 0 && (p.MaxHR < 100 || p.MaxHR > 250) {
+		errs = append(errs, fmt.Errorf("max_hr must be between 100 and 250, got %d", p.MaxHR))
+	}
+	if p.RestingHR != 0 && (p.RestingHR < 25 || p.RestingHR > 120) {
+		errs = append(errs, fmt.Errorf("resting_hr must be between 25 and 120, got %d", p.RestingHR))
+	}
+	if p.MaxHR != 0 && p.RestingHR != 0 && p.RestingHR >= p.MaxHR {
+		errs = append(errs, errors.New("resting_hr must be below max_hr"))
+	}
+
+	switch p.Units {
+	case "":
+		p.Units = "metric"
+	case "metric", "imperial":
+	default:
+		errs = append(errs, fmt.Errorf("units must be metric or imperial, got %q", p.Units))
+	}
+
+	switch p.WeightClass {
+	case "", "heavyweight", "lightweight":
+	default:
+		errs = append(errs, fmt.Errorf("weight_class must be heavyweight, lightweight or empty, got %q", p.WeightClass))
+	}
+
+	switch p.ErgType {
+	case "":
+		p.ErgType = "rower"
+	case "rower", "skierg", "bikeerg":
+	default:
+		errs = append(errs, fmt.Errorf("erg_type must be rower, skierg or bikeerg, got %q", p.ErgType))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Class returns the user's weight class: the one they chose, or otherwise
+// the one their weight and sex put them in. It is empty when unknown.
+func (p *Profile) Class() string {
+	if p.WeightClass != "" {
+		return p.WeightClass
+	}
+
+	var limit float64
+	switch p.Sex {
+	case "male":
+		limit = lightweightMaleLimit
+	case "female":
+		limit = lightweightFemaleLimit
+	default:
+		return ""
+	}
+	if p.Weight == 0 {
+		return ""
+	}
+	if p.Weight <= limit {
+		return "lightweight"
+	}
+	return "heavyweight"
+}
+
+// Age returns the user's age in the year of t, or 0 if unknown
+func (p *Profile) Age(t time.Time) int {
+	if p.BirthYear == 0 {
+		return 0
+	}
+	return t.Year() - p.BirthYear
+}
+
+// WithProfile fills in zone settings the user hasn't set from their
+// profile. s may be nil.
+func (s *ZoneSettings) WithProfile(profile *Profile) *ZoneSettings {
+	var merged ZoneSettings
+	if s != nil {
+		merged = *s
+	}
+	if merged.MaxHR == 0 && profile != nil {
+		merged.MaxHR = profile.MaxHR
+	}
+	return &merged
+}
+
+// EstimateCalories estimates the energy a workout used from heart rate
+// with the Keytel equations, which account for weight, age and sex. It
+// returns 0 when the profile or heart rate data is incomplete.
+func (w *Workout) EstimateCalories(profile *Profile) float64 {
+	if profile == nil || profile.Weight == 0 || profile.BirthYear == 0 || profile.Sex == "" {
+		return 0
+	}
+	age := float64(profile.Age(w.StartedAt))
+
+	var kcal float64
+	for i := 1; i < len(w.Samples); i++ {
+		dt := w.Samples[i].Time - w.Samples[i-1].Time
+		hr := float64(w.Samples[i].HeartRate)
+		if hr == 0 || dt <= 0 || dt > maxSampleGap {
+			continue
+		}
+
+		var perMinute float64
+		if profile.Sex == "male" {
+			perMinute = (-55.0969 + 0.6309*hr + 0.1988*profile.Weight + 0.2017*age) / 4.184
+		} else {
+			perMinute = (-20.4022 + 0.4472*hr - 0.1263*profile.Weight + 0.074*age) / 4.184
+		}
+		kcal += math.Max(perMinute, 0) * dt / 60
+	}
+	return math.Round(kcal)
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfileValidate(t *testing.T) {
+	year := time.Now().Year()
+
+	tests := []struct {
+		name    string
+		profile Profile
+		error   string // substring of the expected error, "" if valid
+	}{
+		{"minimal", Profile{DisplayName: "Sam"}, ""},
+		{"complete", Profile{
+			DisplayName: "Sam", Weight: 80, Height: 185, BirthYear: 1986, Sex: "male", MaxHR: 185, RestingHR: 50,
+			Units: "imperial", WeightClass: "heavyweight", ErgType: "skierg",
+		}, ""},
+		{"no name", Profile{}, "display_name is required"},
+		{"long name", Profile{DisplayName: strings.Repeat("a", maxTemplateNameLength+1)}, "display_name must be at most"},
+		{"light", Profile{DisplayName: "Sam", Weight: 24}, "weight must be between 25 and 250 kg"},
+		{"heavy", Profile{DisplayName: "Sam", Weight: 251}, "weight must be between"},
+		{"short", Profile{DisplayName: "Sam", Height: 99}, "height must be between"},
+		{"born too long ago", Profile{DisplayName: "Sam", BirthYear: year - 111}, "birth_year must be between"},
+		{"born too recently", Profile{DisplayName: "Sam", BirthYear: year - 4}, "birth_year must be between"},
+		{"unknown sex", Profile{DisplayName: "Sam", Sex: "other"}, "sex must be female, male or empty"},
+		{"max_hr", Profile{DisplayName: "Sam", MaxHR: 260}, "max_hr must be between"},
+		{"resting_hr", Profile{DisplayName: "Sam", RestingHR: 20}, "resting_hr must be between"},
+		{"resting above max", Profile{DisplayName: "Sam", MaxHR: 110, RestingHR: 115}, "resting_hr must be below max_hr"},
+		{"units", Profile{DisplayName: "Sam", Units: "furlongs"}, "units must be metric or imperial"},
+		{"weight class", Profile{DisplayName: "Sam", WeightClass: "middleweight"}, "weight_class must be"},
+		{"erg type", Profile{DisplayName: "Sam", ErgType: "treadmill"}, "erg_type must be"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.profile.Validate()
+			if tt.error == "" {
+				if err != nil {
+					t.Fatalf("got %v, want valid", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.error)
+			}
+		})
+	}
+}
+
+func TestProfileValidateDefaults(t *testing.T) {
+	p := Profile{DisplayName: "Sam"}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Units != "metric" || p.ErgType != "rower" {
+		t.Errorf("units %q and erg type %q, want metric and rower", p.Units, p.ErgType)
+	}
+}
+
+func TestProfileClass(t *testing.T) {
+	tests := []struct {
+		profile Profile
+		want    string
+	}{
+		{Profile{Sex: "male", Weight: 75}, "lightweight"},
+		{Profile{Sex: "male", Weight: 75.1}, "heavyweight"},
+		{Profile{Sex: "female", Weight: 61.5}, "lightweight"},
+		{Profile{Sex: "female", Weight: 61.6}, "heavyweight"},
+		// The chosen class wins
+		{Profile{Sex: "male", Weight: 90, WeightClass: "lightweight"}, "lightweight"},
+		{Profile{Sex: "male"}, ""},
+		{Profile{Weight: 70}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.profile.Class(); got != tt.want {
+			t.Errorf("Class of %+v = %q, want %q", tt.profile, got, tt.want)
+		}
+	}
+}
+
+func TestZoneSettingsWithProfile(t *testing.T) {
+	profile := &Profile{MaxHR: 190}
+
+	if got := (*ZoneSettings)(nil).WithProfile(profile); got.MaxHR != 190 {
+		t.Errorf("no settings with a profile: max_hr %d, want 190", got.MaxHR)
+	}
+	settings := &ZoneSettings{MaxHR: 185, FTP: 240}
+	if got := settings.WithProfile(profile); got.MaxHR != 185 || got.FTP != 240 {
+		t.Errorf("settings with a profile %+v, want the user's own max_hr kept", got)
+	}
+	if got := settings.WithProfile(nil); *got != *settings || got == settings {
+		t.Errorf("settings without a profile %+v, want a copy of the settings", got)
+	}
+}
+
+func TestEstimateCalories(t *testing.T) {
+	w := &Workout{StartedAt: time.Date(2026, 10, 18, 7, 0, 0, 0, time.UTC)}
+	for i := 0; i <= 3600; i++ {
+		w.Samples = append(w.Samples, WorkoutSample{Time: float64(i), HeartRate: 150})
+	}
+
+	tests := []struct {
+		name    string
+		profile *Profile
+		want    float64
+	}{
+		// Keytel et al.: an hour at 150 bpm
+		{"man of 40 at 80kg", &Profile{Sex: "male", Weight: 80, BirthYear: 1986}, 911},
+		{"woman of 30 at 60kg", &Profile{Sex: "female", Weight: 60, BirthYear: 1996}, 593},
+		{"no profile", nil, 0},
+		{"no weight", &Profile{Sex: "male", BirthYear: 1986}, 0},
+		{"no age", &Profile{Sex: "male", Weight: 80}, 0},
+		{"no sex", &Profile{Weight: 80, BirthYear: 1986}, 0},
+	}
+	for _, tt := range tests {
+		if got := w.EstimateCalories(tt.profile); got != tt.want {
+			t.Errorf("%s: %v kcal, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Webhook events
+const (
+	WebhookPing             = "ping" // sent on request to test a webhook
+	WebhookWorkoutCompleted = "workout_completed"
+	WebhookPersonalBest     = "personal_best"
+	WebhookChallengeCreated = "challenge_created"
+	WebhookChallengeEntry   = "challenge_entry" // a workout changed an entry
+)
+
+// WebhookEvents are the events a webhook can subscribe to
+var WebhookEvents = []string{
+	WebhookWorkoutCompleted,
+	WebhookPersonalBest,
+	WebhookChallengeCreated,
+	WebhookChallengeEntry,
+}
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// maxWebhookURLLength bounds a webhook's URL
+const maxWebhookURLLength = 2048
+
+// Webhook receives signed JSON payloads for events. It belongs to a user,
+// receiving their events, or to a team, receiving its members' events and
+// those of its challenges.
+type Webhook struct {
+	ID          string    `json:"id" firestore:"-"`
+	URL         string    `json:"url" firestore:"url"`
+	Description string    `json:"description,omitempty" firestore:"description"`
+	Events      []string  `json:"events" firestore:"events"`
+	Active      bool      `json:"active" firestore:"active"`
+	UserID      string    `json:"user_id,omitempty" firestore:"user_id"`
+	TeamID      string    `json:"team_id,omitempty" firestore:"team_id"`
+	Secret      string    `json:"secret,omitempty" firestore:"secret"` // only returned when created
+	CreatedBy   string    `json:"created_by" firestore:"created_by"`
+	CreatedAt   time.Time `json:"created_at" firestore:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" firestore:"updated_at"`
+}
+
+// WebhookPayload is the JSON body posted to a webhook
+type WebhookPayload struct {
+	ID        string      `json:"id"` // the delivery ID; redeliveries get a new one
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	UserID    string      `json:"user_id,omitempty"` // whose event it is
+	TeamID    string      `json:"team_id,omitempty"` // the team webhook it was sent to
+	Data      interface{} `json:"data"`
+}
+
+// DeliveryAttempt is one try at delivering a payload
+type DeliveryAttempt struct {
+	At       time.Time `json:"at" firestore:"at"`
+	Status   int       `json:"status,omitempty" firestore:"status"` // HTTP status, 0 if there was no response
+	Error    string    `json:"error,omitempty" firestore:"error"`
+	Duration float64   `json:"duration" firestore:"duration"` // seconds
+}
+
+// Delivery is a payload sent to a webhook, with a log of each attempt
+type Delivery struct {
+	ID            string            `json:"id" firestore:"-"`
+	WebhookID     string            `json:"webhook_id" firestore:"webhook_id"`
+	Event         string            `json:"event" firestore:"event"`
+	Payload       string            `json:"payload,omitempty" firestore:"payload"` // JSON body, only returned for a single delivery
+	Status        string            `json:"status" firestore:"status"`
+	Attempts      []DeliveryAttempt `json:"attempts" firestore:"attempts"`
+	RedeliveryOf  string            `json:"redelivery_of,omitempty" firestore:"redelivery_of"`
+	CreatedAt     time.Time         `json:"created_at" firestore:"created_at"`
+	NextAttemptAt time.Time         `json:"next_attempt_at,omitempty" firestore:"next_attempt_at"`
+}
+
+// Validate checks a webhook submitted for saving
+func (h *Webhook) Validate() error {
+	var errs []error
+
+	if len(h.URL) > maxWebhookURLLength {
+		errs = append(errs, fmt.Errorf("url must be at most %d characters", maxWebhookURLLength))
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, errors.New("url must be an http or https URL"))
+	} else if u.User != nil {
+		errs = append(errs, errors.New("url must not contain credentials"))
+	}
+
+	if len(h.Description) > maxTemplateNameLength {
+		errs = append(errs, fmt.Errorf("description must be at most %d characters", maxTemplateNameLength))
+	}
+
+	if len(h.Events) == 0 {
+		errs = append(errs, errors.New("at least one event is required"))
+	}
+	for i, event := range h.Events {
+		if !contains(WebhookEvents, event) {
+			errs = append(errs, fmt.Errorf("unknown event %q", event))
+		} else if contains(h.Events[:i], event) {
+			errs = append(errs, fmt.Errorf("event %q is listed twice", event))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Subscribed reports whether the webhook receives event. Every active
+// webhook receives pings.
+func (h *Webhook) Subscribed(event string) bool {
+	return h.Active && (event == WebhookPing || contains(h.Events, event))
+}
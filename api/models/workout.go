@@ -0,0 +1,178 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+)
+
+const (
+	// maxSamples bounds the samples accepted with one workout: over two
+	// hours at the socket server's 100ms polling rate
+	maxSamples = 100000
+
+	// maxWorkoutTime bounds a sample's time since the start
+	maxWorkoutTime = 24 * 60 * 60.0 // seconds
+
+	// maxSampleInterval bounds the time between consecutive samples
+	maxSampleInterval = 10 * 60.0 // seconds
+
+	// maxSessionIDLength bounds session_id, which becomes the workout's
+	// document ID
+	maxSessionIDLength = 128
+)
+
+// sessionIDPattern matches the session_ids accepted: those of the socket
+// server's sessions and imported workouts. Starting with a letter or digit
+// also keeps clear of Firestore's reserved __name__ IDs.
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// WorkoutSample is a single point of a workout, in the same format as the
+// socket server's recorded sessions
+type WorkoutSample struct {
+	Time       float64 `json:"t"`   // seconds since the start
+	Distance   float64 `json:"d"`   // meters
+	Pace       float64 `json:"p"`   // seconds per 500m
+	Power      uint32  `json:"w"`   // watts
+	StrokeRate byte    `json:"spm"` // strokes per minute
+	HeartRate  byte    `json:"hr"`  // bpm (0 = no data)
+}
+
+// Workout is a stored workout. Summary values are computed from the
+// samples when the workout is saved; the samples themselves are kept in
+// InfluxDB.
+type Workout struct {
+	ID            string    `json:"id" firestore:"-"`
+	SessionID     string    `json:"session_id,omitempty" firestore:"session_id"`
+	Serial        string    `json:"serial,omitempty" firestore:"serial"`
+	WorkoutType   string    `json:"workout_type" firestore:"workout_type"`
+	StartedAt     time.Time `json:"started_at" firestore:"started_at"`
+	Distance      float64   `json:"distance" firestore:"distance"`         // meters
+	ElapsedTime   float64   `json:"elapsed_time" firestore:"elapsed_time"` // seconds
+	AvgPace       float64   `json:"avg_pace" firestore:"avg_pace"`         // seconds per 500m
+	AvgPower      float64   `json:"avg_power" firestore:"avg_power"`       // watts
+	AvgStrokeRate float64   `json:"avg_stroke_rate" firestore:"avg_stroke_rate"`
+	AvgHeartRate  float64   `json:"avg_heart_rate" firestore:"avg_heart_rate"` // 0 = no data
+	MaxHeartRate  int       `json:"max_heart_rate" firestore:"max_heart_rate"`
+	BestEfforts   []Effort  `json:"best_efforts" firestore:"best_efforts"`
+
+	// Training stress, computed when the workout is saved from the user's
+	// settings at the time
+	NormalizedPower float64 `json:"normalized_power,omitempty" firestore:"normalized_power"` // watts
+	IntensityFactor float64 `json:"intensity_factor,omitempty" firestore:"intensity_factor"`
+	TSS             float64 `json:"tss,omitempty" firestore:"tss"`
+	TRIMP           float64 `json:"trimp,omitempty" firestore:"trimp"`
+
+	// Energy estimated from heart rate and the user's profile, 0 if unknown
+	Calories float64 `json:"calories,omitempty" firestore:"calories"` // kcal
+
+	// The rower's profile weight when the workout was saved, with the
+	// Concept2 weight-adjusted time and distance and average watts per
+	// kilogram derived from it. 0 when the weight is unknown.
+	Weight           float64 `json:"weight,omitempty" firestore:"weight"`                       // kg
+	AdjustedTime     float64 `json:"adjusted_time,omitempty" firestore:"adjusted_time"`         // seconds
+	AdjustedDistance float64 `json:"adjusted_distance,omitempty" firestore:"adjusted_distance"` // meters
+	WattsPerKg       float64 `json:"watts_per_kg,omitempty" firestore:"watts_per_kg"`
+
+	// Best power and pace for each curve duration the workout covers
+	Curve []CurvePoint `json:"curve,omitempty" firestore:"curve"`
+
+	CreatedAt time.Time `json:"created_at" firestore:"created_at"`
+
+	Samples []WorkoutSample `json:"samples,omitempty" firestore:"-"`
+}
+
+// Validate checks a workout submitted for saving
+func (w *Workout) Validate() error {
+	var errs []error
+
+	if w.StartedAt.IsZero() {
+		errs = append(errs, errors.New("started_at is required"))
+	}
+	if len(w.Samples) < 2 {
+		errs = append(errs, errors.New("at least two samples are required"))
+	}
+	if len(w.Samples) > maxSamples {
+		errs = append(errs, fmt.Errorf("at most %d samples are allowed", maxSamples))
+	}
+	if len(w.SessionID) > maxSessionIDLength {
+		errs = append(errs, fmt.Errorf("session_id must be at most %d characters", maxSessionIDLength))
+	} else if w.SessionID != "" && !sessionIDPattern.MatchString(w.SessionID) {
+		errs = append(errs, errors.New("session_id must start with a letter or digit and contain only letters, digits, '-' and '_'"))
+	}
+
+	for i, s := range w.Samples {
+		if s.Time < 0 || s.Time > maxWorkoutTime {
+			errs = append(errs, fmt.Errorf("sample %d time must be between 0 and %.0f seconds", i, maxWorkoutTime))
+			break
+		}
+		if i == 0 {
+			continue
+		}
+
+		prev := w.Samples[i-1]
+		if s.Time < prev.Time || s.Distance < prev.Distance {
+			errs = append(errs, fmt.Errorf("sample %d goes backwards", i))
+			break
+		}
+		if s.Time-prev.Time > maxSampleInterval {
+			errs = append(errs, fmt.Errorf("sample %d is more than %.0f seconds after the one before", i, maxSampleInterval))
+			break
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Summarize computes the workout's summary values and best efforts from
+// its samples
+func (w *Workout) Summarize() {
+	if len(w.Samples) == 0 {
+		return
+	}
+
+	first, last := w.Samples[0], w.Samples[len(w.Samples)-1]
+	w.ElapsedTime = last.Time
+	w.Distance = last.Distance
+	if w.Distance > 0 {
+		w.AvgPace = w.ElapsedTime / w.Distance * 500
+	}
+
+	// Time-weighted averages, crediting each sample with the time since the
+	// one before
+	var power, rate, heartRate, hrTime float64
+	duration := last.Time - first.Time
+	w.MaxHeartRate = 0
+	for i := 1; i < len(w.Samples); i++ {
+		s := w.Samples[i]
+		dt := s.Time - w.Samples[i-1].Time
+
+		power += float64(s.Power) * dt
+		rate += float64(s.StrokeRate) * dt
+		if s.HeartRate > 0 {
+			heartRate += float64(s.HeartRate) * dt
+			hrTime += dt
+		}
+		if int(s.HeartRate) > w.MaxHeartRate {
+			w.MaxHeartRate = int(s.HeartRate)
+		}
+	}
+
+	if duration > 0 {
+		w.AvgPower = round1(power / duration)
+		w.AvgStrokeRate = round1(rate / duration)
+	}
+	if hrTime > 0 {
+		w.AvgHeartRate = round1(heartRate / hrTime)
+	}
+
+	w.BestEfforts = BestEfforts(w.Samples)
+	w.Curve = BuildCurve(w.Samples)
+}
+
+// round1 rounds to one decimal place
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testWorkout returns a workout that passes validation
+func testWorkout() *Workout {
+	return &Workout{
+		SessionID: "20261018T071502Z-430123456",
+		StartedAt: time.Date(2026, 10, 18, 7, 15, 2, 0, time.UTC),
+		Samples: []WorkoutSample{
+			{Time: 0, Distance: 0, Power: 200},
+			{Time: 1, Distance: 4, Power: 210},
+			{Time: 2, Distance: 8, Power: 220},
+		},
+	}
+}
+
+func TestWorkoutValidateSessionID(t *testing.T) {
+	tests := []struct {
+		sessionID string
+		valid     bool
+	}{
+		{"", true},
+		{"20261018T071502Z", true},
+		{"20261018T071502Z-430123456", true},
+		{"concept2-12345", true},
+		{"a/b", false},
+		{"..", false},
+		{"__id__", false},
+		{"-1", false},
+		{"id with spaces", false},
+		{strings.Repeat("a", maxSessionIDLength), true},
+		{strings.Repeat("a", maxSessionIDLength+1), false},
+	}
+
+	for _, tt := range tests {
+		w := testWorkout()
+		w.SessionID = tt.sessionID
+		if err := w.Validate(); (err == nil) != tt.valid {
+			t.Errorf("session_id %q: got %v, want valid %v", tt.sessionID, err, tt.valid)
+		}
+	}
+}
+
+func TestWorkoutValidateSampleTimes(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []WorkoutSample
+		valid   bool
+	}{
+		{"negative time", []WorkoutSample{{Time: -1}, {Time: 1}}, false},
+		{"past the limit", []WorkoutSample{{Time: 0}, {Time: maxWorkoutTime + 1}}, false},
+		{"gap too long", []WorkoutSample{{Time: 0}, {Time: maxSampleInterval + 1}}, false},
+		{"long gap", []WorkoutSample{{Time: 0}, {Time: maxSampleInterval}}, true},
+		{"goes backwards", []WorkoutSample{{Time: 2}, {Time: 1}}, false},
+	}
+
+	for _, tt := range tests {
+		w := testWorkout()
+		w.Samples = tt.samples
+		if err := w.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ZoneSettings are the physiological values a user's training zones are
+// derived from. Any value may be zero if unknown.
+type ZoneSettings struct {
+	MaxHR     int       `json:"max_hr" firestore:"max_hr"`         // bpm
+	LTHR      int       `json:"lthr" firestore:"lthr"`             // lactate threshold heart rate, bpm
+	Watts2k   int       `json:"watts_2k" firestore:"watts_2k"`     // average watts of a 2k test
+	FTP       int       `json:"ftp" firestore:"ftp"`               // functional threshold power, watts
+	UpdatedAt time.Time `json:"updated_at" firestore:"updated_at"` // set by the server
+}
+
+// Zone is a training zone. Min is inclusive and Max exclusive; a Max of
+// zero leaves the top zone open-ended.
+type Zone struct {
+	Zone int    `json:"zone" firestore:"zone"`
+	Name string `json:"name" firestore:"name"`
+	Min  int    `json:"min" firestore:"min"`
+	Max  int    `json:"max" firestore:"max"`
+}
+
+// Zones are a user's heart rate and power zones, each empty when the
+// settings they come from aren't known
+type Zones struct {
+	HeartRateSource string `json:"heart_rate_source,omitempty"` // max_hr or lthr
+	HeartRate       []Zone `json:"heart_rate"`
+	PowerSource     string `json:"power_source,omitempty"` // watts_2k or ftp
+	Power           []Zone `json:"power"`
+}
+
+// zoneBand is a zone's lower bound as a fraction of a reference value
+type zoneBand struct {
+	name  string
+	lower float64
+}
+
+var (
+	// Percentages of maximum heart rate
+	maxHRBands = []zoneBand{
+		{"Recovery", 0.50},
+		{"Endurance", 0.60},
+		{"Tempo", 0.70},
+		{"Threshold", 0.80},
+		{"Maximum", 0.90},
+	}
+
+	// Percentages of lactate threshold heart rate
+	lthrBands = []zoneBand{
+		{"Recovery", 0},
+		{"Endurance", 0.85},
+		{"Tempo", 0.90},
+		{"Threshold", 0.95},
+		{"Maximum", 1.00},
+	}
+
+	// Rowing training bands as percentages of 2k test watts
+	watts2kBands = []zoneBand{
+		{"UT2", 0},
+		{"UT1", 0.60},
+		{"AT", 0.70},
+		{"TR", 0.80},
+		{"AN", 1.05},
+	}
+
+	// Percentages of functional threshold power
+	ftpBands = []zoneBand{
+		{"Active Recovery", 0},
+		{"Endurance", 0.55},
+		{"Tempo", 0.75},
+		{"Threshold", 0.90},
+		{"VO2 Max", 1.05},
+		{"Anaerobic", 1.20},
+	}
+)
+
+// Validate checks the settings are plausible
+func (s *ZoneSettings) Validate() error {
+	var errs []error
+
+	if s.MaxHR != 0 && (s.MaxHR < 100 || s.MaxHR > 250) {
+		errs = append(errs, fmt.Errorf("max_hr must be between 100 and 250, got %d", s.MaxHR))
+	}
+	if s.LTHR != 0 && (s.LTHR < 80 || s.LTHR > 240) {
+		errs = append(errs, fmt.Errorf("lthr must be between 80 and 240, got %d", s.LTHR))
+	}
+	if s.MaxHR != 0 && s.LTHR != 0 && s.LTHR >= s.MaxHR {
+		errs = append(errs, errors.New("lthr must be below max_hr"))
+	}
+	if s.Watts2k < 0 || s.Watts2k > 1500 {
+		errs = append(errs, fmt.Errorf("watts_2k must be between 0 and 1500, got %d", s.Watts2k))
+	}
+	if s.FTP < 0 || s.FTP > 1500 {
+		errs = append(errs, fmt.Errorf("ftp must be between 0 and 1500, got %d", s.FTP))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Zones derives the training zones. Lactate threshold heart rate is
+// preferred over maximum heart rate, and FTP over 2k watts, as the more
+// specific measures.
+func (s *ZoneSettings) Zones() Zones {
+	zones := Zones{HeartRate: []Zone{}, Power: []Zone{}}
+
+	switch {
+	case s.LTHR > 0:
+		zones.HeartRateSource = "lthr"
+		zones.HeartRate = buildZones(lthrBands, s.LTHR)
+	case s.MaxHR > 0:
+		zones.HeartRateSource = "max_hr"
+		zones.HeartRate = buildZones(maxHRBands, s.MaxHR)
+	}
+
+	switch {
+	case s.FTP > 0:
+		zones.PowerSource = "ftp"
+		zones.Power = buildZones(ftpBands, s.FTP)
+	case s.Watts2k > 0:
+		zones.PowerSource = "watts_2k"
+		zones.Power = buildZones(watts2kBands, s.Watts2k)
+	}
+
+	return zones
+}
+
+// buildZones turns bands into zones for a reference value
+func buildZones(bands []zoneBand, reference int) []Zone {
+	zones := make([]Zone, len(bands))
+	for i, band := range bands {
+		zones[i] = Zone{
+			Zone: i + 1,
+			Name: band.name,
+			Min:  int(math.Round(band.lower * float64(reference))),
+		}
+		if i > 0 {
+			zones[i-1].Max = zones[i].Min
+		}
+	}
+	return zones
+}
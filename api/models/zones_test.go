@@ -0,0 +1,105 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestZones(t *testing.T) {
+	tests := []struct {
+		name      string
+		settings  ZoneSettings
+		hrSource  string
+		heartRate []Zone
+		pwrSource string
+		power     []Zone
+	}{
+		{
+			name:      "max heart rate and 2k watts",
+			settings:  ZoneSettings{MaxHR: 190, Watts2k: 300},
+			hrSource:  "max_hr",
+			heartRate: []Zone{{1, "Recovery", 95, 114}, {2, "Endurance", 114, 133}, {3, "Tempo", 133, 152}, {4, "Threshold", 152, 171}, {5, "Maximum", 171, 0}},
+			pwrSource: "watts_2k",
+			power:     []Zone{{1, "UT2", 0, 180}, {2, "UT1", 180, 210}, {3, "AT", 210, 240}, {4, "TR", 240, 315}, {5, "AN", 315, 0}},
+		},
+		{
+			name:      "threshold measures are preferred",
+			settings:  ZoneSettings{MaxHR: 190, LTHR: 170, Watts2k: 300, FTP: 240},
+			hrSource:  "lthr",
+			heartRate: []Zone{{1, "Recovery", 0, 145}, {2, "Endurance", 145, 153}, {3, "Tempo", 153, 162}, {4, "Threshold", 162, 170}, {5, "Maximum", 170, 0}},
+			pwrSource: "ftp",
+			power: []Zone{
+				{1, "Active Recovery", 0, 132}, {2, "Endurance", 132, 180}, {3, "Tempo", 180, 216},
+				{4, "Threshold", 216, 252}, {5, "VO2 Max", 252, 288}, {6, "Anaerobic", 288, 0},
+			},
+		},
+		{
+			name:      "nothing known",
+			heartRate: []Zone{},
+			power:     []Zone{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zones := tt.settings.Zones()
+			if zones.HeartRateSource != tt.hrSource || zones.PowerSource != tt.pwrSource {
+				t.Errorf("sources %q and %q, want %q and %q", zones.HeartRateSource, zones.PowerSource, tt.hrSource, tt.pwrSource)
+			}
+			if !equalZones(zones.HeartRate, tt.heartRate) {
+				t.Errorf("heart rate zones %v, want %v", zones.HeartRate, tt.heartRate)
+			}
+			if !equalZones(zones.Power, tt.power) {
+				t.Errorf("power zones %v, want %v", zones.Power, tt.power)
+			}
+		})
+	}
+}
+
+func equalZones(a, b []Zone) bool {
+	if a == nil || b == nil || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestZoneSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings ZoneSettings
+		errors   []string // substrings of the expected error, none if valid
+	}{
+		{"empty", ZoneSettings{}, nil},
+		{"plausible", ZoneSettings{MaxHR: 190, LTHR: 170, Watts2k: 300, FTP: 240}, nil},
+		{"bounds", ZoneSettings{MaxHR: 100, LTHR: 80, Watts2k: 1500, FTP: 1500}, nil},
+		{"max_hr low", ZoneSettings{MaxHR: 99}, []string{"max_hr"}},
+		{"max_hr high", ZoneSettings{MaxHR: 251}, []string{"max_hr"}},
+		{"lthr low", ZoneSettings{LTHR: 79}, []string{"lthr must be between"}},
+		{"lthr at max_hr", ZoneSettings{MaxHR: 180, LTHR: 180}, []string{"lthr must be below max_hr"}},
+		{"negative power", ZoneSettings{Watts2k: -1, FTP: -1}, []string{"watts_2k", "ftp"}},
+		{"power high", ZoneSettings{Watts2k: 1501, FTP: 1501}, []string{"watts_2k", "ftp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Validate()
+			if len(tt.errors) == 0 {
+				if err != nil {
+					t.Fatalf("got %v, want valid", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got valid, want errors about %v", tt.errors)
+			}
+			for _, want := range tt.errors {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't mention %q", err, want)
+				}
+			}
+		})
+	}
+}
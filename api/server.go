@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/services"
+)
+
+const (
+	// Server timeouts
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	writeTimeout      = 30 * time.Second
+	idleTimeout       = 120 * time.Second
+
+	// shutdownTimeout bounds how long a graceful shutdown may take
+	shutdownTimeout = 10 * time.Second
+)
+
+// server is the API's HTTP server and the background services that stop
+// with it
+type server struct {
+	http *http.Server
+
+	// Server-sent event streams never go idle, so they are ended before
+	// the HTTP server waits for connections to close. streams is closed
+	// for the handlers watching it.
+	streams  chan struct{}
+	relayHub *services.RelayHub
+
+	// Stopped in order once the HTTP server has
+	background []background
+}
+
+// background is work that carries on after a request, such as webhook
+// deliveries, and must finish before the process exits
+type background struct {
+	name     string
+	shutdown func(context.Context) error
+}
+
+// newServer creates a server for handler. streams is closed on shutdown to
+// end the handlers' event streams.
+func newServer(addr string, handler http.Handler, streams chan struct{}, relayHub *services.RelayHub) *server {
+	return &server{
+		http: &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadHeaderTimeout: readHeaderTimeout,
+			ReadTimeout:       readTimeout,
+			WriteTimeout:      writeTimeout,
+			IdleTimeout:       idleTimeout,
+		},
+		streams:  streams,
+		relayHub: relayHub,
+	}
+}
+
+// addBackground registers work to stop after the HTTP server
+func (s *server) addBackground(name string, shutdown func(context.Context) error) {
+	s.background = append(s.background, background{name: name, shutdown: shutdown})
+}
+
+// ListenAndServe serves HTTP on the server's address until Shutdown is
+// called, in which case it returns nil
+func (s *server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves HTTP on ln until Shutdown is called, in which case it
+// returns nil
+func (s *server) Serve(ln net.Listener) error {
+	if err := s.http.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown ends the event streams, waits for the remaining requests and
+// then stops the background services, all within ctx. Every service is
+// given the chance to stop; their errors are returned together.
+func (s *server) Shutdown(ctx context.Context) error {
+	s.relayHub.Shutdown()
+	close(s.streams)
+
+	var errs []error
+	if err := s.http.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("HTTP server: %w", err))
+	}
+	for _, b := range s.background {
+		if err := b.shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/services"
+)
+
+// streamHandler holds each request open as an event stream until streams
+// is closed, like the live leaderboard
+func streamHandler(streams <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		http.NewResponseController(w).Flush()
+
+		select {
+		case <-streams:
+		case <-r.Context().Done():
+		}
+	})
+}
+
+// startServer serves srv on a free local port, returning its URL and the
+// channel Serve's result is sent to
+func startServer(t *testing.T, srv *server) (string, <-chan error) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+	return "http://" + ln.Addr().String(), served
+}
+
+func TestServerShutdownEndsStreams(t *testing.T) {
+	streams := make(chan struct{})
+	srv := newServer("127.0.0.1:0", streamHandler(streams), streams, services.NewRelayHub())
+
+	// Background services stop in order, after the HTTP server, with time
+	// left to finish
+	var mu sync.Mutex
+	var stopped []string
+	for _, name := range []string{"Webhook dispatcher", "Strava"} {
+		srv.addBackground(name, func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if err := ctx.Err(); err != nil {
+				t.Errorf("%s stopped with %v", name, err)
+			}
+			stopped = append(stopped, name)
+			return nil
+		})
+	}
+
+	url, served := startServer(t, srv)
+
+	// A viewer is watching a stream when the server shuts down
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %v with a stream open", elapsed)
+	}
+
+	if err := <-served; err != nil {
+		t.Errorf("Serve returned %v after Shutdown", err)
+	}
+	if got := strings.Join(stopped, ", "); got != "Webhook dispatcher, Strava" {
+		t.Errorf("stopped %s", got)
+	}
+}
+
+func TestServerShutdownStopsEveryService(t *testing.T) {
+	streams := make(chan struct{})
+	srv := newServer("127.0.0.1:0", http.NotFoundHandler(), streams, services.NewRelayHub())
+
+	concept2Stopped := false
+	srv.addBackground("Strava", func(ctx context.Context) error {
+		return errors.New("uploads still running")
+	})
+	srv.addBackground("Concept2", func(ctx context.Context) error {
+		concept2Stopped = true
+		return nil
+	})
+
+	_, served := startServer(t, srv)
+
+	err := srv.Shutdown(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Strava: uploads still running") {
+		t.Errorf("Shutdown returned %v, want Strava's error", err)
+	}
+	if !concept2Stopped {
+		t.Error("a failing service kept the next from stopping")
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve returned %v after Shutdown", err)
+	}
+}
+
+func TestServerListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The address is taken
+	srv := newServer(ln.Addr().String(), http.NotFoundHandler(), make(chan struct{}), services.NewRelayHub())
+	if err := srv.ListenAndServe(); err == nil {
+		t.Fatal("ListenAndServe succeeded on an address in use")
+	}
+}
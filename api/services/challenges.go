@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// ChallengeService stores challenges and their entries
+type ChallengeService struct {
+	client *firestore.Client
+}
+
+// NewChallengeService creates a new challenge service
+func NewChallengeService(client *firestore.Client) *ChallengeService {
+	return &ChallengeService{client: client}
+}
+
+// Entrant is who an entry is for, refreshed each time they enter a workout
+type Entrant struct {
+	UserID      string
+	DisplayName string
+	TeamID      string
+	WeightClass string
+	BirthYear   int
+}
+
+// collection returns the collection holding challenges
+func (s *ChallengeService) collection() *firestore.CollectionRef {
+	return s.client.Collection("challenges")
+}
+
+// entries returns the collection holding a challenge's entries
+func (s *ChallengeService) entries(id string) *firestore.CollectionRef {
+	return s.collection().Doc(id).Collection("entries")
+}
+
+// List returns the challenges any of teamIDs take part in, newest first
+func (s *ChallengeService) List(ctx context.Context, teamIDs []string) ([]*models.Challenge, error) {
+	challenges := []*models.Challenge{}
+	seen := make(map[string]bool)
+
+	// array-contains-any takes at most 30 values
+	for start := 0; start < len(teamIDs); start += 30 {
+		end := min(start+30, len(teamIDs))
+		snaps, err := s.collection().Where("team_ids", "array-contains-any", teamIDs[start:end]).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, fmt.Errorf("error listing challenges: %w", err)
+		}
+
+		for _, snap := range snaps {
+			if seen[snap.Ref.ID] {
+				continue
+			}
+			seen[snap.Ref.ID] = true
+
+			challenge, err := decodeChallenge(snap)
+			if err != nil {
+				return nil, err
+			}
+			challenges = append(challenges, challenge)
+		}
+	}
+
+	sort.Slice(challenges, func(i, j int) bool {
+		return challenges[i].Start.After(challenges[j].Start)
+	})
+	return challenges, nil
+}
+
+// Get returns a single challenge
+func (s *ChallengeService) Get(ctx context.Context, id string) (*models.Challenge, error) {
+	snap, err := s.collection().Doc(id).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching challenge: %w", err)
+	}
+	return decodeChallenge(snap)
+}
+
+// Create stores a new challenge and sets its ID
+func (s *ChallengeService) Create(ctx context.Context, uid string, challenge *models.Challenge) error {
+	challenge.CreatedBy = uid
+	challenge.CreatedAt = time.Now().UTC()
+
+	ref := s.collection().NewDoc()
+	if _, err := ref.Create(ctx, challenge); err != nil {
+		return fmt.Errorf("error saving challenge: %w", err)
+	}
+
+	challenge.ID = ref.ID
+	return nil
+}
+
+// Delete removes a challenge and its entries
+func (s *ChallengeService) Delete(ctx context.Context, id string) error {
+	ref := s.collection().Doc(id)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(ref); isNotFound(err) {
+			return ErrNotFound
+		} else if err != nil {
+			return fmt.Errorf("error fetching challenge: %w", err)
+		}
+
+		entries, err := tx.Documents(s.entries(id)).GetAll()
+		if err != nil {
+			return fmt.Errorf("error listing entries: %w", err)
+		}
+		for _, entry := range entries {
+			if err := tx.Delete(entry.Ref); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// Entries returns a challenge's entries
+func (s *ChallengeService) Entries(ctx context.Context, id string) ([]*models.Entry, error) {
+	snaps, err := s.entries(id).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing entries: %w", err)
+	}
+	return decodeEntries(snaps)
+}
+
+// Watch calls fn with a challenge's entries now and each time they
+// change, until ctx is done or fn returns an error
+func (s *ChallengeService) Watch(ctx context.Context, id string, fn func([]*models.Entry) error) error {
+	iter := s.entries(id).Snapshots(ctx)
+	defer iter.Stop()
+
+	for {
+		snap, err := iter.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("error watching entries: %w", err)
+		}
+
+		docs, err := snap.Documents.GetAll()
+		if err != nil {
+			return fmt.Errorf("error reading entries: %w", err)
+		}
+		entries, err := decodeEntries(docs)
+		if err != nil {
+			return err
+		}
+		if err := fn(entries); err != nil {
+			return err
+		}
+	}
+}
+
+// Enter adds workouts to an entrant's entry in a challenge, creating the
+// entry if needed. Workouts the entry already counts are skipped, so a
+// workout entered both when it's saved and by a backfill counts once. It
+// returns the entry, or nil if none of the workouts counted.
+func (s *ChallengeService) Enter(ctx context.Context, challenge *models.Challenge, entrant Entrant, workouts ...*models.Workout) (*models.Entry, error) {
+	ref := s.entries(challenge.ID).Doc(entrant.UserID)
+
+	var entry *models.Entry
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		entry = &models.Entry{}
+		snap, err := tx.Get(ref)
+		if err == nil {
+			if err := snap.DataTo(entry); err != nil {
+				return fmt.Errorf("error decoding entry: %w", err)
+			}
+		} else if !isNotFound(err) {
+			return fmt.Errorf("error fetching entry: %w", err)
+		}
+
+		changed := false
+		for _, workout := range workouts {
+			if challenge.Apply(entry, workout) {
+				changed = true
+			}
+		}
+		if !changed {
+			entry = nil
+			return nil
+		}
+
+		entry.DisplayName = entrant.DisplayName
+		entry.TeamID = entrant.TeamID
+		entry.WeightClass = entrant.WeightClass
+		entry.BirthYear = entrant.BirthYear
+		entry.UpdatedAt = time.Now().UTC()
+		return tx.Set(ref, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if entry != nil {
+		entry.UserID = entrant.UserID
+	}
+	return entry, nil
+}
+
+// decodeChallenge reads a challenge document
+func decodeChallenge(snap *firestore.DocumentSnapshot) (*models.Challenge, error) {
+	var challenge models.Challenge
+	if err := snap.DataTo(&challenge); err != nil {
+		return nil, fmt.Errorf("error decoding challenge %s: %w", snap.Ref.ID, err)
+	}
+	challenge.ID = snap.Ref.ID
+	return &challenge, nil
+}
+
+// decodeEntries reads challenge entry documents
+func decodeEntries(snaps []*firestore.DocumentSnapshot) ([]*models.Entry, error) {
+	entries := make([]*models.Entry, 0, len(snaps))
+	for _, snap := range snaps {
+		var entry models.Entry
+		if err := snap.DataTo(&entry); err != nil {
+			return nil, fmt.Errorf("error decoding entry %s: %w", snap.Ref.ID, err)
+		}
+		entry.UserID = snap.Ref.ID
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
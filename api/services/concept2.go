@@ -0,0 +1,579 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+const (
+	// concept2Scope is the access requested: user:read to identify the
+	// athlete, results:read to import and find duplicates, and
+	// results:write to push workouts
+	concept2Scope = "user:read,results:read,results:write"
+
+	// concept2MediaType selects version 1 of the Logbook API
+	concept2MediaType = "application/vnd.c2logbook.v1+json"
+
+	// concept2Timeout bounds a single request to the Logbook
+	concept2Timeout = 30 * time.Second
+
+	// concept2Jobs is the number of pushes and imports run at once
+	concept2Jobs = 4
+
+	// staleImportAge is how long an import can run before another may be
+	// started, as when the server restarted mid-import
+	staleImportAge = time.Hour
+)
+
+// ErrRunning is returned when starting an import while one is running
+var ErrRunning = errors.New("already running")
+
+// errConcept2Unauthorized is returned when the Logbook rejects a user's
+// tokens
+var errConcept2Unauthorized = errors.New("Concept2 Logbook authorization was revoked; reconnect the Logbook to sync")
+
+// concept2Listed is a result as the Logbook lists it, saying whether it
+// has stroke data rather than including it
+type concept2Listed struct {
+	models.LogbookResult
+	StrokeData bool `json:"stroke_data"`
+}
+
+// workoutStore saves and lists users' workouts, normally a
+// *WorkoutService
+type workoutStore interface {
+	Create(ctx context.Context, uid string, workout *models.Workout) error
+	List(ctx context.Context, uid string, from, to time.Time, limit int) ([]*models.Workout, error)
+}
+
+// recordStore updates users' records, normally a *RecordService
+type recordStore interface {
+	Update(ctx context.Context, uid string, workout *models.Workout) ([]*models.Record, error)
+}
+
+// SavedHandler is called after a workout is saved outside a request,
+// with the records it set
+type SavedHandler func(ctx context.Context, uid string, workout *models.Workout, records []*models.Record)
+
+// profileStore reads users' profiles, normally a *ProfileService
+type profileStore interface {
+	Get(ctx context.Context, uid string) (*models.Profile, error)
+}
+
+// Concept2Service links users' Concept2 Online Logbook accounts, pushing
+// their workouts as results in the background and importing past results
+type Concept2Service struct {
+	integrations integrationStore
+	workouts     workoutStore
+	records      recordStore
+	profiles     profileStore
+	samples      *InfluxDBService // nil when InfluxDB isn't configured
+	oauth        *oauthClient
+	saved        SavedHandler // nil if nothing follows imports
+
+	baseURL     string
+	redirectURL string
+
+	jobs *jobs
+}
+
+// NewConcept2Service creates a Logbook service for an API application.
+// baseURL is normally https://log.concept2.com; samples may be nil.
+func NewConcept2Service(integrations *IntegrationService, workouts *WorkoutService, records *RecordService, profiles *ProfileService, samples *InfluxDBService, baseURL, clientID, clientSecret, redirectURL string) *Concept2Service {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return &Concept2Service{
+		integrations: integrations,
+		workouts:     workouts,
+		records:      records,
+		profiles:     profiles,
+		samples:      samples,
+		oauth: &oauthClient{
+			integrations: integrations,
+			client:       &http.Client{Timeout: concept2Timeout},
+			provider:     models.ProviderConcept2,
+			name:         "Concept2 Logbook",
+			tokenURL:     baseURL + "/oauth/access_token",
+			apiURL:       baseURL + "/api",
+			clientID:     clientID,
+			clientSecret: clientSecret,
+			tokenParams:  url.Values{"scope": {concept2Scope}},
+			apiHeader:    http.Header{"Accept": {concept2MediaType}},
+			maxResponse:  32 << 20,
+			errorMessage: concept2ErrorMessage,
+			refreshHint:  "refresh",
+			unauthorized: errConcept2Unauthorized,
+		},
+		baseURL:     baseURL,
+		redirectURL: redirectURL,
+		jobs:        newJobs(concept2Jobs),
+	}
+}
+
+// SetSavedHandler sets the function called after each imported workout is
+// saved, so imports are followed up like workouts saved through the API
+func (s *Concept2Service) SetSavedHandler(handler SavedHandler) {
+	s.saved = handler
+}
+
+// AuthorizeURL starts linking a user's Logbook, returning the Logbook page
+// to send them to
+func (s *Concept2Service) AuthorizeURL(ctx context.Context, uid string) (string, error) {
+	state, err := s.integrations.NewState(ctx, uid, models.ProviderConcept2)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"client_id":     {s.oauth.clientID},
+		"redirect_uri":  {s.redirectURL},
+		"response_type": {"code"},
+		"scope":         {concept2Scope},
+		"state":         {state},
+	}
+	return s.baseURL + "/oauth/authorize?" + query.Encode(), nil
+}
+
+// Connect finishes linking a user's Logbook with the code and state the
+// Logbook redirected back with
+func (s *Concept2Service) Connect(ctx context.Context, uid, state, code string) (*models.Connection, error) {
+	if err := s.integrations.ConsumeState(ctx, uid, models.ProviderConcept2, state); err != nil {
+		return nil, err
+	}
+
+	token, err := s.oauth.token(ctx, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {s.redirectURL},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var user struct {
+		Data struct {
+			ID        int64  `json:"id"`
+			Username  string `json:"username"`
+			FirstName string `json:"first_name"`
+			LastName  string `json:"last_name"`
+		} `json:"data"`
+	}
+	req, err := s.oauth.apiRequest(ctx, http.MethodGet, "/users/me", token.AccessToken, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.oauth.do(req, &user); err != nil {
+		return nil, err
+	}
+
+	conn := &models.Connection{
+		Provider:     models.ProviderConcept2,
+		AthleteID:    strconv.FormatInt(user.Data.ID, 10),
+		AthleteName:  strings.TrimSpace(user.Data.FirstName + " " + user.Data.LastName),
+		Scope:        concept2Scope,
+		AutoUpload:   true,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    token.expiry(),
+		ConnectedAt:  time.Now().UTC(),
+	}
+	if conn.AthleteName == "" {
+		conn.AthleteName = user.Data.Username
+	}
+
+	if err := s.integrations.SaveConnection(ctx, uid, conn); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Disconnect forgets a user's Logbook tokens. The Logbook has no way to
+// revoke them; users can do so from their Logbook settings.
+func (s *Concept2Service) Disconnect(ctx context.Context, uid string) error {
+	return s.integrations.DeleteConnection(ctx, uid, models.ProviderConcept2)
+}
+
+// SetAutoUpload turns pushing each saved workout on or off
+func (s *Concept2Service) SetAutoUpload(ctx context.Context, uid string, enabled bool) (*models.Connection, error) {
+	err := s.integrations.UpdateConnection(ctx, uid, models.ProviderConcept2, []firestore.Update{
+		{Path: "auto_upload", Value: enabled},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.integrations.Connection(ctx, uid, models.ProviderConcept2)
+}
+
+// AutoUpload pushes a newly saved workout if the user has the Logbook
+// linked with auto upload on. Failures are logged and recorded on the
+// upload.
+func (s *Concept2Service) AutoUpload(ctx context.Context, uid string, workout *models.Workout) {
+	conn, err := s.integrations.Connection(ctx, uid, models.ProviderConcept2)
+	if errors.Is(err, ErrNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get Concept2 connection for %s: %v", uid, err)
+		return
+	}
+	if !conn.AutoUpload || conn.Error != "" {
+		return
+	}
+
+	if _, err := s.Upload(ctx, uid, workout); err != nil {
+		log.Printf("Failed to queue Concept2 upload of workout %s: %v", workout.ID, err)
+	}
+}
+
+// Upload queues a workout to be pushed to the Logbook, returning its
+// pending status. Workouts without samples have them loaded from InfluxDB
+// if it's configured.
+func (s *Concept2Service) Upload(ctx context.Context, uid string, workout *models.Workout) (*models.Upload, error) {
+	upload, err := s.integrations.StartUpload(ctx, uid, workout.ID, models.ProviderConcept2)
+	if err != nil {
+		return nil, err
+	}
+
+	queued := *upload
+	s.jobs.run(func(ctx context.Context) {
+		err := s.push(ctx, uid, workout, &queued)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if err != nil {
+			queued.Status = models.UploadFailed
+			queued.Error = err.Error()
+			log.Printf("Concept2 upload of workout %s failed: %v", workout.ID, err)
+		}
+
+		// Record the outcome even if the server is stopping
+		saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), concept2Timeout)
+		defer cancel()
+		if err := s.integrations.SaveUpload(saveCtx, uid, &queued); err != nil {
+			log.Printf("Failed to record Concept2 upload of workout %s: %v", workout.ID, err)
+		}
+	})
+
+	return upload, nil
+}
+
+// Import queues copying a user's Logbook results rowed between from and
+// to, returning the import's starting status. It returns ErrRunning if an
+// import is already running.
+func (s *Concept2Service) Import(ctx context.Context, uid string, from, to time.Time) (*models.Import, error) {
+	conn, err := s.integrations.Connection(ctx, uid, models.ProviderConcept2)
+	if err != nil {
+		return nil, err
+	}
+	if conn.Import != nil && conn.Import.Status == models.ImportRunning && time.Since(conn.Import.StartedAt) < staleImportAge {
+		return nil, ErrRunning
+	}
+
+	imp := &models.Import{
+		Status:    models.ImportRunning,
+		From:      from,
+		To:        to,
+		StartedAt: time.Now().UTC(),
+	}
+	if err := s.saveImport(ctx, uid, imp); err != nil {
+		return nil, err
+	}
+
+	queued := *imp
+	s.jobs.run(func(ctx context.Context) {
+		err := s.importResults(ctx, uid, &queued)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+
+		queued.Status = models.ImportComplete
+		if err != nil {
+			queued.Status = models.ImportFailed
+			queued.Error = err.Error()
+			log.Printf("Concept2 import for %s failed: %v", uid, err)
+		}
+		queued.FinishedAt = time.Now().UTC()
+
+		saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), concept2Timeout)
+		defer cancel()
+		if err := s.saveImport(saveCtx, uid, &queued); err != nil {
+			log.Printf("Failed to record Concept2 import for %s: %v", uid, err)
+		}
+	})
+
+	return imp, nil
+}
+
+// Shutdown stops pushes and imports in progress, waiting for them to
+// stop. Uploads left pending can be retried and imports run again.
+func (s *Concept2Service) Shutdown(ctx context.Context) error {
+	return s.jobs.shutdown(ctx)
+}
+
+// push sends a workout to the Logbook as a result with splits, heart rate
+// and stroke data. A result already in the Logbook for the same piece,
+// as when the monitor synced it through ErgData, is linked instead.
+func (s *Concept2Service) push(ctx context.Context, uid string, workout *models.Workout, upload *models.Upload) error {
+	if len(workout.Samples) < 2 && s.samples != nil {
+		samples, err := s.samples.ReadSamples(ctx, uid, workout)
+		if err != nil {
+			log.Printf("Failed to read samples for workout %s, uploading summary only: %v", workout.ID, err)
+		}
+		copied := *workout
+		copied.Samples = samples
+		workout = &copied
+	}
+
+	conn, err := s.oauth.accessToken(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	day := 24 * time.Hour
+	var existing *models.LogbookResult
+	err = s.results(ctx, uid, conn.AccessToken, workout.StartedAt.Add(-day), workout.StartedAt.Add(day), func(results []concept2Listed) error {
+		for i := range results {
+			if results[i].Matches(workout) {
+				existing = &results[i].LogbookResult
+				return errStopPaging
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		weightClass := ""
+		if profile, err := s.profiles.Get(ctx, uid); err == nil {
+			weightClass = profile.Class()
+		}
+
+		body, err := json.Marshal(workout.LogbookResult(weightClass))
+		if err != nil {
+			return fmt.Errorf("error encoding workout: %w", err)
+		}
+		req, err := s.oauth.apiRequest(ctx, http.MethodPost, "/users/me/results", conn.AccessToken, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		var created struct {
+			Data models.LogbookResult `json:"data"`
+		}
+		if err := s.oauth.do(req, &created); err != nil {
+			return s.oauth.checkAuth(ctx, uid, err)
+		}
+		existing = &created.Data
+	} else {
+		upload.Duplicate = true
+	}
+
+	upload.Status = models.UploadComplete
+	upload.ActivityID = strconv.FormatInt(existing.ID, 10)
+	upload.URL = s.resultURL(conn, upload.ActivityID)
+	return nil
+}
+
+// importResults copies a user's results between imp's dates into their
+// workouts, updating imp's counts as it goes. Results matching a workout
+// already saved are skipped.
+func (s *Concept2Service) importResults(ctx context.Context, uid string, imp *models.Import) error {
+	conn, err := s.oauth.accessToken(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	day := 24 * time.Hour
+	saved, err := s.workouts.List(ctx, uid, imp.From.Add(-day), imp.To.Add(day), 0)
+	if err != nil {
+		return err
+	}
+
+	return s.results(ctx, uid, conn.AccessToken, imp.From, imp.To, func(results []concept2Listed) error {
+		for _, result := range results {
+			if s.importResult(ctx, uid, conn, &result, saved) {
+				imp.Imported++
+			} else {
+				imp.Skipped++
+			}
+		}
+
+		// Progress is only informational
+		if err := s.saveImport(ctx, uid, imp); err != nil {
+			log.Printf("Failed to record Concept2 import progress for %s: %v", uid, err)
+		}
+		return ctx.Err()
+	})
+}
+
+// importResult saves a Logbook result as a workout unless it matches one
+// already saved, reporting whether it did. Failures are logged and the
+// result is skipped.
+func (s *Concept2Service) importResult(ctx context.Context, uid string, conn *models.Connection, result *concept2Listed, saved []*models.Workout) bool {
+	if result.Type != "rower" {
+		return false
+	}
+	for _, workout := range saved {
+		if result.Matches(workout) {
+			return false
+		}
+	}
+
+	var strokes []models.LogbookStroke
+	if result.StrokeData {
+		var err error
+		if strokes, err = s.strokes(ctx, conn.AccessToken, result.ID); err != nil {
+			log.Printf("Failed to get strokes of Concept2 result %d, importing summary only: %v", result.ID, err)
+		}
+	}
+
+	workout, err := result.Workout(strokes)
+	if err != nil {
+		log.Printf("Failed to convert Concept2 result %d: %v", result.ID, err)
+		return false
+	}
+
+	err = s.workouts.Create(ctx, uid, workout)
+	if errors.Is(err, ErrExists) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to save Concept2 result %d for %s: %v", result.ID, uid, err)
+		return false
+	}
+
+	if s.samples != nil && len(workout.Samples) > 0 {
+		if err := s.samples.WriteSamples(ctx, uid, workout); err != nil {
+			log.Printf("Failed to store samples for workout %s: %v", workout.ID, err)
+		}
+	}
+	records, err := s.records.Update(ctx, uid, workout)
+	if err != nil {
+		log.Printf("Failed to update records for %s: %v", uid, err)
+	}
+
+	// Link the workout to its result so it isn't pushed back
+	id := strconv.FormatInt(result.ID, 10)
+	err = s.integrations.SaveUpload(ctx, uid, &models.Upload{
+		WorkoutID:  workout.ID,
+		Provider:   models.ProviderConcept2,
+		Status:     models.UploadComplete,
+		ActivityID: id,
+		URL:        s.resultURL(conn, id),
+		Duplicate:  true,
+	})
+	if err != nil {
+		log.Printf("Failed to link workout %s to Concept2 result %s: %v", workout.ID, id, err)
+	}
+
+	if s.saved != nil {
+		s.saved(ctx, uid, workout, records)
+	}
+	return true
+}
+
+// errStopPaging stops results paging early without an error
+var errStopPaging = errors.New("stop paging")
+
+// results calls fn with each page of a user's rowing results between
+// from and to, stopping early if fn returns errStopPaging
+func (s *Concept2Service) results(ctx context.Context, uid, token string, from, to time.Time, fn func([]concept2Listed) error) error {
+	for page := 1; ; page++ {
+		query := url.Values{
+			"from": {from.UTC().Format(time.DateOnly)},
+			"to":   {to.UTC().Format(time.DateOnly)},
+			"type": {"rower"},
+			"page": {strconv.Itoa(page)},
+		}
+		req, err := s.oauth.apiRequest(ctx, http.MethodGet, "/users/me/results?"+query.Encode(), token, nil)
+		if err != nil {
+			return err
+		}
+
+		var list struct {
+			Data []concept2Listed `json:"data"`
+			Meta struct {
+				Pagination struct {
+					CurrentPage int `json:"current_page"`
+					TotalPages  int `json:"total_pages"`
+				} `json:"pagination"`
+			} `json:"meta"`
+		}
+		if err := s.oauth.do(req, &list); err != nil {
+			return s.oauth.checkAuth(ctx, uid, err)
+		}
+
+		if err := fn(list.Data); err != nil {
+			if errors.Is(err, errStopPaging) {
+				return nil
+			}
+			return err
+		}
+		if len(list.Data) == 0 || page >= list.Meta.Pagination.TotalPages {
+			return nil
+		}
+	}
+}
+
+// strokes returns a result's stroke data
+func (s *Concept2Service) strokes(ctx context.Context, token string, id int64) ([]models.LogbookStroke, error) {
+	req, err := s.oauth.apiRequest(ctx, http.MethodGet, fmt.Sprintf("/users/me/results/%d/strokes", id), token, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var strokes struct {
+		Data []models.LogbookStroke `json:"data"`
+	}
+	if err := s.oauth.do(req, &strokes); err != nil {
+		return nil, err
+	}
+	return strokes.Data, nil
+}
+
+// saveImport records an import's status on the user's connection
+func (s *Concept2Service) saveImport(ctx context.Context, uid string, imp *models.Import) error {
+	return s.integrations.UpdateConnection(ctx, uid, models.ProviderConcept2, []firestore.Update{
+		{Path: "import", Value: imp},
+	})
+}
+
+// resultURL returns where a result can be seen in the Logbook
+func (s *Concept2Service) resultURL(conn *models.Connection, id string) string {
+	return s.baseURL + "/profile/" + conn.AthleteID + "/log/" + id
+}
+
+// concept2ErrorMessage returns the message in a Logbook error response,
+// with its hint and the fields it names
+func concept2ErrorMessage(body []byte) string {
+	var detail struct {
+		Message string              `json:"message"`
+		Hint    string              `json:"hint"`
+		Errors  map[string][]string `json:"errors"`
+	}
+	if json.Unmarshal(body, &detail) != nil || detail.Message == "" {
+		return ""
+	}
+	message := detail.Message
+	if detail.Hint != "" {
+		message += " (" + detail.Hint + ")"
+	}
+	for field, messages := range detail.Errors {
+		message += fmt.Sprintf("; %s: %s", field, strings.Join(messages, ", "))
+	}
+	return message
+}
@@ -0,0 +1,445 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// logbookPageSize is how many results the fake Logbook lists per page
+const logbookPageSize = 2
+
+// fakeLogbook plays the parts of the Concept2 Logbook API pushes and
+// imports use
+type fakeLogbook struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	results []concept2Listed
+	strokes map[int64][]models.LogbookStroke
+	pushed  []models.LogbookResult
+	pages   int
+}
+
+func newFakeLogbook(t *testing.T, results ...concept2Listed) (*fakeLogbook, *httptest.Server) {
+	t.Helper()
+
+	logbook := &fakeLogbook{t: t, results: results, strokes: make(map[int64][]models.LogbookStroke)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/users/me/results", logbook.list)
+	mux.HandleFunc("POST /api/users/me/results", logbook.create)
+	mux.HandleFunc("GET /api/users/me/results/{id}/strokes", logbook.strokeData)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-1" || r.Header.Get("Accept") != concept2MediaType {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"message":"Unauthorized"}`)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return logbook, srv
+}
+
+func (l *fakeLogbook) list(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	query := r.URL.Query()
+	if query.Get("type") != "rower" || query.Get("from") == "" || query.Get("to") == "" {
+		l.t.Errorf("unexpected results query %v", query)
+	}
+	page, _ := strconv.Atoi(query.Get("page"))
+	l.pages++
+
+	start := min((page-1)*logbookPageSize, len(l.results))
+	end := min(start+logbookPageSize, len(l.results))
+	total := (len(l.results) + logbookPageSize - 1) / logbookPageSize
+
+	var list struct {
+		Data []concept2Listed `json:"data"`
+		Meta struct {
+			Pagination struct {
+				CurrentPage int `json:"current_page"`
+				TotalPages  int `json:"total_pages"`
+			} `json:"pagination"`
+		} `json:"meta"`
+	}
+	list.Data = l.results[start:end]
+	list.Meta.Pagination.CurrentPage = page
+	list.Meta.Pagination.TotalPages = total
+	json.NewEncoder(w).Encode(list)
+}
+
+func (l *fakeLogbook) create(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var result models.LogbookResult
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		l.t.Errorf("pushed result doesn't decode: %v", err)
+		http.Error(w, `{"message":"Bad Request"}`, http.StatusBadRequest)
+		return
+	}
+	l.pushed = append(l.pushed, result)
+
+	result.ID = 500
+	result.Strokes = nil
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": result})
+}
+
+func (l *fakeLogbook) strokeData(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	strokes, ok := l.strokes[id]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": strokes})
+}
+
+// fakeWorkoutStore keeps one user's workouts in memory
+type fakeWorkoutStore struct {
+	mu      sync.Mutex
+	saved   []*models.Workout
+	created []*models.Workout
+}
+
+func (s *fakeWorkoutStore) Create(ctx context.Context, uid string, workout *models.Workout) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, saved := range s.saved {
+		if workout.SessionID != "" && saved.SessionID == workout.SessionID {
+			return ErrExists
+		}
+	}
+	workout.ID = workout.SessionID
+	s.saved = append(s.saved, workout)
+	s.created = append(s.created, workout)
+	return nil
+}
+
+func (s *fakeWorkoutStore) List(ctx context.Context, uid string, from, to time.Time, limit int) ([]*models.Workout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var workouts []*models.Workout
+	for _, workout := range s.saved {
+		if !workout.StartedAt.Before(from) && workout.StartedAt.Before(to) {
+			workouts = append(workouts, workout)
+		}
+	}
+	return workouts, nil
+}
+
+// fakeRecordStore counts the workouts records are updated from
+type fakeRecordStore struct {
+	mu      sync.Mutex
+	updated []string
+}
+
+func (s *fakeRecordStore) Update(ctx context.Context, uid string, workout *models.Workout) ([]*models.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updated = append(s.updated, workout.ID)
+	return nil, nil
+}
+
+// fakeProfileStore returns a single profile
+type fakeProfileStore struct {
+	profile *models.Profile
+}
+
+func (s *fakeProfileStore) Get(ctx context.Context, uid string) (*models.Profile, error) {
+	if s.profile == nil {
+		return nil, ErrNotFound
+	}
+	return s.profile, nil
+}
+
+// testConcept2 is a Logbook service with its stores
+type testConcept2 struct {
+	*Concept2Service
+	integrations *fakeIntegrationStore
+	workouts     *fakeWorkoutStore
+	records      *fakeRecordStore
+}
+
+func newTestConcept2(t *testing.T, srv *httptest.Server, saved ...*models.Workout) *testConcept2 {
+	t.Helper()
+
+	s := &testConcept2{
+		Concept2Service: NewConcept2Service(nil, nil, nil, nil, nil, srv.URL, "client", "secret", "https://ergometer.live/concept2/callback"),
+		integrations: newFakeIntegrationStore(&models.Connection{
+			Provider:    models.ProviderConcept2,
+			AthleteID:   "1234",
+			AutoUpload:  true,
+			AccessToken: "access-1",
+			ExpiresAt:   time.Now().Add(time.Hour),
+		}),
+		workouts: &fakeWorkoutStore{saved: saved},
+		records:  &fakeRecordStore{},
+	}
+	s.Concept2Service.integrations = s.integrations
+	s.Concept2Service.oauth.integrations = s.integrations
+	s.Concept2Service.workouts = s.workouts
+	s.Concept2Service.records = s.records
+	s.Concept2Service.profiles = &fakeProfileStore{profile: &models.Profile{WeightClass: "lightweight"}}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return s
+}
+
+// testLogbookWorkout is a 2000m piece with samples every 10 seconds
+func testLogbookWorkout() *models.Workout {
+	w := &models.Workout{
+		ID:          "20261018T071502Z-430123456",
+		SessionID:   "20261018T071502Z-430123456",
+		WorkoutType: "fixed_distance",
+		StartedAt:   time.Date(2026, 10, 18, 7, 15, 2, 0, time.UTC),
+	}
+	for t := 0.0; t <= 410; t += 10 {
+		w.Samples = append(w.Samples, models.WorkoutSample{
+			Time: t, Distance: min(t/410*2000, 2000), Pace: 102.5, Power: 325, StrokeRate: 30, HeartRate: 150,
+		})
+	}
+	w.Summarize()
+	return w
+}
+
+// listed returns a Logbook rowing result
+func listed(id int64, date string, distance, tenths int, strokeData bool) concept2Listed {
+	return concept2Listed{
+		LogbookResult: models.LogbookResult{
+			ID:          id,
+			Type:        "rower",
+			Date:        date,
+			Timezone:    "UTC",
+			Distance:    distance,
+			Time:        tenths,
+			WeightClass: "H",
+			WorkoutType: "FixedDistanceSplits",
+			StrokeRate:  30,
+		},
+		StrokeData: strokeData,
+	}
+}
+
+func TestConcept2Push(t *testing.T) {
+	logbook, srv := newFakeLogbook(t,
+		listed(1, "2026-10-17 07:00:00", 5000, 12000, false),
+	)
+	s := newTestConcept2(t, srv)
+	workout := testLogbookWorkout()
+
+	if _, err := s.Upload(context.Background(), "user-1", workout); err != nil {
+		t.Fatal(err)
+	}
+
+	done := s.integrations.finished(t)
+	if done.Status != models.UploadComplete || done.Duplicate || done.Error != "" {
+		t.Fatalf("upload %+v, want a new result", done)
+	}
+	if done.ActivityID != "500" || done.URL != srv.URL+"/profile/1234/log/500" {
+		t.Errorf("upload %+v doesn't link the result", done)
+	}
+
+	logbook.mu.Lock()
+	defer logbook.mu.Unlock()
+
+	if len(logbook.pushed) != 1 {
+		t.Fatalf("pushed %d results, want 1", len(logbook.pushed))
+	}
+	pushed := logbook.pushed[0]
+	if pushed.Date != "2026-10-18 07:15:02" || pushed.Distance != 2000 || pushed.Time != 4100 ||
+		pushed.WeightClass != "L" || pushed.WorkoutType != "FixedDistanceSplits" {
+		t.Errorf("pushed result %+v", pushed)
+	}
+	if pushed.Detail == nil || len(pushed.Detail.Splits) != 4 {
+		t.Fatalf("pushed splits %+v, want four 500m splits", pushed.Detail)
+	}
+	if len(pushed.Strokes) < 2 || pushed.Strokes[len(pushed.Strokes)-1].Distance != 20000 {
+		t.Errorf("pushed %d strokes, want the whole piece", len(pushed.Strokes))
+	}
+}
+
+func TestConcept2PushDuplicate(t *testing.T) {
+	// ErgData already synced the piece; it's on the second page, dated
+	// when it finished
+	logbook, srv := newFakeLogbook(t,
+		listed(1, "2026-10-17 07:00:00", 5000, 12000, false),
+		listed(2, "2026-10-18 06:00:00", 2000, 4100, false),
+		listed(3, "2026-10-18 07:21:55", 2000, 4101, true),
+	)
+	s := newTestConcept2(t, srv)
+
+	if _, err := s.Upload(context.Background(), "user-1", testLogbookWorkout()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := s.integrations.finished(t)
+	if done.Status != models.UploadComplete || !done.Duplicate || done.ActivityID != "3" {
+		t.Fatalf("upload %+v, want it linked to result 3", done)
+	}
+
+	logbook.mu.Lock()
+	defer logbook.mu.Unlock()
+	if len(logbook.pushed) != 0 {
+		t.Errorf("pushed %d results, want none", len(logbook.pushed))
+	}
+	if logbook.pages != 2 {
+		t.Errorf("listed %d pages, want 2", logbook.pages)
+	}
+}
+
+func TestConcept2PushUnauthorized(t *testing.T) {
+	_, srv := newFakeLogbook(t)
+	s := newTestConcept2(t, srv)
+	s.integrations.connections[models.ProviderConcept2].AccessToken = "revoked"
+
+	if _, err := s.Upload(context.Background(), "user-1", testLogbookWorkout()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := s.integrations.finished(t)
+	if done.Status != models.UploadFailed || done.Error != errConcept2Unauthorized.Error() {
+		t.Fatalf("upload %+v, want the user asked to reconnect", done)
+	}
+	if conn := s.integrations.connection(t, models.ProviderConcept2); conn.Error != errConcept2Unauthorized.Error() {
+		t.Errorf("connection error %q", conn.Error)
+	}
+}
+
+func TestConcept2Import(t *testing.T) {
+	// Already saved here from the socket server
+	saved := testLogbookWorkout()
+
+	logbook, srv := newFakeLogbook(t,
+		// Stroke data becomes samples
+		listed(10, "2026-10-01 07:00:00", 1000, 2000, true),
+		// A 2k without stroke data is a whole best effort
+		listed(11, "2026-10-02 07:00:00", 2000, 4200, false),
+		// The piece saved here
+		listed(12, "2026-10-18 07:21:55", 2000, 4101, false),
+		// Imported before
+		listed(13, "2026-10-03 07:00:00", 500, 950, false),
+		// Not rowing
+		func() concept2Listed {
+			r := listed(14, "2026-10-04 07:00:00", 5000, 12000, false)
+			r.Type = "skierg"
+			return r
+		}(),
+	)
+	logbook.strokes[10] = []models.LogbookStroke{
+		{Time: 0, Distance: 0, Pace: 1000, StrokeRate: 30, HeartRate: 140},
+		{Time: 1000, Distance: 5000, Pace: 1000, StrokeRate: 30, HeartRate: 150},
+		{Time: 2000, Distance: 10000, Pace: 1000, StrokeRate: 30, HeartRate: 160},
+	}
+	s := newTestConcept2(t, srv, saved, &models.Workout{
+		ID: "concept2-13", SessionID: "concept2-13",
+		StartedAt: time.Date(2026, 10, 3, 7, 0, 0, 0, time.UTC), Distance: 1000, ElapsedTime: 300,
+	})
+
+	var followed struct {
+		sync.Mutex
+		ids []string
+	}
+	s.SetSavedHandler(func(ctx context.Context, uid string, workout *models.Workout, records []*models.Record) {
+		followed.Lock()
+		defer followed.Unlock()
+		followed.ids = append(followed.ids, workout.ID)
+	})
+
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 10, 31, 0, 0, 0, 0, time.UTC)
+	imp, err := s.Import(context.Background(), "user-1", from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imp.Status != models.ImportRunning {
+		t.Fatalf("Import returned status %s, want running", imp.Status)
+	}
+	if _, err := s.Import(context.Background(), "user-1", from, to); err != ErrRunning {
+		t.Errorf("second Import returned %v, want ErrRunning", err)
+	}
+
+	var conn *models.Connection
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn = s.integrations.connection(t, models.ProviderConcept2)
+		if conn.Import != nil && conn.Import.Status != models.ImportRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("import never finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if conn.Import.Status != models.ImportComplete || conn.Import.Imported != 2 || conn.Import.Skipped != 3 {
+		t.Fatalf("import %+v, want 2 imported and 3 skipped", conn.Import)
+	}
+
+	s.workouts.mu.Lock()
+	defer s.workouts.mu.Unlock()
+
+	if len(s.workouts.created) != 2 {
+		t.Fatalf("created %d workouts, want 2", len(s.workouts.created))
+	}
+	withStrokes, ranked := s.workouts.created[0], s.workouts.created[1]
+	if withStrokes.SessionID != "concept2-10" || len(withStrokes.Samples) != 3 ||
+		withStrokes.Distance != 1000 || withStrokes.ElapsedTime != 200 || withStrokes.MaxHeartRate != 160 {
+		t.Errorf("workout from stroke data %+v", withStrokes)
+	}
+	if ranked.SessionID != "concept2-11" || len(ranked.Samples) != 0 ||
+		len(ranked.BestEfforts) != 1 || ranked.BestEfforts[0].Event != "2k" {
+		t.Errorf("workout from a 2k result %+v", ranked)
+	}
+
+	s.records.mu.Lock()
+	defer s.records.mu.Unlock()
+	if fmt.Sprint(s.records.updated) != "[concept2-10 concept2-11]" {
+		t.Errorf("records updated from %v", s.records.updated)
+	}
+
+	// Imports are followed up like workouts saved through the API
+	followed.Lock()
+	defer followed.Unlock()
+	if fmt.Sprint(followed.ids) != "[concept2-10 concept2-11]" {
+		t.Errorf("saved handler called for %v", followed.ids)
+	}
+
+	// Imported workouts are linked to their results so they aren't pushed
+	// back
+	linked := map[string]models.Upload{}
+	for len(s.integrations.uploads) > 0 {
+		upload := <-s.integrations.uploads
+		linked[upload.WorkoutID] = upload
+	}
+	for _, id := range []string{"10", "11"} {
+		upload, ok := linked["concept2-"+id]
+		if !ok || !upload.Duplicate || upload.ActivityID != id || upload.Status != models.UploadComplete {
+			t.Errorf("result %s linked as %+v", id, upload)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// shareTokenBytes is the entropy of a dashboard share link
+const shareTokenBytes = 18
+
+// DashboardService stores users' dashboards and the links they are shared
+// by
+type DashboardService struct {
+	client *firestore.Client
+}
+
+// NewDashboardService creates a new dashboard service
+func NewDashboardService(client *firestore.Client) *DashboardService {
+	return &DashboardService{client: client}
+}
+
+// sharedDashboard maps a share token to the dashboard it opens
+type sharedDashboard struct {
+	UserID      string    `firestore:"user_id"`
+	DashboardID string    `firestore:"dashboard_id"`
+	CreatedAt   time.Time `firestore:"created_at"`
+}
+
+// collection returns the collection holding a user's dashboards
+func (s *DashboardService) collection(uid string) *firestore.CollectionRef {
+	return userDoc(s.client, uid).Collection("dashboards")
+}
+
+// seededDoc records that a user has been given the default dashboards, so
+// deleting them all doesn't bring them back
+func (s *DashboardService) seededDoc(uid string) *firestore.DocumentRef {
+	return userDoc(s.client, uid).Collection("settings").Doc("dashboards")
+}
+
+// shareDoc returns the document a share token is stored in
+func (s *DashboardService) shareDoc(token string) *firestore.DocumentRef {
+	return s.client.Collection("shared_dashboards").Doc(token)
+}
+
+// Seed gives a user the default dashboards the first time it's called for
+// them
+func (s *DashboardService) Seed(ctx context.Context, uid string) error {
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(s.seededDoc(uid))
+		if err == nil && snap.Exists() {
+			return nil
+		}
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, dashboard := range models.DefaultDashboards() {
+			dashboard.CreatedAt = now
+			dashboard.UpdatedAt = now
+			if err := tx.Create(s.collection(uid).NewDoc(), dashboard); err != nil {
+				return err
+			}
+		}
+		return tx.Set(s.seededDoc(uid), map[string]interface{}{"seeded_at": now})
+	})
+	if err != nil {
+		return fmt.Errorf("error seeding dashboards: %w", err)
+	}
+	return nil
+}
+
+// List returns a user's dashboards sorted by name, seeding the defaults
+// for new users
+func (s *DashboardService) List(ctx context.Context, uid string) ([]*models.Dashboard, error) {
+	if err := s.Seed(ctx, uid); err != nil {
+		return nil, err
+	}
+
+	snaps, err := s.collection(uid).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing dashboards: %w", err)
+	}
+
+	dashboards := make([]*models.Dashboard, 0, len(snaps))
+	for _, snap := range snaps {
+		dashboard, err := decodeDashboard(snap)
+		if err != nil {
+			return nil, err
+		}
+		dashboards = append(dashboards, dashboard)
+	}
+
+	sort.Slice(dashboards, func(i, j int) bool {
+		return strings.ToLower(dashboards[i].Name) < strings.ToLower(dashboards[j].Name)
+	})
+	return dashboards, nil
+}
+
+// Get returns a single dashboard
+func (s *DashboardService) Get(ctx context.Context, uid, id string) (*models.Dashboard, error) {
+	snap, err := s.collection(uid).Doc(id).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching dashboard: %w", err)
+	}
+	return decodeDashboard(snap)
+}
+
+// Create stores a new dashboard and sets its ID
+func (s *DashboardService) Create(ctx context.Context, uid string, dashboard *models.Dashboard) error {
+	dashboard.ShareToken = ""
+	dashboard.CreatedAt = time.Now().UTC()
+	dashboard.UpdatedAt = dashboard.CreatedAt
+
+	ref := s.collection(uid).NewDoc()
+	if _, err := ref.Create(ctx, dashboard); err != nil {
+		return fmt.Errorf("error saving dashboard: %w", err)
+	}
+
+	dashboard.ID = ref.ID
+	return nil
+}
+
+// Update replaces an existing dashboard's name and layout, keeping its
+// creation time and share link
+func (s *DashboardService) Update(ctx context.Context, uid, id string, dashboard *models.Dashboard) error {
+	ref := s.collection(uid).Doc(id)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching dashboard: %w", err)
+		}
+
+		current, err := decodeDashboard(snap)
+		if err != nil {
+			return err
+		}
+
+		dashboard.ID = id
+		dashboard.ShareToken = current.ShareToken
+		dashboard.CreatedAt = current.CreatedAt
+		dashboard.UpdatedAt = time.Now().UTC()
+		return tx.Set(ref, dashboard)
+	})
+}
+
+// Delete removes a dashboard and its share link
+func (s *DashboardService) Delete(ctx context.Context, uid, id string) error {
+	ref := s.collection(uid).Doc(id)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching dashboard: %w", err)
+		}
+
+		current, err := decodeDashboard(snap)
+		if err != nil {
+			return err
+		}
+		if current.ShareToken != "" {
+			if err := tx.Delete(s.shareDoc(current.ShareToken)); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// Share creates a share link for a dashboard, returning its token. A
+// dashboard that is already shared keeps its token.
+func (s *DashboardService) Share(ctx context.Context, uid, id string) (string, error) {
+	token, err := newShareToken()
+	if err != nil {
+		return "", err
+	}
+
+	ref := s.collection(uid).Doc(id)
+	err = s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching dashboard: %w", err)
+		}
+
+		current, err := decodeDashboard(snap)
+		if err != nil {
+			return err
+		}
+		if current.ShareToken != "" {
+			token = current.ShareToken
+			return nil
+		}
+
+		if err := tx.Create(s.shareDoc(token), sharedDashboard{
+			UserID:      uid,
+			DashboardID: id,
+			CreatedAt:   time.Now().UTC(),
+		}); err != nil {
+			return err
+		}
+		return tx.Update(ref, []firestore.Update{{Path: "share_token", Value: token}})
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Unshare revokes a dashboard's share link
+func (s *DashboardService) Unshare(ctx context.Context, uid, id string) error {
+	ref := s.collection(uid).Doc(id)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching dashboard: %w", err)
+		}
+
+		current, err := decodeDashboard(snap)
+		if err != nil {
+			return err
+		}
+		if current.ShareToken == "" {
+			return nil
+		}
+
+		if err := tx.Delete(s.shareDoc(current.ShareToken)); err != nil {
+			return err
+		}
+		return tx.Update(ref, []firestore.Update{{Path: "share_token", Value: ""}})
+	})
+}
+
+// GetShared returns the dashboard a share token opens
+func (s *DashboardService) GetShared(ctx context.Context, token string) (*models.Dashboard, error) {
+	snap, err := s.shareDoc(token).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching share link: %w", err)
+	}
+
+	var shared sharedDashboard
+	if err := snap.DataTo(&shared); err != nil {
+		return nil, fmt.Errorf("error decoding share link: %w", err)
+	}
+
+	dashboard, err := s.Get(ctx, shared.UserID, shared.DashboardID)
+	if err != nil {
+		return nil, err
+	}
+	if dashboard.ShareToken != token {
+		return nil, ErrNotFound
+	}
+	return dashboard, nil
+}
+
+// newShareToken returns a random URL-safe token
+func newShareToken() (string, error) {
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating share token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeDashboard reads a dashboard document
+func decodeDashboard(snap *firestore.DocumentSnapshot) (*models.Dashboard, error) {
+	var dashboard models.Dashboard
+	if err := snap.DataTo(&dashboard); err != nil {
+		return nil, fmt.Errorf("error decoding dashboard %s: %w", snap.Ref.ID, err)
+	}
+	dashboard.ID = snap.Ref.ID
+	return &dashboard, nil
+}
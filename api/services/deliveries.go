@@ -0,0 +1,332 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+const (
+	// webhookWorkers is the number of deliveries attempted at once
+	webhookWorkers = 4
+
+	// webhookTimeout bounds a single delivery attempt
+	webhookTimeout = 10 * time.Second
+
+	// maxDeliveryAttempts is how many times a delivery is tried before it
+	// is marked failed
+	maxDeliveryAttempts = 6
+
+	// firstRetryDelay is the wait before the first retry, doubling for each
+	// one after: 30s, 1m, 2m, 4m, 8m
+	firstRetryDelay = 30 * time.Second
+
+	// deliveryQueueSize bounds deliveries waiting for a worker
+	deliveryQueueSize = 256
+)
+
+// Headers sent with each delivery
+const (
+	HeaderWebhookEvent     = "X-Ergometer-Event"
+	HeaderWebhookDelivery  = "X-Ergometer-Delivery"
+	HeaderWebhookTimestamp = "X-Ergometer-Timestamp"
+	HeaderWebhookSignature = "X-Ergometer-Signature"
+)
+
+// deliveryStore stores webhooks and their deliveries, normally a
+// *WebhookService
+type deliveryStore interface {
+	Subscribers(ctx context.Context, event, uid string, teamIDs []string) ([]*models.Webhook, error)
+	Get(ctx context.Context, id string) (*models.Webhook, error)
+	CreateDelivery(ctx context.Context, delivery *models.Delivery, payload func(id string) ([]byte, error)) error
+	Pending(ctx context.Context) ([]*models.Delivery, error)
+	RecordAttempt(ctx context.Context, delivery *models.Delivery, attempt models.DeliveryAttempt, status string, next time.Time) error
+}
+
+// WebhookDispatcher posts signed payloads to webhooks in the background,
+// retrying failed deliveries with exponential backoff
+type WebhookDispatcher struct {
+	webhooks   deliveryStore
+	client     *http.Client
+	jobs       chan *models.Delivery
+	retryDelay time.Duration // firstRetryDelay outside tests
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewWebhookDispatcher creates a dispatcher for webhooks. Unless
+// allowPrivate is set, deliveries to loopback, private and link-local
+// addresses are refused so webhooks can't reach internal services.
+func NewWebhookDispatcher(webhooks *WebhookService, allowPrivate bool) *WebhookDispatcher {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookDispatcher{
+		webhooks: webhooks,
+		client: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		jobs:       make(chan *models.Delivery, deliveryQueueSize),
+		retryDelay: firstRetryDelay,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Start starts the delivery workers and resumes deliveries left pending
+// when the server last stopped
+func (d *WebhookDispatcher) Start() {
+	for i := 0; i < webhookWorkers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for {
+				select {
+				case delivery := <-d.jobs:
+					d.attempt(delivery)
+				case <-d.ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		pending, err := d.webhooks.Pending(d.ctx)
+		if err != nil {
+			log.Printf("Failed to resume webhook deliveries: %v", err)
+			return
+		}
+		for _, delivery := range pending {
+			d.schedule(delivery, time.Until(delivery.NextAttemptAt))
+		}
+		if len(pending) > 0 {
+			log.Printf("Resumed %d webhook deliveries", len(pending))
+		}
+	}()
+}
+
+// Shutdown stops the workers, waiting for attempts in progress. Pending
+// deliveries are resumed by the next Start.
+func (d *WebhookDispatcher) Shutdown(ctx context.Context) error {
+	d.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Publish sends event to every webhook subscribed to it for a user, their
+// own and those of teamIDs. uid may be empty for team-only events.
+// Failures are logged; publishing never fails the request that caused it.
+func (d *WebhookDispatcher) Publish(ctx context.Context, event, uid string, teamIDs []string, data interface{}) {
+	webhooks, err := d.webhooks.Subscribers(ctx, event, uid, teamIDs)
+	if err != nil {
+		log.Printf("Failed to find webhooks for %s: %v", event, err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if _, err := d.Send(ctx, webhook, event, uid, data); err != nil {
+			log.Printf("Failed to queue %s for webhook %s: %v", event, webhook.ID, err)
+		}
+	}
+}
+
+// Send queues a new delivery of event to a webhook
+func (d *WebhookDispatcher) Send(ctx context.Context, webhook *models.Webhook, event, uid string, data interface{}) (*models.Delivery, error) {
+	delivery := &models.Delivery{WebhookID: webhook.ID, Event: event}
+	err := d.webhooks.CreateDelivery(ctx, delivery, func(id string) ([]byte, error) {
+		return json.Marshal(models.WebhookPayload{
+			ID:        id,
+			Event:     event,
+			CreatedAt: time.Now().UTC(),
+			UserID:    uid,
+			TeamID:    webhook.TeamID,
+			Data:      data,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.schedule(delivery, 0)
+	return delivery, nil
+}
+
+// Redeliver queues a new delivery of a past delivery's payload. The
+// payload gets the new delivery's ID and keeps its original creation
+// time.
+func (d *WebhookDispatcher) Redeliver(ctx context.Context, webhook *models.Webhook, original *models.Delivery) (*models.Delivery, error) {
+	var payload struct {
+		models.WebhookPayload
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(original.Payload), &payload); err != nil {
+		return nil, fmt.Errorf("error decoding payload: %w", err)
+	}
+
+	delivery := &models.Delivery{WebhookID: webhook.ID, Event: original.Event, RedeliveryOf: original.ID}
+	err := d.webhooks.CreateDelivery(ctx, delivery, func(id string) ([]byte, error) {
+		payload.ID = id
+		payload.WebhookPayload.Data = payload.Data
+		return json.Marshal(payload.WebhookPayload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.schedule(delivery, 0)
+	return delivery, nil
+}
+
+// schedule queues a delivery for a worker after delay
+func (d *WebhookDispatcher) schedule(delivery *models.Delivery, delay time.Duration) {
+	enqueue := func() {
+		select {
+		case d.jobs <- delivery:
+		case <-d.ctx.Done():
+		}
+	}
+
+	if delay <= 0 {
+		go enqueue()
+		return
+	}
+	time.AfterFunc(delay, enqueue)
+}
+
+// attempt posts a delivery to its webhook once, recording the result and
+// scheduling a retry if it failed
+func (d *WebhookDispatcher) attempt(delivery *models.Delivery) {
+	webhook, err := d.webhooks.Get(d.ctx, delivery.WebhookID)
+	if errors.Is(err, ErrNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load webhook %s: %v", delivery.WebhookID, err)
+		d.schedule(delivery, d.retryDelay)
+		return
+	}
+
+	attempt := models.DeliveryAttempt{At: time.Now().UTC()}
+	if webhook.Active {
+		attempt.Status, err = d.post(webhook, delivery)
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+	} else {
+		attempt.Error = "webhook is disabled"
+	}
+	attempt.Duration = time.Since(attempt.At).Seconds()
+
+	status, next := models.DeliverySucceeded, time.Time{}
+	tries := len(delivery.Attempts) + 1
+	switch {
+	case attempt.Error == "":
+	case !webhook.Active || tries >= maxDeliveryAttempts:
+		status = models.DeliveryFailed
+	default:
+		status = models.DeliveryPending
+		next = attempt.At.Add(d.retryDelay << (tries - 1))
+	}
+
+	if err := d.webhooks.RecordAttempt(d.ctx, delivery, attempt, status, next); err != nil {
+		log.Printf("Failed to record delivery %s: %v", delivery.ID, err)
+	}
+	if status == models.DeliveryPending {
+		d.schedule(delivery, time.Until(next))
+	}
+}
+
+// post sends a delivery's payload, returning the response status. Any
+// status outside 2xx is an error.
+func (d *WebhookDispatcher) post(webhook *models.Webhook, delivery *models.Delivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Ergometer.Live-Webhooks/1.0")
+	req.Header.Set(HeaderWebhookEvent, delivery.Event)
+	req.Header.Set(HeaderWebhookDelivery, delivery.ID)
+	req.Header.Set(HeaderWebhookTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderWebhookSignature, "sha256="+SignWebhook(webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "timestamp.body" keyed with a
+// webhook's secret, as sent in the X-Ergometer-Signature header
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// publicIP reports whether ip is routable on the public internet
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
@@ -0,0 +1,392 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// testRetryDelay replaces firstRetryDelay so retries run within a test
+const testRetryDelay = 20 * time.Millisecond
+
+// recordedAttempt is an attempt the fake store was asked to record
+type recordedAttempt struct {
+	DeliveryID string
+	Attempt    models.DeliveryAttempt
+	Status     string
+	Next       time.Time
+}
+
+// fakeDeliveryStore keeps webhooks and deliveries in memory
+type fakeDeliveryStore struct {
+	mu         sync.Mutex
+	webhooks   map[string]*models.Webhook
+	deliveries int
+	recorded   chan recordedAttempt
+}
+
+func newFakeDeliveryStore(webhooks ...*models.Webhook) *fakeDeliveryStore {
+	store := &fakeDeliveryStore{
+		webhooks: make(map[string]*models.Webhook),
+		recorded: make(chan recordedAttempt, 64),
+	}
+	for _, webhook := range webhooks {
+		store.webhooks[webhook.ID] = webhook
+	}
+	return store
+}
+
+func (s *fakeDeliveryStore) Subscribers(ctx context.Context, event, uid string, teamIDs []string) ([]*models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var webhooks []*models.Webhook
+	for _, webhook := range s.webhooks {
+		if webhook.UserID == uid {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (s *fakeDeliveryStore) Get(ctx context.Context, id string) (*models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *webhook
+	return &copied, nil
+}
+
+func (s *fakeDeliveryStore) CreateDelivery(ctx context.Context, delivery *models.Delivery, payload func(id string) ([]byte, error)) error {
+	s.mu.Lock()
+	s.deliveries++
+	id := fmt.Sprintf("delivery-%d", s.deliveries)
+	s.mu.Unlock()
+
+	body, err := payload(id)
+	if err != nil {
+		return err
+	}
+
+	delivery.ID = id
+	delivery.Payload = string(body)
+	delivery.Status = models.DeliveryPending
+	delivery.Attempts = []models.DeliveryAttempt{}
+	delivery.CreatedAt = time.Now().UTC()
+	delivery.NextAttemptAt = delivery.CreatedAt
+	return nil
+}
+
+func (s *fakeDeliveryStore) Pending(ctx context.Context) ([]*models.Delivery, error) {
+	return nil, nil
+}
+
+func (s *fakeDeliveryStore) RecordAttempt(ctx context.Context, delivery *models.Delivery, attempt models.DeliveryAttempt, status string, next time.Time) error {
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.Status = status
+	delivery.NextAttemptAt = next
+
+	s.recorded <- recordedAttempt{DeliveryID: delivery.ID, Attempt: attempt, Status: status, Next: next}
+	return nil
+}
+
+// next waits for the next recorded attempt
+func (s *fakeDeliveryStore) next(t *testing.T) recordedAttempt {
+	t.Helper()
+
+	select {
+	case recorded := <-s.recorded:
+		return recorded
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery attempt was recorded")
+		return recordedAttempt{}
+	}
+}
+
+// receivedRequest is a delivery as the receiver saw it
+type receivedRequest struct {
+	Header http.Header
+	Body   []byte
+}
+
+// newReceiver starts a webhook receiver answering each request with the
+// next of statuses, then 204
+func newReceiver(t *testing.T, statuses ...int) (*httptest.Server, <-chan receivedRequest) {
+	t.Helper()
+
+	var mu sync.Mutex
+	received := make(chan receivedRequest, 64)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedRequest{Header: r.Header.Clone(), Body: body}
+
+		mu.Lock()
+		status := http.StatusNoContent
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+// newTestDispatcher starts a dispatcher on store that may deliver to the
+// loopback receivers tests use
+func newTestDispatcher(t *testing.T, store deliveryStore, allowPrivate bool) *WebhookDispatcher {
+	t.Helper()
+
+	d := NewWebhookDispatcher(nil, allowPrivate)
+	d.webhooks = store
+	d.retryDelay = testRetryDelay
+	d.Start()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		d.Shutdown(ctx)
+	})
+	return d
+}
+
+func testWebhook(url string) *models.Webhook {
+	return &models.Webhook{
+		ID:     "webhook-1",
+		URL:    url,
+		Events: []string{models.WebhookWorkoutCompleted},
+		Active: true,
+		UserID: "user-1",
+		Secret: "whsec_test",
+	}
+}
+
+func TestWebhookDeliverySigned(t *testing.T) {
+	srv, received := newReceiver(t)
+	webhook := testWebhook(srv.URL)
+	store := newFakeDeliveryStore(webhook)
+	d := newTestDispatcher(t, store, true)
+
+	delivery, err := d.Send(context.Background(), webhook, models.WebhookWorkoutCompleted, "user-1", map[string]int{"distance": 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-received
+	if got := req.Header.Get(HeaderWebhookEvent); got != models.WebhookWorkoutCompleted {
+		t.Errorf("event header %q", got)
+	}
+	if got := req.Header.Get(HeaderWebhookDelivery); got != delivery.ID {
+		t.Errorf("delivery header %q, want %q", got, delivery.ID)
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("content type %q", got)
+	}
+
+	// Receivers check the signature as documented: the HMAC of
+	// "timestamp.body" with the webhook's secret
+	timestamp := req.Header.Get(HeaderWebhookTimestamp)
+	if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+		t.Fatalf("timestamp header %q: %v", timestamp, err)
+	}
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(timestamp + "." + string(req.Body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := req.Header.Get(HeaderWebhookSignature); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("signature %q, want %q", got, want)
+	}
+
+	var payload struct {
+		ID     string         `json:"id"`
+		Event  string         `json:"event"`
+		UserID string         `json:"user_id"`
+		Data   map[string]int `json:"data"`
+	}
+	if err := json.Unmarshal(req.Body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.ID != delivery.ID || payload.Event != models.WebhookWorkoutCompleted ||
+		payload.UserID != "user-1" || payload.Data["distance"] != 2000 {
+		t.Errorf("payload %+v", payload)
+	}
+
+	recorded := store.next(t)
+	if recorded.Status != models.DeliverySucceeded || recorded.Attempt.Status != http.StatusNoContent ||
+		recorded.Attempt.Error != "" || !recorded.Next.IsZero() {
+		t.Errorf("recorded %+v, want a success", recorded)
+	}
+}
+
+func TestWebhookDeliveryRetries(t *testing.T) {
+	srv, received := newReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	webhook := testWebhook(srv.URL)
+	store := newFakeDeliveryStore(webhook)
+	d := newTestDispatcher(t, store, true)
+
+	if _, err := d.Send(context.Background(), webhook, models.WebhookWorkoutCompleted, "user-1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each retry waits twice as long as the one before
+	for i, want := range []int{http.StatusInternalServerError, http.StatusBadGateway} {
+		recorded := store.next(t)
+		if recorded.Status != models.DeliveryPending || recorded.Attempt.Status != want || recorded.Attempt.Error == "" {
+			t.Fatalf("attempt %d recorded %+v, want a pending failure", i+1, recorded)
+		}
+		if delay := recorded.Next.Sub(recorded.Attempt.At); delay != testRetryDelay<<i {
+			t.Fatalf("attempt %d retries after %v, want %v", i+1, delay, testRetryDelay<<i)
+		}
+	}
+
+	recorded := store.next(t)
+	if recorded.Status != models.DeliverySucceeded || !recorded.Next.IsZero() {
+		t.Fatalf("third attempt recorded %+v, want a success", recorded)
+	}
+
+	// Every attempt carried the same delivery
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, (<-received).Header.Get(HeaderWebhookDelivery))
+	}
+	if ids[0] != ids[1] || ids[1] != ids[2] {
+		t.Errorf("attempts sent deliveries %v", ids)
+	}
+}
+
+func TestWebhookDeliveryGivesUp(t *testing.T) {
+	statuses := make([]int, maxDeliveryAttempts+1)
+	for i := range statuses {
+		statuses[i] = http.StatusServiceUnavailable
+	}
+	srv, received := newReceiver(t, statuses...)
+	webhook := testWebhook(srv.URL)
+	store := newFakeDeliveryStore(webhook)
+	d := newTestDispatcher(t, store, true)
+
+	if _, err := d.Send(context.Background(), webhook, models.WebhookWorkoutCompleted, "user-1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= maxDeliveryAttempts; i++ {
+		recorded := store.next(t)
+		want := models.DeliveryPending
+		if i == maxDeliveryAttempts {
+			want = models.DeliveryFailed
+		}
+		if recorded.Status != want {
+			t.Fatalf("attempt %d recorded %s, want %s", i, recorded.Status, want)
+		}
+	}
+
+	// No attempt follows the last
+	time.Sleep(testRetryDelay << maxDeliveryAttempts)
+	if len(received) != maxDeliveryAttempts {
+		t.Fatalf("receiver got %d attempts, want %d", len(received), maxDeliveryAttempts)
+	}
+}
+
+func TestWebhookDeliveryDisabled(t *testing.T) {
+	srv, received := newReceiver(t)
+	webhook := testWebhook(srv.URL)
+	webhook.Active = false
+	store := newFakeDeliveryStore(webhook)
+	d := newTestDispatcher(t, store, true)
+
+	if _, err := d.Send(context.Background(), webhook, models.WebhookWorkoutCompleted, "user-1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := store.next(t)
+	if recorded.Status != models.DeliveryFailed || recorded.Attempt.Error != "webhook is disabled" {
+		t.Fatalf("recorded %+v, want a failure without retries", recorded)
+	}
+	if len(received) != 0 {
+		t.Fatal("a disabled webhook was posted to")
+	}
+}
+
+func TestWebhookDeliveryRefusesPrivateAddresses(t *testing.T) {
+	srv, received := newReceiver(t)
+	webhook := testWebhook(srv.URL)
+	store := newFakeDeliveryStore(webhook)
+	d := newTestDispatcher(t, store, false)
+
+	if _, err := d.Send(context.Background(), webhook, models.WebhookWorkoutCompleted, "user-1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := store.next(t)
+	if recorded.Status != models.DeliveryPending || !strings.Contains(recorded.Attempt.Error, "not public") {
+		t.Fatalf("recorded %+v, want the loopback address refused", recorded)
+	}
+	if len(received) != 0 {
+		t.Fatal("a loopback webhook was posted to")
+	}
+}
+
+func TestWebhookRedeliver(t *testing.T) {
+	srv, received := newReceiver(t)
+	webhook := testWebhook(srv.URL)
+	store := newFakeDeliveryStore(webhook)
+	d := newTestDispatcher(t, store, true)
+
+	original, err := d.Send(context.Background(), webhook, models.WebhookPersonalBest, "user-1", map[string]string{"event": "2k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := <-received
+	store.next(t)
+
+	redelivery, err := d.Redeliver(context.Background(), webhook, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redelivery.ID == original.ID || redelivery.RedeliveryOf != original.ID || redelivery.Event != original.Event {
+		t.Fatalf("redelivery %+v of %s", redelivery, original.ID)
+	}
+
+	second := <-received
+	if got := second.Header.Get(HeaderWebhookDelivery); got != redelivery.ID {
+		t.Errorf("redelivery sent as %q, want %q", got, redelivery.ID)
+	}
+	if recorded := store.next(t); recorded.DeliveryID != redelivery.ID || recorded.Status != models.DeliverySucceeded {
+		t.Errorf("recorded %+v, want the redelivery to succeed", recorded)
+	}
+
+	// The payload keeps its data and creation time under the new ID
+	var before, after map[string]interface{}
+	if err := json.Unmarshal(first.Body, &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(second.Body, &after); err != nil {
+		t.Fatal(err)
+	}
+	if after["id"] != redelivery.ID {
+		t.Errorf("redelivered payload id %v, want %s", after["id"], redelivery.ID)
+	}
+	for _, field := range []string{"event", "created_at", "user_id"} {
+		if before[field] != after[field] {
+			t.Errorf("redelivered %s %v, want %v", field, after[field], before[field])
+		}
+	}
+	if fmt.Sprint(before["data"]) != fmt.Sprint(after["data"]) {
+		t.Errorf("redelivered data %v, want %v", after["data"], before["data"])
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 
+	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/auth"
 	"google.golang.org/api/option"
@@ -12,8 +13,9 @@ import (
 
 // FirebaseService handles Firebase operations
 type FirebaseService struct {
-	app    *firebase.App
-	client *auth.Client
+	app       *firebase.App
+	client    *auth.Client
+	firestore *firestore.Client
 }
 
 // NewFirebaseService creates a new Firebase service
@@ -39,14 +41,30 @@ func NewFirebaseService(credentialsPath, projectID string) (*FirebaseService, er
 		return nil, fmt.Errorf("error getting Auth client: %w", err)
 	}
 
+	store, err := app.Firestore(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting Firestore client: %w", err)
+	}
+
 	log.Printf("Firebase service initialized for project: %s", projectID)
 
 	return &FirebaseService{
-		app:    app,
-		client: client,
+		app:       app,
+		client:    client,
+		firestore: store,
 	}, nil
 }
 
+// Firestore returns the Firestore client used for application data
+func (s *FirebaseService) Firestore() *firestore.Client {
+	return s.firestore
+}
+
+// Close releases the Firestore connection
+func (s *FirebaseService) Close() error {
+	return s.firestore.Close()
+}
+
 // VerifyIDToken verifies a Firebase ID token and returns the UID
 func (s *FirebaseService) VerifyIDToken(ctx context.Context, idToken string) (string, error) {
 	token, err := s.client.VerifyIDToken(ctx, idToken)
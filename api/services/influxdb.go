@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// sampleMeasurement is the InfluxDB measurement workout samples are
+// written to
+const sampleMeasurement = "workout_sample"
+
+// writeBatchSize bounds the points written per request
+const writeBatchSize = 5000
+
+// InfluxDBService stores per-snapshot workout data in InfluxDB
+type InfluxDBService struct {
+	client influxdb2.Client
+	org    string
+	bucket string
+	write  api.WriteAPIBlocking
+	query  api.QueryAPI
+}
+
+// NewInfluxDBService creates a new InfluxDB service
+func NewInfluxDBService(url, token, org, bucket string) *InfluxDBService {
+	client := influxdb2.NewClient(url, token)
+
+	log.Printf("InfluxDB service initialized for bucket: %s", bucket)
+
+	return &InfluxDBService{
+		client: client,
+		org:    org,
+		bucket: bucket,
+		write:  client.WriteAPIBlocking(org, bucket),
+		query:  client.QueryAPI(org),
+	}
+}
+
+// WriteSamples stores a workout's samples, timestamped from its start
+func (s *InfluxDBService) WriteSamples(ctx context.Context, uid string, workout *models.Workout) error {
+	tags := map[string]string{
+		"user_id":    uid,
+		"workout_id": workout.ID,
+	}
+
+	points := make([]*write.Point, 0, writeBatchSize)
+	for i, sample := range workout.Samples {
+		ts := workout.StartedAt.Add(time.Duration(sample.Time * float64(time.Second)))
+		points = append(points, influxdb2.NewPoint(sampleMeasurement, tags, map[string]interface{}{
+			"elapsed_time": sample.Time,
+			"distance":     sample.Distance,
+			"pace":         sample.Pace,
+			"power":        int64(sample.Power),
+			"stroke_rate":  int64(sample.StrokeRate),
+			"heart_rate":   int64(sample.HeartRate),
+		}, ts))
+
+		if len(points) == writeBatchSize || i == len(workout.Samples)-1 {
+			if err := s.write.WritePoint(ctx, points...); err != nil {
+				return fmt.Errorf("error writing workout samples: %w", err)
+			}
+			points = points[:0]
+		}
+	}
+	return nil
+}
+
+// ReadSamples loads a stored workout's samples in time order
+func (s *InfluxDBService) ReadSamples(ctx context.Context, uid string, workout *models.Workout) ([]models.WorkoutSample, error) {
+	start := workout.StartedAt.Add(-time.Second)
+	stop := workout.StartedAt.Add(time.Duration((workout.ElapsedTime + 2) * float64(time.Second)))
+
+	flux := fmt.Sprintf(`from(bucket: "%s")
+  |> range(start: %s, stop: %s)
+  |> filter(fn: (r) => r._measurement == "%s" and r.user_id == "%s" and r.workout_id == "%s")
+  |> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")
+  |> sort(columns: ["_time"])`,
+		fluxString(s.bucket),
+		start.UTC().Format(time.RFC3339Nano), stop.UTC().Format(time.RFC3339Nano),
+		sampleMeasurement, fluxString(uid), fluxString(workout.ID))
+
+	result, err := s.query.Query(ctx, flux)
+	if err != nil {
+		return nil, fmt.Errorf("error querying workout samples: %w", err)
+	}
+	defer result.Close()
+
+	var samples []models.WorkoutSample
+	for result.Next() {
+		record := result.Record()
+		samples = append(samples, models.WorkoutSample{
+			Time:       fluxFloat(record.ValueByKey("elapsed_time")),
+			Distance:   fluxFloat(record.ValueByKey("distance")),
+			Pace:       fluxFloat(record.ValueByKey("pace")),
+			Power:      uint32(fluxFloat(record.ValueByKey("power"))),
+			StrokeRate: byte(fluxFloat(record.ValueByKey("stroke_rate"))),
+			HeartRate:  byte(fluxFloat(record.ValueByKey("heart_rate"))),
+		})
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("error reading workout samples: %w", err)
+	}
+	return samples, nil
+}
+
+// fluxString escapes a value for a Flux string literal
+func fluxString(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`).Replace(value)
+}
+
+// fluxFloat reads a numeric field, 0 if missing
+func fluxFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	}
+	return 0
+}
+
+// Close releases the InfluxDB client
+func (s *InfluxDBService) Close() {
+	s.client.Close()
+}
@@ -0,0 +1,484 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// oauthStateLifetime bounds how long a user has to authorize a provider
+const oauthStateLifetime = 10 * time.Minute
+
+// ErrNotGranted is returned when a user connects a provider without
+// granting the access needed
+var ErrNotGranted = errors.New("access not granted")
+
+// oauthState ties an authorization in progress to the user who started it
+type oauthState struct {
+	UserID    string    `firestore:"user_id"`
+	Provider  string    `firestore:"provider"`
+	ExpiresAt time.Time `firestore:"expires_at"`
+}
+
+// integrationStore stores what an integration needs to connect users and
+// track their uploads, normally an *IntegrationService
+type integrationStore interface {
+	NewState(ctx context.Context, uid, provider string) (string, error)
+	ConsumeState(ctx context.Context, uid, provider, state string) error
+	Connection(ctx context.Context, uid, provider string) (*models.Connection, error)
+	SaveConnection(ctx context.Context, uid string, conn *models.Connection) error
+	UpdateConnection(ctx context.Context, uid, provider string, updates []firestore.Update) error
+	DeleteConnection(ctx context.Context, uid, provider string) error
+	StartUpload(ctx context.Context, uid, workoutID, provider string) (*models.Upload, error)
+	SaveUpload(ctx context.Context, uid string, upload *models.Upload) error
+}
+
+// IntegrationService stores users' connections to other services, the
+// authorizations in progress and the status of workouts sent to them
+type IntegrationService struct {
+	client *firestore.Client
+}
+
+// NewIntegrationService creates a new integration service
+func NewIntegrationService(client *firestore.Client) *IntegrationService {
+	return &IntegrationService{client: client}
+}
+
+// connectionDoc returns the document holding a user's connection to a
+// provider
+func (s *IntegrationService) connectionDoc(uid, provider string) *firestore.DocumentRef {
+	return userDoc(s.client, uid).Collection("integrations").Doc(provider)
+}
+
+// uploadDoc returns the document tracking a workout sent to a provider
+func (s *IntegrationService) uploadDoc(uid, workoutID, provider string) *firestore.DocumentRef {
+	return userDoc(s.client, uid).Collection("workouts").Doc(workoutID).Collection("uploads").Doc(provider)
+}
+
+// stateDoc returns the document for an authorization in progress
+func (s *IntegrationService) stateDoc(state string) *firestore.DocumentRef {
+	return s.client.Collection("oauth_states").Doc(state)
+}
+
+// NewState starts an authorization, returning the state to send the
+// provider
+func (s *IntegrationService) NewState(ctx context.Context, uid, provider string) (string, error) {
+	state, err := newShareToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.stateDoc(state).Create(ctx, oauthState{
+		UserID:    uid,
+		Provider:  provider,
+		ExpiresAt: time.Now().UTC().Add(oauthStateLifetime),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error saving authorization state: %w", err)
+	}
+	return state, nil
+}
+
+// ConsumeState checks a state returned by a provider was issued to the
+// user for it, deleting it so it can't be used again. It returns
+// ErrNotFound for an unknown state and ErrExpired for an old one.
+func (s *IntegrationService) ConsumeState(ctx context.Context, uid, provider, state string) error {
+	if state == "" {
+		return ErrNotFound
+	}
+	ref := s.stateDoc(state)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching authorization state: %w", err)
+		}
+
+		var stored oauthState
+		if err := snap.DataTo(&stored); err != nil {
+			return fmt.Errorf("error decoding authorization state: %w", err)
+		}
+		if stored.UserID != uid || stored.Provider != provider {
+			return ErrNotFound
+		}
+		if time.Now().After(stored.ExpiresAt) {
+			return ErrExpired
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// Connection returns a user's connection to a provider, including its
+// tokens
+func (s *IntegrationService) Connection(ctx context.Context, uid, provider string) (*models.Connection, error) {
+	snap, err := s.connectionDoc(uid, provider).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching connection: %w", err)
+	}
+
+	var conn models.Connection
+	if err := snap.DataTo(&conn); err != nil {
+		return nil, fmt.Errorf("error decoding connection: %w", err)
+	}
+	conn.Provider = provider
+	return &conn, nil
+}
+
+// SaveConnection stores a user's connection to a provider
+func (s *IntegrationService) SaveConnection(ctx context.Context, uid string, conn *models.Connection) error {
+	if _, err := s.connectionDoc(uid, conn.Provider).Set(ctx, conn); err != nil {
+		return fmt.Errorf("error saving connection: %w", err)
+	}
+	return nil
+}
+
+// UpdateConnection applies updates to a user's connection to a provider
+func (s *IntegrationService) UpdateConnection(ctx context.Context, uid, provider string, updates []firestore.Update) error {
+	if _, err := s.connectionDoc(uid, provider).Update(ctx, updates); err != nil {
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error updating connection: %w", err)
+	}
+	return nil
+}
+
+// DeleteConnection removes a user's connection to a provider. Upload
+// history is kept.
+func (s *IntegrationService) DeleteConnection(ctx context.Context, uid, provider string) error {
+	if _, err := s.connectionDoc(uid, provider).Delete(ctx, firestore.Exists); err != nil {
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error deleting connection: %w", err)
+	}
+	return nil
+}
+
+// Upload returns the status of a workout sent to a provider
+func (s *IntegrationService) Upload(ctx context.Context, uid, workoutID, provider string) (*models.Upload, error) {
+	snap, err := s.uploadDoc(uid, workoutID, provider).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching upload: %w", err)
+	}
+
+	var upload models.Upload
+	if err := snap.DataTo(&upload); err != nil {
+		return nil, fmt.Errorf("error decoding upload: %w", err)
+	}
+	upload.WorkoutID = workoutID
+	return &upload, nil
+}
+
+// StartUpload marks a workout as waiting to be sent to a provider,
+// clearing the outcome of any earlier attempt
+func (s *IntegrationService) StartUpload(ctx context.Context, uid, workoutID, provider string) (*models.Upload, error) {
+	upload, err := s.Upload(ctx, uid, workoutID, provider)
+	if errors.Is(err, ErrNotFound) {
+		upload = &models.Upload{WorkoutID: workoutID, Provider: provider}
+	} else if err != nil {
+		return nil, err
+	}
+
+	upload.Status = models.UploadPending
+	upload.UploadID = ""
+	upload.ActivityID = ""
+	upload.URL = ""
+	upload.Duplicate = false
+	upload.Error = ""
+	upload.Attempts++
+	if err := s.SaveUpload(ctx, uid, upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// SaveUpload stores the status of a workout sent to a provider
+func (s *IntegrationService) SaveUpload(ctx context.Context, uid string, upload *models.Upload) error {
+	upload.UpdatedAt = time.Now().UTC()
+	if upload.CreatedAt.IsZero() {
+		upload.CreatedAt = upload.UpdatedAt
+	}
+
+	if _, err := s.uploadDoc(uid, upload.WorkoutID, upload.Provider).Set(ctx, upload); err != nil {
+		return fmt.Errorf("error saving upload: %w", err)
+	}
+	return nil
+}
+
+// jobs runs an integration's uploads and imports in the background,
+// bounding how many run at once
+type jobs struct {
+	slots chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newJobs creates a runner for at most n jobs at once
+func newJobs(n int) *jobs {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobs{slots: make(chan struct{}, n), ctx: ctx, cancel: cancel}
+}
+
+// run starts fn once a slot is free. fn's context is cancelled when the
+// server shuts down.
+func (j *jobs) run(fn func(ctx context.Context)) {
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		select {
+		case j.slots <- struct{}{}:
+			defer func() { <-j.slots }()
+		case <-j.ctx.Done():
+			return
+		}
+		fn(j.ctx)
+	}()
+}
+
+// shutdown cancels running jobs and waits for them to finish
+func (j *jobs) shutdown(ctx context.Context) error {
+	j.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// oauthError is an error response from a provider's API
+type oauthError struct {
+	Provider string // as the provider is named in messages
+	Status   int
+	Message  string
+}
+
+func (e *oauthError) Error() string {
+	if e.Status == http.StatusTooManyRequests {
+		return e.Provider + " rate limit reached; try again later"
+	}
+	return fmt.Sprintf("%s returned %d: %s", e.Provider, e.Status, e.Message)
+}
+
+// oauthToken is a provider's response to a token request
+type oauthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresAt    int64  `json:"expires_at"` // Unix seconds, from providers that send it
+	ExpiresIn    int64  `json:"expires_in"` // seconds, from providers that send it
+}
+
+// expiry returns when the access token expires, zero if the provider
+// didn't say
+func (t *oauthToken) expiry() time.Time {
+	switch {
+	case t.ExpiresAt > 0:
+		return time.Unix(t.ExpiresAt, 0).UTC()
+	case t.ExpiresIn > 0:
+		return time.Now().UTC().Add(time.Duration(t.ExpiresIn) * time.Second)
+	}
+	return time.Time{}
+}
+
+// oauthClient calls a provider's API for connected users, refreshing
+// their tokens as they expire and marking connections whose tokens the
+// provider rejects. Integrations set the parts that differ between
+// providers.
+type oauthClient struct {
+	integrations integrationStore
+	client       *http.Client
+
+	provider     string // models.Provider*
+	name         string // how the provider is named in messages
+	tokenURL     string
+	apiURL       string // prefixed to API paths
+	clientID     string
+	clientSecret string
+
+	tokenParams url.Values  // sent with every token request
+	apiHeader   http.Header // sent with every API request
+	maxResponse int64       // bytes of a response read
+
+	// errorMessage returns the message in an error response, "" if there
+	// is none
+	errorMessage func(body []byte) string
+
+	// refreshHint is in the message of a 400 response to a refresh the
+	// provider won't accept the refresh token for
+	refreshHint string
+
+	// unauthorized is returned once the provider rejects a user's tokens
+	unauthorized error
+
+	refresh sync.Mutex // serializes token refreshes
+}
+
+// accessToken returns a user's connection with a current access token,
+// refreshing it if it is about to expire
+func (c *oauthClient) accessToken(ctx context.Context, uid string) (*models.Connection, error) {
+	c.refresh.Lock()
+	defer c.refresh.Unlock()
+
+	conn, err := c.integrations.Connection(ctx, uid, c.provider)
+	if errors.Is(err, ErrNotFound) {
+		return nil, errors.New(c.name + " is not connected")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if conn.Error != "" {
+		return nil, errors.New(conn.Error)
+	}
+	if !conn.Expired(time.Now()) {
+		return conn, nil
+	}
+
+	token, err := c.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {conn.RefreshToken},
+	}, nil)
+	if err != nil {
+		return nil, c.checkAuth(ctx, uid, err)
+	}
+
+	conn.AccessToken = token.AccessToken
+	conn.RefreshToken = token.RefreshToken
+	conn.ExpiresAt = token.expiry()
+	err = c.integrations.UpdateConnection(ctx, uid, c.provider, []firestore.Update{
+		{Path: "access_token", Value: conn.AccessToken},
+		{Path: "refresh_token", Value: conn.RefreshToken},
+		{Path: "expires_at", Value: conn.ExpiresAt},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// checkAuth marks a user's connection as needing to be reconnected if err
+// shows the provider rejected their tokens, returning the error to report
+func (c *oauthClient) checkAuth(ctx context.Context, uid string, err error) error {
+	var apiErr *oauthError
+	if !errors.As(err, &apiErr) || (apiErr.Status != http.StatusUnauthorized && apiErr.Status != http.StatusBadRequest) {
+		return err
+	}
+	if apiErr.Status == http.StatusBadRequest && !strings.Contains(apiErr.Message, c.refreshHint) {
+		return err
+	}
+
+	updateErr := c.integrations.UpdateConnection(context.WithoutCancel(ctx), uid, c.provider, []firestore.Update{
+		{Path: "error", Value: c.unauthorized.Error()},
+	})
+	if updateErr != nil {
+		log.Printf("Failed to mark %s connection of %s: %v", c.name, uid, updateErr)
+	}
+	return c.unauthorized
+}
+
+// token requests tokens with the app's credentials. The response is also
+// decoded into extra if it isn't nil, for providers that send more.
+func (c *oauthClient) token(ctx context.Context, form url.Values, extra interface{}) (*oauthToken, error) {
+	form.Set("client_id", c.clientID)
+	form.Set("client_secret", c.clientSecret)
+	for key, values := range c.tokenParams {
+		form[key] = values
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var body json.RawMessage
+	if err := c.do(req, &body); err != nil {
+		return nil, err
+	}
+	var token oauthToken
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("error decoding %s token: %w", c.name, err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("%s returned no access token", c.name)
+	}
+	if extra != nil {
+		if err := json.Unmarshal(body, extra); err != nil {
+			return nil, fmt.Errorf("error decoding %s token: %w", c.name, err)
+		}
+	}
+	return &token, nil
+}
+
+// apiRequest builds an authorized request to an API path
+func (c *oauthClient) apiRequest(ctx context.Context, method, path, token string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.apiHeader {
+		req.Header[key] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+// do sends a request, decoding a JSON response into v if it isn't nil.
+// Responses outside 2xx are returned as an *oauthError.
+func (c *oauthClient) do(req *http.Request, v interface{}) error {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.maxResponse))
+	if err != nil {
+		return fmt.Errorf("error reading %s response: %w", c.name, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &oauthError{Provider: c.name, Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		if message := c.errorMessage(body); message != "" {
+			apiErr.Message = message
+		}
+		return apiErr
+	}
+
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("error decoding %s response: %w", c.name, err)
+	}
+	return nil
+}
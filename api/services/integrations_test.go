@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// fakeIntegrationStore keeps one user's connections and uploads in memory
+type fakeIntegrationStore struct {
+	mu          sync.Mutex
+	connections map[string]*models.Connection
+	uploads     chan models.Upload
+}
+
+func newFakeIntegrationStore(conns ...*models.Connection) *fakeIntegrationStore {
+	store := &fakeIntegrationStore{
+		connections: make(map[string]*models.Connection),
+		uploads:     make(chan models.Upload, 64),
+	}
+	for _, conn := range conns {
+		store.connections[conn.Provider] = conn
+	}
+	return store
+}
+
+func (s *fakeIntegrationStore) NewState(ctx context.Context, uid, provider string) (string, error) {
+	return "state", nil
+}
+
+func (s *fakeIntegrationStore) ConsumeState(ctx context.Context, uid, provider, state string) error {
+	return nil
+}
+
+func (s *fakeIntegrationStore) Connection(ctx context.Context, uid, provider string) (*models.Connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn, ok := s.connections[provider]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *conn
+	return &copied, nil
+}
+
+func (s *fakeIntegrationStore) SaveConnection(ctx context.Context, uid string, conn *models.Connection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *conn
+	s.connections[conn.Provider] = &copied
+	return nil
+}
+
+func (s *fakeIntegrationStore) UpdateConnection(ctx context.Context, uid, provider string, updates []firestore.Update) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn, ok := s.connections[provider]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, update := range updates {
+		switch update.Path {
+		case "access_token":
+			conn.AccessToken = update.Value.(string)
+		case "refresh_token":
+			conn.RefreshToken = update.Value.(string)
+		case "expires_at":
+			conn.ExpiresAt = update.Value.(time.Time)
+		case "error":
+			conn.Error = update.Value.(string)
+		case "auto_upload":
+			conn.AutoUpload = update.Value.(bool)
+		case "import":
+			imp := *update.Value.(*models.Import)
+			conn.Import = &imp
+		default:
+			return fmt.Errorf("unexpected update of %s", update.Path)
+		}
+	}
+	return nil
+}
+
+func (s *fakeIntegrationStore) DeleteConnection(ctx context.Context, uid, provider string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.connections, provider)
+	return nil
+}
+
+func (s *fakeIntegrationStore) StartUpload(ctx context.Context, uid, workoutID, provider string) (*models.Upload, error) {
+	return &models.Upload{WorkoutID: workoutID, Provider: provider, Status: models.UploadPending, Attempts: 1}, nil
+}
+
+func (s *fakeIntegrationStore) SaveUpload(ctx context.Context, uid string, upload *models.Upload) error {
+	s.uploads <- *upload
+	return nil
+}
+
+// connection returns the stored connection to provider
+func (s *fakeIntegrationStore) connection(t *testing.T, provider string) *models.Connection {
+	t.Helper()
+
+	conn, err := s.Connection(context.Background(), "", provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// finished waits for an upload to be saved with its outcome
+func (s *fakeIntegrationStore) finished(t *testing.T) models.Upload {
+	t.Helper()
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case upload := <-s.uploads:
+			if upload.Status == models.UploadComplete || upload.Status == models.UploadFailed {
+				return upload
+			}
+		case <-timeout:
+			t.Fatal("upload never finished")
+		}
+	}
+}
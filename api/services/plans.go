@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// PlanService stores users' training plans
+type PlanService struct {
+	client *firestore.Client
+}
+
+// NewPlanService creates a new plan service
+func NewPlanService(client *firestore.Client) *PlanService {
+	return &PlanService{client: client}
+}
+
+// collection returns the collection holding a user's plans
+func (s *PlanService) collection(uid string) *firestore.CollectionRef {
+	return userDoc(s.client, uid).Collection("plans")
+}
+
+// List returns a user's plans sorted by name
+func (s *PlanService) List(ctx context.Context, uid string) ([]*models.Plan, error) {
+	snaps, err := s.collection(uid).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing plans: %w", err)
+	}
+
+	plans := make([]*models.Plan, 0, len(snaps))
+	for _, snap := range snaps {
+		plan, err := decodePlan(snap)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+
+	sort.Slice(plans, func(i, j int) bool {
+		return strings.ToLower(plans[i].Name) < strings.ToLower(plans[j].Name)
+	})
+	return plans, nil
+}
+
+// Get returns a single plan
+func (s *PlanService) Get(ctx context.Context, uid, id string) (*models.Plan, error) {
+	snap, err := s.collection(uid).Doc(id).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching plan: %w", err)
+	}
+	return decodePlan(snap)
+}
+
+// Create stores a new plan and sets its ID
+func (s *PlanService) Create(ctx context.Context, uid string, plan *models.Plan) error {
+	plan.CreatedAt = time.Now().UTC()
+	plan.UpdatedAt = plan.CreatedAt
+
+	ref := s.collection(uid).NewDoc()
+	if _, err := ref.Create(ctx, plan); err != nil {
+		return fmt.Errorf("error saving plan: %w", err)
+	}
+
+	plan.ID = ref.ID
+	return nil
+}
+
+// Update replaces an existing plan, keeping its creation time
+func (s *PlanService) Update(ctx context.Context, uid, id string, plan *models.Plan) error {
+	ref := s.collection(uid).Doc(id)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching plan: %w", err)
+		}
+
+		current, err := decodePlan(snap)
+		if err != nil {
+			return err
+		}
+
+		plan.ID = id
+		plan.CreatedAt = current.CreatedAt
+		plan.UpdatedAt = time.Now().UTC()
+		return tx.Set(ref, plan)
+	})
+}
+
+// Delete removes a plan
+func (s *PlanService) Delete(ctx context.Context, uid, id string) error {
+	ref := s.collection(uid).Doc(id)
+	if _, err := ref.Delete(ctx, firestore.Exists); err != nil {
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error deleting plan: %w", err)
+	}
+	return nil
+}
+
+// decodePlan reads a plan document
+func decodePlan(snap *firestore.DocumentSnapshot) (*models.Plan, error) {
+	var plan models.Plan
+	if err := snap.DataTo(&plan); err != nil {
+		return nil, fmt.Errorf("error decoding plan %s: %w", snap.Ref.ID, err)
+	}
+	plan.ID = snap.Ref.ID
+	return &plan, nil
+}
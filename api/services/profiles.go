@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// ProfileService stores user profiles on each user's document
+type ProfileService struct {
+	client *firestore.Client
+}
+
+// NewProfileService creates a new profile service
+func NewProfileService(client *firestore.Client) *ProfileService {
+	return &ProfileService{client: client}
+}
+
+// Get returns a user's profile, or ErrNotFound if they have none yet
+func (s *ProfileService) Get(ctx context.Context, uid string) (*models.Profile, error) {
+	snap, err := userDoc(s.client, uid).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching profile: %w", err)
+	}
+	return decodeProfile(snap)
+}
+
+// Ensure returns a user's profile, creating it with newProfile if they
+// have none. created reports whether it was created.
+func (s *ProfileService) Ensure(ctx context.Context, uid string, newProfile func() (*models.Profile, error)) (profile *models.Profile, created bool, err error) {
+	profile, err = s.Get(ctx, uid)
+	if err != ErrNotFound {
+		return profile, false, err
+	}
+
+	profile, err = newProfile()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, false, fmt.Errorf("invalid new profile: %w", err)
+	}
+	profile.CreatedAt = time.Now().UTC()
+	profile.UpdatedAt = profile.CreatedAt
+
+	if _, err := userDoc(s.client, uid).Create(ctx, profile); err != nil {
+		// Another request may have created it first
+		if existing, getErr := s.Get(ctx, uid); getErr == nil {
+			return existing, false, nil
+		}
+		return nil, false, fmt.Errorf("error saving profile: %w", err)
+	}
+	return profile, true, nil
+}
+
+// Put replaces a user's editable profile fields. The email address and
+// photo come from the sign-in provider and are kept.
+func (s *ProfileService) Put(ctx context.Context, uid string, profile *models.Profile) error {
+	ref := userDoc(s.client, uid)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching profile: %w", err)
+		}
+
+		current, err := decodeProfile(snap)
+		if err != nil {
+			return err
+		}
+
+		profile.Email = current.Email
+		profile.PhotoURL = current.PhotoURL
+		profile.CreatedAt = current.CreatedAt
+		profile.UpdatedAt = time.Now().UTC()
+		return tx.Set(ref, profile)
+	})
+}
+
+// decodeProfile reads a profile from a user's document
+func decodeProfile(snap *firestore.DocumentSnapshot) (*models.Profile, error) {
+	var profile models.Profile
+	if err := snap.DataTo(&profile); err != nil {
+		return nil, fmt.Errorf("error decoding profile: %w", err)
+	}
+	return &profile, nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// RecordService keeps users' personal bests
+type RecordService struct {
+	client *firestore.Client
+}
+
+// NewRecordService creates a new record service
+func NewRecordService(client *firestore.Client) *RecordService {
+	return &RecordService{client: client}
+}
+
+// collection returns the collection holding a user's records, one
+// document per event
+func (s *RecordService) collection(uid string) *firestore.CollectionRef {
+	return userDoc(s.client, uid).Collection("records")
+}
+
+// List returns a user's records in event order
+func (s *RecordService) List(ctx context.Context, uid string) ([]*models.Record, error) {
+	snaps, err := s.collection(uid).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing records: %w", err)
+	}
+
+	byEvent := make(map[string]*models.Record, len(snaps))
+	for _, snap := range snaps {
+		var record models.Record
+		if err := snap.DataTo(&record); err != nil {
+			return nil, fmt.Errorf("error decoding record %s: %w", snap.Ref.ID, err)
+		}
+		byEvent[record.Event] = &record
+	}
+
+	records := []*models.Record{}
+	for _, event := range models.Events {
+		if record, ok := byEvent[event.Name]; ok {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// Update records any of the workout's best efforts that beat the user's
+// current records, returning the new records
+func (s *RecordService) Update(ctx context.Context, uid string, workout *models.Workout) ([]*models.Record, error) {
+	var set []*models.Record
+	if len(workout.BestEfforts) == 0 {
+		return set, nil
+	}
+
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		set = nil
+
+		refs := make([]*firestore.DocumentRef, len(workout.BestEfforts))
+		for i, effort := range workout.BestEfforts {
+			refs[i] = s.collection(uid).Doc(effort.Event)
+		}
+
+		snaps, err := tx.GetAll(refs)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for i, effort := range workout.BestEfforts {
+			var previous *models.Effort
+			if snaps[i].Exists() {
+				var current models.Record
+				if err := snaps[i].DataTo(&current); err != nil {
+					return err
+				}
+				previous = &current.Effort
+			}
+
+			if !effort.Beats(previous) {
+				continue
+			}
+
+			record := &models.Record{
+				Effort:    effort,
+				WorkoutID: workout.ID,
+				RowedAt:   workout.StartedAt,
+				SetAt:     now,
+				Previous:  previous,
+			}
+			if err := tx.Set(refs[i], record); err != nil {
+				return err
+			}
+			set = append(set, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating records: %w", err)
+	}
+
+	return set, nil
+}
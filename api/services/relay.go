@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// relayKeyPrefix starts every relay key, telling them apart from other
+// tokens
+const relayKeyPrefix = "relay_"
+
+// RelayService stores the keys socket servers publish their live streams
+// with. Keys are stored by their hash, so a key can't be recovered after
+// it's created.
+type RelayService struct {
+	client *firestore.Client
+}
+
+// NewRelayService creates a new relay service
+func NewRelayService(client *firestore.Client) *RelayService {
+	return &RelayService{client: client}
+}
+
+// collection returns the collection holding every user's relay keys
+func (s *RelayService) collection() *firestore.CollectionRef {
+	return s.client.Collection("relay_keys")
+}
+
+// List returns a user's relay keys, newest first, without the keys
+// themselves
+func (s *RelayService) List(ctx context.Context, uid string) ([]*models.RelayKey, error) {
+	snaps, err := s.collection().Where("user_id", "==", uid).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing relay keys: %w", err)
+	}
+
+	keys := make([]*models.RelayKey, 0, len(snaps))
+	for _, snap := range snaps {
+		key, err := decodeRelayKey(snap)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// Create stores a new relay key for a user, setting its ID and the key
+func (s *RelayService) Create(ctx context.Context, uid string, key *models.RelayKey) error {
+	token, err := newShareToken()
+	if err != nil {
+		return err
+	}
+	secret := relayKeyPrefix + token
+
+	key.UserID = uid
+	key.CreatedAt = time.Now().UTC()
+	key.LastConnectedAt = nil
+
+	id := relayKeyID(secret)
+	if _, err := s.collection().Doc(id).Create(ctx, key); err != nil {
+		return fmt.Errorf("error saving relay key: %w", err)
+	}
+
+	key.ID = id
+	key.Key = secret
+	return nil
+}
+
+// Delete removes one of a user's relay keys
+func (s *RelayService) Delete(ctx context.Context, uid, id string) error {
+	ref := s.collection().Doc(id)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching relay key: %w", err)
+		}
+
+		key, err := decodeRelayKey(snap)
+		if err != nil {
+			return err
+		}
+		if key.UserID != uid {
+			return ErrNotFound
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// Lookup returns the relay key for a key presented by a socket server. It
+// returns ErrNotFound for an unknown or deleted key.
+func (s *RelayService) Lookup(ctx context.Context, secret string) (*models.RelayKey, error) {
+	if !strings.HasPrefix(secret, relayKeyPrefix) {
+		return nil, ErrNotFound
+	}
+
+	snap, err := s.collection().Doc(relayKeyID(secret)).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching relay key: %w", err)
+	}
+	return decodeRelayKey(snap)
+}
+
+// Authenticate returns the relay key for a key presented by a socket
+// server, recording that it connected. It returns ErrNotFound for an
+// unknown or deleted key.
+func (s *RelayService) Authenticate(ctx context.Context, secret string) (*models.RelayKey, error) {
+	key, err := s.Lookup(ctx, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	update := []firestore.Update{{Path: "last_connected_at", Value: now}}
+	if _, err := s.collection().Doc(key.ID).Update(ctx, update); err != nil {
+		return nil, fmt.Errorf("error updating relay key: %w", err)
+	}
+	key.LastConnectedAt = &now
+	return key, nil
+}
+
+// relayKeyID returns the ID a relay key is stored under, the hash of the
+// key
+func relayKeyID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:16])
+}
+
+// decodeRelayKey reads a relay key document
+func decodeRelayKey(snap *firestore.DocumentSnapshot) (*models.RelayKey, error) {
+	var key models.RelayKey
+	if err := snap.DataTo(&key); err != nil {
+		return nil, fmt.Errorf("error decoding relay key: %w", err)
+	}
+	key.ID = snap.Ref.ID
+	return &key, nil
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// relayViewerBufferSize is the queue size for each viewer of a relayed
+// stream
+const relayViewerBufferSize = 64
+
+// RelayEvent is sent to the viewers of a user's relayed stream
+type RelayEvent struct {
+	Type    string          // models.RelayEventSources or models.RelayEventMessage
+	Sources []string        // names of the connected socket servers, for sources events
+	Source  string          // the socket server Message came from
+	Message json.RawMessage // a message from the socket server's hub
+}
+
+// RelayHub fans the live streams socket servers publish out to their
+// users' viewers. Streams are held in memory, so publishers and viewers
+// must reach the same API instance.
+type RelayHub struct {
+	mu      sync.Mutex
+	streams map[string]*relayStream // by user
+	closed  bool
+}
+
+// relayStream is one user's publishers and viewers
+type relayStream struct {
+	sources map[*RelaySource]struct{}
+	viewers map[chan RelayEvent]struct{}
+}
+
+// RelaySource is a socket server publishing a user's live stream
+type RelaySource struct {
+	hub   *RelayHub
+	uid   string
+	keyID string
+	name  string
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// NewRelayHub creates a hub with no streams
+func NewRelayHub() *RelayHub {
+	return &RelayHub{streams: make(map[string]*relayStream)}
+}
+
+// stream returns a user's stream, creating it if needed. Must be called
+// with mu held.
+func (h *RelayHub) stream(uid string) *relayStream {
+	stream, ok := h.streams[uid]
+	if !ok {
+		stream = &relayStream{
+			sources: make(map[*RelaySource]struct{}),
+			viewers: make(map[chan RelayEvent]struct{}),
+		}
+		h.streams[uid] = stream
+	}
+	return stream
+}
+
+// release forgets a user's stream once nothing is using it. Must be
+// called with mu held.
+func (h *RelayHub) release(uid string) {
+	if stream, ok := h.streams[uid]; ok && len(stream.sources) == 0 && len(stream.viewers) == 0 {
+		delete(h.streams, uid)
+	}
+}
+
+// Join adds a socket server publishing with a user's key under name,
+// telling their viewers. It returns nil once the hub has shut down.
+func (h *RelayHub) Join(uid, keyID, name string) *RelaySource {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil
+	}
+
+	source := &RelaySource{hub: h, uid: uid, keyID: keyID, name: name, done: make(chan struct{})}
+	stream := h.stream(uid)
+	stream.sources[source] = struct{}{}
+	stream.send(stream.sourcesEvent())
+	return source
+}
+
+// Watch returns a channel receiving a user's relayed stream, starting with
+// the socket servers connected, and a function to stop watching. The
+// channel is closed when the hub shuts down. Slow viewers miss messages
+// rather than holding up the stream.
+func (h *RelayHub) Watch(uid string) (<-chan RelayEvent, func()) {
+	ch := make(chan RelayEvent, relayViewerBufferSize)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	stream := h.stream(uid)
+	stream.viewers[ch] = struct{}{}
+	ch <- stream.sourcesEvent()
+
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if stream, ok := h.streams[uid]; ok {
+			if _, ok := stream.viewers[ch]; ok {
+				delete(stream.viewers, ch)
+				close(ch)
+				h.release(uid)
+			}
+		}
+	}
+	return ch, cancel
+}
+
+// Connected returns the IDs of the keys socket servers are publishing
+// with for a user
+func (h *RelayHub) Connected(uid string) map[string]bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	connected := make(map[string]bool)
+	if stream, ok := h.streams[uid]; ok {
+		for source := range stream.sources {
+			connected[source.keyID] = true
+		}
+	}
+	return connected
+}
+
+// Disconnect ends the publishing of every socket server using a key, as
+// when it's deleted
+func (h *RelayHub) Disconnect(keyID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, stream := range h.streams {
+		for source := range stream.sources {
+			if source.keyID == keyID {
+				source.stop()
+			}
+		}
+	}
+}
+
+// Shutdown ends every publisher's connection and closes every viewer's
+// channel
+func (h *RelayHub) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for uid, stream := range h.streams {
+		for source := range stream.sources {
+			source.stop()
+		}
+		for ch := range stream.viewers {
+			close(ch)
+		}
+		delete(h.streams, uid)
+	}
+}
+
+// Publish relays a message from the socket server to the user's viewers
+func (s *RelaySource) Publish(message json.RawMessage) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if stream, ok := s.hub.streams[s.uid]; ok {
+		stream.send(RelayEvent{Type: models.RelayEventMessage, Source: s.name, Message: message})
+	}
+}
+
+// Leave removes the socket server from the user's stream, telling their
+// viewers
+func (s *RelaySource) Leave() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	stream, ok := s.hub.streams[s.uid]
+	if !ok {
+		return
+	}
+	if _, ok := stream.sources[s]; ok {
+		delete(stream.sources, s)
+		stream.send(stream.sourcesEvent())
+		s.hub.release(s.uid)
+	}
+}
+
+// Done is closed when the hub ends the socket server's publishing
+func (s *RelaySource) Done() <-chan struct{} {
+	return s.done
+}
+
+// stop signals the publisher to disconnect
+func (s *RelaySource) stop() {
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// send delivers an event to every viewer, dropping it for any that are
+// behind
+func (st *relayStream) send(event RelayEvent) {
+	for ch := range st.viewers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// sourcesEvent lists the socket servers publishing the stream
+func (st *relayStream) sourcesEvent() RelayEvent {
+	names := make([]string, 0, len(st.sources))
+	for source := range st.sources {
+		names = append(names, source.name)
+	}
+	sort.Strings(names)
+	return RelayEvent{Type: models.RelayEventSources, Sources: names}
+}
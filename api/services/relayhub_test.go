@@ -0,0 +1,166 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// nextEvent returns the next event a viewer receives
+func nextEvent(t *testing.T, events <-chan RelayEvent) RelayEvent {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("viewer's channel closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("viewer received nothing")
+	}
+	return RelayEvent{}
+}
+
+// noEvent checks a viewer has nothing waiting
+func noEvent(t *testing.T, events <-chan RelayEvent) {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		t.Errorf("viewer received %+v", event)
+	default:
+	}
+}
+
+// wantSources checks an event lists the sources connected
+func wantSources(t *testing.T, event RelayEvent, sources ...string) {
+	t.Helper()
+
+	if event.Type != models.RelayEventSources || strings.Join(event.Sources, ",") != strings.Join(sources, ",") {
+		t.Errorf("received %+v, want a sources event listing %q", event, sources)
+	}
+}
+
+func TestRelayHubFansOut(t *testing.T) {
+	h := NewRelayHub()
+	defer h.Shutdown()
+
+	first, cancelFirst := h.Watch("athlete")
+	defer cancelFirst()
+	second, cancelSecond := h.Watch("athlete")
+	defer cancelSecond()
+	other, cancelOther := h.Watch("someone-else")
+	defer cancelOther()
+
+	// Each stream starts with the sources connected
+	for _, events := range []<-chan RelayEvent{first, second, other} {
+		wantSources(t, nextEvent(t, events))
+	}
+
+	garage := h.Join("athlete", "key-1", "Garage erg")
+	club := h.Join("athlete", "key-2", "Club erg")
+	for _, events := range []<-chan RelayEvent{first, second} {
+		wantSources(t, nextEvent(t, events), "Garage erg")
+		wantSources(t, nextEvent(t, events), "Club erg", "Garage erg")
+	}
+
+	message := json.RawMessage(`{"type":"workout_stats"}`)
+	garage.Publish(message)
+	for _, events := range []<-chan RelayEvent{first, second} {
+		event := nextEvent(t, events)
+		if event.Type != models.RelayEventMessage || event.Source != "Garage erg" || string(event.Message) != string(message) {
+			t.Errorf("received %+v, want the garage erg's message", event)
+		}
+	}
+
+	// Other users' viewers see none of it
+	noEvent(t, other)
+
+	if connected := h.Connected("athlete"); !reflect.DeepEqual(connected, map[string]bool{"key-1": true, "key-2": true}) {
+		t.Errorf("Connected returned %v", connected)
+	}
+	club.Leave()
+	garage.Leave()
+}
+
+func TestRelayHubRemovesViewersAndSources(t *testing.T) {
+	h := NewRelayHub()
+	defer h.Shutdown()
+
+	leaving, cancelLeaving := h.Watch("athlete")
+	staying, cancelStaying := h.Watch("athlete")
+	nextEvent(t, leaving)
+	nextEvent(t, staying)
+
+	// A viewer that stops watching has its channel closed and hears
+	// nothing more
+	cancelLeaving()
+	cancelLeaving()
+	if _, ok := <-leaving; ok {
+		t.Error("cancelled viewer's channel still open")
+	}
+
+	garage := h.Join("athlete", "key-1", "Garage erg")
+	club := h.Join("athlete", "key-2", "Club erg")
+	nextEvent(t, staying)
+	nextEvent(t, staying)
+
+	// Sources that leave are dropped from the list
+	club.Leave()
+	wantSources(t, nextEvent(t, staying), "Garage erg")
+
+	// Deleting a key ends its publishing, while others carry on
+	h.Disconnect("key-1")
+	select {
+	case <-garage.Done():
+	default:
+		t.Error("source publishing with a deleted key wasn't stopped")
+	}
+	garage.Leave()
+	wantSources(t, nextEvent(t, staying))
+
+	// The stream is forgotten once nothing uses it
+	cancelStaying()
+	h.mu.Lock()
+	streams := len(h.streams)
+	h.mu.Unlock()
+	if streams != 0 {
+		t.Errorf("%d streams held after every viewer and source left", streams)
+	}
+}
+
+func TestRelayHubShutdown(t *testing.T) {
+	h := NewRelayHub()
+
+	events, cancel := h.Watch("athlete")
+	defer cancel()
+	nextEvent(t, events)
+	source := h.Join("athlete", "key-1", "Garage erg")
+	nextEvent(t, events)
+
+	h.Shutdown()
+
+	if _, ok := <-events; ok {
+		t.Error("viewer's channel still open after shutdown")
+	}
+	select {
+	case <-source.Done():
+	default:
+		t.Error("source wasn't stopped by shutdown")
+	}
+	source.Leave()
+
+	if h.Join("athlete", "key-1", "Garage erg") != nil {
+		t.Error("Join accepted a source after shutdown")
+	}
+	late, cancelLate := h.Watch("athlete")
+	defer cancelLate()
+	if _, ok := <-late; ok {
+		t.Error("Watch after shutdown returned an open channel")
+	}
+}
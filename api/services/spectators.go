@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// SpectatorService stores the links users share their live stats by
+type SpectatorService struct {
+	client *firestore.Client
+}
+
+// NewSpectatorService creates a new spectator service
+func NewSpectatorService(client *firestore.Client) *SpectatorService {
+	return &SpectatorService{client: client}
+}
+
+// collection returns the collection holding every user's shares, keyed by
+// token
+func (s *SpectatorService) collection() *firestore.CollectionRef {
+	return s.client.Collection("spectator_shares")
+}
+
+// List returns a user's unexpired shares, newest first
+func (s *SpectatorService) List(ctx context.Context, uid string) ([]*models.SpectatorShare, error) {
+	snaps, err := s.collection().Where("user_id", "==", uid).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing spectator shares: %w", err)
+	}
+
+	now := time.Now()
+	shares := make([]*models.SpectatorShare, 0, len(snaps))
+	for _, snap := range snaps {
+		share, err := decodeSpectatorShare(snap)
+		if err != nil {
+			return nil, err
+		}
+		if share.Active(now) {
+			shares = append(shares, share)
+		}
+	}
+
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].CreatedAt.After(shares[j].CreatedAt)
+	})
+	return shares, nil
+}
+
+// Create stores a new share for a user and sets its token
+func (s *SpectatorService) Create(ctx context.Context, uid string, share *models.SpectatorShare) error {
+	token, err := newShareToken()
+	if err != nil {
+		return err
+	}
+
+	share.UserID = uid
+	share.CreatedAt = time.Now().UTC()
+	share.ExpiresAt = share.ExpiresAt.UTC()
+	if _, err := s.collection().Doc(token).Create(ctx, share); err != nil {
+		return fmt.Errorf("error saving spectator share: %w", err)
+	}
+
+	share.Token = token
+	return nil
+}
+
+// Revoke deletes one of a user's shares. Socket servers disconnect its
+// spectators when they next check it.
+func (s *SpectatorService) Revoke(ctx context.Context, uid, token string) error {
+	ref := s.collection().Doc(token)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching spectator share: %w", err)
+		}
+
+		share, err := decodeSpectatorShare(snap)
+		if err != nil {
+			return err
+		}
+		if share.UserID != uid {
+			return ErrNotFound
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// Resolve returns the share a token opens on a socket server of the user
+// uid. It returns ErrNotFound for an unknown or revoked token, or one
+// another user minted, and ErrExpired for an expired one.
+func (s *SpectatorService) Resolve(ctx context.Context, token, uid string) (*models.SpectatorShare, error) {
+	snap, err := s.collection().Doc(token).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching spectator share: %w", err)
+	}
+
+	share, err := decodeSpectatorShare(snap)
+	if err != nil {
+		return nil, err
+	}
+	if share.UserID != uid {
+		return nil, ErrNotFound
+	}
+	if !share.Active(time.Now()) {
+		return nil, ErrExpired
+	}
+	return share, nil
+}
+
+// decodeSpectatorShare reads a spectator share document
+func decodeSpectatorShare(snap *firestore.DocumentSnapshot) (*models.SpectatorShare, error) {
+	var share models.SpectatorShare
+	if err := snap.DataTo(&share); err != nil {
+		return nil, fmt.Errorf("error decoding spectator share: %w", err)
+	}
+	share.Token = snap.Ref.ID
+	return &share, nil
+}
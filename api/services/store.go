@@ -0,0 +1,22 @@
+package services
+
+import (
+	"errors"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNotFound is returned when a requested document doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// userDoc returns the Firestore document holding a user's data
+func userDoc(client *firestore.Client, uid string) *firestore.DocumentRef {
+	return client.Collection("users").Doc(uid)
+}
+
+// isNotFound reports whether a Firestore error means the document is missing
+func isNotFound(err error) bool {
+	return status.Code(err) == codes.NotFound
+}
@@ -0,0 +1,418 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+const (
+	// stravaScope is the access requested: activity:write to upload and
+	// read to identify the athlete
+	stravaScope = "read,activity:write"
+
+	// stravaTimeout bounds a single request to Strava
+	stravaTimeout = 30 * time.Second
+
+	// stravaUploads is the number of uploads sent at once
+	stravaUploads = 4
+
+	// stravaPollInterval is the first wait for Strava to process an
+	// upload, doubling up to stravaMaxPollInterval
+	stravaPollInterval    = 2 * time.Second
+	stravaMaxPollInterval = 30 * time.Second
+
+	// stravaProcessingTimeout bounds the wait for Strava to process an
+	// upload
+	stravaProcessingTimeout = 5 * time.Minute
+
+	// stravaSportType is the type uploaded workouts are given
+	stravaSportType = "VirtualRow"
+)
+
+// errStravaUnauthorized is returned when Strava rejects a user's tokens
+var errStravaUnauthorized = errors.New("Strava authorization was revoked; reconnect Strava to upload")
+
+// stravaAthlete is the athlete Strava returns with a new token
+type stravaAthlete struct {
+	Athlete *struct {
+		ID        int64  `json:"id"`
+		FirstName string `json:"firstname"`
+		LastName  string `json:"lastname"`
+	} `json:"athlete"`
+}
+
+// stravaUpload is Strava's status of an uploaded file
+type stravaUpload struct {
+	ID         int64  `json:"id"`
+	Status     string `json:"status"`
+	Error      string `json:"error"`
+	ActivityID int64  `json:"activity_id"`
+}
+
+// StravaService connects users' Strava accounts and uploads their
+// workouts as indoor rowing activities in the background
+type StravaService struct {
+	integrations integrationStore
+	samples      *InfluxDBService // nil when InfluxDB isn't configured
+	oauth        *oauthClient
+	pollInterval time.Duration // stravaPollInterval outside tests
+
+	baseURL     string
+	redirectURL string
+
+	jobs *jobs
+}
+
+// NewStravaService creates a Strava service for an API application.
+// baseURL is normally https://www.strava.com; samples may be nil.
+func NewStravaService(integrations *IntegrationService, samples *InfluxDBService, baseURL, clientID, clientSecret, redirectURL string) *StravaService {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return &StravaService{
+		integrations: integrations,
+		samples:      samples,
+		oauth: &oauthClient{
+			integrations: integrations,
+			client:       &http.Client{Timeout: stravaTimeout},
+			provider:     models.ProviderStrava,
+			name:         "Strava",
+			tokenURL:     baseURL + "/oauth/token",
+			apiURL:       baseURL + "/api/v3",
+			clientID:     clientID,
+			clientSecret: clientSecret,
+			maxResponse:  1 << 20,
+			errorMessage: stravaErrorMessage,
+			refreshHint:  "refresh_token",
+			unauthorized: errStravaUnauthorized,
+		},
+		pollInterval: stravaPollInterval,
+		baseURL:      baseURL,
+		redirectURL:  redirectURL,
+		jobs:         newJobs(stravaUploads),
+	}
+}
+
+// AuthorizeURL starts connecting a user's account, returning the Strava
+// page to send them to
+func (s *StravaService) AuthorizeURL(ctx context.Context, uid string) (string, error) {
+	state, err := s.integrations.NewState(ctx, uid, models.ProviderStrava)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"client_id":       {s.oauth.clientID},
+		"redirect_uri":    {s.redirectURL},
+		"response_type":   {"code"},
+		"approval_prompt": {"auto"},
+		"scope":           {stravaScope},
+		"state":           {state},
+	}
+	return s.baseURL + "/oauth/authorize?" + query.Encode(), nil
+}
+
+// Connect finishes connecting a user's account with the code, state and
+// granted scope Strava redirected back with. It returns ErrNotGranted if
+// the user didn't allow uploads.
+func (s *StravaService) Connect(ctx context.Context, uid, state, code, scope string) (*models.Connection, error) {
+	if err := s.integrations.ConsumeState(ctx, uid, models.ProviderStrava, state); err != nil {
+		return nil, err
+	}
+	if !hasScope(scope, "activity:write") {
+		return nil, ErrNotGranted
+	}
+
+	var athlete stravaAthlete
+	token, err := s.oauth.token(ctx, url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {code},
+	}, &athlete)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &models.Connection{
+		Provider:     models.ProviderStrava,
+		Scope:        scope,
+		AutoUpload:   true,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    token.expiry(),
+		ConnectedAt:  time.Now().UTC(),
+	}
+	if a := athlete.Athlete; a != nil {
+		conn.AthleteID = strconv.FormatInt(a.ID, 10)
+		conn.AthleteName = strings.TrimSpace(a.FirstName + " " + a.LastName)
+	}
+
+	if err := s.integrations.SaveConnection(ctx, uid, conn); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Disconnect revokes the app's access to a user's account and forgets
+// their tokens. A failure to revoke is logged; the tokens are deleted
+// regardless.
+func (s *StravaService) Disconnect(ctx context.Context, uid string) error {
+	conn, err := s.integrations.Connection(ctx, uid, models.ProviderStrava)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{"access_token": {conn.AccessToken}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/oauth/deauthorize", strings.NewReader(form.Encode()))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		err = s.oauth.do(req, nil)
+	}
+	if err != nil {
+		log.Printf("Failed to deauthorize Strava for %s: %v", uid, err)
+	}
+
+	return s.integrations.DeleteConnection(ctx, uid, models.ProviderStrava)
+}
+
+// SetAutoUpload turns uploading each saved workout on or off
+func (s *StravaService) SetAutoUpload(ctx context.Context, uid string, enabled bool) (*models.Connection, error) {
+	err := s.integrations.UpdateConnection(ctx, uid, models.ProviderStrava, []firestore.Update{
+		{Path: "auto_upload", Value: enabled},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.integrations.Connection(ctx, uid, models.ProviderStrava)
+}
+
+// AutoUpload uploads a newly saved workout if the user has Strava
+// connected with auto upload on. Failures are logged and recorded on the
+// upload.
+func (s *StravaService) AutoUpload(ctx context.Context, uid string, workout *models.Workout) {
+	conn, err := s.integrations.Connection(ctx, uid, models.ProviderStrava)
+	if errors.Is(err, ErrNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get Strava connection for %s: %v", uid, err)
+		return
+	}
+	if !conn.AutoUpload || conn.Error != "" {
+		return
+	}
+
+	if _, err := s.Upload(ctx, uid, workout); err != nil {
+		log.Printf("Failed to queue Strava upload of workout %s: %v", workout.ID, err)
+	}
+}
+
+// Upload queues a workout to be uploaded, returning its pending status.
+// Workouts without samples have them loaded from InfluxDB if it's
+// configured.
+func (s *StravaService) Upload(ctx context.Context, uid string, workout *models.Workout) (*models.Upload, error) {
+	upload, err := s.integrations.StartUpload(ctx, uid, workout.ID, models.ProviderStrava)
+	if err != nil {
+		return nil, err
+	}
+
+	queued := *upload
+	s.jobs.run(func(ctx context.Context) {
+		s.send(ctx, uid, workout, &queued)
+	})
+
+	return upload, nil
+}
+
+// Shutdown stops uploads in progress, waiting for them to record their
+// status. Uploads left pending can be retried.
+func (s *StravaService) Shutdown(ctx context.Context) error {
+	return s.jobs.shutdown(ctx)
+}
+
+// send uploads a workout and waits for Strava to process it, recording
+// the outcome
+func (s *StravaService) send(ctx context.Context, uid string, workout *models.Workout, upload *models.Upload) {
+	err := s.upload(ctx, uid, workout, upload)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err != nil {
+		upload.Status = models.UploadFailed
+		upload.Error = err.Error()
+		log.Printf("Strava upload of workout %s failed: %v", workout.ID, err)
+	}
+
+	// Record the outcome even if the server is stopping
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), stravaTimeout)
+	defer cancel()
+	if err := s.integrations.SaveUpload(saveCtx, uid, upload); err != nil {
+		log.Printf("Failed to record Strava upload of workout %s: %v", workout.ID, err)
+	}
+}
+
+// upload sends a workout's TCX file, polls until Strava has made it an
+// activity and marks it as indoor rowing
+func (s *StravaService) upload(ctx context.Context, uid string, workout *models.Workout, upload *models.Upload) error {
+	if len(workout.Samples) < 2 && s.samples != nil {
+		samples, err := s.samples.ReadSamples(ctx, uid, workout)
+		if err != nil {
+			log.Printf("Failed to read samples for workout %s, uploading summary only: %v", workout.ID, err)
+		}
+		copied := *workout
+		copied.Samples = samples
+		workout = &copied
+	}
+
+	file, err := workout.TCX()
+	if err != nil {
+		return fmt.Errorf("error encoding workout: %w", err)
+	}
+
+	conn, err := s.oauth.accessToken(ctx, uid)
+	if err != nil {
+		return err
+	}
+	token := conn.AccessToken
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for field, value := range map[string]string{
+		"data_type":   "tcx",
+		"external_id": "ergometer-" + workout.ID + ".tcx",
+		"name":        activityName(workout),
+		"description": activityDescription(workout),
+		"trainer":     "1",
+	} {
+		if err := form.WriteField(field, value); err != nil {
+			return err
+		}
+	}
+	part, err := form.CreateFormFile("file", workout.ID+".tcx")
+	if err != nil {
+		return err
+	}
+	part.Write(file)
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	req, err := s.oauth.apiRequest(ctx, http.MethodPost, "/uploads", token, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	var status stravaUpload
+	if err := s.oauth.do(req, &status); err != nil {
+		return s.oauth.checkAuth(ctx, uid, err)
+	}
+
+	upload.Status = models.UploadProcessing
+	upload.UploadID = strconv.FormatInt(status.ID, 10)
+	if err := s.integrations.SaveUpload(ctx, uid, upload); err != nil {
+		log.Printf("Failed to record Strava upload of workout %s: %v", workout.ID, err)
+	}
+
+	deadline := time.Now().Add(stravaProcessingTimeout)
+	for wait := s.pollInterval; status.Error == "" && status.ActivityID == 0; wait = min(wait*2, stravaMaxPollInterval) {
+		if time.Now().After(deadline) {
+			return errors.New("Strava did not finish processing the upload; check Strava before retrying")
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		req, err := s.oauth.apiRequest(ctx, http.MethodGet, "/uploads/"+upload.UploadID, token, nil)
+		if err != nil {
+			return err
+		}
+		if err := s.oauth.do(req, &status); err != nil {
+			return s.oauth.checkAuth(ctx, uid, err)
+		}
+	}
+	if status.Error != "" {
+		return errors.New(status.Error)
+	}
+
+	upload.Status = models.UploadComplete
+	upload.ActivityID = strconv.FormatInt(status.ActivityID, 10)
+	upload.URL = s.baseURL + "/activities/" + upload.ActivityID
+
+	// The file format has no indoor rowing sport, so set it afterwards.
+	// The activity exists either way.
+	update, _ := json.Marshal(map[string]interface{}{"sport_type": stravaSportType, "trainer": true})
+	req, err = s.oauth.apiRequest(ctx, http.MethodPut, "/activities/"+upload.ActivityID, token, bytes.NewReader(update))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		err = s.oauth.do(req, nil)
+	}
+	if err != nil {
+		log.Printf("Failed to set sport type of Strava activity %s: %v", upload.ActivityID, err)
+	}
+	return nil
+}
+
+// stravaErrorMessage returns the message in a Strava error response,
+// with the fields it names
+func stravaErrorMessage(body []byte) string {
+	var detail struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Resource string `json:"resource"`
+			Field    string `json:"field"`
+			Code     string `json:"code"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &detail) != nil || detail.Message == "" {
+		return ""
+	}
+	message := detail.Message
+	for _, e := range detail.Errors {
+		message += fmt.Sprintf(" (%s %s %s)", e.Resource, e.Field, e.Code)
+	}
+	return message
+}
+
+// hasScope reports whether a comma-separated scope list includes want
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Split(scope, ",") {
+		if strings.TrimSpace(s) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// activityName titles an uploaded workout
+func activityName(w *models.Workout) string {
+	return fmt.Sprintf("%.0fm indoor row", w.Distance)
+}
+
+// activityDescription summarizes an uploaded workout
+func activityDescription(w *models.Workout) string {
+	var b strings.Builder
+	if w.AvgPace > 0 {
+		pace := int(w.AvgPace * 10)
+		fmt.Fprintf(&b, "Avg pace %d:%02d.%d/500m, ", pace/600, pace/10%60, pace%10)
+	}
+	fmt.Fprintf(&b, "%.0fW, %.0f spm", w.AvgPower, w.AvgStrokeRate)
+	if w.AvgHeartRate > 0 {
+		fmt.Fprintf(&b, ", %.0f bpm", w.AvgHeartRate)
+	}
+	b.WriteString("\nRecorded with Ergometer.Live")
+	return b.String()
+}
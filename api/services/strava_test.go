@@ -0,0 +1,335 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// stravaStandIn plays the parts of the Strava API uploads use
+type stravaStandIn struct {
+	t *testing.T
+
+	mu           sync.Mutex
+	accessToken  string // the token API calls must carry
+	refreshToken string // the token a refresh must present
+	refreshes    int
+	refreshError int    // status to refuse refreshes with, 0 to allow them
+	uploadError  int    // status to refuse uploads with, 0 to accept them
+	processing   int    // polls answered as still processing
+	failure      string // Strava's processing error, if any
+	polls        int
+	fields       map[string]string
+	file         []byte
+	update       map[string]interface{}
+}
+
+func newStravaStandIn(t *testing.T) (*stravaStandIn, *httptest.Server) {
+	t.Helper()
+
+	strava := &stravaStandIn{t: t, accessToken: "access-2", refreshToken: "refresh-1", processing: 1}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth/token", strava.token)
+	mux.HandleFunc("POST /api/v3/uploads", strava.upload)
+	mux.HandleFunc("GET /api/v3/uploads/{id}", strava.status)
+	mux.HandleFunc("PUT /api/v3/activities/{id}", strava.activity)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return strava, srv
+}
+
+func (s *stravaStandIn) token(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.FormValue("client_id") != "client" || r.FormValue("client_secret") != "secret" {
+		http.Error(w, `{"message":"Bad Request"}`, http.StatusBadRequest)
+		return
+	}
+	if s.refreshError != 0 {
+		w.WriteHeader(s.refreshError)
+		io.WriteString(w, `{"message":"Bad Request","errors":[{"resource":"RefreshToken","field":"refresh_token","code":"invalid"}]}`)
+		return
+	}
+	if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != s.refreshToken {
+		s.t.Errorf("unexpected token request %v", r.Form)
+		http.Error(w, `{"message":"Bad Request"}`, http.StatusBadRequest)
+		return
+	}
+
+	s.refreshes++
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  s.accessToken,
+		"refresh_token": "refresh-2",
+		"expires_at":    time.Now().Add(6 * time.Hour).Unix(),
+	})
+}
+
+// authorized checks a request carries the current access token
+func (s *stravaStandIn) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Authorization") != "Bearer "+s.accessToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"message":"Authorization Error","errors":[{"resource":"Athlete","field":"access_token","code":"invalid"}]}`)
+		return false
+	}
+	return true
+}
+
+func (s *stravaStandIn) upload(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorized(w, r) {
+		return
+	}
+	if s.uploadError != 0 {
+		w.WriteHeader(s.uploadError)
+		io.WriteString(w, `{"message":"Rate Limit Exceeded"}`)
+		return
+	}
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		s.t.Errorf("upload isn't a multipart form: %v", err)
+		return
+	}
+	s.fields = make(map[string]string)
+	for field, values := range r.MultipartForm.Value {
+		s.fields[field] = values[0]
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		s.t.Errorf("upload has no file: %v", err)
+		return
+	}
+	s.file, _ = io.ReadAll(file)
+
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, `{"id":77,"status":"Your activity is still being processed."}`)
+}
+
+func (s *stravaStandIn) status(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorized(w, r) {
+		return
+	}
+	if r.PathValue("id") != "77" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.polls++
+	switch {
+	case s.polls <= s.processing:
+		io.WriteString(w, `{"id":77,"status":"Your activity is still being processed."}`)
+	case s.failure != "":
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 77, "status": "There was an error processing your activity.", "error": s.failure})
+	default:
+		io.WriteString(w, `{"id":77,"status":"Your activity is ready.","activity_id":99}`)
+	}
+}
+
+func (s *stravaStandIn) activity(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorized(w, r) {
+		return
+	}
+	if r.PathValue("id") != "99" {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewDecoder(r.Body).Decode(&s.update)
+	io.WriteString(w, `{"id":99}`)
+}
+
+// newTestStrava creates a Strava service uploading to the stand-in for a
+// user whose access token has expired
+func newTestStrava(t *testing.T, srv *httptest.Server) (*StravaService, *fakeIntegrationStore) {
+	t.Helper()
+
+	store := newFakeIntegrationStore(&models.Connection{
+		Provider:     models.ProviderStrava,
+		AthleteID:    "1234",
+		AutoUpload:   true,
+		AccessToken:  "access-1",
+		RefreshToken: "refresh-1",
+		ExpiresAt:    time.Now().Add(-time.Hour),
+	})
+
+	s := NewStravaService(nil, nil, srv.URL+"/", "client", "secret", "https://ergometer.live/strava/callback")
+	s.integrations = store
+	s.oauth.integrations = store
+	s.pollInterval = 10 * time.Millisecond
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return s, store
+}
+
+func testStravaWorkout() *models.Workout {
+	return &models.Workout{
+		ID:            "20261018T071502Z-430123456",
+		StartedAt:     time.Date(2026, 10, 18, 7, 15, 2, 0, time.UTC),
+		Distance:      2000,
+		ElapsedTime:   412.3,
+		AvgPace:       103.1,
+		AvgPower:      320,
+		AvgStrokeRate: 31,
+		Samples: []models.WorkoutSample{
+			{Time: 0, Distance: 0},
+			{Time: 206, Distance: 1000, Power: 320, StrokeRate: 31},
+			{Time: 412.3, Distance: 2000, Power: 320, StrokeRate: 31},
+		},
+	}
+}
+
+func TestStravaUpload(t *testing.T) {
+	strava, srv := newStravaStandIn(t)
+	s, store := newTestStrava(t, srv)
+	strava.processing = 2
+
+	upload, err := s.Upload(context.Background(), "user-1", testStravaWorkout())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Status != models.UploadPending {
+		t.Fatalf("Upload returned status %s, want pending", upload.Status)
+	}
+
+	done := store.finished(t)
+	if done.Status != models.UploadComplete || done.Error != "" {
+		t.Fatalf("upload %+v, want complete", done)
+	}
+	if done.UploadID != "77" || done.ActivityID != "99" || done.URL != srv.URL+"/activities/99" {
+		t.Errorf("upload %+v doesn't link the activity", done)
+	}
+
+	strava.mu.Lock()
+	defer strava.mu.Unlock()
+
+	// The expired token was refreshed once and stored
+	if strava.refreshes != 1 {
+		t.Errorf("token refreshed %d times, want 1", strava.refreshes)
+	}
+	conn := store.connection(t, models.ProviderStrava)
+	if conn.AccessToken != "access-2" || conn.RefreshToken != "refresh-2" || conn.Expired(time.Now()) {
+		t.Errorf("stored connection %+v, want the refreshed tokens", conn)
+	}
+
+	// Strava was polled until it finished processing
+	if strava.polls != 3 {
+		t.Errorf("upload polled %d times, want 3", strava.polls)
+	}
+
+	if strava.fields["data_type"] != "tcx" || strava.fields["trainer"] != "1" ||
+		strava.fields["external_id"] != "ergometer-20261018T071502Z-430123456.tcx" ||
+		strava.fields["name"] != "2000m indoor row" {
+		t.Errorf("upload fields %v", strava.fields)
+	}
+	var file struct {
+		Distance float64 `xml:"Activities>Activity>Lap>DistanceMeters"`
+	}
+	if err := xml.Unmarshal(strava.file, &file); err != nil || file.Distance != 2000 {
+		t.Errorf("uploaded file isn't the workout's TCX: %v %.80s", err, strava.file)
+	}
+
+	if strava.update["sport_type"] != stravaSportType || strava.update["trainer"] != true {
+		t.Errorf("activity updated with %v", strava.update)
+	}
+}
+
+func TestStravaUploadProcessingError(t *testing.T) {
+	strava, srv := newStravaStandIn(t)
+	s, store := newTestStrava(t, srv)
+	strava.failure = "20261018T071502Z-430123456.tcx duplicate of activity 98"
+
+	if _, err := s.Upload(context.Background(), "user-1", testStravaWorkout()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := store.finished(t)
+	if done.Status != models.UploadFailed || done.Error != strava.failure {
+		t.Fatalf("upload %+v, want Strava's processing error", done)
+	}
+	if done.ActivityID != "" || done.UploadID != "77" {
+		t.Errorf("failed upload %+v", done)
+	}
+}
+
+func TestStravaUploadRateLimited(t *testing.T) {
+	strava, srv := newStravaStandIn(t)
+	s, store := newTestStrava(t, srv)
+	strava.uploadError = http.StatusTooManyRequests
+
+	if _, err := s.Upload(context.Background(), "user-1", testStravaWorkout()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := store.finished(t)
+	if done.Status != models.UploadFailed || !strings.Contains(done.Error, "rate limit") {
+		t.Fatalf("upload %+v, want the rate limit reported", done)
+	}
+	if conn := store.connection(t, models.ProviderStrava); conn.Error != "" {
+		t.Errorf("rate limit marked the connection: %s", conn.Error)
+	}
+}
+
+func TestStravaRefreshRevoked(t *testing.T) {
+	strava, srv := newStravaStandIn(t)
+	s, store := newTestStrava(t, srv)
+	strava.refreshError = http.StatusBadRequest
+
+	if _, err := s.Upload(context.Background(), "user-1", testStravaWorkout()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := store.finished(t)
+	if done.Status != models.UploadFailed || done.Error != errStravaUnauthorized.Error() {
+		t.Fatalf("upload %+v, want the user asked to reconnect", done)
+	}
+
+	// The connection is marked so later uploads fail without calling Strava
+	conn := store.connection(t, models.ProviderStrava)
+	if conn.Error != errStravaUnauthorized.Error() {
+		t.Fatalf("connection error %q", conn.Error)
+	}
+	if _, err := s.oauth.accessToken(context.Background(), "user-1"); err == nil || err.Error() != conn.Error {
+		t.Errorf("accessToken of a revoked connection returned %v", err)
+	}
+}
+
+func TestStravaErrorMessages(t *testing.T) {
+	_, srv := newStravaStandIn(t)
+	s, _ := newTestStrava(t, srv)
+
+	// A token Strava doesn't accept is surfaced with its details
+	req, err := s.oauth.apiRequest(context.Background(), http.MethodPost, "/uploads", "stale", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.oauth.do(req, nil)
+	apiErr, ok := err.(*oauthError)
+	if !ok || apiErr.Status != http.StatusUnauthorized {
+		t.Fatalf("do returned %v, want a 401 oauthError", err)
+	}
+	if want := "Strava returned 401: Authorization Error (Athlete access_token invalid)"; err.Error() != want {
+		t.Errorf("error %q, want %q", err, want)
+	}
+}
@@ -0,0 +1,508 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// inviteCodeAlphabet leaves out characters easily confused when a code is
+// read aloud or typed
+const inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// inviteCodeLength is the number of characters in an invite code
+const inviteCodeLength = 8
+
+// ErrExpired is returned when accepting an invite that has expired or been
+// used up
+var ErrExpired = errors.New("expired")
+
+// ErrOwnerRole is returned when changing the role of a team's owner
+var ErrOwnerRole = errors.New("the owner's role can't be changed")
+
+// TeamService stores teams, their members and invites. Each membership is
+// mirrored under the member's user document so their teams can be listed.
+type TeamService struct {
+	client *firestore.Client
+}
+
+// NewTeamService creates a new team service
+func NewTeamService(client *firestore.Client) *TeamService {
+	return &TeamService{client: client}
+}
+
+// membership is the mirror of a team membership kept under the user
+type membership struct {
+	Role     string    `firestore:"role"`
+	JoinedAt time.Time `firestore:"joined_at"`
+}
+
+// teamDoc returns a team's document
+func (s *TeamService) teamDoc(id string) *firestore.DocumentRef {
+	return s.client.Collection("teams").Doc(id)
+}
+
+// memberDoc returns a member's document within a team
+func (s *TeamService) memberDoc(teamID, uid string) *firestore.DocumentRef {
+	return s.teamDoc(teamID).Collection("members").Doc(uid)
+}
+
+// membershipDoc returns the mirror of a membership under the user
+func (s *TeamService) membershipDoc(uid, teamID string) *firestore.DocumentRef {
+	return userDoc(s.client, uid).Collection("teams").Doc(teamID)
+}
+
+// inviteDoc returns an invite's document
+func (s *TeamService) inviteDoc(code string) *firestore.DocumentRef {
+	return s.client.Collection("team_invites").Doc(code)
+}
+
+// List returns the teams a user belongs to sorted by name, each with the
+// user's role
+func (s *TeamService) List(ctx context.Context, uid string) ([]*models.Team, error) {
+	snaps, err := userDoc(s.client, uid).Collection("teams").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing teams: %w", err)
+	}
+
+	roles := make(map[string]string, len(snaps))
+	refs := make([]*firestore.DocumentRef, len(snaps))
+	for i, snap := range snaps {
+		var m membership
+		if err := snap.DataTo(&m); err != nil {
+			return nil, fmt.Errorf("error decoding membership %s: %w", snap.Ref.ID, err)
+		}
+		roles[snap.Ref.ID] = m.Role
+		refs[i] = s.teamDoc(snap.Ref.ID)
+	}
+
+	teams := []*models.Team{}
+	if len(refs) == 0 {
+		return teams, nil
+	}
+
+	teamSnaps, err := s.client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching teams: %w", err)
+	}
+	for _, snap := range teamSnaps {
+		// A team deleted since the membership was read
+		if !snap.Exists() {
+			continue
+		}
+		team, err := decodeTeam(snap)
+		if err != nil {
+			return nil, err
+		}
+		team.Role = roles[team.ID]
+		teams = append(teams, team)
+	}
+
+	sort.Slice(teams, func(i, j int) bool {
+		return strings.ToLower(teams[i].Name) < strings.ToLower(teams[j].Name)
+	})
+	return teams, nil
+}
+
+// Get returns a single team
+func (s *TeamService) Get(ctx context.Context, id string) (*models.Team, error) {
+	snap, err := s.teamDoc(id).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching team: %w", err)
+	}
+	return decodeTeam(snap)
+}
+
+// Role returns a user's role in a team, or ErrNotFound if they aren't a
+// member
+func (s *TeamService) Role(ctx context.Context, teamID, uid string) (string, error) {
+	snap, err := s.memberDoc(teamID, uid).Get(ctx)
+	if isNotFound(err) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("error fetching membership: %w", err)
+	}
+
+	member, err := decodeMember(snap)
+	if err != nil {
+		return "", err
+	}
+	return member.Role, nil
+}
+
+// Create stores a new team owned by uid and sets its ID
+func (s *TeamService) Create(ctx context.Context, uid, displayName string, team *models.Team) error {
+	now := time.Now().UTC()
+	team.OwnerID = uid
+	team.CreatedAt = now
+	team.UpdatedAt = now
+
+	ref := s.client.Collection("teams").NewDoc()
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := tx.Create(ref, team); err != nil {
+			return err
+		}
+		return s.addMember(tx, ref.ID, uid, displayName, models.RoleOwner, now)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving team: %w", err)
+	}
+
+	team.ID = ref.ID
+	team.Role = models.RoleOwner
+	return nil
+}
+
+// Update replaces a team's name, description and kind, keeping its owner
+// and creation time
+func (s *TeamService) Update(ctx context.Context, id string, team *models.Team) error {
+	ref := s.teamDoc(id)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching team: %w", err)
+		}
+
+		current, err := decodeTeam(snap)
+		if err != nil {
+			return err
+		}
+
+		team.ID = id
+		team.OwnerID = current.OwnerID
+		team.CreatedAt = current.CreatedAt
+		team.UpdatedAt = time.Now().UTC()
+		return tx.Set(ref, team)
+	})
+}
+
+// Delete removes a team with its memberships, invites and shared templates
+func (s *TeamService) Delete(ctx context.Context, id string) error {
+	ref := s.teamDoc(id)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(ref); isNotFound(err) {
+			return ErrNotFound
+		} else if err != nil {
+			return fmt.Errorf("error fetching team: %w", err)
+		}
+
+		members, err := tx.Documents(ref.Collection("members")).GetAll()
+		if err != nil {
+			return fmt.Errorf("error listing members: %w", err)
+		}
+		invites, err := tx.Documents(s.client.Collection("team_invites").Where("team_id", "==", id)).GetAll()
+		if err != nil {
+			return fmt.Errorf("error listing invites: %w", err)
+		}
+		templates, err := tx.Documents(ref.Collection("templates")).GetAll()
+		if err != nil {
+			return fmt.Errorf("error listing templates: %w", err)
+		}
+
+		for _, member := range members {
+			if err := tx.Delete(s.membershipDoc(member.Ref.ID, id)); err != nil {
+				return err
+			}
+			if err := tx.Delete(member.Ref); err != nil {
+				return err
+			}
+		}
+		for _, invite := range invites {
+			if err := tx.Delete(invite.Ref); err != nil {
+				return err
+			}
+		}
+		for _, template := range templates {
+			if err := tx.Delete(template.Ref); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// Members returns a team's members, owner first, then coaches and
+// athletes, each sorted by name
+func (s *TeamService) Members(ctx context.Context, teamID string) ([]*models.Member, error) {
+	snaps, err := s.teamDoc(teamID).Collection("members").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing members: %w", err)
+	}
+
+	members := make([]*models.Member, 0, len(snaps))
+	for _, snap := range snaps {
+		member, err := decodeMember(snap)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	rank := map[string]int{models.RoleOwner: 0, models.RoleCoach: 1, models.RoleAthlete: 2}
+	sort.Slice(members, func(i, j int) bool {
+		if rank[members[i].Role] != rank[members[j].Role] {
+			return rank[members[i].Role] < rank[members[j].Role]
+		}
+		return strings.ToLower(members[i].DisplayName) < strings.ToLower(members[j].DisplayName)
+	})
+	return members, nil
+}
+
+// SetRole changes a member's role. It returns ErrOwnerRole for the owner,
+// whose role can't be changed.
+func (s *TeamService) SetRole(ctx context.Context, teamID, uid, role string) error {
+	ref := s.memberDoc(teamID, uid)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching member: %w", err)
+		}
+
+		member, err := decodeMember(snap)
+		if err != nil {
+			return err
+		}
+		if member.Role == models.RoleOwner {
+			return ErrOwnerRole
+		}
+
+		update := []firestore.Update{{Path: "role", Value: role}}
+		if err := tx.Update(ref, update); err != nil {
+			return err
+		}
+		return tx.Update(s.membershipDoc(uid, teamID), update)
+	})
+}
+
+// RemoveMember removes a member from a team
+func (s *TeamService) RemoveMember(ctx context.Context, teamID, uid string) error {
+	ref := s.memberDoc(teamID, uid)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(ref); isNotFound(err) {
+			return ErrNotFound
+		} else if err != nil {
+			return fmt.Errorf("error fetching member: %w", err)
+		}
+
+		if err := tx.Delete(s.membershipDoc(uid, teamID)); err != nil {
+			return err
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// CreateInvite stores a new invite to a team and sets its code
+func (s *TeamService) CreateInvite(ctx context.Context, team *models.Team, uid string, invite *models.Invite) error {
+	invite.TeamID = team.ID
+	invite.TeamName = team.Name
+	invite.Uses = 0
+	invite.CreatedBy = uid
+	invite.CreatedAt = time.Now().UTC()
+
+	// Retry the unlikely collision with an existing code
+	for attempt := 0; attempt < 3; attempt++ {
+		code, err := newInviteCode()
+		if err != nil {
+			return err
+		}
+
+		_, err = s.inviteDoc(code).Create(ctx, invite)
+		if status.Code(err) == codes.AlreadyExists {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error saving invite: %w", err)
+		}
+
+		invite.Code = code
+		return nil
+	}
+	return errors.New("error saving invite: no unused code found")
+}
+
+// Invites returns a team's invites, newest first
+func (s *TeamService) Invites(ctx context.Context, teamID string) ([]*models.Invite, error) {
+	snaps, err := s.client.Collection("team_invites").Where("team_id", "==", teamID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing invites: %w", err)
+	}
+
+	invites := make([]*models.Invite, 0, len(snaps))
+	for _, snap := range snaps {
+		invite, err := decodeInvite(snap)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+
+	sort.Slice(invites, func(i, j int) bool {
+		return invites[i].CreatedAt.After(invites[j].CreatedAt)
+	})
+	return invites, nil
+}
+
+// GetInvite returns an invite by its code. Codes are case-insensitive.
+func (s *TeamService) GetInvite(ctx context.Context, code string) (*models.Invite, error) {
+	snap, err := s.inviteDoc(strings.ToUpper(code)).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching invite: %w", err)
+	}
+	return decodeInvite(snap)
+}
+
+// DeleteInvite revokes one of a team's invites
+func (s *TeamService) DeleteInvite(ctx context.Context, teamID, code string) error {
+	ref := s.inviteDoc(strings.ToUpper(code))
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching invite: %w", err)
+		}
+
+		invite, err := decodeInvite(snap)
+		if err != nil {
+			return err
+		}
+		if invite.TeamID != teamID {
+			return ErrNotFound
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// Join adds a user to the team an invite is for, with the invite's role.
+// It returns ErrExpired for an expired or used up invite and ErrExists if
+// the user is already a member.
+func (s *TeamService) Join(ctx context.Context, code, uid, displayName string) (*models.Team, error) {
+	ref := s.inviteDoc(strings.ToUpper(code))
+
+	var team *models.Team
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching invite: %w", err)
+		}
+
+		invite, err := decodeInvite(snap)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		if !invite.Usable(now) {
+			return ErrExpired
+		}
+
+		teamSnap, err := tx.Get(s.teamDoc(invite.TeamID))
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching team: %w", err)
+		}
+		if team, err = decodeTeam(teamSnap); err != nil {
+			return err
+		}
+
+		if _, err := tx.Get(s.memberDoc(invite.TeamID, uid)); err == nil {
+			return ErrExists
+		} else if !isNotFound(err) {
+			return fmt.Errorf("error fetching member: %w", err)
+		}
+
+		if err := s.addMember(tx, invite.TeamID, uid, displayName, invite.Role, now); err != nil {
+			return err
+		}
+		team.Role = invite.Role
+		return tx.Update(ref, []firestore.Update{{Path: "uses", Value: firestore.Increment(1)}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return team, nil
+}
+
+// addMember writes a membership and its mirror under the user
+func (s *TeamService) addMember(tx *firestore.Transaction, teamID, uid, displayName, role string, joinedAt time.Time) error {
+	member := &models.Member{DisplayName: displayName, Role: role, JoinedAt: joinedAt}
+	if err := tx.Create(s.memberDoc(teamID, uid), member); err != nil {
+		return err
+	}
+	return tx.Set(s.membershipDoc(uid, teamID), membership{Role: role, JoinedAt: joinedAt})
+}
+
+// newInviteCode returns a random invite code
+func newInviteCode() (string, error) {
+	b := make([]byte, inviteCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating invite code: %w", err)
+	}
+	for i := range b {
+		b[i] = inviteCodeAlphabet[int(b[i])%len(inviteCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// decodeTeam reads a team document
+func decodeTeam(snap *firestore.DocumentSnapshot) (*models.Team, error) {
+	var team models.Team
+	if err := snap.DataTo(&team); err != nil {
+		return nil, fmt.Errorf("error decoding team %s: %w", snap.Ref.ID, err)
+	}
+	team.ID = snap.Ref.ID
+	return &team, nil
+}
+
+// decodeMember reads a team member document
+func decodeMember(snap *firestore.DocumentSnapshot) (*models.Member, error) {
+	var member models.Member
+	if err := snap.DataTo(&member); err != nil {
+		return nil, fmt.Errorf("error decoding member %s: %w", snap.Ref.ID, err)
+	}
+	member.UserID = snap.Ref.ID
+	return &member, nil
+}
+
+// decodeInvite reads an invite document
+func decodeInvite(snap *firestore.DocumentSnapshot) (*models.Invite, error) {
+	var invite models.Invite
+	if err := snap.DataTo(&invite); err != nil {
+		return nil, fmt.Errorf("error decoding invite %s: %w", snap.Ref.ID, err)
+	}
+	invite.Code = snap.Ref.ID
+	return &invite, nil
+}
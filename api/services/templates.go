@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// TemplateService stores users' workout templates and those shared with
+// teams
+type TemplateService struct {
+	client *firestore.Client
+}
+
+// NewTemplateService creates a new template service
+func NewTemplateService(client *firestore.Client) *TemplateService {
+	return &TemplateService{client: client}
+}
+
+// collection returns the collection holding a user's templates
+func (s *TemplateService) collection(uid string) *firestore.CollectionRef {
+	return userDoc(s.client, uid).Collection("templates")
+}
+
+// teamCollection returns the collection holding a team's shared templates
+func (s *TemplateService) teamCollection(teamID string) *firestore.CollectionRef {
+	return s.client.Collection("teams").Doc(teamID).Collection("templates")
+}
+
+// List returns a user's templates sorted by name
+func (s *TemplateService) List(ctx context.Context, uid string) ([]*models.Template, error) {
+	return s.list(ctx, s.collection(uid), "")
+}
+
+// ListForTeam returns the templates shared with a team sorted by name
+func (s *TemplateService) ListForTeam(ctx context.Context, teamID string) ([]*models.Template, error) {
+	return s.list(ctx, s.teamCollection(teamID), teamID)
+}
+
+// Get returns a single template of a user's
+func (s *TemplateService) Get(ctx context.Context, uid, id string) (*models.Template, error) {
+	return s.get(ctx, s.collection(uid), "", id)
+}
+
+// GetForTeam returns a single template shared with a team
+func (s *TemplateService) GetForTeam(ctx context.Context, teamID, id string) (*models.Template, error) {
+	return s.get(ctx, s.teamCollection(teamID), teamID, id)
+}
+
+// GetMany returns the templates with the given IDs that exist, by ID
+func (s *TemplateService) GetMany(ctx context.Context, uid string, ids []string) (map[string]*models.Template, error) {
+	templates := make(map[string]*models.Template, len(ids))
+	if len(ids) == 0 {
+		return templates, nil
+	}
+
+	refs := make([]*firestore.DocumentRef, len(ids))
+	for i, id := range ids {
+		refs[i] = s.collection(uid).Doc(id)
+	}
+
+	snaps, err := s.client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching templates: %w", err)
+	}
+	for _, snap := range snaps {
+		if !snap.Exists() {
+			continue
+		}
+		template, err := decodeTemplate(snap)
+		if err != nil {
+			return nil, err
+		}
+		templates[template.ID] = template
+	}
+	return templates, nil
+}
+
+// Create stores a new template of a user's and sets its ID
+func (s *TemplateService) Create(ctx context.Context, uid string, template *models.Template) error {
+	return s.create(ctx, s.collection(uid), template)
+}
+
+// CreateForTeam shares a new template with a team and sets its ID
+func (s *TemplateService) CreateForTeam(ctx context.Context, teamID string, template *models.Template) error {
+	template.TeamID = teamID
+	return s.create(ctx, s.teamCollection(teamID), template)
+}
+
+// Update replaces an existing template of a user's, keeping its creation
+// time
+func (s *TemplateService) Update(ctx context.Context, uid, id string, template *models.Template) error {
+	return s.update(ctx, s.collection(uid), id, template)
+}
+
+// UpdateForTeam replaces an existing template shared with a team, keeping
+// its creation time
+func (s *TemplateService) UpdateForTeam(ctx context.Context, teamID, id string, template *models.Template) error {
+	template.TeamID = teamID
+	return s.update(ctx, s.teamCollection(teamID), id, template)
+}
+
+// Delete removes a template of a user's
+func (s *TemplateService) Delete(ctx context.Context, uid, id string) error {
+	return s.delete(ctx, s.collection(uid), id)
+}
+
+// DeleteForTeam removes a template shared with a team
+func (s *TemplateService) DeleteForTeam(ctx context.Context, teamID, id string) error {
+	return s.delete(ctx, s.teamCollection(teamID), id)
+}
+
+// list returns the templates in a collection sorted by name, marking them
+// as shared with teamID if it's set
+func (s *TemplateService) list(ctx context.Context, collection *firestore.CollectionRef, teamID string) ([]*models.Template, error) {
+	snaps, err := collection.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing templates: %w", err)
+	}
+
+	templates := make([]*models.Template, 0, len(snaps))
+	for _, snap := range snaps {
+		template, err := decodeTemplate(snap)
+		if err != nil {
+			return nil, err
+		}
+		template.TeamID = teamID
+		templates = append(templates, template)
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		return strings.ToLower(templates[i].Name) < strings.ToLower(templates[j].Name)
+	})
+	return templates, nil
+}
+
+// get returns a single template from a collection
+func (s *TemplateService) get(ctx context.Context, collection *firestore.CollectionRef, teamID, id string) (*models.Template, error) {
+	snap, err := collection.Doc(id).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching template: %w", err)
+	}
+
+	template, err := decodeTemplate(snap)
+	if err != nil {
+		return nil, err
+	}
+	template.TeamID = teamID
+	return template, nil
+}
+
+// create stores a new template in a collection and sets its ID
+func (s *TemplateService) create(ctx context.Context, collection *firestore.CollectionRef, template *models.Template) error {
+	template.CreatedAt = time.Now().UTC()
+	template.UpdatedAt = template.CreatedAt
+
+	ref := collection.NewDoc()
+	if _, err := ref.Create(ctx, template); err != nil {
+		return fmt.Errorf("error saving template: %w", err)
+	}
+
+	template.ID = ref.ID
+	return nil
+}
+
+// update replaces an existing template in a collection, keeping its
+// creation time
+func (s *TemplateService) update(ctx context.Context, collection *firestore.CollectionRef, id string, template *models.Template) error {
+	ref := collection.Doc(id)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching template: %w", err)
+		}
+
+		current, err := decodeTemplate(snap)
+		if err != nil {
+			return err
+		}
+
+		template.ID = id
+		template.CreatedAt = current.CreatedAt
+		template.UpdatedAt = time.Now().UTC()
+		return tx.Set(ref, template)
+	})
+}
+
+// delete removes a template from a collection
+func (s *TemplateService) delete(ctx context.Context, collection *firestore.CollectionRef, id string) error {
+	ref := collection.Doc(id)
+	if _, err := ref.Delete(ctx, firestore.Exists); err != nil {
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error deleting template: %w", err)
+	}
+	return nil
+}
+
+// decodeTemplate reads a template document
+func decodeTemplate(snap *firestore.DocumentSnapshot) (*models.Template, error) {
+	var template models.Template
+	if err := snap.DataTo(&template); err != nil {
+		return nil, fmt.Errorf("error decoding template %s: %w", snap.Ref.ID, err)
+	}
+	template.ID = snap.Ref.ID
+	return &template, nil
+}
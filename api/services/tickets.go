@@ -0,0 +1,72 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// streamTicketLifetime is how long a stream ticket can wait to be redeemed
+const streamTicketLifetime = time.Minute
+
+// TicketService issues the short-lived tickets browsers open live streams
+// with in place of an Authorization header. Each ticket opens a single
+// stream. Tickets are held in memory, so like relayed streams they must be
+// redeemed on the API instance that issued them.
+type TicketService struct {
+	mu      sync.Mutex
+	tickets map[string]streamTicket
+}
+
+// streamTicket is who a ticket was issued to and until when
+type streamTicket struct {
+	uid       string
+	expiresAt time.Time
+}
+
+// NewTicketService creates a ticket service with no tickets
+func NewTicketService() *TicketService {
+	return &TicketService{tickets: make(map[string]streamTicket)}
+}
+
+// Issue creates a ticket for a user
+func (s *TicketService) Issue(uid string) (*models.StreamTicket, error) {
+	token, err := newShareToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(streamTicketLifetime)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Forget tickets that were never redeemed
+	for token, ticket := range s.tickets {
+		if !now.Before(ticket.expiresAt) {
+			delete(s.tickets, token)
+		}
+	}
+	s.tickets[token] = streamTicket{uid: uid, expiresAt: expiresAt}
+
+	return &models.StreamTicket{Ticket: token, ExpiresAt: expiresAt.UTC()}, nil
+}
+
+// Redeem returns the user a ticket was issued to, using it up. It returns
+// ErrNotFound for an unknown or used ticket and ErrExpired for an old one.
+func (s *TicketService) Redeem(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ticket, ok := s.tickets[token]
+	if !ok {
+		return "", ErrNotFound
+	}
+	delete(s.tickets, token)
+
+	if !time.Now().Before(ticket.expiresAt) {
+		return "", ErrExpired
+	}
+	return ticket.uid, nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTicketsRedeemedOnce(t *testing.T) {
+	s := NewTicketService()
+
+	ticket, err := s.Issue("athlete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.Ticket == "" || !ticket.ExpiresAt.After(time.Now()) {
+		t.Fatalf("issued %+v", ticket)
+	}
+
+	uid, err := s.Redeem(ticket.Ticket)
+	if err != nil || uid != "athlete" {
+		t.Fatalf("Redeem returned %q, %v", uid, err)
+	}
+	if _, err := s.Redeem(ticket.Ticket); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Redeem returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.Redeem("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Redeem of an unknown ticket returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestTicketsExpire(t *testing.T) {
+	s := NewTicketService()
+
+	old, err := s.Issue("athlete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unused, err := s.Issue("athlete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	for token, ticket := range s.tickets {
+		ticket.expiresAt = time.Now()
+		s.tickets[token] = ticket
+	}
+	s.mu.Unlock()
+
+	if _, err := s.Redeem(old.Ticket); !errors.Is(err, ErrExpired) {
+		t.Errorf("Redeem of an old ticket returned %v, want ErrExpired", err)
+	}
+
+	// Issuing forgets tickets that expired unused
+	if _, err := s.Issue("athlete"); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	_, kept := s.tickets[unused.Ticket]
+	s.mu.Unlock()
+	if kept {
+		t.Error("expired ticket still held after issuing another")
+	}
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// deliveryListLimit bounds the deliveries listed for a webhook
+const deliveryListLimit = 100
+
+// WebhookService stores webhooks and the log of deliveries to them
+type WebhookService struct {
+	client *firestore.Client
+}
+
+// NewWebhookService creates a new webhook service
+func NewWebhookService(client *firestore.Client) *WebhookService {
+	return &WebhookService{client: client}
+}
+
+// collection returns the collection holding webhooks
+func (s *WebhookService) collection() *firestore.CollectionRef {
+	return s.client.Collection("webhooks")
+}
+
+// deliveries returns the collection holding a webhook's deliveries
+func (s *WebhookService) deliveries(webhookID string) *firestore.CollectionRef {
+	return s.collection().Doc(webhookID).Collection("deliveries")
+}
+
+// ListForUser returns a user's own webhooks
+func (s *WebhookService) ListForUser(ctx context.Context, uid string) ([]*models.Webhook, error) {
+	return s.list(ctx, s.collection().Where("user_id", "==", uid))
+}
+
+// ListForTeam returns a team's webhooks
+func (s *WebhookService) ListForTeam(ctx context.Context, teamID string) ([]*models.Webhook, error) {
+	return s.list(ctx, s.collection().Where("team_id", "==", teamID))
+}
+
+// Subscribers returns the webhooks that receive event for a user: their
+// own and those of teamIDs
+func (s *WebhookService) Subscribers(ctx context.Context, event, uid string, teamIDs []string) ([]*models.Webhook, error) {
+	var webhooks []*models.Webhook
+	if uid != "" {
+		own, err := s.ListForUser(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = own
+	}
+
+	// in takes at most 30 values
+	for start := 0; start < len(teamIDs); start += 30 {
+		end := min(start+30, len(teamIDs))
+		team, err := s.list(ctx, s.collection().Where("team_id", "in", teamIDs[start:end]))
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, team...)
+	}
+
+	subscribed := make([]*models.Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		if webhook.Subscribed(event) {
+			subscribed = append(subscribed, webhook)
+		}
+	}
+	return subscribed, nil
+}
+
+// Get returns a single webhook, including its secret
+func (s *WebhookService) Get(ctx context.Context, id string) (*models.Webhook, error) {
+	snap, err := s.collection().Doc(id).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching webhook: %w", err)
+	}
+	return decodeWebhook(snap)
+}
+
+// Create stores a new webhook with a new signing secret and sets its ID
+func (s *WebhookService) Create(ctx context.Context, uid string, webhook *models.Webhook) error {
+	secret, err := newShareToken()
+	if err != nil {
+		return err
+	}
+
+	webhook.Secret = "whsec_" + secret
+	webhook.CreatedBy = uid
+	webhook.CreatedAt = time.Now().UTC()
+	webhook.UpdatedAt = webhook.CreatedAt
+
+	ref := s.collection().NewDoc()
+	if _, err := ref.Create(ctx, webhook); err != nil {
+		return fmt.Errorf("error saving webhook: %w", err)
+	}
+
+	webhook.ID = ref.ID
+	return nil
+}
+
+// Update replaces a webhook's URL, description, events and active flag,
+// keeping its owner and secret
+func (s *WebhookService) Update(ctx context.Context, id string, webhook *models.Webhook) error {
+	ref := s.collection().Doc(id)
+
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching webhook: %w", err)
+		}
+
+		current, err := decodeWebhook(snap)
+		if err != nil {
+			return err
+		}
+
+		webhook.ID = id
+		webhook.UserID = current.UserID
+		webhook.TeamID = current.TeamID
+		webhook.Secret = current.Secret
+		webhook.CreatedBy = current.CreatedBy
+		webhook.CreatedAt = current.CreatedAt
+		webhook.UpdatedAt = time.Now().UTC()
+		return tx.Set(ref, webhook)
+	})
+}
+
+// Delete removes a webhook and its delivery log
+func (s *WebhookService) Delete(ctx context.Context, id string) error {
+	ref := s.collection().Doc(id)
+	if _, err := ref.Delete(ctx, firestore.Exists); err != nil {
+		if isNotFound(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+
+	// The log can be longer than a transaction allows
+	snaps, err := s.deliveries(id).Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("error listing deliveries: %w", err)
+	}
+	if len(snaps) == 0 {
+		return nil
+	}
+
+	writer := s.client.BulkWriter(ctx)
+	for _, snap := range snaps {
+		if _, err := writer.Delete(snap.Ref); err != nil {
+			writer.End()
+			return fmt.Errorf("error deleting deliveries: %w", err)
+		}
+	}
+	writer.End()
+	return nil
+}
+
+// CreateDelivery stores a new pending delivery. payload is called with the
+// delivery's ID to build the body.
+func (s *WebhookService) CreateDelivery(ctx context.Context, delivery *models.Delivery, payload func(id string) ([]byte, error)) error {
+	ref := s.deliveries(delivery.WebhookID).NewDoc()
+
+	body, err := payload(ref.ID)
+	if err != nil {
+		return fmt.Errorf("error encoding payload: %w", err)
+	}
+
+	delivery.Payload = string(body)
+	delivery.Status = models.DeliveryPending
+	delivery.Attempts = []models.DeliveryAttempt{}
+	delivery.CreatedAt = time.Now().UTC()
+	delivery.NextAttemptAt = delivery.CreatedAt
+
+	if _, err := ref.Create(ctx, delivery); err != nil {
+		return fmt.Errorf("error saving delivery: %w", err)
+	}
+
+	delivery.ID = ref.ID
+	return nil
+}
+
+// GetDelivery returns a single delivery to a webhook
+func (s *WebhookService) GetDelivery(ctx context.Context, webhookID, id string) (*models.Delivery, error) {
+	snap, err := s.deliveries(webhookID).Doc(id).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching delivery: %w", err)
+	}
+	return decodeDelivery(snap)
+}
+
+// Deliveries returns a webhook's most recent deliveries, newest first,
+// without their payloads
+func (s *WebhookService) Deliveries(ctx context.Context, webhookID string) ([]*models.Delivery, error) {
+	snaps, err := s.deliveries(webhookID).
+		OrderBy("created_at", firestore.Desc).
+		Limit(deliveryListLimit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing deliveries: %w", err)
+	}
+
+	deliveries := make([]*models.Delivery, 0, len(snaps))
+	for _, snap := range snaps {
+		delivery, err := decodeDelivery(snap)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = ""
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// Pending returns every delivery still to be attempted
+func (s *WebhookService) Pending(ctx context.Context) ([]*models.Delivery, error) {
+	snaps, err := s.client.CollectionGroup("deliveries").
+		Where("status", "==", models.DeliveryPending).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing pending deliveries: %w", err)
+	}
+
+	deliveries := make([]*models.Delivery, 0, len(snaps))
+	for _, snap := range snaps {
+		delivery, err := decodeDelivery(snap)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt logs an attempt at a delivery and sets its status, with
+// the time of the next attempt while it is pending
+func (s *WebhookService) RecordAttempt(ctx context.Context, delivery *models.Delivery, attempt models.DeliveryAttempt, status string, next time.Time) error {
+	_, err := s.deliveries(delivery.WebhookID).Doc(delivery.ID).Update(ctx, []firestore.Update{
+		{Path: "attempts", Value: firestore.ArrayUnion(attempt)},
+		{Path: "status", Value: status},
+		{Path: "next_attempt_at", Value: next},
+	})
+	if err != nil {
+		return fmt.Errorf("error recording delivery attempt: %w", err)
+	}
+
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.Status = status
+	delivery.NextAttemptAt = next
+	return nil
+}
+
+// list runs a webhook query, sorting the results by creation
+func (s *WebhookService) list(ctx context.Context, query firestore.Query) ([]*models.Webhook, error) {
+	snaps, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhooks: %w", err)
+	}
+
+	webhooks := make([]*models.Webhook, 0, len(snaps))
+	for _, snap := range snaps {
+		webhook, err := decodeWebhook(snap)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+	return webhooks, nil
+}
+
+// decodeWebhook reads a webhook document
+func decodeWebhook(snap *firestore.DocumentSnapshot) (*models.Webhook, error) {
+	var webhook models.Webhook
+	if err := snap.DataTo(&webhook); err != nil {
+		return nil, fmt.Errorf("error decoding webhook %s: %w", snap.Ref.ID, err)
+	}
+	webhook.ID = snap.Ref.ID
+	return &webhook, nil
+}
+
+// decodeDelivery reads a delivery document
+func decodeDelivery(snap *firestore.DocumentSnapshot) (*models.Delivery, error) {
+	var delivery models.Delivery
+	if err := snap.DataTo(&delivery); err != nil {
+		return nil, fmt.Errorf("error decoding delivery %s: %w", snap.Ref.ID, err)
+	}
+	delivery.ID = snap.Ref.ID
+	return &delivery, nil
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrExists is returned when saving a document that already exists
+var ErrExists = errors.New("already exists")
+
+// WorkoutService stores users' workout summaries
+type WorkoutService struct {
+	client *firestore.Client
+}
+
+// NewWorkoutService creates a new workout service
+func NewWorkoutService(client *firestore.Client) *WorkoutService {
+	return &WorkoutService{client: client}
+}
+
+// collection returns the collection holding a user's workouts
+func (s *WorkoutService) collection(uid string) *firestore.CollectionRef {
+	return userDoc(s.client, uid).Collection("workouts")
+}
+
+// Create stores a new workout and sets its ID. Workouts recorded by the
+// socket server are keyed by their session ID so they are only stored
+// once.
+func (s *WorkoutService) Create(ctx context.Context, uid string, workout *models.Workout) error {
+	workout.CreatedAt = time.Now().UTC()
+
+	var ref *firestore.DocumentRef
+	if workout.SessionID != "" {
+		ref = s.collection(uid).Doc(workout.SessionID)
+	} else {
+		ref = s.collection(uid).NewDoc()
+	}
+
+	if _, err := ref.Create(ctx, workout); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return ErrExists
+		}
+		return fmt.Errorf("error saving workout: %w", err)
+	}
+
+	workout.ID = ref.ID
+	return nil
+}
+
+// Get returns a single workout
+func (s *WorkoutService) Get(ctx context.Context, uid, id string) (*models.Workout, error) {
+	snap, err := s.collection(uid).Doc(id).Get(ctx)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching workout: %w", err)
+	}
+	return decodeWorkout(snap)
+}
+
+// List returns a user's workouts started in [from, to), newest first. A
+// zero from or to leaves that end open; limit 0 returns them all.
+func (s *WorkoutService) List(ctx context.Context, uid string, from, to time.Time, limit int) ([]*models.Workout, error) {
+	query := s.collection(uid).OrderBy("started_at", firestore.Desc)
+	if !from.IsZero() {
+		query = query.Where("started_at", ">=", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("started_at", "<", to)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	workouts := []*models.Workout{}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		snap, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing workouts: %w", err)
+		}
+
+		workout, err := decodeWorkout(snap)
+		if err != nil {
+			return nil, err
+		}
+		workouts = append(workouts, workout)
+	}
+	return workouts, nil
+}
+
+// SetCurve stores a curve built for a workout saved without one
+func (s *WorkoutService) SetCurve(ctx context.Context, uid, id string, curve []models.CurvePoint) error {
+	_, err := s.collection(uid).Doc(id).Update(ctx, []firestore.Update{{Path: "curve", Value: curve}})
+	if err != nil {
+		return fmt.Errorf("error saving workout curve: %w", err)
+	}
+	return nil
+}
+
+// decodeWorkout reads a workout document
+func decodeWorkout(snap *firestore.DocumentSnapshot) (*models.Workout, error) {
+	var workout models.Workout
+	if err := snap.DataTo(&workout); err != nil {
+		return nil, fmt.Errorf("error decoding workout %s: %w", snap.Ref.ID, err)
+	}
+	workout.ID = snap.Ref.ID
+	return &workout, nil
+}
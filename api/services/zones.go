@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/danhigham/ergometer.live/api/models"
+)
+
+// ZoneService stores users' training zone settings
+type ZoneService struct {
+	client *firestore.Client
+}
+
+// NewZoneService creates a new zone service
+func NewZoneService(client *firestore.Client) *ZoneService {
+	return &ZoneService{client: client}
+}
+
+// doc returns the document holding a user's zone settings
+func (s *ZoneService) doc(uid string) *firestore.DocumentRef {
+	return userDoc(s.client, uid).Collection("settings").Doc("zones")
+}
+
+// Get returns a user's zone settings. Users who haven't saved any get
+// empty settings.
+func (s *ZoneService) Get(ctx context.Context, uid string) (*models.ZoneSettings, error) {
+	snap, err := s.doc(uid).Get(ctx)
+	if isNotFound(err) {
+		return &models.ZoneSettings{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching zone settings: %w", err)
+	}
+
+	var settings models.ZoneSettings
+	if err := snap.DataTo(&settings); err != nil {
+		return nil, fmt.Errorf("error decoding zone settings: %w", err)
+	}
+	return &settings, nil
+}
+
+// Put replaces a user's zone settings
+func (s *ZoneService) Put(ctx context.Context, uid string, settings *models.ZoneSettings) error {
+	settings.UpdatedAt = time.Now().UTC()
+	if _, err := s.doc(uid).Set(ctx, settings); err != nil {
+		return fmt.Errorf("error saving zone settings: %w", err)
+	}
+	return nil
+}
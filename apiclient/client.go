@@ -0,0 +1,230 @@
+// Package apiclient talks to the Ergometer.Live REST API on behalf of a
+// signed-in user.
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/danhigham/ergometer.live/pm5"
+)
+
+// requestTimeout bounds a single API request
+const requestTimeout = 30 * time.Second
+
+var (
+	// ErrAlreadySaved is returned when a session has already been saved
+	ErrAlreadySaved = errors.New("workout has already been saved")
+
+	// ErrNotFound is returned when a requested item doesn't exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrExpired is returned when a requested item has expired
+	ErrExpired = errors.New("expired")
+)
+
+// Client calls the REST API at a base URL
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// New creates a client for the API at baseURL (http://localhost:3000)
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Effort is a best performance for a rankable event
+type Effort struct {
+	Event    string  `json:"event"`
+	Kind     string  `json:"kind"`     // distance or time
+	Distance float64 `json:"distance"` // meters
+	Time     float64 `json:"time"`     // seconds
+	Pace     float64 `json:"pace"`     // seconds per 500m
+	Offset   float64 `json:"offset"`   // seconds into the workout
+	Whole    bool    `json:"whole"`
+}
+
+// Record is a personal best set by a saved workout
+type Record struct {
+	Effort
+	WorkoutID string    `json:"workout_id"`
+	RowedAt   time.Time `json:"rowed_at"`
+	Previous  *Effort   `json:"previous,omitempty"`
+}
+
+// SavedWorkout is the API's response to saving a workout
+type SavedWorkout struct {
+	Workout struct {
+		ID string `json:"id"`
+	} `json:"workout"`
+	PersonalBests []Record `json:"personal_bests"`
+}
+
+// workoutRequest is the body of POST /api/v1/workouts
+type workoutRequest struct {
+	SessionID   string              `json:"session_id"`
+	Serial      string              `json:"serial,omitempty"`
+	WorkoutType string              `json:"workout_type"`
+	StartedAt   time.Time           `json:"started_at"`
+	Samples     []pm5.SessionSample `json:"samples"`
+}
+
+// SaveWorkout stores a recorded session as the user identified by token,
+// a Firebase ID token
+func (c *Client) SaveWorkout(ctx context.Context, token string, session *pm5.Session) (*SavedWorkout, error) {
+	body, err := json.Marshal(workoutRequest{
+		SessionID:   session.ID,
+		Serial:      session.Serial,
+		WorkoutType: session.WorkoutType,
+		StartedAt:   session.StartedAt,
+		Samples:     session.Samples,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode workout: %w", err)
+	}
+
+	var saved SavedWorkout
+	err = c.do(ctx, http.MethodPost, "/api/v1/workouts", token, body, &saved)
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// Template is a saved workout whose body is the parameters to start it with
+type Template struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	pm5.WorkoutParams
+}
+
+// Template fetches one of the user's workout templates, or one shared with
+// a team they belong to when teamID is set
+func (c *Client) Template(ctx context.Context, token, teamID, id string) (*Template, error) {
+	path := "/api/v1/templates/" + url.PathEscape(id)
+	if teamID != "" {
+		path = "/api/v1/teams/" + url.PathEscape(teamID) + "/templates/" + url.PathEscape(id)
+	}
+
+	var template Template
+	if err := c.do(ctx, http.MethodGet, path, token, nil, &template); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// Profile is the signed-in user's profile
+type Profile struct {
+	DisplayName string  `json:"display_name"`
+	Weight      float64 `json:"weight"` // kg, 0 if unknown
+	MaxHR       int     `json:"max_hr"`
+	WeightClass string  `json:"weight_class"`
+	ErgType     string  `json:"erg_type"`
+}
+
+// Profile fetches the user's profile
+func (c *Client) Profile(ctx context.Context, token string) (*Profile, error) {
+	var profile Profile
+	if err := c.do(ctx, http.MethodGet, "/api/v1/profile", token, nil, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// PlannedSession is a training plan session due today
+type PlannedSession struct {
+	PlanID     string    `json:"plan_id"`
+	PlanName   string    `json:"plan_name"`
+	Date       string    `json:"date"`
+	TemplateID string    `json:"template_id"`
+	Notes      string    `json:"notes,omitempty"`
+	Status     string    `json:"status"` // pending, partial or completed
+	Template   *Template `json:"template"`
+}
+
+// TodaysSessions fetches the sessions the user's plans have due on date
+// (YYYY-MM-DD), or today in each plan's time zone when date is empty
+func (c *Client) TodaysSessions(ctx context.Context, token, date string) ([]PlannedSession, error) {
+	path := "/api/v1/plans/today"
+	if date != "" {
+		path += "?date=" + url.QueryEscape(date)
+	}
+
+	var sessions []PlannedSession
+	if err := c.do(ctx, http.MethodGet, path, token, nil, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// SpectatorShare is a link letting anyone who has it watch one erg's live
+// stats
+type SpectatorShare struct {
+	Serial    string    `json:"serial"` // the PM5 the share is for
+	Label     string    `json:"label,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SpectatorShare looks up a spectator share token minted through the API,
+// authenticating with the socket server's relay key rather than a sign-in.
+// It returns ErrNotFound for an unknown or revoked token, or one minted
+// by a user other than the relay key's, and ErrExpired for an expired one.
+func (c *Client) SpectatorShare(ctx context.Context, relayKey, token string) (*SpectatorShare, error) {
+	var share SpectatorShare
+	if err := c.do(ctx, http.MethodGet, "/api/v1/spectate/"+url.PathEscape(token), relayKey, nil, &share); err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// do sends a request and decodes a JSON response into out. Requests
+// without a token are sent unauthenticated.
+func (c *Client) do(ctx context.Context, method, path, token string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusConflict:
+		return ErrAlreadySaved
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusGone:
+		return ErrExpired
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("API returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode API response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+
+	"github.com/danhigham/ergometer.live/socketserver"
+)
+
+// webFiles holds the static test pages from web/
+//
+//go:embed web
+var webFiles embed.FS
+
+// uiFiles holds the built Vue app. Build it with `npm run build` in ui/
+// before compiling; until then ui/dist only holds a placeholder. all: keeps
+// Vite's underscore-prefixed chunks; the static handler doesn't serve the
+// .gitkeep placeholder it also embeds.
+//
+//go:embed all:ui/dist
+var uiFiles embed.FS
+
+// embeddedAssets returns the static files compiled into the binary. The
+// built Vue app takes precedence over the test pages when it is embedded.
+func embeddedAssets() fs.FS {
+	web, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		log.Fatalf("Failed to load embedded web files: %v", err)
+	}
+
+	ui := uiAssets()
+	if ui == nil {
+		return web
+	}
+
+	return socketserver.LayeredFS{ui, web}
+}
+
+// uiAssets returns the embedded Vue app, or nil when it hasn't been built
+func uiAssets() fs.FS {
+	ui, err := fs.Sub(uiFiles, "ui/dist")
+	if err != nil {
+		log.Fatalf("Failed to load embedded UI files: %v", err)
+	}
+
+	if _, err := fs.Stat(ui, "index.html"); err != nil {
+		return nil
+	}
+	return ui
+}
@@ -2,6 +2,7 @@ package broadcast
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -20,7 +21,7 @@ const (
 	// Maximum message size allowed from peer
 	maxMessageSize = 8192
 
-	// Buffer size for client send channel
+	// Default buffer size for client send channel
 	sendBufferSize = 256
 )
 
@@ -33,24 +34,74 @@ type Client struct {
 
 	// Buffered channel of outbound messages
 	send chan []byte
+
+	// Guards Send against the hub closing send, as handlers may reply
+	// after the client has gone
+	mu     sync.Mutex
+	closed bool
+
+	// Close frame written once the hub closes the send channel
+	closeMessage []byte
+
+	// Closed when the write pump has exited
+	done chan struct{}
+
+	// What the client may see and do, enforced by the hub
+	access Access
+}
+
+// Access limits what a client may see and do. The zero value gives full
+// access.
+type Access struct {
+	// ReadOnly clients' inbound messages are dropped
+	ReadOnly bool
+
+	// Filter rewrites each broadcast for the client, returning nil to
+	// withhold it. nil passes every broadcast unchanged.
+	Filter func(message []byte) []byte
+
+	// Grant identifies what the access was granted by, so it can be
+	// revoked with Hub.Revoke
+	Grant string
+
+	// ExpiresAt is when the hub disconnects the client; zero never expires
+	ExpiresAt time.Time
 }
 
-// NewClient creates a new Client instance
+// NewClient creates a new Client instance with full access
 func NewClient(hub *Hub, conn *websocket.Conn) *Client {
+	return NewClientWithAccess(hub, conn, Access{})
+}
+
+// NewClientWithAccess creates a new Client instance limited by access
+func NewClientWithAccess(hub *Hub, conn *websocket.Conn, access Access) *Client {
 	return &Client{
-		hub:  hub,
-		conn: conn,
-		send: make(chan []byte, sendBufferSize),
+		hub:    hub,
+		conn:   conn,
+		send:   make(chan []byte, hub.clientBufferSize),
+		done:   make(chan struct{}),
+		access: access,
 	}
 }
 
+// closeWith sets the close frame sent to the peer and closes the send
+// channel. Must only be called by the hub.
+func (c *Client) closeWith(code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeMessage = websocket.FormatCloseMessage(code, reason)
+	c.closed = true
+	close(c.send)
+}
+
 // readPump pumps messages from the websocket connection to the hub
 // The application runs readPump in a per-connection goroutine. The application
 // ensures that there is at most one reader on a connection by executing all
 // reads from this goroutine.
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.Unregister(c)
 		c.conn.Close()
 	}()
 
@@ -71,9 +122,10 @@ func (c *Client) readPump() {
 		}
 
 		// Send message to hub for processing
-		c.hub.inbound <- &InboundMessage{
-			client:  c,
-			message: message,
+		select {
+		case c.hub.inbound <- &InboundMessage{client: c, message: message}:
+		case <-c.hub.shutdown:
+			return
 		}
 	}
 }
@@ -87,6 +139,7 @@ func (c *Client) writePump() {
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
+		close(c.done)
 	}()
 
 	for {
@@ -95,7 +148,11 @@ func (c *Client) writePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				closeMessage := c.closeMessage
+				if closeMessage == nil {
+					closeMessage = websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
 
@@ -131,8 +188,16 @@ func (c *Client) Run() {
 	go c.readPump()
 }
 
-// Send queues a message to be sent to the client
+// Send queues a message to be sent to the client. Messages to a closed
+// client are dropped.
 func (c *Client) Send(message []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+
 	select {
 	case c.send <- message:
 		// Message queued successfully
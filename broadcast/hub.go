@@ -1,10 +1,18 @@
 package broadcast
 
 import (
+	"context"
 	"log"
 	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
+// expiryCheckInterval is how often the hub disconnects clients whose
+// access has expired
+const expiryCheckInterval = time.Second
+
 // InboundMessage represents a message received from a client
 type InboundMessage struct {
 	client  *Client
@@ -14,6 +22,9 @@ type InboundMessage struct {
 // MessageHandler is a function that processes inbound messages from clients
 type MessageHandler func(client *Client, message []byte)
 
+// DisconnectHandler is a function called after a client is unregistered
+type DisconnectHandler func(client *Client)
+
 // Hub maintains the set of active clients and broadcasts messages to the clients
 type Hub struct {
 	// Registered clients
@@ -31,25 +42,70 @@ type Hub struct {
 	// Unregister requests from clients
 	unregister chan *Client
 
+	// Grants whose clients are to be disconnected
+	revoke chan string
+
 	// Shutdown signal
-	shutdown chan struct{}
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+
+	// Clients closed during shutdown, waited on until their close frames
+	// have been written
+	closing []*Client
 
-	// Wait group for graceful shutdown
-	wg sync.WaitGroup
+	// Closed when Run returns
+	stopped chan struct{}
 
 	// Handler for inbound messages
 	messageHandler MessageHandler
+
+	// Handler for client disconnects
+	disconnectHandler DisconnectHandler
+
+	// Send queue size for new clients
+	clientBufferSize int
+
+	// Channels receiving every broadcast message in-process, closed at
+	// shutdown
+	subscribersMu sync.Mutex
+	subscribers   map[chan []byte]struct{}
+	closed        bool
+}
+
+// Options configures the queue sizes used by a Hub and its clients
+type Options struct {
+	BroadcastBufferSize int
+	InboundBufferSize   int
+	ClientBufferSize    int
+}
+
+// DefaultOptions returns the queue sizes used by NewHub
+func DefaultOptions() Options {
+	return Options{
+		BroadcastBufferSize: 256,
+		InboundBufferSize:   256,
+		ClientBufferSize:    sendBufferSize,
+	}
 }
 
 // NewHub creates a new Hub instance
 func NewHub() *Hub {
+	return NewHubWithOptions(DefaultOptions())
+}
+
+// NewHubWithOptions creates a new Hub instance with the given queue sizes
+func NewHubWithOptions(opts Options) *Hub {
 	return &Hub{
-		clients:    make(map[*Client]bool),
-		inbound:    make(chan *InboundMessage, 256),
-		broadcast:  make(chan []byte, 256),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		shutdown:   make(chan struct{}),
+		clients:          make(map[*Client]bool),
+		inbound:          make(chan *InboundMessage, opts.InboundBufferSize),
+		broadcast:        make(chan []byte, opts.BroadcastBufferSize),
+		register:         make(chan *Client),
+		unregister:       make(chan *Client),
+		revoke:           make(chan string),
+		shutdown:         make(chan struct{}),
+		stopped:          make(chan struct{}),
+		clientBufferSize: opts.ClientBufferSize,
+		subscribers:      make(map[chan []byte]struct{}),
 	}
 }
 
@@ -58,10 +114,17 @@ func (h *Hub) SetMessageHandler(handler MessageHandler) {
 	h.messageHandler = handler
 }
 
+// SetDisconnectHandler sets the handler function for client disconnects
+func (h *Hub) SetDisconnectHandler(handler DisconnectHandler) {
+	h.disconnectHandler = handler
+}
+
 // Run starts the hub's main loop
 func (h *Hub) Run() {
-	h.wg.Add(1)
-	defer h.wg.Done()
+	defer close(h.stopped)
+
+	expiry := time.NewTicker(expiryCheckInterval)
+	defer expiry.Stop()
 
 	for {
 		select {
@@ -72,8 +135,12 @@ func (h *Hub) Run() {
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				close(client.send)
+				client.closeWith(websocket.CloseNormalClosure, "")
 				log.Printf("Client unregistered. Total clients: %d", len(h.clients))
+
+				if h.disconnectHandler != nil {
+					go h.disconnectHandler(client)
+				}
 			}
 
 		case message := <-h.broadcast:
@@ -81,34 +148,135 @@ func (h *Hub) Run() {
 			h.broadcastToClients(message)
 
 		case msg := <-h.inbound:
-			// Process inbound message from client
+			// Process inbound message from client; read-only clients
+			// can't send anything
+			if msg.client.access.ReadOnly {
+				continue
+			}
 			if h.messageHandler != nil {
 				go h.messageHandler(msg.client, msg.message)
 			}
 
+		case grant := <-h.revoke:
+			for client := range h.clients {
+				if client.access.Grant == grant {
+					h.remove(client, websocket.ClosePolicyViolation, "access revoked")
+				}
+			}
+
+		case now := <-expiry.C:
+			for client := range h.clients {
+				if expires := client.access.ExpiresAt; !expires.IsZero() && !now.Before(expires) {
+					h.remove(client, websocket.ClosePolicyViolation, "access expired")
+				}
+			}
+
 		case <-h.shutdown:
-			// Close all client connections
+			// Deliver anything already queued, such as final workout
+			// messages, before closing the connections
+			h.drainBroadcast()
+
+			// Close all client connections with a close frame
 			for client := range h.clients {
-				close(client.send)
+				client.closeWith(websocket.CloseGoingAway, "server shutting down")
 				delete(h.clients, client)
+				h.closing = append(h.closing, client)
 			}
-			log.Println("Hub shutdown complete")
+			h.closeSubscribers()
 			return
 		}
 	}
 }
 
-// broadcastToClients sends a message to all registered clients
+// broadcastToClients sends a message to all registered clients, filtered
+// by each client's access
 func (h *Hub) broadcastToClients(message []byte) {
 	for client := range h.clients {
+		filtered := message
+		if client.access.Filter != nil {
+			if filtered = client.access.Filter(message); filtered == nil {
+				continue
+			}
+		}
+
 		select {
-		case client.send <- message:
+		case client.send <- filtered:
 			// Message sent successfully
 		default:
 			// Client's send channel is full, close and remove the client
 			log.Printf("Client send buffer full, removing slow client")
-			close(client.send)
-			delete(h.clients, client)
+			h.remove(client, websocket.CloseTryAgainLater, "client too slow")
+		}
+	}
+
+	h.subscribersMu.Lock()
+	defer h.subscribersMu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- message:
+		default:
+			// Subscribers miss messages rather than holding up clients
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every message broadcast, and a
+// function to unsubscribe. The channel is closed when the hub shuts down,
+// after any queued messages have been delivered.
+func (h *Hub) Subscribe() (<-chan []byte, func()) {
+	ch := make(chan []byte, h.clientBufferSize)
+
+	h.subscribersMu.Lock()
+	defer h.subscribersMu.Unlock()
+
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subscribers[ch] = struct{}{}
+
+	unsubscribe := func() {
+		h.subscribersMu.Lock()
+		defer h.subscribersMu.Unlock()
+
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// closeSubscribers closes every subscriber's channel
+func (h *Hub) closeSubscribers() {
+	h.subscribersMu.Lock()
+	defer h.subscribersMu.Unlock()
+
+	h.closed = true
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// remove closes a registered client with a close frame and removes it
+func (h *Hub) remove(client *Client, code int, reason string) {
+	client.closeWith(code, reason)
+	delete(h.clients, client)
+
+	if h.disconnectHandler != nil {
+		go h.disconnectHandler(client)
+	}
+}
+
+// drainBroadcast delivers any messages still queued for broadcast
+func (h *Hub) drainBroadcast() {
+	for {
+		select {
+		case message := <-h.broadcast:
+			h.broadcastToClients(message)
+		default:
+			return
 		}
 	}
 }
@@ -124,20 +292,60 @@ func (h *Hub) Broadcast(message []byte) {
 	}
 }
 
-// Register queues a client for registration
+// Register queues a client for registration. Clients registering after
+// shutdown are closed immediately.
 func (h *Hub) Register(client *Client) {
-	h.register <- client
+	select {
+	case h.register <- client:
+	case <-h.shutdown:
+		client.closeWith(websocket.CloseGoingAway, "server shutting down")
+	}
 }
 
 // Unregister queues a client for removal
 func (h *Hub) Unregister(client *Client) {
-	h.unregister <- client
+	select {
+	case h.unregister <- client:
+	case <-h.shutdown:
+	}
+}
+
+// Revoke disconnects every client whose access was granted by grant
+func (h *Hub) Revoke(grant string) {
+	if grant == "" {
+		return
+	}
+
+	select {
+	case h.revoke <- grant:
+	case <-h.shutdown:
+	}
 }
 
-// Shutdown gracefully shuts down the hub
-func (h *Hub) Shutdown() {
-	close(h.shutdown)
-	h.wg.Wait()
+// Shutdown gracefully shuts down the hub, sending every client a close
+// frame and waiting until they are written or ctx is done
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.shutdownOnce.Do(func() {
+		close(h.shutdown)
+	})
+
+	select {
+	case <-h.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for _, client := range h.closing {
+		select {
+		case <-client.done:
+		case <-ctx.Done():
+			log.Printf("Hub shutdown timed out waiting for clients")
+			return ctx.Err()
+		}
+	}
+
+	log.Println("Hub shutdown complete")
+	return nil
 }
 
 // ClientCount returns the number of connected clients
@@ -0,0 +1,178 @@
+package broadcast
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testHub is a running hub with a server connecting WebSockets to it
+type testHub struct {
+	*Hub
+	url        string
+	registered chan struct{} // receives once per client registered
+}
+
+// startHub runs a hub and a server connecting WebSockets to it with the
+// access returned by accessFor, which is given the request's query
+func startHub(t *testing.T, accessFor func(query string) Access) *testHub {
+	t.Helper()
+
+	h := &testHub{Hub: NewHub(), registered: make(chan struct{}, 8)}
+	go h.Run()
+
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClientWithAccess(h.Hub, conn, accessFor(r.URL.RawQuery))
+		h.Register(client)
+		client.Run()
+		h.registered <- struct{}{}
+	}))
+	t.Cleanup(func() {
+		h.Shutdown(context.Background())
+		srv.Close()
+	})
+
+	h.url = "ws" + strings.TrimPrefix(srv.URL, "http")
+	return h
+}
+
+// dial connects a client with query, returning once the hub has
+// registered it
+func (h *testHub) dial(t *testing.T, query string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.url+"/?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	select {
+	case <-h.registered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client never registered")
+	}
+	return conn
+}
+
+// readMessages reads the messages in the next frame from conn, split on
+// the newlines batched messages are joined with
+func readMessages(t *testing.T, conn *websocket.Conn) []string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, frame, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(string(frame), "\n")
+}
+
+func TestReadOnlyClientsInboundMessagesDropped(t *testing.T) {
+	h := startHub(t, func(query string) Access {
+		return Access{ReadOnly: query == "spectator"}
+	})
+
+	received := make(chan string, 4)
+	h.SetMessageHandler(func(client *Client, message []byte) {
+		received <- string(message)
+	})
+
+	spectator := h.dial(t, "spectator")
+	rower := h.dial(t, "rower")
+
+	if err := spectator.WriteMessage(websocket.TextMessage, []byte(`{"type":"stop_workout"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rower.WriteMessage(websocket.TextMessage, []byte(`{"type":"get_status"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The spectator's message, sent first, would be handled around the
+	// same time as the rower's had it not been dropped
+	select {
+	case message := <-received:
+		if message != `{"type":"get_status"}` {
+			t.Fatalf("handler received %s, want only the rower's message", message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the rower's message was never handled")
+	}
+	select {
+	case message := <-received:
+		t.Errorf("handler received %s as well", message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestFilterRewritesAndWithholdsBroadcasts(t *testing.T) {
+	h := startHub(t, func(query string) Access {
+		if query != "filtered" {
+			return Access{}
+		}
+		return Access{Filter: func(message []byte) []byte {
+			if bytes.HasPrefix(message, []byte("secret")) {
+				return nil
+			}
+			return bytes.ToUpper(message)
+		}}
+	})
+
+	filtered := h.dial(t, "filtered")
+	full := h.dial(t, "full")
+
+	h.Broadcast([]byte("secret"))
+	h.Broadcast([]byte("stats"))
+
+	got := readMessages(t, filtered)
+	if len(got) != 1 || got[0] != "STATS" {
+		t.Errorf("filtered client received %q, want [STATS]", got)
+	}
+
+	got = nil
+	for len(got) < 2 {
+		got = append(got, readMessages(t, full)...)
+	}
+	if len(got) != 2 || got[0] != "secret" || got[1] != "stats" {
+		t.Errorf("client without a filter received %q, want [secret stats]", got)
+	}
+}
+
+func TestClientsDisconnectedWhenAccessExpires(t *testing.T) {
+	expiresAt := time.Now().Add(100 * time.Millisecond)
+	h := startHub(t, func(query string) Access {
+		if query == "expiring" {
+			return Access{ExpiresAt: expiresAt}
+		}
+		return Access{}
+	})
+
+	expiring := h.dial(t, "expiring")
+	lasting := h.dial(t, "lasting")
+
+	expiring.SetReadDeadline(time.Now().Add(expiryCheckInterval + 2*time.Second))
+	_, _, err := expiring.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("expiring client read %v, want a policy violation close", err)
+	}
+	if time.Now().Before(expiresAt) {
+		t.Error("client disconnected before its access expired")
+	}
+
+	// Clients that don't expire stay connected
+	h.Broadcast([]byte("stats"))
+	if got := readMessages(t, lasting); len(got) != 1 || got[0] != "stats" {
+		t.Errorf("lasting client received %q, want [stats]", got)
+	}
+}
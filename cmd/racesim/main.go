@@ -0,0 +1,88 @@
+// Command racesim connects simulated ergs to a race coordinator so
+// networked races can be tried out on a single machine.
+//
+//	go run main.go -race-coordinator &
+//	go run ./cmd/racesim -lanes 4 -distance 500
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/danhigham/ergometer.live/race"
+)
+
+func main() {
+	coordinator := flag.String("coordinator", "ws://localhost:8080/race", "race coordinator URL")
+	lanes := flag.Int("lanes", 3, "number of simulated ergs")
+	distance := flag.Uint("distance", 500, "race distance in meters (0 joins without starting a race)")
+	countdown := flag.Int("countdown", 5, "countdown in seconds")
+	pace := flag.Float64("pace", 110, "pace of the first erg in seconds per 500m")
+	spread := flag.Float64("spread", 2, "pace difference between ergs in seconds per 500m")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var once sync.Once
+	finished := make(chan race.State, 1)
+
+	var first *race.Lane
+	for i := 0; i < *lanes; i++ {
+		erg := race.NewSimulatedErg(*pace+float64(i)**spread, 28)
+		name := fmt.Sprintf("Sim %d", i+1)
+
+		lane := race.NewLane(*coordinator, name, erg, func(msgType string, data interface{}) {
+			switch msgType {
+			case race.MsgCountdown:
+				if c, ok := data.(race.Countdown); ok && c.Remaining > 0 {
+					log.Printf("%s: %d", name, c.Remaining)
+				}
+
+			case race.MsgRaceState:
+				raw, ok := data.(json.RawMessage)
+				if !ok {
+					return
+				}
+				var state race.State
+				if err := json.Unmarshal(raw, &state); err == nil &&
+					(state.Status == race.StatusFinished || state.Status == race.StatusCancelled) {
+					once.Do(func() { finished <- state })
+				}
+			}
+		})
+		go lane.Run(ctx)
+
+		if first == nil {
+			first = lane
+		}
+	}
+
+	if *distance > 0 {
+		// Give the lanes a moment to join before starting
+		time.Sleep(time.Second)
+		if err := first.RequestStart(race.StartRequest{
+			Distance:         uint32(*distance),
+			CountdownSeconds: *countdown,
+		}); err != nil {
+			log.Fatalf("Failed to start race: %v", err)
+		}
+	}
+
+	select {
+	case state := <-finished:
+		fmt.Printf("\nRace %s (%dm) %s\n", state.RaceID, state.Distance, state.Status)
+		for _, result := range state.Results {
+			fmt.Printf("%2d. %-10s lane %d  %7.1fs\n", result.Place, result.Name, result.Lane, result.FinishTime)
+		}
+	case <-ctx.Done():
+	}
+}
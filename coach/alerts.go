@@ -0,0 +1,118 @@
+package coach
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Alert types
+const (
+	AlertDisconnected = "disconnected"
+	AlertStale        = "stale"
+	AlertIdle         = "idle"
+	AlertPaceOff      = "pace_off"
+	AlertRateOff      = "rate_off"
+)
+
+const (
+	// staleAfter is how long an active rower may go without an update
+	staleAfter = 5 * time.Second
+
+	// paceTolerance is how far a rower's pace may drift from the crew's
+	// median before an alert, in seconds per 500m
+	paceTolerance = 5.0
+
+	// rateTolerance is how far a rower's stroke rate may drift from the
+	// crew's median before an alert, in strokes per minute
+	rateTolerance = 2
+)
+
+// Alert flags something the coach should look at
+type Alert struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// crewAverages are the medians of the rowers currently rowing
+type crewAverages struct {
+	active int // rowers with an active workout
+	rowing int // active rowers with stats, used for the medians
+	pace   float64
+	rate   float64
+}
+
+// newCrewAverages computes the crew medians from snapshots
+func newCrewAverages(snapshots []Snapshot) crewAverages {
+	var crew crewAverages
+	var paces, rates []float64
+
+	for _, s := range snapshots {
+		if !s.Connected || !s.Active {
+			continue
+		}
+		crew.active++
+
+		if s.Stats == nil || s.Stats.Pace <= 0 {
+			continue
+		}
+		paces = append(paces, s.Stats.Pace)
+		rates = append(rates, float64(s.Stats.StrokeRate))
+	}
+
+	crew.rowing = len(paces)
+	crew.pace = median(paces)
+	crew.rate = median(rates)
+	return crew
+}
+
+// alertsFor returns the alerts for one rower
+func alertsFor(s Snapshot, crew crewAverages, now time.Time) []Alert {
+	alerts := []Alert{}
+
+	if !s.Connected {
+		return append(alerts, Alert{AlertDisconnected, "Erg is not connected"})
+	}
+
+	if !s.Active {
+		if crew.active > 0 {
+			alerts = append(alerts, Alert{AlertIdle, "Not rowing while the crew is"})
+		}
+		return alerts
+	}
+
+	if !s.UpdatedAt.IsZero() && now.Sub(s.UpdatedAt) > staleAfter {
+		alerts = append(alerts, Alert{AlertStale, fmt.Sprintf("No data for %.0fs", now.Sub(s.UpdatedAt).Seconds())})
+	}
+
+	// Compare against the rest of the crew only when there is one
+	if crew.rowing < 2 || s.Stats == nil || s.Stats.Pace <= 0 {
+		return alerts
+	}
+
+	if diff := s.Stats.Pace - crew.pace; diff > paceTolerance || diff < -paceTolerance {
+		alerts = append(alerts, Alert{AlertPaceOff, fmt.Sprintf("Pace %+.1fs/500m from the crew", diff)})
+	}
+
+	if diff := float64(s.Stats.StrokeRate) - crew.rate; diff > rateTolerance || diff < -rateTolerance {
+		alerts = append(alerts, Alert{AlertRateOff, fmt.Sprintf("Rate %+.0f spm from the crew", diff)})
+	}
+
+	return alerts
+}
+
+// median returns the median of values, or 0 if there are none
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
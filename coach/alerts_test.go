@@ -0,0 +1,113 @@
+package coach
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danhigham/ergometer.live/pm5"
+)
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{"none", nil, 0},
+		{"one", []float64{120}, 120},
+		{"odd", []float64{125, 115, 120}, 120},
+		{"even", []float64{110, 130, 120, 100}, 115},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := append([]float64(nil), tt.values...)
+			if got := median(values); got != tt.want {
+				t.Errorf("median(%v) = %v, want %v", tt.values, got, tt.want)
+			}
+			for i := range values {
+				if values[i] != tt.values[i] {
+					t.Fatalf("median sorted its input: %v", values)
+				}
+			}
+		})
+	}
+}
+
+func TestNewCrewAverages(t *testing.T) {
+	crew := newCrewAverages([]Snapshot{
+		rowing(110, 30),
+		rowing(120, 28),
+		rowing(130, 26),
+		// Active but without stats yet
+		{Connected: true, Active: true},
+		// Not counted at all
+		{Connected: true},
+		{Active: true, Stats: &pm5.WorkoutStats{Pace: 90, StrokeRate: 40}},
+	})
+	if crew.active != 4 || crew.rowing != 3 || crew.pace != 120 || crew.rate != 28 {
+		t.Errorf("crew %+v, want 4 active, 3 rowing at 120s and 28 spm", crew)
+	}
+}
+
+func TestAlertsFor(t *testing.T) {
+	now := time.Date(2026, 10, 18, 7, 0, 0, 0, time.UTC)
+	crew := crewAverages{active: 3, rowing: 3, pace: 120, rate: 28}
+
+	tests := []struct {
+		name     string
+		snapshot Snapshot
+		crew     crewAverages
+		want     []string
+	}{
+		{"disconnected", Snapshot{}, crew, []string{AlertDisconnected}},
+		{"idle while the crew rows", Snapshot{Connected: true}, crew, []string{AlertIdle}},
+		{"idle with the crew", Snapshot{Connected: true}, crewAverages{}, nil},
+		{"with the crew", rowing(120, 28), crew, nil},
+		{"pace at the tolerance", rowing(125, 28), crew, nil},
+		{"pace behind", rowing(125.1, 28), crew, []string{AlertPaceOff}},
+		{"pace ahead", rowing(114.9, 28), crew, []string{AlertPaceOff}},
+		{"rate at the tolerance", rowing(120, 30), crew, nil},
+		{"rate high", rowing(120, 31), crew, []string{AlertRateOff}},
+		{"rate low", rowing(120, 25), crew, []string{AlertRateOff}},
+		{"both off", rowing(130, 34), crew, []string{AlertPaceOff, AlertRateOff}},
+		{"alone", rowing(130, 34), crewAverages{active: 1, rowing: 1, pace: 130, rate: 34}, nil},
+		{"no stats yet", Snapshot{Connected: true, Active: true}, crew, nil},
+		{
+			"stale",
+			Snapshot{Connected: true, Active: true, UpdatedAt: now.Add(-staleAfter - time.Second)},
+			crew,
+			[]string{AlertStale},
+		},
+		{
+			"not yet stale",
+			Snapshot{Connected: true, Active: true, UpdatedAt: now.Add(-staleAfter)},
+			crew,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alerts := alertsFor(tt.snapshot, tt.crew, now)
+			if alerts == nil {
+				t.Fatal("alertsFor returned nil, want an empty list")
+			}
+			if len(alerts) != len(tt.want) {
+				t.Fatalf("alerts %+v, want %v", alerts, tt.want)
+			}
+			for i, alert := range alerts {
+				if alert.Type != tt.want[i] {
+					t.Errorf("alerts %+v, want %v", alerts, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// rowing returns an active rower's snapshot at a pace and rate
+func rowing(pace float64, rate byte) Snapshot {
+	return Snapshot{
+		Connected: true,
+		Active:    true,
+		Stats:     &pm5.WorkoutStats{Pace: pace, StrokeRate: rate},
+	}
+}
@@ -0,0 +1,208 @@
+// Package coach aggregates the live state of several rowers into a
+// single crew_state feed so a coach can watch a whole squad on one screen.
+package coach
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danhigham/ergometer.live/broadcast"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// MsgCrewState is sent to coach connections once per tick
+	MsgCrewState = "crew_state"
+
+	// crewInterval is how often crew_state is sent
+	crewInterval = time.Second
+)
+
+// RowerState is one rower's entry in the crew state
+type RowerState struct {
+	Name        string  `json:"name"`
+	Serial      string  `json:"serial,omitempty"`
+	Connected   bool    `json:"connected"`
+	Active      bool    `json:"active"`
+	Pace        float64 `json:"pace"`         // seconds per 500m
+	StrokeRate  byte    `json:"stroke_rate"`  // strokes per minute
+	Power       uint32  `json:"power"`        // watts
+	HeartRate   byte    `json:"heart_rate"`   // bpm (0 = no data)
+	Distance    float64 `json:"distance"`     // meters
+	ElapsedTime float64 `json:"elapsed_time"` // seconds
+	Alerts      []Alert `json:"alerts"`
+}
+
+// CrewState is the combined state of every rower the coach follows
+type CrewState struct {
+	Rowers []RowerState `json:"rowers"`
+}
+
+// runner is a source that needs a connection kept open
+type runner interface {
+	Run(ctx context.Context)
+}
+
+// Feed serves crew_state to coach connections
+type Feed struct {
+	hub      *broadcast.Hub
+	upgrader websocket.Upgrader
+	sources  []Source
+
+	// Remote sources run until ctx is cancelled by Shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewFeed creates a Feed following sources, accepting connections with
+// upgrader
+func NewFeed(upgrader websocket.Upgrader, sources ...Source) *Feed {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Feed{
+		hub:      broadcast.NewHub(),
+		upgrader: upgrader,
+		sources:  sources,
+		ctx:      ctx,
+		cancel:   cancel,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Run connects remote sources and sends crew_state every tick until
+// Shutdown is called
+func (f *Feed) Run() {
+	go f.hub.Run()
+
+	f.mu.Lock()
+	if !f.closed {
+		for _, source := range f.sources {
+			if r, ok := source.(runner); ok {
+				f.wg.Add(1)
+				go func() {
+					defer f.wg.Done()
+					r.Run(f.ctx)
+				}()
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	ticker := time.NewTicker(crewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.broadcast(f.State())
+
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// ServeHTTP upgrades a coach connection
+func (f *Feed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := f.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Coach WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	client := broadcast.NewClient(f.hub, conn)
+	f.hub.Register(client)
+	client.Run()
+
+	// Send the current state straight away rather than on the next tick
+	if message, err := encode(f.State()); err == nil {
+		client.Send(message)
+	}
+}
+
+// Shutdown disconnects remote sources and closes all coach connections
+func (f *Feed) Shutdown(ctx context.Context) error {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+
+		f.mu.Lock()
+		f.closed = true
+		f.cancel()
+		f.mu.Unlock()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return f.hub.Shutdown(ctx)
+}
+
+// State returns the current crew state
+func (f *Feed) State() CrewState {
+	now := time.Now()
+
+	snapshots := make([]Snapshot, len(f.sources))
+	for i, source := range f.sources {
+		snapshots[i] = source.Snapshot()
+	}
+	crew := newCrewAverages(snapshots)
+
+	state := CrewState{Rowers: make([]RowerState, len(f.sources))}
+	for i, source := range f.sources {
+		snapshot := snapshots[i]
+		rower := RowerState{
+			Name:      source.Name(),
+			Serial:    snapshot.Serial,
+			Connected: snapshot.Connected,
+			Active:    snapshot.Active,
+		}
+		if stats := snapshot.Stats; stats != nil {
+			rower.Pace = stats.Pace
+			rower.StrokeRate = stats.StrokeRate
+			rower.Power = stats.Power
+			rower.HeartRate = stats.HeartRate
+			rower.Distance = stats.Distance
+			rower.ElapsedTime = stats.ElapsedTime
+		}
+		rower.Alerts = alertsFor(snapshot, crew, now)
+		state.Rowers[i] = rower
+	}
+	return state
+}
+
+// broadcast sends the crew state to every coach connection
+func (f *Feed) broadcast(state CrewState) {
+	message, err := encode(state)
+	if err != nil {
+		log.Printf("Failed to marshal crew state: %v", err)
+		return
+	}
+	f.hub.Broadcast(message)
+}
+
+// encode marshals a crew_state message
+func encode(state CrewState) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":      MsgCrewState,
+		"data":      state,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,223 @@
+package coach
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/danhigham/ergometer.live/pm5"
+	"github.com/danhigham/ergometer.live/reconnect"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a message to a remote server
+	writeWait = 10 * time.Second
+)
+
+// Snapshot is a rower's latest state as seen by a source
+type Snapshot struct {
+	Connected bool
+	Active    bool
+	Serial    string
+	Stats     *pm5.WorkoutStats
+	UpdatedAt time.Time // zero when the source is always current
+}
+
+// Source is a rower the coach can follow
+type Source interface {
+	Name() string
+	Snapshot() Snapshot
+}
+
+// Erg is a device attached to this server
+type Erg interface {
+	DeviceInfo() *pm5.DeviceInfo
+	LatestStats() *pm5.WorkoutStats
+	IsWorkoutActive() bool
+}
+
+// localSource follows an erg attached to this server
+type localSource struct {
+	name string
+	erg  Erg
+}
+
+// NewLocalSource creates a source for an erg attached to this server
+func NewLocalSource(name string, erg Erg) Source {
+	return &localSource{name: name, erg: erg}
+}
+
+// Name implements Source
+func (s *localSource) Name() string {
+	return s.name
+}
+
+// Snapshot implements Source
+func (s *localSource) Snapshot() Snapshot {
+	snapshot := Snapshot{
+		Active: s.erg.IsWorkoutActive(),
+		Stats:  s.erg.LatestStats(),
+	}
+	if info := s.erg.DeviceInfo(); info != nil {
+		snapshot.Connected = info.Connected
+		snapshot.Serial = info.Serial
+	}
+	return snapshot
+}
+
+// RemoteSource follows the erg on another socket server by listening to
+// its /ws broadcasts. If that server drives several ergs the source
+// follows the first one it hears from.
+type RemoteSource struct {
+	name string
+	url  string
+
+	mu       sync.RWMutex
+	conn     *websocket.Conn
+	snapshot Snapshot
+}
+
+// NewRemoteSource creates a source for the socket server at url
+// (ws://host:8080/ws)
+func NewRemoteSource(name, url string) *RemoteSource {
+	return &RemoteSource{name: name, url: url}
+}
+
+// Name implements Source
+func (s *RemoteSource) Name() string {
+	return s.name
+}
+
+// Snapshot implements Source
+func (s *RemoteSource) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot := s.snapshot
+	snapshot.Connected = s.conn != nil && snapshot.Connected
+	return snapshot
+}
+
+// Run keeps the source connected until ctx is done
+func (s *RemoteSource) Run(ctx context.Context) {
+	reconnect.Run(ctx, "Coach source "+s.name, s.serve, nil)
+}
+
+// serve runs a single connection to the remote server
+func (s *RemoteSource) serve(ctx context.Context) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", s.url, err)
+	}
+
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	// Close the connection when ctx ends to unblock the read loop
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "coach shutting down"),
+				time.Now().Add(writeWait))
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	// Ask for the device status so the connection state is known before
+	// the first workout
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"get_status"}`)); err != nil {
+		return err
+	}
+
+	log.Printf("Coach following %s at %s", s.name, s.url)
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		// The hub may batch several messages per frame
+		for _, line := range bytes.Split(message, []byte{'\n'}) {
+			if len(line) > 0 {
+				s.handleMessage(line)
+			}
+		}
+	}
+}
+
+// remoteMessage is the socket server's broadcast envelope
+type remoteMessage struct {
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data"`
+	Serial string          `json:"serial"`
+}
+
+// handleMessage applies a message from the remote server
+func (s *RemoteSource) handleMessage(message []byte) {
+	var msg remoteMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Follow a single erg
+	if msg.Serial != "" {
+		if s.snapshot.Serial == "" {
+			s.snapshot.Serial = msg.Serial
+		} else if msg.Serial != s.snapshot.Serial {
+			return
+		}
+	}
+
+	switch msg.Type {
+	case "status":
+		var info pm5.DeviceInfo
+		if err := json.Unmarshal(msg.Data, &info); err == nil {
+			s.snapshot.Connected = info.Connected
+			if s.snapshot.Serial == "" {
+				s.snapshot.Serial = info.Serial
+			}
+		}
+
+	case "workout_stats":
+		var stats pm5.WorkoutStats
+		if err := json.Unmarshal(msg.Data, &stats); err == nil {
+			s.snapshot.Connected = true
+			s.snapshot.Active = true
+			s.snapshot.Stats = &stats
+			s.snapshot.UpdatedAt = time.Now()
+		}
+
+	case "workout_state":
+		var state pm5.WorkoutStateInfo
+		if err := json.Unmarshal(msg.Data, &state); err == nil {
+			s.snapshot.Connected = true
+			s.snapshot.Active = state.IsActive
+			s.snapshot.UpdatedAt = time.Now()
+		}
+
+	case "workout_ended":
+		s.snapshot.Active = false
+	}
+}
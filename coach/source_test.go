@@ -0,0 +1,62 @@
+package coach
+
+import (
+	"testing"
+)
+
+func TestRemoteSourceHandleMessage(t *testing.T) {
+	s := NewRemoteSource("Bow", "ws://localhost:8080/ws")
+
+	s.handleMessage([]byte(`{"type":"status","data":{"connected":true,"serial":"430000001"}}`))
+	if !s.snapshot.Connected || s.snapshot.Serial != "430000001" || s.snapshot.Active {
+		t.Fatalf("snapshot after status %+v", s.snapshot)
+	}
+
+	s.handleMessage([]byte(`{"type":"workout_stats","serial":"430000001","data":{"distance":250,"pace":118.5,"stroke_rate":29}}`))
+	if !s.snapshot.Active || s.snapshot.Stats == nil || s.snapshot.Stats.Distance != 250 ||
+		s.snapshot.Stats.Pace != 118.5 || s.snapshot.UpdatedAt.IsZero() {
+		t.Fatalf("snapshot after stats %+v", s.snapshot)
+	}
+
+	// Another erg on the same server is ignored
+	s.handleMessage([]byte(`{"type":"workout_stats","serial":"430000002","data":{"distance":900}}`))
+	if s.snapshot.Stats.Distance != 250 {
+		t.Errorf("stats of another erg were applied: %+v", s.snapshot.Stats)
+	}
+
+	// Malformed messages change nothing
+	s.handleMessage([]byte(`not json`))
+	s.handleMessage([]byte(`{"type":"workout_stats","data":"nope"}`))
+	if s.snapshot.Stats.Distance != 250 || !s.snapshot.Active {
+		t.Errorf("snapshot after malformed messages %+v", s.snapshot)
+	}
+
+	s.handleMessage([]byte(`{"type":"workout_state","data":{"is_active":false}}`))
+	if s.snapshot.Active {
+		t.Error("still active after an inactive workout state")
+	}
+	s.handleMessage([]byte(`{"type":"workout_state","data":{"is_active":true}}`))
+	if !s.snapshot.Active {
+		t.Error("not active after an active workout state")
+	}
+
+	s.handleMessage([]byte(`{"type":"workout_ended","serial":"430000001"}`))
+	if s.snapshot.Active || s.snapshot.Serial != "430000001" {
+		t.Errorf("snapshot after workout ended %+v", s.snapshot)
+	}
+
+	// The snapshot is only connected while the source is
+	if s.Snapshot().Connected {
+		t.Error("snapshot connected without a connection to the server")
+	}
+}
+
+func TestRemoteSourceFollowsFirstErg(t *testing.T) {
+	s := NewRemoteSource("Stroke", "ws://localhost:8080/ws")
+
+	s.handleMessage([]byte(`{"type":"workout_stats","serial":"430000002","data":{"distance":100}}`))
+	s.handleMessage([]byte(`{"type":"workout_stats","serial":"430000001","data":{"distance":500}}`))
+	if s.snapshot.Serial != "430000002" || s.snapshot.Stats.Distance != 100 {
+		t.Errorf("snapshot %+v, want the first erg heard from", s.snapshot)
+	}
+}
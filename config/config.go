@@ -0,0 +1,442 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the WebSocket server configuration
+type Config struct {
+	// HTTP listener
+	ListenAddr string `yaml:"listen_addr" toml:"listen_addr"`
+	TLSCert    string `yaml:"tls_cert" toml:"tls_cert"`
+	TLSKey     string `yaml:"tls_key" toml:"tls_key"`
+
+	// Serve static files from disk instead of the embedded UI (development)
+	StaticDir string `yaml:"static_dir" toml:"static_dir"`
+
+	// Comma separated list of origins allowed to open a WebSocket ("*" allows all)
+	AllowedOrigins string `yaml:"allowed_origins" toml:"allowed_origins"`
+
+	// PM5 device
+	DeviceSerial       string        `yaml:"device_serial" toml:"device_serial"`
+	AllDevices         bool          `yaml:"all_devices" toml:"all_devices"`
+	Debug              bool          `yaml:"debug" toml:"debug"`
+	PollIntervalActive time.Duration `yaml:"poll_interval_active" toml:"poll_interval_active"`
+	PollIntervalIdle   time.Duration `yaml:"poll_interval_idle" toml:"poll_interval_idle"`
+	PollIntervalCheck  time.Duration `yaml:"poll_interval_check" toml:"poll_interval_check"`
+
+	// Directory where workouts are recorded for pace boat racing (empty disables)
+	SessionsDir string `yaml:"sessions_dir" toml:"sessions_dir"`
+
+	// Number of recorded sessions kept, oldest deleted first (0 keeps all)
+	MaxSessions int `yaml:"max_sessions" toml:"max_sessions"`
+
+	// Networked racing: host a race coordinator and/or join one as a lane
+	RaceCoordinator bool   `yaml:"race_coordinator" toml:"race_coordinator"`
+	RaceJoin        string `yaml:"race_join" toml:"race_join"`
+	RaceName        string `yaml:"race_name" toml:"race_name"`
+
+	// REST API that workouts are saved to (http://localhost:3000)
+	APIURL string `yaml:"api_url" toml:"api_url"`
+
+	// Relay key, created through the API, that publishes the live stream
+	// to the API for remote viewers and coaches (empty disables)
+	RelayKey string `yaml:"relay_key" toml:"relay_key"`
+
+	// Coach feed at /coach. Sources are comma separated name=target pairs
+	// where target is a local device serial or a remote ws:// /ws URL.
+	Coach        bool   `yaml:"coach" toml:"coach"`
+	CoachSources string `yaml:"coach_sources" toml:"coach_sources"`
+
+	// Buffer sizes
+	BroadcastBufferSize int `yaml:"broadcast_buffer_size" toml:"broadcast_buffer_size"`
+	InboundBufferSize   int `yaml:"inbound_buffer_size" toml:"inbound_buffer_size"`
+	ClientBufferSize    int `yaml:"client_buffer_size" toml:"client_buffer_size"`
+	ReadBufferSize      int `yaml:"read_buffer_size" toml:"read_buffer_size"`
+	WriteBufferSize     int `yaml:"write_buffer_size" toml:"write_buffer_size"`
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	return &Config{
+		ListenAddr:          ":8080",
+		AllowedOrigins:      "*",
+		PollIntervalActive:  100 * time.Millisecond,
+		PollIntervalIdle:    1 * time.Second,
+		PollIntervalCheck:   500 * time.Millisecond,
+		SessionsDir:         "sessions",
+		MaxSessions:         100,
+		BroadcastBufferSize: 256,
+		InboundBufferSize:   256,
+		ClientBufferSize:    256,
+		ReadBufferSize:      1024,
+		WriteBufferSize:     1024,
+	}
+}
+
+// Load builds the configuration from defaults, an optional YAML or TOML
+// file, ERGOMETER_* environment variables and command line flags, in
+// increasing order of precedence. The file is selected with -config or
+// ERGOMETER_CONFIG.
+func Load(args []string) (*Config, error) {
+	// First pass only discovers the config file so that flags can be
+	// re-applied on top of the file and environment values afterwards
+	probe := flag.NewFlagSet("ergometer", flag.ContinueOnError)
+	probe.SetOutput(io.Discard)
+	configPath := probe.String("config", os.Getenv("ERGOMETER_CONFIG"), "")
+	bindFlags(probe, Default())
+	if err := probe.Parse(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return nil, err
+	}
+
+	cfg := Default()
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	fs := flag.NewFlagSet("ergometer", flag.ContinueOnError)
+	fs.String("config", *configPath, "path to a YAML or TOML config file")
+	bindFlags(fs, cfg)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// bindFlags registers a flag for every config field, using the current
+// values as defaults
+func bindFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.ListenAddr, "addr", cfg.ListenAddr, "listen address")
+	fs.StringVar(&cfg.StaticDir, "static-dir", cfg.StaticDir, "serve static files from this directory instead of the embedded UI")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "TLS certificate file")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "TLS private key file")
+	fs.StringVar(&cfg.AllowedOrigins, "allowed-origins", cfg.AllowedOrigins, "comma separated WebSocket origins (* for any)")
+	fs.StringVar(&cfg.DeviceSerial, "device-serial", cfg.DeviceSerial, "serial number of the PM5 to connect to (default first found)")
+	fs.BoolVar(&cfg.AllDevices, "all-devices", cfg.AllDevices, "connect to every PM5 found instead of just one")
+	fs.BoolVar(&cfg.Debug, "debug", cfg.Debug, "enable PM5 debug logging")
+	fs.DurationVar(&cfg.PollIntervalActive, "poll-active", cfg.PollIntervalActive, "polling interval during workouts")
+	fs.DurationVar(&cfg.PollIntervalIdle, "poll-idle", cfg.PollIntervalIdle, "polling interval when idle")
+	fs.DurationVar(&cfg.PollIntervalCheck, "poll-check", cfg.PollIntervalCheck, "initial polling interval")
+	fs.StringVar(&cfg.SessionsDir, "sessions-dir", cfg.SessionsDir, "directory for recorded sessions (empty disables recording)")
+	fs.IntVar(&cfg.MaxSessions, "max-sessions", cfg.MaxSessions, "number of recorded sessions to keep, deleting the oldest (0 keeps all)")
+	fs.BoolVar(&cfg.RaceCoordinator, "race-coordinator", cfg.RaceCoordinator, "host a race coordinator at /race")
+	fs.StringVar(&cfg.RaceJoin, "race-join", cfg.RaceJoin, "race coordinator URL to join as a lane (ws://host:8080/race)")
+	fs.StringVar(&cfg.RaceName, "race-name", cfg.RaceName, "name shown for this lane in races")
+	fs.StringVar(&cfg.APIURL, "api-url", cfg.APIURL, "REST API base URL that workouts are saved to")
+	fs.StringVar(&cfg.RelayKey, "relay-key", cfg.RelayKey, "relay key that publishes the live stream through the REST API")
+	fs.BoolVar(&cfg.Coach, "coach", cfg.Coach, "serve the coach crew feed at /coach")
+	fs.StringVar(&cfg.CoachSources, "coach-sources", cfg.CoachSources, "comma separated name=serial or name=ws://host:8080/ws rowers for the coach feed")
+	fs.IntVar(&cfg.BroadcastBufferSize, "broadcast-buffer", cfg.BroadcastBufferSize, "hub broadcast queue size")
+	fs.IntVar(&cfg.InboundBufferSize, "inbound-buffer", cfg.InboundBufferSize, "hub inbound queue size")
+	fs.IntVar(&cfg.ClientBufferSize, "client-buffer", cfg.ClientBufferSize, "per-client send queue size")
+	fs.IntVar(&cfg.ReadBufferSize, "read-buffer", cfg.ReadBufferSize, "WebSocket read buffer size in bytes")
+	fs.IntVar(&cfg.WriteBufferSize, "write-buffer", cfg.WriteBufferSize, "WebSocket write buffer size in bytes")
+}
+
+// loadFile reads a YAML or TOML file, chosen by extension
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("unsupported config file type: %s", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv applies ERGOMETER_* environment variables
+func (c *Config) loadEnv() error {
+	envString("ERGOMETER_LISTEN_ADDR", &c.ListenAddr)
+	envString("ERGOMETER_STATIC_DIR", &c.StaticDir)
+	envString("ERGOMETER_TLS_CERT", &c.TLSCert)
+	envString("ERGOMETER_TLS_KEY", &c.TLSKey)
+	envString("ERGOMETER_ALLOWED_ORIGINS", &c.AllowedOrigins)
+	envString("ERGOMETER_DEVICE_SERIAL", &c.DeviceSerial)
+	envString("ERGOMETER_SESSIONS_DIR", &c.SessionsDir)
+	envString("ERGOMETER_RACE_JOIN", &c.RaceJoin)
+	envString("ERGOMETER_RACE_NAME", &c.RaceName)
+	envString("ERGOMETER_COACH_SOURCES", &c.CoachSources)
+	envString("ERGOMETER_API_URL", &c.APIURL)
+	envString("ERGOMETER_RELAY_KEY", &c.RelayKey)
+
+	if err := envBool("ERGOMETER_RACE_COORDINATOR", &c.RaceCoordinator); err != nil {
+		return err
+	}
+
+	if err := envBool("ERGOMETER_COACH", &c.Coach); err != nil {
+		return err
+	}
+
+	if err := envBool("ERGOMETER_ALL_DEVICES", &c.AllDevices); err != nil {
+		return err
+	}
+
+	if err := envBool("ERGOMETER_DEBUG", &c.Debug); err != nil {
+		return err
+	}
+
+	durations := map[string]*time.Duration{
+		"ERGOMETER_POLL_ACTIVE": &c.PollIntervalActive,
+		"ERGOMETER_POLL_IDLE":   &c.PollIntervalIdle,
+		"ERGOMETER_POLL_CHECK":  &c.PollIntervalCheck,
+	}
+	for key, target := range durations {
+		if err := envDuration(key, target); err != nil {
+			return err
+		}
+	}
+
+	ints := map[string]*int{
+		"ERGOMETER_BROADCAST_BUFFER": &c.BroadcastBufferSize,
+		"ERGOMETER_INBOUND_BUFFER":   &c.InboundBufferSize,
+		"ERGOMETER_CLIENT_BUFFER":    &c.ClientBufferSize,
+		"ERGOMETER_READ_BUFFER":      &c.ReadBufferSize,
+		"ERGOMETER_WRITE_BUFFER":     &c.WriteBufferSize,
+		"ERGOMETER_MAX_SESSIONS":     &c.MaxSessions,
+	}
+	for key, target := range ints {
+		if err := envInt(key, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the configuration for values the server cannot run with
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("invalid listen address %q: %w", c.ListenAddr, err))
+	}
+
+	if c.StaticDir != "" {
+		if info, err := os.Stat(c.StaticDir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("static directory %q does not exist", c.StaticDir))
+		}
+	}
+
+	if c.MaxSessions < 0 {
+		errs = append(errs, fmt.Errorf("max_sessions must not be negative, got %d", c.MaxSessions))
+	}
+
+	if c.AllDevices && c.DeviceSerial != "" {
+		errs = append(errs, errors.New("all_devices and device_serial cannot be used together"))
+	}
+
+	if c.RaceJoin != "" {
+		if u, err := url.Parse(c.RaceJoin); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("race_join must be a ws:// or wss:// URL, got %q", c.RaceJoin))
+		}
+	}
+
+	if c.APIURL != "" {
+		if u, err := url.Parse(c.APIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("api_url must be an http:// or https:// URL, got %q", c.APIURL))
+		}
+	}
+
+	if c.RelayKey != "" && c.APIURL == "" {
+		errs = append(errs, errors.New("relay_key requires api_url"))
+	}
+
+	if _, err := c.CoachSourceList(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key must be set together"))
+	}
+	for _, path := range []string{c.TLSCert, c.TLSKey} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("TLS file %q: %w", path, err))
+		}
+	}
+
+	intervals := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"poll_interval_active", c.PollIntervalActive},
+		{"poll_interval_idle", c.PollIntervalIdle},
+		{"poll_interval_check", c.PollIntervalCheck},
+	}
+	for _, interval := range intervals {
+		if interval.value < 10*time.Millisecond {
+			errs = append(errs, fmt.Errorf("%s must be at least 10ms, got %v", interval.name, interval.value))
+		}
+	}
+
+	buffers := []struct {
+		name  string
+		value int
+	}{
+		{"broadcast_buffer_size", c.BroadcastBufferSize},
+		{"inbound_buffer_size", c.InboundBufferSize},
+		{"client_buffer_size", c.ClientBufferSize},
+		{"read_buffer_size", c.ReadBufferSize},
+		{"write_buffer_size", c.WriteBufferSize},
+	}
+	for _, buffer := range buffers {
+		if buffer.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %d", buffer.name, buffer.value))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Origins returns the allowed origins as a list. An empty list means any
+// origin is accepted.
+func (c *Config) Origins() []string {
+	var origins []string
+	for _, origin := range strings.Split(c.AllowedOrigins, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			return nil
+		}
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// CoachSource is a rower followed by the coach feed
+type CoachSource struct {
+	Name   string
+	Serial string // local device, or empty for a remote server
+	URL    string // remote socket server, or empty for a local device
+}
+
+// CoachSourceList parses CoachSources
+func (c *Config) CoachSourceList() ([]CoachSource, error) {
+	var sources []CoachSource
+	for _, entry := range strings.Split(c.CoachSources, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, target, ok := strings.Cut(entry, "=")
+		name, target = strings.TrimSpace(name), strings.TrimSpace(target)
+		if !ok || name == "" || target == "" {
+			return nil, fmt.Errorf("coach_sources entries must be name=serial or name=url, got %q", entry)
+		}
+
+		if strings.Contains(target, "://") {
+			if u, err := url.Parse(target); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+				return nil, fmt.Errorf("coach source %s must be a ws:// or wss:// URL, got %q", name, target)
+			}
+			sources = append(sources, CoachSource{Name: name, URL: target})
+		} else {
+			sources = append(sources, CoachSource{Name: name, Serial: target})
+		}
+	}
+	return sources, nil
+}
+
+// RelayURL returns the API WebSocket URL the live stream is relayed to
+func (c *Config) RelayURL() string {
+	u, err := url.Parse(c.APIURL)
+	if err != nil {
+		return ""
+	}
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v1/relay/publish"
+	return u.String()
+}
+
+// TLSEnabled reports whether the server should serve HTTPS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCert != "" && c.TLSKey != ""
+}
+
+func envString(key string, target *string) {
+	if value := os.Getenv(key); value != "" {
+		*target = value
+	}
+}
+
+func envBool(key string, target *bool) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	*target = b
+	return nil
+}
+
+func envDuration(key string, target *time.Duration) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	*target = d
+	return nil
+}
+
+func envInt(key string, target *int) error {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	*target = n
+	return nil
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file named name to a temporary directory
+func writeConfig(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	yamlFile := writeConfig(t, "ergometer.yaml", "listen_addr: \":9000\"\nrace_name: file\n")
+	tomlFile := writeConfig(t, "ergometer.toml", "listen_addr = \":9000\"\nrace_name = \"file\"\n")
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		addr     string
+		raceName string
+	}{
+		{"defaults", nil, nil, ":8080", ""},
+		{"yaml file", []string{"-config", yamlFile}, nil, ":9000", "file"},
+		{"toml file", []string{"-config", tomlFile}, nil, ":9000", "file"},
+		{"file from the environment", nil, map[string]string{"ERGOMETER_CONFIG": yamlFile}, ":9000", "file"},
+		{"environment over file", []string{"-config", yamlFile}, map[string]string{"ERGOMETER_LISTEN_ADDR": ":9001"}, ":9001", "file"},
+		{"flag over environment", []string{"-config", yamlFile, "-addr", ":9002"}, map[string]string{"ERGOMETER_LISTEN_ADDR": ":9001"}, ":9002", "file"},
+		{"flag over file", []string{"-config", yamlFile, "-race-name", "flag"}, nil, ":9000", "flag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ERGOMETER_CONFIG", "")
+			t.Setenv("ERGOMETER_LISTEN_ADDR", "")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := Load(tt.args)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.ListenAddr != tt.addr || cfg.RaceName != tt.raceName {
+				t.Errorf("got addr %q, race name %q, want %q, %q", cfg.ListenAddr, cfg.RaceName, tt.addr, tt.raceName)
+			}
+			// Values set nowhere keep their defaults
+			if cfg.ClientBufferSize != Default().ClientBufferSize {
+				t.Errorf("client buffer %d, want the default", cfg.ClientBufferSize)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{"missing file", []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, nil},
+		{"unsupported file", []string{"-config", writeConfig(t, "ergometer.json", "{}")}, nil},
+		{"malformed file", []string{"-config", writeConfig(t, "ergometer.yaml", "listen_addr: [")}, nil},
+		{"bad environment value", nil, map[string]string{"ERGOMETER_DEBUG": "sometimes"}},
+		{"unknown flag", []string{"-nope"}, nil},
+		{"invalid result", []string{"-poll-active", "1ms"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ERGOMETER_CONFIG", "")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			if _, err := Load(tt.args); err == nil {
+				t.Error("Load succeeded")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cert := writeConfig(t, "cert.pem", "cert")
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		valid  bool
+	}{
+		{"defaults", func(c *Config) {}, true},
+		{"listen address without port", func(c *Config) { c.ListenAddr = "localhost" }, false},
+		{"missing static directory", func(c *Config) { c.StaticDir = filepath.Join(t.TempDir(), "missing") }, false},
+		{"static directory", func(c *Config) { c.StaticDir = t.TempDir() }, true},
+		{"all devices and a serial", func(c *Config) { c.AllDevices, c.DeviceSerial = true, "430000001" }, false},
+		{"race join over http", func(c *Config) { c.RaceJoin = "http://host:8080/race" }, false},
+		{"race join", func(c *Config) { c.RaceJoin = "ws://host:8080/race" }, true},
+		{"api url without scheme", func(c *Config) { c.APIURL = "localhost:3000" }, false},
+		{"relay key without api url", func(c *Config) { c.RelayKey = "key" }, false},
+		{"relay key", func(c *Config) { c.RelayKey, c.APIURL = "key", "http://localhost:3000" }, true},
+		{"malformed coach source", func(c *Config) { c.CoachSources = "alice" }, false},
+		{"coach sources", func(c *Config) { c.CoachSources = "alice=430000001, bob=ws://bob:8080/ws" }, true},
+		{"tls cert without key", func(c *Config) { c.TLSCert = cert }, false},
+		{"missing tls key", func(c *Config) { c.TLSCert, c.TLSKey = cert, filepath.Join(t.TempDir(), "key.pem") }, false},
+		{"tls", func(c *Config) { c.TLSCert, c.TLSKey = cert, cert }, true},
+		{"poll interval too short", func(c *Config) { c.PollIntervalIdle = time.Millisecond }, false},
+		{"empty buffer", func(c *Config) { c.ReadBufferSize = 0 }, false},
+		{"negative max sessions", func(c *Config) { c.MaxSessions = -1 }, false},
+		{"unlimited sessions", func(c *Config) { c.MaxSessions = 0 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(cfg)
+			if err := cfg.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -1,19 +1,36 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/danhigham/ergometer.live/config"
 	"github.com/danhigham/ergometer.live/socketserver"
 )
 
+// shutdownTimeout bounds how long a graceful shutdown may take
+const shutdownTimeout = 10 * time.Second
+
 func main() {
+	// Load configuration
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	log.Println("Starting Ergometer.Live WebSocket Server...")
 
 	// Create server
-	srv := socketserver.NewServer(":8080")
+	srv := socketserver.NewServer(cfg, embeddedAssets())
 
 	// Setup graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -31,7 +48,12 @@ func main() {
 	log.Println("\nReceived shutdown signal...")
 
 	// Shutdown server
-	srv.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown error: %v", err)
+	}
 
 	log.Println("Server stopped")
 }
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/danhigham/ergometer.live/pm5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "ergometer"
+
+// Source provides the live values for a single erg
+type Source interface {
+	DeviceInfo() *pm5.DeviceInfo
+	LatestStats() *pm5.WorkoutStats
+	IsWorkoutActive() bool
+}
+
+// Collector exposes the current erg values of every source as gauges
+// labelled by device serial. Values are read on each scrape so the
+// exported gauges always reflect the latest poll.
+type Collector struct {
+	mu      sync.RWMutex
+	sources []Source
+
+	connected   *prometheus.Desc
+	active      *prometheus.Desc
+	battery     *prometheus.Desc
+	pace        *prometheus.Desc
+	power       *prometheus.Desc
+	strokeRate  *prometheus.Desc
+	heartRate   *prometheus.Desc
+	distance    *prometheus.Desc
+	elapsedTime *prometheus.Desc
+	dragFactor  *prometheus.Desc
+	calories    *prometheus.Desc
+}
+
+// NewCollector creates a new Collector for the given sources
+func NewCollector(sources ...Source) *Collector {
+	labels := []string{"serial"}
+
+	return &Collector{
+		sources: sources,
+
+		connected: prometheus.NewDesc(namespace+"_connected",
+			"Whether the erg is connected (1) or not (0).", labels, nil),
+		active: prometheus.NewDesc(namespace+"_workout_active",
+			"Whether a workout is in progress (1) or not (0).", labels, nil),
+		battery: prometheus.NewDesc(namespace+"_battery_level_percent",
+			"Battery level reported by the monitor.", labels, nil),
+		pace: prometheus.NewDesc(namespace+"_pace_seconds",
+			"Current pace in seconds per 500m.", labels, nil),
+		power: prometheus.NewDesc(namespace+"_power_watts",
+			"Current power output in watts.", labels, nil),
+		strokeRate: prometheus.NewDesc(namespace+"_stroke_rate_spm",
+			"Current stroke rate in strokes per minute.", labels, nil),
+		heartRate: prometheus.NewDesc(namespace+"_heart_rate_bpm",
+			"Current heart rate in beats per minute (0 = no data).", labels, nil),
+		distance: prometheus.NewDesc(namespace+"_distance_meters",
+			"Distance rowed in the current workout.", labels, nil),
+		elapsedTime: prometheus.NewDesc(namespace+"_elapsed_time_seconds",
+			"Elapsed time in the current workout.", labels, nil),
+		dragFactor: prometheus.NewDesc(namespace+"_drag_factor",
+			"Current drag factor.", labels, nil),
+		calories: prometheus.NewDesc(namespace+"_calories",
+			"Calories burned in the current workout.", labels, nil),
+	}
+}
+
+// AddSource registers an additional erg with the collector
+func (c *Collector) AddSource(source Source) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sources = append(c.sources, source)
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connected
+	ch <- c.active
+	ch <- c.battery
+	ch <- c.pace
+	ch <- c.power
+	ch <- c.strokeRate
+	ch <- c.heartRate
+	ch <- c.distance
+	ch <- c.elapsedTime
+	ch <- c.dragFactor
+	ch <- c.calories
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	sources := c.sources
+	c.mu.RUnlock()
+
+	for _, source := range sources {
+		info := source.DeviceInfo()
+		if info == nil || info.Serial == "" {
+			// Without a serial there is no stable label to report under
+			continue
+		}
+		serial := info.Serial
+
+		ch <- gauge(c.connected, boolValue(info.Connected), serial)
+		ch <- gauge(c.battery, float64(info.Battery), serial)
+		ch <- gauge(c.active, boolValue(source.IsWorkoutActive()), serial)
+
+		stats := source.LatestStats()
+		if stats == nil {
+			continue
+		}
+
+		ch <- gauge(c.pace, stats.Pace, serial)
+		ch <- gauge(c.power, float64(stats.Power), serial)
+		ch <- gauge(c.strokeRate, float64(stats.StrokeRate), serial)
+		ch <- gauge(c.heartRate, float64(stats.HeartRate), serial)
+		ch <- gauge(c.distance, stats.Distance, serial)
+		ch <- gauge(c.elapsedTime, stats.ElapsedTime, serial)
+		ch <- gauge(c.dragFactor, float64(stats.DragFactor), serial)
+		ch <- gauge(c.calories, float64(stats.Calories), serial)
+	}
+}
+
+// gauge builds a constant gauge sample for the given serial
+func gauge(desc *prometheus.Desc, value float64, serial string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, serial)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
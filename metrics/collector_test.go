@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danhigham/ergometer.live/pm5"
+)
+
+// fakeSource is an erg with fixed values
+type fakeSource struct {
+	info   *pm5.DeviceInfo
+	stats  *pm5.WorkoutStats
+	active bool
+}
+
+func (s *fakeSource) DeviceInfo() *pm5.DeviceInfo    { return s.info }
+func (s *fakeSource) LatestStats() *pm5.WorkoutStats { return s.stats }
+func (s *fakeSource) IsWorkoutActive() bool          { return s.active }
+
+// scrape fetches the collector's gauges in the Prometheus text format
+func scrape(t *testing.T, collector *Collector) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	Handler(collector).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("scrape got %d", w.Code)
+	}
+	body, err := io.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestCollectorScrape(t *testing.T) {
+	rowing := &fakeSource{
+		info: &pm5.DeviceInfo{Connected: true, Serial: "430000001", Battery: 80},
+		stats: &pm5.WorkoutStats{
+			Pace:        118.5,
+			Power:       212,
+			StrokeRate:  24,
+			HeartRate:   151,
+			Distance:    1250,
+			ElapsedTime: 296.4,
+			DragFactor:  120,
+			Calories:    84,
+		},
+		active: true,
+	}
+	// Connected but no workout polled yet
+	idle := &fakeSource{info: &pm5.DeviceInfo{Connected: true, Serial: "430000002", Battery: 55}}
+	// Not found yet, so there is no serial to label it with
+	missing := &fakeSource{info: &pm5.DeviceInfo{}}
+
+	collector := NewCollector(rowing, missing)
+	collector.AddSource(idle)
+	body := scrape(t, collector)
+
+	want := []string{
+		`ergometer_connected{serial="430000001"} 1`,
+		`ergometer_workout_active{serial="430000001"} 1`,
+		`ergometer_battery_level_percent{serial="430000001"} 80`,
+		`ergometer_pace_seconds{serial="430000001"} 118.5`,
+		`ergometer_power_watts{serial="430000001"} 212`,
+		`ergometer_stroke_rate_spm{serial="430000001"} 24`,
+		`ergometer_heart_rate_bpm{serial="430000001"} 151`,
+		`ergometer_distance_meters{serial="430000001"} 1250`,
+		`ergometer_elapsed_time_seconds{serial="430000001"} 296.4`,
+		`ergometer_drag_factor{serial="430000001"} 120`,
+		`ergometer_calories{serial="430000001"} 84`,
+		`ergometer_connected{serial="430000002"} 1`,
+		`ergometer_workout_active{serial="430000002"} 0`,
+		`ergometer_battery_level_percent{serial="430000002"} 55`,
+	}
+	for _, line := range want {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("scrape is missing %s", line)
+		}
+	}
+
+	if strings.Contains(body, `ergometer_power_watts{serial="430000002"}`) {
+		t.Error("exported workout values for an erg with no stats")
+	}
+	if strings.Contains(body, `serial=""`) {
+		t.Error("exported an erg without a serial")
+	}
+
+	// Values are read on each scrape
+	rowing.stats.Power = 250
+	if body := scrape(t, collector); !strings.Contains(body, `ergometer_power_watts{serial="430000001"} 250`+"\n") {
+		t.Error("second scrape didn't pick up the latest power")
+	}
+}
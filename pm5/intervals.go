@@ -0,0 +1,199 @@
+package pm5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// maxIntervals bounds the pieces in one interval workout
+	maxIntervals = 50
+
+	// minRest gives the PM5 time to settle after a piece before the next
+	// is programmed
+	minRest = time.Second
+)
+
+// Interval is one work piece of an interval workout and the rest after it.
+// Each piece is run on the PM5 as its own fixed distance or fixed time
+// workout, with the rest timed by the server, so each piece is recorded as
+// its own session with its own summary. Both carry the piece's number to
+// group them by.
+type Interval struct {
+	Distance uint32 `json:"distance,omitempty"` // meters, for a distance piece
+	Time     uint32 `json:"time,omitempty"`     // seconds, for a time piece
+	Rest     uint32 `json:"rest"`               // seconds of rest after the piece
+}
+
+// IntervalState is broadcast as each piece starts and each rest begins
+type IntervalState struct {
+	Interval  int    `json:"interval"`  // piece number, from 1
+	Intervals int    `json:"intervals"` // pieces in the workout
+	Distance  uint32 `json:"distance,omitempty"`
+	Time      uint32 `json:"time,omitempty"`
+	Rest      uint32 `json:"rest"`
+}
+
+// intervalRun tracks the interval workout in progress
+type intervalRun struct {
+	intervals []Interval
+	index     int
+	timer     *time.Timer
+}
+
+// state describes the current piece
+func (r *intervalRun) state() *IntervalState {
+	interval := r.intervals[r.index]
+	return &IntervalState{
+		Interval:  r.index + 1,
+		Intervals: len(r.intervals),
+		Distance:  interval.Distance,
+		Time:      interval.Time,
+		Rest:      interval.Rest,
+	}
+}
+
+// validateIntervals checks every piece has exactly one of distance or time
+func validateIntervals(intervals []Interval) error {
+	if len(intervals) == 0 {
+		return errors.New("intervals are required for an intervals workout")
+	}
+	if len(intervals) > maxIntervals {
+		return fmt.Errorf("at most %d intervals are allowed", maxIntervals)
+	}
+	for i, interval := range intervals {
+		if (interval.Distance == 0) == (interval.Time == 0) {
+			return fmt.Errorf("interval %d needs either distance or time", i+1)
+		}
+	}
+	return nil
+}
+
+// startIntervals begins an interval workout with its first piece. Called
+// on the control goroutine.
+func (m *Manager) startIntervals(intervals []Interval) error {
+	if err := validateIntervals(intervals); err != nil {
+		return err
+	}
+
+	run := &intervalRun{intervals: intervals}
+	if err := m.startPiece(intervals[0]); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.stopIntervalsLocked()
+	m.intervals = run
+	state := run.state()
+	m.mu.Unlock()
+
+	m.BroadcastJSON("interval_started", state)
+	return nil
+}
+
+// startPiece programs one piece on the PM5
+func (m *Manager) startPiece(interval Interval) error {
+	if interval.Distance > 0 {
+		return m.pm5Device.StartFixedDistanceWorkout(interval.Distance, 0)
+	}
+	return m.pm5Device.StartFixedTimeWorkout(interval.Time*100, 0)
+}
+
+// advanceIntervals moves on from a finished piece, resting before the
+// next one or completing the workout. Called from the monitor goroutine
+// when a workout ends.
+func (m *Manager) advanceIntervals() {
+	m.mu.Lock()
+	run := m.intervals
+	if run == nil || run.timer != nil {
+		m.mu.Unlock()
+		return
+	}
+
+	finished := run.state()
+	if run.index == len(run.intervals)-1 {
+		m.intervals = nil
+		m.mu.Unlock()
+
+		m.BroadcastJSON("intervals_completed", map[string]int{"intervals": len(run.intervals)})
+		return
+	}
+
+	rest := max(time.Duration(finished.Rest)*time.Second, minRest)
+	run.timer = time.AfterFunc(rest, func() { m.nextInterval(run) })
+	m.mu.Unlock()
+
+	m.BroadcastJSON("interval_rest", finished)
+}
+
+// nextInterval starts the piece after a rest, unless the workout has been
+// stopped or replaced in the meantime
+func (m *Manager) nextInterval(run *intervalRun) {
+	// The piece's number is copied while mu is held; run.index can't be
+	// read once the control request has returned
+	m.mu.RLock()
+	number := run.index + 2
+	m.mu.RUnlock()
+
+	_, err := m.control(context.Background(), func() (interface{}, error) {
+		m.mu.Lock()
+		if m.intervals != run {
+			m.mu.Unlock()
+			return nil, nil
+		}
+		run.index++
+		run.timer = nil
+		state := run.state()
+		interval := run.intervals[run.index]
+		m.mu.Unlock()
+
+		if err := m.startPiece(interval); err != nil {
+			return nil, err
+		}
+		m.BroadcastJSON("interval_started", state)
+		return nil, nil
+	})
+	if err != nil {
+		log.Printf("Failed to start interval %d: %v", number, err)
+
+		m.mu.Lock()
+		if m.intervals == run {
+			m.intervals = nil
+		}
+		m.mu.Unlock()
+		m.BroadcastJSON("intervals_stopped", map[string]interface{}{
+			"interval": number,
+			"reason":   err.Error(),
+		})
+	}
+}
+
+// intervalPosition returns the number of the piece in progress and the
+// pieces in the workout, or zeros outside an interval workout
+func (m *Manager) intervalPosition() (int, int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.intervals == nil {
+		return 0, 0
+	}
+	return m.intervals.index + 1, len(m.intervals.intervals)
+}
+
+// stopIntervals abandons any interval workout in progress
+func (m *Manager) stopIntervals() {
+	m.mu.Lock()
+	m.stopIntervalsLocked()
+	m.mu.Unlock()
+}
+
+// stopIntervalsLocked abandons any interval workout. m.mu must be held.
+func (m *Manager) stopIntervalsLocked() {
+	if m.intervals != nil && m.intervals.timer != nil {
+		m.intervals.timer.Stop()
+	}
+	m.intervals = nil
+}
@@ -0,0 +1,143 @@
+package pm5
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/danhigham/ergometer.live/broadcast"
+)
+
+// newIntervalsManager returns a manager recording sessions, broadcasting
+// on a running hub, and a subscription to what it broadcasts
+func newIntervalsManager(t *testing.T) (*Manager, <-chan []byte) {
+	t.Helper()
+
+	hub := broadcast.NewHub()
+	go hub.Run()
+	messages, unsubscribe := hub.Subscribe()
+	t.Cleanup(func() {
+		unsubscribe()
+		hub.Shutdown(context.Background())
+	})
+
+	store, err := NewSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newManager(hub, DefaultOptions())
+	m.sessions = store
+	return m, messages
+}
+
+// nextBroadcast returns the data of the next message of type typ,
+// skipping others
+func nextBroadcast(t *testing.T, messages <-chan []byte, typ string) map[string]interface{} {
+	t.Helper()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case message := <-messages:
+			var msg struct {
+				Type string                 `json:"type"`
+				Data map[string]interface{} `json:"data"`
+			}
+			if err := json.Unmarshal(message, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type == typ {
+				return msg.Data
+			}
+		case <-timeout:
+			t.Fatalf("no %s message broadcast", typ)
+		}
+	}
+}
+
+func TestIntervalPiecesRecordedSeparately(t *testing.T) {
+	m, messages := newIntervalsManager(t)
+	m.intervals = &intervalRun{intervals: []Interval{{Distance: 500, Rest: 60}, {Distance: 500}}}
+
+	// rowPiece does what the monitor does as a piece starts and ends.
+	// Session IDs are to the second, so pieces are started minutes apart
+	// as on an erg.
+	start := time.Now()
+	rowPiece := func() *Session {
+		m.startRecording()
+		start = start.Add(5 * time.Minute)
+		m.recording.ID = newSessionID(start, "")
+		stats := &WorkoutStats{ElapsedTime: 95, Distance: 500, AvgPace: 95}
+		m.recordSample(stats)
+		m.mu.Lock()
+		m.lastStats = stats
+		m.mu.Unlock()
+
+		session := m.finishRecording(true)
+		m.broadcastSummary(session)
+		m.advanceIntervals()
+		return session
+	}
+
+	first := rowPiece()
+	if summary := nextBroadcast(t, messages, "workout_summary"); summary["interval"] != 1.0 || summary["intervals"] != 2.0 {
+		t.Errorf("first piece's workout_summary was %v", summary)
+	}
+	if rest := nextBroadcast(t, messages, "interval_rest"); rest["interval"] != 1.0 || rest["rest"] != 60.0 {
+		t.Errorf("interval_rest was %v", rest)
+	}
+
+	// Start the second piece as the rest timer would, without a PM5
+	m.mu.Lock()
+	m.intervals.timer.Stop()
+	m.intervals.timer = nil
+	m.intervals.index++
+	m.mu.Unlock()
+
+	second := rowPiece()
+	if summary := nextBroadcast(t, messages, "workout_summary"); summary["interval"] != 2.0 || summary["intervals"] != 2.0 {
+		t.Errorf("second piece's workout_summary was %v", summary)
+	}
+	nextBroadcast(t, messages, "intervals_completed")
+
+	// Each piece is its own session and summary, numbered to group them by
+	for i, session := range []*Session{first, second} {
+		if session == nil || session.Interval != i+1 || session.Intervals != 2 {
+			t.Fatalf("piece %d recorded as %+v", i+1, session)
+		}
+	}
+	summaries, err := m.Sessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 || summaries[0].Intervals != 2 || summaries[1].Intervals != 2 {
+		t.Errorf("listed %+v, want a session per piece", summaries)
+	}
+
+	// Workouts outside an interval run aren't numbered
+	if session := rowPiece(); session.Interval != 0 || session.Intervals != 0 {
+		t.Errorf("plain workout recorded as piece %d of %d", session.Interval, session.Intervals)
+	}
+}
+
+func TestNextIntervalFailureReportsThePiece(t *testing.T) {
+	m, messages := newIntervalsManager(t)
+	m.start()
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The control goroutine has gone, so the next piece can't start
+	run := &intervalRun{intervals: []Interval{{Distance: 500, Rest: 60}, {Distance: 500}}}
+	m.intervals = run
+	m.nextInterval(run)
+
+	stopped := nextBroadcast(t, messages, "intervals_stopped")
+	if stopped["interval"] != 2.0 {
+		t.Errorf("intervals_stopped was %v, want piece 2", stopped)
+	}
+	if m.intervals != nil {
+		t.Error("failed run still in progress")
+	}
+}
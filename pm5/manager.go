@@ -1,7 +1,9 @@
 package pm5
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
@@ -13,45 +15,95 @@ import (
 	"github.com/danhigham/pm5/device"
 )
 
-var (
-	instance *Manager
-	once     sync.Once
-)
-
 // WorkoutParams contains parameters for starting a workout
 type WorkoutParams struct {
-	WorkoutType   string `json:"workout_type"`   // just_row, fixed_distance, fixed_time
+	WorkoutType   string `json:"workout_type"`   // just_row, fixed_distance, fixed_time, intervals
 	Distance      uint32 `json:"distance"`       // meters (for fixed_distance)
 	Time          uint32 `json:"time"`           // seconds (for fixed_time)
 	SplitDistance uint32 `json:"split_distance"` // meters (optional)
 	SplitTime     uint32 `json:"split_time"`     // seconds (optional)
+
+	// Intervals are the pieces of an intervals workout
+	Intervals []Interval `json:"intervals,omitempty"`
+
+	// PaceBoat optionally races the workout against a recorded session or
+	// a target pace
+	PaceBoat *PaceBoatParams `json:"pace_boat,omitempty"`
 }
 
-// ControlRequest represents a control command sent to the manager
-type ControlRequest struct {
-	Type     string         // "start_workout", "stop_workout", "get_status"
-	Data     *WorkoutParams // Workout parameters (for start_workout)
-	Response chan *ControlResponse
+// DefaultControlTimeout bounds control commands whose context has no
+// deadline of its own
+const DefaultControlTimeout = 10 * time.Second
+
+var (
+	// ErrClosed is returned by Manager methods called after Shutdown
+	ErrClosed = errors.New("pm5 manager is shut down")
+
+	// ErrNotConnected is returned when no PM5 device is connected
+	ErrNotConnected = errors.New("PM5 not connected")
+)
+
+// controlRequest is a device command run on the control goroutine, which
+// serialises all USB commands
+type controlRequest struct {
+	ctx      context.Context
+	run      func() (interface{}, error)
+	response chan controlResponse
+}
+
+// controlResponse contains the result of a control request
+type controlResponse struct {
+	data interface{}
+	err  error
 }
 
-// ControlResponse contains the result of a control request
-type ControlResponse struct {
-	Success bool
-	Data    interface{}
-	Error   error
+// Options configures how the Manager selects and polls a device
+type Options struct {
+	// DeviceSerial selects a specific PM5; empty uses the first found
+	DeviceSerial string
+
+	// Debug enables the PM5 library's debug logging
+	Debug bool
+
+	// SessionsDir is where workouts are recorded; empty disables recording
+	SessionsDir string
+
+	// MaxSessions is how many recorded sessions are kept, the oldest being
+	// deleted after each save; 0 keeps them all
+	MaxSessions int
+
+	// Polling intervals for the monitor loop
+	PollIntervalActive time.Duration
+	PollIntervalIdle   time.Duration
+	PollIntervalCheck  time.Duration
+}
+
+// DefaultOptions returns the options used when nothing is configured
+func DefaultOptions() Options {
+	return Options{
+		PollIntervalActive: PollIntervalActive,
+		PollIntervalIdle:   PollIntervalIdle,
+		PollIntervalCheck:  PollIntervalCheck,
+	}
 }
 
 // Manager manages the PM5 device connection and monitoring
 type Manager struct {
 	pm5Device *pm5lib.PM5
 	hub       *broadcast.Hub
+	opts      Options
 
 	// Control channel for workout commands
-	controlChan chan *ControlRequest
+	controlChan chan *controlRequest
+	controlWg   sync.WaitGroup
+
+	// Closed by Shutdown
+	closed chan struct{}
 
 	// Monitor control
-	stopMonitor chan struct{}
-	monitorWg   sync.WaitGroup
+	stopMonitor  chan struct{}
+	monitorWg    sync.WaitGroup
+	shutdownOnce sync.Once
 
 	// State
 	mu               sync.RWMutex
@@ -59,6 +111,22 @@ type Manager struct {
 	lastWorkoutState csafe.WorkoutState
 	connected        bool
 	deviceInfo       *DeviceInfo
+	lastStats        *WorkoutStats
+	paceBoat         *PaceBoat
+	zones            *Zones
+	weight           float64 // rower's weight in kg, 0 if unknown
+	intervals        *intervalRun
+
+	// Stats subscribers
+	subMu       sync.Mutex
+	subscribers map[chan *WorkoutStats]struct{}
+
+	// Session recording and time in zone, only touched by the monitor
+	// goroutine
+	sessions    *SessionStore
+	recording   *Session
+	zoneTime    *TimeInZone
+	zoneElapsed float64
 }
 
 // DeviceInfo contains information about the connected PM5 device
@@ -71,29 +139,89 @@ type DeviceInfo struct {
 	OpState   string `json:"operational_state"`
 }
 
-// GetManager returns the singleton Manager instance
-func GetManager(hub *broadcast.Hub) *Manager {
-	once.Do(func() {
-		instance = &Manager{
-			hub:         hub,
-			controlChan: make(chan *ControlRequest),
-			stopMonitor: make(chan struct{}),
-			connected:   false,
-		}
+// NewManager creates a Manager, connects to a PM5 and starts monitoring it.
+// Each Manager owns its device connection and must be shut down with
+// Shutdown.
+func NewManager(hub *broadcast.Hub, opts Options) *Manager {
+	m := newManager(hub, opts)
+
+	// Try to connect to PM5
+	if err := m.connect(); err != nil {
+		log.Printf("Failed to connect to PM5: %v", err)
+	}
+
+	m.start()
+	return m
+}
 
-		// Try to connect to PM5
-		if err := instance.connect(); err != nil {
+// NewManagers connects to every PM5 found and returns a started Manager
+// for each, all broadcasting to hub. If no device can be connected a
+// single disconnected Manager is returned so callers always have one.
+func NewManagers(hub *broadcast.Hub, opts Options) []*Manager {
+	var managers []*Manager
+
+	devices, err := device.EnumerateDevices()
+	if err != nil {
+		log.Printf("Failed to enumerate devices: %v", err)
+	}
+
+	for _, dev := range devices {
+		pm := pm5lib.New(device.NewUSBDevice(dev))
+		pm.SetDebug(opts.Debug)
+
+		if err := pm.Connect(); err != nil {
 			log.Printf("Failed to connect to PM5: %v", err)
+			continue
 		}
 
-		// Start control handler
-		go instance.handleControl()
+		m := newManager(hub, opts)
+		m.attach(pm)
+		m.start()
+		managers = append(managers, m)
+	}
 
-		// Start monitor
-		go instance.startMonitor()
-	})
+	if len(managers) == 0 {
+		log.Printf("Failed to connect to PM5: no PM5 devices found")
+		m := newManager(hub, opts)
+		m.start()
+		managers = append(managers, m)
+	}
 
-	return instance
+	return managers
+}
+
+// newManager creates a Manager without connecting or starting it
+func newManager(hub *broadcast.Hub, opts Options) *Manager {
+	m := &Manager{
+		hub:         hub,
+		opts:        opts,
+		controlChan: make(chan *controlRequest),
+		stopMonitor: make(chan struct{}),
+		closed:      make(chan struct{}),
+		connected:   false,
+	}
+
+	if opts.SessionsDir != "" {
+		sessions, err := NewSessionStore(opts.SessionsDir)
+		if err != nil {
+			log.Printf("Session recording disabled: %v", err)
+		} else {
+			m.sessions = sessions
+		}
+	}
+
+	return m
+}
+
+// start launches the control handler and monitor goroutines
+func (m *Manager) start() {
+	// Start control handler
+	m.controlWg.Add(1)
+	go m.handleControl()
+
+	// Start monitor
+	m.monitorWg.Add(1)
+	go m.startMonitor()
 }
 
 // connect attempts to connect to a PM5 device
@@ -107,14 +235,43 @@ func (m *Manager) connect() error {
 		return fmt.Errorf("no PM5 devices found")
 	}
 
-	usbDev := device.NewUSBDevice(devices[0])
-	pm := pm5lib.New(usbDev)
-	pm.SetDebug(true)
+	var pm *pm5lib.PM5
+	for _, dev := range devices {
+		candidate := pm5lib.New(device.NewUSBDevice(dev))
+		candidate.SetDebug(m.opts.Debug)
+
+		if err := candidate.Connect(); err != nil {
+			log.Printf("Failed to connect to PM5: %v", err)
+			continue
+		}
+
+		if m.opts.DeviceSerial == "" {
+			pm = candidate
+			break
+		}
 
-	if err := pm.Connect(); err != nil {
-		return fmt.Errorf("failed to connect to PM5: %w", err)
+		// Only keep the device whose serial matches the configured one
+		serial, err := candidate.GetSerial()
+		if err == nil && serial == m.opts.DeviceSerial {
+			pm = candidate
+			break
+		}
+		candidate.Disconnect()
 	}
 
+	if pm == nil {
+		if m.opts.DeviceSerial != "" {
+			return fmt.Errorf("no PM5 with serial %s found", m.opts.DeviceSerial)
+		}
+		return fmt.Errorf("failed to connect to PM5")
+	}
+
+	m.attach(pm)
+	return nil
+}
+
+// attach makes a connected PM5 the Manager's device
+func (m *Manager) attach(pm *pm5lib.PM5) {
 	m.pm5Device = pm
 	m.connected = true
 
@@ -123,14 +280,15 @@ func (m *Manager) connect() error {
 		log.Printf("Failed to get device info: %v", err)
 	}
 
-	log.Printf("Connected to PM5: %s", m.deviceInfo.ErgType)
-	return nil
+	if info := m.DeviceInfo(); info != nil {
+		log.Printf("Connected to PM5: %s (serial %s)", info.ErgType, info.Serial)
+	}
 }
 
 // updateDeviceInfo retrieves and caches device information
 func (m *Manager) updateDeviceInfo() error {
 	if m.pm5Device == nil {
-		return fmt.Errorf("PM5 not connected")
+		return ErrNotConnected
 	}
 
 	info := &DeviceInfo{
@@ -163,58 +321,136 @@ func (m *Manager) updateDeviceInfo() error {
 	}
 
 	// Get workout state
-	if workoutState, err := m.pm5Device.GetWorkoutState(); err == nil {
+	workoutState, err := m.pm5Device.GetWorkoutState()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
 		m.lastWorkoutState = workoutState
 	}
-
 	m.deviceInfo = info
 	return nil
 }
 
 // handleControl processes control requests from the control channel
 func (m *Manager) handleControl() {
-	for req := range m.controlChan {
-		switch req.Type {
-		case "start_workout":
-			err := m.startWorkout(req.Data)
-			req.Response <- &ControlResponse{
-				Success: err == nil,
-				Error:   err,
+	defer m.controlWg.Done()
+
+	for {
+		select {
+		case req := <-m.controlChan:
+			// Skip commands whose caller has already given up
+			if err := req.ctx.Err(); err != nil {
+				req.response <- controlResponse{err: err}
+				continue
 			}
 
-		case "stop_workout":
-			err := m.stopWorkout()
-			req.Response <- &ControlResponse{
-				Success: err == nil,
-				Error:   err,
-			}
+			data, err := req.run()
+			req.response <- controlResponse{data: data, err: err}
 
-		case "get_status":
-			m.mu.RLock()
-			info := m.deviceInfo
-			m.mu.RUnlock()
+		case <-m.closed:
+			return
+		}
+	}
+}
 
-			req.Response <- &ControlResponse{
-				Success: true,
-				Data:    info,
-			}
+// control runs fn on the control goroutine and waits for its result. If
+// ctx has no deadline, DefaultControlTimeout applies.
+func (m *Manager) control(ctx context.Context, fn func() (interface{}, error)) (interface{}, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultControlTimeout)
+		defer cancel()
+	}
 
-		default:
-			req.Response <- &ControlResponse{
-				Success: false,
-				Error:   fmt.Errorf("unknown request type: %s", req.Type),
-			}
+	select {
+	case <-m.closed:
+		return nil, ErrClosed
+	default:
+	}
+
+	req := &controlRequest{
+		ctx:      ctx,
+		run:      fn,
+		response: make(chan controlResponse, 1),
+	}
+
+	select {
+	case m.controlChan <- req:
+	case <-m.closed:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, fmt.Errorf("control request not accepted: %w", ctx.Err())
+	}
+
+	select {
+	case resp := <-req.response:
+		return resp.data, resp.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("control request timed out: %w", ctx.Err())
+	}
+}
+
+// StartWorkout programs and starts a workout on the device
+func (m *Manager) StartWorkout(ctx context.Context, params *WorkoutParams) error {
+	if params == nil {
+		return fmt.Errorf("workout parameters are required")
+	}
+
+	_, err := m.control(ctx, func() (interface{}, error) {
+		return nil, m.startWorkout(params)
+	})
+	return err
+}
+
+// StopWorkout terminates the workout in progress
+func (m *Manager) StopWorkout(ctx context.Context) error {
+	_, err := m.control(ctx, func() (interface{}, error) {
+		return nil, m.stopWorkout()
+	})
+	return err
+}
+
+// Status returns the device information. When no device is connected the
+// returned info reports Connected as false.
+func (m *Manager) Status(ctx context.Context) (*DeviceInfo, error) {
+	data, err := m.control(ctx, func() (interface{}, error) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+
+		if m.deviceInfo == nil {
+			return &DeviceInfo{Connected: false}, nil
 		}
+		info := *m.deviceInfo
+		return &info, nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return data.(*DeviceInfo), nil
 }
 
 // startWorkout starts a workout with the given parameters
 func (m *Manager) startWorkout(params *WorkoutParams) error {
 	if m.pm5Device == nil {
-		return fmt.Errorf("PM5 not connected")
+		return ErrNotConnected
 	}
 
-	var err error
+	paceBoat, err := m.newPaceBoat(params.PaceBoat)
+	if err != nil {
+		return err
+	}
+
+	if params.WorkoutType == "intervals" {
+		if paceBoat != nil {
+			return errors.New("pace_boat is not supported for intervals workouts")
+		}
+		if err := m.startIntervals(params.Intervals); err != nil {
+			return fmt.Errorf("failed to start workout: %w", err)
+		}
+		log.Printf("Started %d interval workout", len(params.Intervals))
+		return nil
+	}
 
 	switch params.WorkoutType {
 	case "just_row":
@@ -244,6 +480,11 @@ func (m *Manager) startWorkout(params *WorkoutParams) error {
 		return fmt.Errorf("failed to start workout: %w", err)
 	}
 
+	m.mu.Lock()
+	m.stopIntervalsLocked()
+	m.paceBoat = paceBoat
+	m.mu.Unlock()
+
 	log.Printf("Started %s workout", params.WorkoutType)
 	return nil
 }
@@ -251,9 +492,12 @@ func (m *Manager) startWorkout(params *WorkoutParams) error {
 // stopWorkout terminates the current workout
 func (m *Manager) stopWorkout() error {
 	if m.pm5Device == nil {
-		return fmt.Errorf("PM5 not connected")
+		return ErrNotConnected
 	}
 
+	// Stop first so the terminated piece doesn't lead into the next
+	m.stopIntervals()
+
 	if err := m.pm5Device.TerminateWorkout(); err != nil {
 		return fmt.Errorf("failed to stop workout: %w", err)
 	}
@@ -262,32 +506,17 @@ func (m *Manager) stopWorkout() error {
 	return nil
 }
 
-// SendControl sends a control request and waits for the response
-func (m *Manager) SendControl(reqType string, data *WorkoutParams) (*ControlResponse, error) {
-	req := &ControlRequest{
-		Type:     reqType,
-		Data:     data,
-		Response: make(chan *ControlResponse),
-	}
-
-	m.controlChan <- req
-
-	// Wait for response with timeout
-	select {
-	case resp := <-req.Response:
-		return resp, nil
-	case <-time.After(10 * time.Second):
-		return nil, fmt.Errorf("control request timeout")
-	}
-}
-
-// BroadcastJSON marshals data to JSON and broadcasts it
+// BroadcastJSON marshals data to JSON and broadcasts it. Messages carry
+// the device serial so clients can tell ergs sharing a hub apart.
 func (m *Manager) BroadcastJSON(messageType string, data interface{}) {
 	msg := map[string]interface{}{
 		"type":      messageType,
 		"data":      data,
 		"timestamp": time.Now().Format(time.RFC3339),
 	}
+	if info := m.DeviceInfo(); info != nil && info.Serial != "" {
+		msg["serial"] = info.Serial
+	}
 
 	jsonData, err := json.Marshal(msg)
 	if err != nil {
@@ -298,23 +527,104 @@ func (m *Manager) BroadcastJSON(messageType string, data interface{}) {
 	m.hub.Broadcast(jsonData)
 }
 
-// Shutdown gracefully shuts down the manager
-func (m *Manager) Shutdown() {
-	log.Println("Shutting down PM5 manager...")
+// Shutdown gracefully shuts down the manager. A workout in progress is
+// left running on the monitor, but clients are sent its final stats and a
+// workout_ended event so they can close out their recording. If ctx is
+// done first, for example because a USB command never returns, Shutdown
+// gives up waiting and returns ctx's error. Calling Shutdown more than
+// once is a no-op; other methods return ErrClosed afterwards.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	var err error
+	m.shutdownOnce.Do(func() {
+		log.Println("Shutting down PM5 manager...")
+
+		// Reject further commands
+		m.stopIntervals()
+		close(m.closed)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			// Wait for any running command to finish, then stop the
+			// monitor
+			m.controlWg.Wait()
+			close(m.stopMonitor)
+			m.monitorWg.Wait()
+
+			m.closeOut()
+		}()
+
+		select {
+		case <-done:
+			log.Println("PM5 manager shutdown complete")
+		case <-ctx.Done():
+			log.Println("PM5 manager shutdown timed out waiting for the device")
+			err = ctx.Err()
+		}
+	})
+	return err
+}
 
-	// Stop monitor
-	close(m.stopMonitor)
-	m.monitorWg.Wait()
+// closeOut ends any workout the clients are recording and disconnects the
+// device
+func (m *Manager) closeOut() {
+	if m.pm5Device != nil && m.IsWorkoutActive() {
+		m.broadcastWorkoutStats()
+		m.broadcastSummary(m.finishRecording(false))
+		m.BroadcastJSON("workout_ended", map[string]string{
+			"message": "Workout recording ended",
+			"state":   m.lastWorkoutStateString(),
+			"reason":  "server_shutdown",
+		})
+	}
 
-	// Close control channel
-	close(m.controlChan)
+	m.closeSubscribers()
 
-	// Disconnect PM5
 	if m.pm5Device != nil {
 		m.pm5Device.Disconnect()
 	}
+}
 
-	log.Println("PM5 manager shutdown complete")
+// lastWorkoutStateString returns the last observed workout state as text
+func (m *Manager) lastWorkoutStateString() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastWorkoutState.String()
+}
+
+// DeviceInfo returns a copy of the cached device information, or nil if
+// no device has been connected
+func (m *Manager) DeviceInfo() *DeviceInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.deviceInfo == nil {
+		return nil
+	}
+	info := *m.deviceInfo
+	return &info
+}
+
+// LatestStats returns a copy of the most recent workout statistics read
+// from the device, or nil if no workout is in progress
+func (m *Manager) LatestStats() *WorkoutStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.lastStats == nil {
+		return nil
+	}
+	stats := *m.lastStats
+	return &stats
+}
+
+// IsWorkoutActive returns whether the device is currently in an active
+// workout state
+func (m *Manager) IsWorkoutActive() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return isWorkoutActive(m.lastWorkoutState)
 }
 
 // IsConnected returns whether the PM5 device is connected
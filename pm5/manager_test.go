@@ -0,0 +1,94 @@
+package pm5
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/danhigham/ergometer.live/broadcast"
+)
+
+// newTestManager starts a Manager with no device
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+
+	m := newManager(broadcast.NewHub(), DefaultOptions())
+	m.start()
+	return m
+}
+
+func TestManagerShutdown(t *testing.T) {
+	m := newTestManager(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown returned %v", err)
+	}
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("second Shutdown returned %v", err)
+	}
+	if _, err := m.Status(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Status after Shutdown returned %v, want ErrClosed", err)
+	}
+}
+
+func TestManagerShutdownDeadline(t *testing.T) {
+	m := newTestManager(t)
+
+	// A command that never returns, like a hung USB call
+	running := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	go m.control(context.Background(), func() (interface{}, error) {
+		close(running)
+		<-release
+		return nil, nil
+	})
+	<-running
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := m.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown returned %v, want the deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Shutdown took %v past its deadline", elapsed)
+	}
+}
+
+func TestManagerLatestStatsCopy(t *testing.T) {
+	m := newManager(broadcast.NewHub(), DefaultOptions())
+	m.lastStats = &WorkoutStats{Distance: 100}
+	m.deviceInfo = &DeviceInfo{Serial: "430123456"}
+
+	m.LatestStats().Distance = 200
+	m.DeviceInfo().Serial = "changed"
+
+	if got := m.LatestStats().Distance; got != 100 {
+		t.Fatalf("LatestStats shares the manager's stats: distance %v", got)
+	}
+	if got := m.DeviceInfo().Serial; got != "430123456" {
+		t.Fatalf("DeviceInfo shares the manager's info: serial %q", got)
+	}
+}
+
+func TestManagerSetBattery(t *testing.T) {
+	m := newManager(broadcast.NewHub(), DefaultOptions())
+
+	// Nothing to update before a device is attached
+	m.setBattery(50)
+	if m.DeviceInfo() != nil {
+		t.Fatal("battery level created device info")
+	}
+
+	m.deviceInfo = &DeviceInfo{Connected: true, Serial: "430123456", Battery: 80}
+	m.setBattery(65)
+	if got := m.DeviceInfo(); got.Battery != 65 || got.Serial != "430123456" {
+		t.Fatalf("device info %+v, want the new battery level", got)
+	}
+}
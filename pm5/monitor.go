@@ -8,6 +8,7 @@ import (
 	"github.com/danhigham/pm5/csafe"
 )
 
+// Default polling intervals, overridable through Options
 const (
 	// PollIntervalActive is the polling interval during active workouts
 	PollIntervalActive = 100 * time.Millisecond
@@ -19,6 +20,9 @@ const (
 	PollIntervalCheck = 500 * time.Millisecond
 )
 
+// batteryInterval is how often the battery level is re-read while idle
+const batteryInterval = time.Minute
+
 // WorkoutStats contains real-time workout statistics
 type WorkoutStats struct {
 	ElapsedTime   float64 `json:"elapsed_time"`    // seconds
@@ -34,6 +38,14 @@ type WorkoutStats struct {
 	AvgHeartRate  byte    `json:"avg_heart_rate"`  // bpm
 	DragFactor    byte    `json:"drag_factor"`     // drag factor
 
+	// Training zones, 0 when no zones are set or the value has no zone
+	HeartRateZone int `json:"heart_rate_zone,omitempty"`
+	PowerZone     int `json:"power_zone,omitempty"`
+
+	// Power per kilogram of the rower's weight, 0 when it isn't set
+	WattsPerKg    float64 `json:"watts_per_kg,omitempty"`
+	AvgWattsPerKg float64 `json:"avg_watts_per_kg,omitempty"`
+
 	// State information
 	WorkoutType  string `json:"workout_type"`
 	WorkoutState string `json:"workout_state"`
@@ -51,7 +63,6 @@ type WorkoutStateInfo struct {
 
 // startMonitor starts the monitoring goroutine
 func (m *Manager) startMonitor() {
-	m.monitorWg.Add(1)
 	defer m.monitorWg.Done()
 
 	m.mu.Lock()
@@ -64,12 +75,15 @@ func (m *Manager) startMonitor() {
 
 // monitorLoop is the main monitoring loop with adaptive polling
 func (m *Manager) monitorLoop() {
-	ticker := time.NewTicker(PollIntervalCheck)
+	ticker := time.NewTicker(m.opts.PollIntervalCheck)
 	defer ticker.Stop()
 
-	currentInterval := PollIntervalCheck
+	currentInterval := m.opts.PollIntervalCheck
 	var lastWorkoutState csafe.WorkoutState
 
+	// The battery was read when the device was attached
+	lastBattery := time.Now()
+
 	for {
 		select {
 		case <-ticker.C:
@@ -89,6 +103,10 @@ func (m *Manager) monitorLoop() {
 			if lastWorkoutState != workoutState {
 				m.broadcastStateChange(lastWorkoutState, workoutState)
 				lastWorkoutState = workoutState
+
+				m.mu.Lock()
+				m.lastWorkoutState = workoutState
+				m.mu.Unlock()
 			}
 
 			// Check if workout is active (rowing or in intervals)
@@ -97,8 +115,8 @@ func (m *Manager) monitorLoop() {
 			// Adjust polling rate based on state
 			if isActive {
 				// Active workout - poll fast
-				if currentInterval != PollIntervalActive {
-					currentInterval = PollIntervalActive
+				if currentInterval != m.opts.PollIntervalActive {
+					currentInterval = m.opts.PollIntervalActive
 					ticker.Reset(currentInterval)
 					log.Printf("Switched to active polling (%v) - state: %s", currentInterval, workoutState)
 				}
@@ -108,14 +126,19 @@ func (m *Manager) monitorLoop() {
 
 			} else {
 				// Idle state - poll slowly
-				if currentInterval != PollIntervalIdle {
-					currentInterval = PollIntervalIdle
+				if currentInterval != m.opts.PollIntervalIdle {
+					currentInterval = m.opts.PollIntervalIdle
 					ticker.Reset(currentInterval)
 					log.Printf("Switched to idle polling (%v) - state: %s", currentInterval, workoutState)
 				}
 
 				// Just send state update
 				m.broadcastStateOnly(workoutState)
+
+				if time.Since(lastBattery) >= batteryInterval {
+					lastBattery = time.Now()
+					m.refreshBattery()
+				}
 			}
 
 		case <-m.stopMonitor:
@@ -125,6 +148,25 @@ func (m *Manager) monitorLoop() {
 	}
 }
 
+// refreshBattery re-reads the battery level into the device info
+func (m *Manager) refreshBattery() {
+	battery, err := m.pm5Device.GetBatteryLevel()
+	if err != nil {
+		log.Printf("Failed to get battery level: %v", err)
+		return
+	}
+	m.setBattery(battery)
+}
+
+// setBattery records the device's battery level
+func (m *Manager) setBattery(battery byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.deviceInfo != nil {
+		m.deviceInfo.Battery = battery
+	}
+}
+
 // isWorkoutActive returns true if the workout state indicates active rowing
 func isWorkoutActive(state csafe.WorkoutState) bool {
 	switch state {
@@ -151,7 +193,18 @@ func (m *Manager) broadcastWorkoutStats() {
 	}
 
 	stats := m.convertSnapshot(snapshot)
+	m.applyZones(stats)
+	m.applyWeight(stats)
+
+	m.mu.Lock()
+	m.lastStats = stats
+	m.mu.Unlock()
+
+	m.recordSample(stats)
+	m.publishStats(stats)
+
 	m.BroadcastJSON("workout_stats", stats)
+	m.broadcastPaceBoat(stats)
 }
 
 // broadcastStateOnly broadcasts just the workout state
@@ -184,11 +237,26 @@ func (m *Manager) broadcastStateChange(oldState, newState csafe.WorkoutState) {
 
 	// Send specific events for workout start/end
 	if !wasActive && isActive {
+		m.resetZoneTime()
+		m.startRecording()
 		m.BroadcastJSON("workout_started", map[string]string{"message": "Workout started", "state": newState.String()})
 	}
 
 	if wasActive && !isActive {
+		// Send the final numbers; the last active poll can be a fraction
+		// short of the workout's end
+		m.broadcastWorkoutStats()
+		m.broadcastSummary(m.finishRecording(true))
+
+		// Stop reporting the finished workout's numbers as current
+		m.mu.Lock()
+		m.paceBoat = nil
+		m.lastStats = nil
+		m.mu.Unlock()
+
 		m.BroadcastJSON("workout_ended", map[string]string{"message": "Workout ended", "state": newState.String()})
+
+		m.advanceIntervals()
 	}
 }
 
@@ -0,0 +1,120 @@
+package pm5
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PaceBoatParams selects what a workout races against. Exactly one of
+// SessionID or TargetPace should be set.
+type PaceBoatParams struct {
+	SessionID  string  `json:"session_id,omitempty"`  // recorded session id, or "last"
+	TargetPace float64 `json:"target_pace,omitempty"` // seconds per 500m
+}
+
+// PaceBoatPosition compares the rower to the pace boat. Positive values
+// mean the rower is ahead.
+type PaceBoatPosition struct {
+	Source        string  `json:"source"` // "session" or "target_pace"
+	SessionID     string  `json:"session_id,omitempty"`
+	TargetPace    float64 `json:"target_pace,omitempty"`
+	GhostDistance float64 `json:"ghost_distance"` // meters
+	MetersAhead   float64 `json:"meters_ahead"`
+	SecondsAhead  float64 `json:"seconds_ahead"`
+	GhostFinished bool    `json:"ghost_finished"`
+}
+
+// PaceBoat is a ghost rower the live workout is compared against
+type PaceBoat struct {
+	session    *Session
+	targetPace float64
+}
+
+// NewSessionPaceBoat creates a pace boat replaying a recorded session
+func NewSessionPaceBoat(session *Session) (*PaceBoat, error) {
+	if len(session.Samples) < 2 {
+		return nil, fmt.Errorf("session %s has too few samples to race", session.ID)
+	}
+	return &PaceBoat{session: session}, nil
+}
+
+// NewTargetPaceBoat creates a pace boat rowing a constant pace in seconds
+// per 500m
+func NewTargetPaceBoat(pace float64) (*PaceBoat, error) {
+	if pace <= 0 {
+		return nil, fmt.Errorf("target pace must be positive")
+	}
+	return &PaceBoat{targetPace: pace}, nil
+}
+
+// Position returns where the rower is relative to the pace boat after
+// elapsed seconds and distance meters
+func (b *PaceBoat) Position(elapsed, distance float64) *PaceBoatPosition {
+	if b.session == nil {
+		ghost := elapsed * 500 / b.targetPace
+		return &PaceBoatPosition{
+			Source:        "target_pace",
+			TargetPace:    b.targetPace,
+			GhostDistance: ghost,
+			MetersAhead:   distance - ghost,
+			SecondsAhead:  distance*b.targetPace/500 - elapsed,
+		}
+	}
+
+	ghost, finished := b.distanceAt(elapsed)
+	return &PaceBoatPosition{
+		Source:        "session",
+		SessionID:     b.session.ID,
+		GhostDistance: ghost,
+		MetersAhead:   distance - ghost,
+		SecondsAhead:  b.timeAt(distance) - elapsed,
+		GhostFinished: finished,
+	}
+}
+
+// distanceAt interpolates the ghost's distance at the given time
+func (b *PaceBoat) distanceAt(t float64) (float64, bool) {
+	samples := b.session.Samples
+	last := samples[len(samples)-1]
+	if t >= last.Time {
+		return last.Distance, true
+	}
+
+	i := sort.Search(len(samples), func(i int) bool { return samples[i].Time >= t })
+	if i == 0 {
+		return samples[0].Distance, false
+	}
+
+	prev, next := samples[i-1], samples[i]
+	return interpolate(t, prev.Time, next.Time, prev.Distance, next.Distance), false
+}
+
+// timeAt interpolates the time at which the ghost reached the given
+// distance. Beyond the end of the recording the ghost's average pace is
+// extrapolated.
+func (b *PaceBoat) timeAt(d float64) float64 {
+	samples := b.session.Samples
+	last := samples[len(samples)-1]
+	if d >= last.Distance {
+		if last.Distance <= 0 {
+			return last.Time
+		}
+		return d * last.Time / last.Distance
+	}
+
+	i := sort.Search(len(samples), func(i int) bool { return samples[i].Distance >= d })
+	if i == 0 {
+		return samples[0].Time
+	}
+
+	prev, next := samples[i-1], samples[i]
+	return interpolate(d, prev.Distance, next.Distance, prev.Time, next.Time)
+}
+
+// interpolate maps x in [x0, x1] linearly onto [y0, y1]
+func interpolate(x, x0, x1, y0, y1 float64) float64 {
+	if x1 == x0 {
+		return y0
+	}
+	return y0 + (x-x0)*(y1-y0)/(x1-x0)
+}
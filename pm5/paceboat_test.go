@@ -0,0 +1,78 @@
+package pm5
+
+import (
+	"testing"
+)
+
+func TestNewPaceBoat(t *testing.T) {
+	if _, err := NewTargetPaceBoat(0); err == nil {
+		t.Error("created a pace boat with no target pace")
+	}
+	if _, err := NewSessionPaceBoat(&Session{ID: "s1", Samples: []SessionSample{{}}}); err == nil {
+		t.Error("created a pace boat from a single sample")
+	}
+}
+
+func TestTargetPaceBoatPosition(t *testing.T) {
+	// 2:00/500m covers 250m in the first minute
+	boat, err := NewTargetPaceBoat(120)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name              string
+		elapsed, distance float64
+		want              PaceBoatPosition
+	}{
+		{"level", 60, 250, PaceBoatPosition{GhostDistance: 250}},
+		{"ahead", 60, 275, PaceBoatPosition{GhostDistance: 250, MetersAhead: 25, SecondsAhead: 6}},
+		{"behind", 60, 200, PaceBoatPosition{GhostDistance: 250, MetersAhead: -50, SecondsAhead: -12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			want.Source, want.TargetPace = "target_pace", 120
+			if got := boat.Position(tt.elapsed, tt.distance); *got != want {
+				t.Errorf("Position(%v, %v) = %+v, want %+v", tt.elapsed, tt.distance, *got, want)
+			}
+		})
+	}
+}
+
+func TestSessionPaceBoatPosition(t *testing.T) {
+	session := &Session{ID: "s1", Samples: []SessionSample{
+		{Time: 0, Distance: 0},
+		{Time: 10, Distance: 40},
+		{Time: 20, Distance: 100},
+		{Time: 30, Distance: 150},
+	}}
+	boat, err := NewSessionPaceBoat(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name              string
+		elapsed, distance float64
+		want              PaceBoatPosition
+	}{
+		{"start", 0, 0, PaceBoatPosition{}},
+		// Halfway between samples the ghost is at 70m, which the rower's
+		// 100m reached at 20s
+		{"ahead", 15, 100, PaceBoatPosition{GhostDistance: 70, MetersAhead: 30, SecondsAhead: 5}},
+		{"behind", 25, 100, PaceBoatPosition{GhostDistance: 125, MetersAhead: -25, SecondsAhead: -5}},
+		// Past the end of the recording the ghost stops, but the time gap
+		// extrapolates its average pace of 5m/s
+		{"finished", 40, 240, PaceBoatPosition{GhostDistance: 150, MetersAhead: 90, SecondsAhead: 8, GhostFinished: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			want.Source, want.SessionID = "session", "s1"
+			if got := boat.Position(tt.elapsed, tt.distance); *got != want {
+				t.Errorf("Position(%v, %v) = %+v, want %+v", tt.elapsed, tt.distance, *got, want)
+			}
+		})
+	}
+}
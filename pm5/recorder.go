@@ -0,0 +1,128 @@
+package pm5
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// startRecording begins recording a new session. Called from the monitor
+// goroutine when a workout becomes active.
+func (m *Manager) startRecording() {
+	if m.sessions == nil {
+		return
+	}
+
+	now := time.Now()
+	session := &Session{StartedAt: now}
+	if info := m.DeviceInfo(); info != nil {
+		session.Serial = info.Serial
+	}
+	session.ID = newSessionID(now, session.Serial)
+	session.Interval, session.Intervals = m.intervalPosition()
+
+	m.recording = session
+	log.Printf("Recording session %s", session.ID)
+}
+
+// recordSample appends the latest stats to the session being recorded
+func (m *Manager) recordSample(stats *WorkoutStats) {
+	if m.recording == nil {
+		return
+	}
+
+	m.recording.WorkoutType = stats.WorkoutType
+	m.recording.Distance = stats.Distance
+	m.recording.ElapsedTime = stats.ElapsedTime
+	m.recording.Samples = append(m.recording.Samples, SessionSample{
+		Time:       stats.ElapsedTime,
+		Distance:   stats.Distance,
+		Pace:       stats.Pace,
+		Power:      stats.Power,
+		StrokeRate: stats.StrokeRate,
+		HeartRate:  stats.HeartRate,
+	})
+}
+
+// finishRecording saves the session being recorded. completed is false
+// when the recording is cut short, e.g. by a server shutdown.
+func (m *Manager) finishRecording(completed bool) *Session {
+	session := m.recording
+	m.recording = nil
+
+	if session == nil || len(session.Samples) == 0 {
+		return nil
+	}
+
+	session.Completed = completed
+	session.TimeInZone = m.zoneTime
+	if err := m.sessions.Save(session); err != nil {
+		log.Printf("Failed to save session %s: %v", session.ID, err)
+		return nil
+	}
+
+	log.Printf("Saved session %s (%.0fm in %.1fs)", session.ID, session.Distance, session.ElapsedTime)
+
+	if m.opts.MaxSessions > 0 {
+		if err := m.sessions.Prune(m.opts.MaxSessions); err != nil {
+			log.Printf("Failed to prune sessions: %v", err)
+		}
+	}
+	return session
+}
+
+// Sessions returns summaries of the recorded sessions, newest first
+func (m *Manager) Sessions() ([]SessionSummary, error) {
+	if m.sessions == nil {
+		return nil, fmt.Errorf("session recording is disabled")
+	}
+	return m.sessions.List()
+}
+
+// newPaceBoat builds the pace boat requested by params, or nil if none
+func (m *Manager) newPaceBoat(params *PaceBoatParams) (*PaceBoat, error) {
+	if params == nil {
+		return nil, nil
+	}
+
+	if params.SessionID != "" {
+		if m.sessions == nil {
+			return nil, fmt.Errorf("session recording is disabled")
+		}
+
+		session, err := m.sessions.Load(params.SessionID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load pace boat session %s: %w", params.SessionID, err)
+		}
+		return NewSessionPaceBoat(session)
+	}
+
+	if params.TargetPace > 0 {
+		return NewTargetPaceBoat(params.TargetPace)
+	}
+
+	return nil, nil
+}
+
+// broadcastPaceBoat broadcasts the rower's position relative to the pace
+// boat, if one is set
+func (m *Manager) broadcastPaceBoat(stats *WorkoutStats) {
+	m.mu.RLock()
+	boat := m.paceBoat
+	m.mu.RUnlock()
+
+	if boat == nil {
+		return
+	}
+
+	m.BroadcastJSON("pace_boat", boat.Position(stats.ElapsedTime, stats.Distance))
+}
+
+// Session loads a recorded session by id; "last" loads the most recent
+// completed one
+func (m *Manager) Session(id string) (*Session, error) {
+	if m.sessions == nil {
+		return nil, fmt.Errorf("session recording is disabled")
+	}
+	return m.sessions.Load(id)
+}
@@ -0,0 +1,293 @@
+package pm5
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound is returned when a recorded session doesn't exist
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionSample is a single point of a recorded workout
+type SessionSample struct {
+	Time       float64 `json:"t"`   // seconds since the start
+	Distance   float64 `json:"d"`   // meters
+	Pace       float64 `json:"p"`   // seconds per 500m
+	Power      uint32  `json:"w"`   // watts
+	StrokeRate byte    `json:"spm"` // strokes per minute
+	HeartRate  byte    `json:"hr"`  // bpm (0 = no data)
+}
+
+// Session is a recorded workout, kept so it can be raced later
+type Session struct {
+	ID          string          `json:"id"`
+	Serial      string          `json:"serial,omitempty"`
+	WorkoutType string          `json:"workout_type"`
+	StartedAt   time.Time       `json:"started_at"`
+	Distance    float64         `json:"distance"`     // meters
+	ElapsedTime float64         `json:"elapsed_time"` // seconds
+	Completed   bool            `json:"completed"`
+	TimeInZone  *TimeInZone     `json:"time_in_zone,omitempty"`
+	Samples     []SessionSample `json:"samples,omitempty"`
+
+	// The piece of an interval workout the session is, numbered from 1
+	Interval  int `json:"interval,omitempty"`
+	Intervals int `json:"intervals,omitempty"`
+}
+
+// SessionSummary describes a recorded session without its samples
+type SessionSummary struct {
+	ID          string      `json:"id"`
+	WorkoutType string      `json:"workout_type"`
+	StartedAt   time.Time   `json:"started_at"`
+	Distance    float64     `json:"distance"`
+	ElapsedTime float64     `json:"elapsed_time"`
+	Completed   bool        `json:"completed"`
+	TimeInZone  *TimeInZone `json:"time_in_zone,omitempty"`
+	Interval    int         `json:"interval,omitempty"`
+	Intervals   int         `json:"intervals,omitempty"`
+}
+
+// Summary returns the session without its samples
+func (s *Session) Summary() SessionSummary {
+	return SessionSummary{
+		ID:          s.ID,
+		WorkoutType: s.WorkoutType,
+		StartedAt:   s.StartedAt,
+		Distance:    s.Distance,
+		ElapsedTime: s.ElapsedTime,
+		Completed:   s.Completed,
+		TimeInZone:  s.TimeInZone,
+		Interval:    s.Interval,
+		Intervals:   s.Intervals,
+	}
+}
+
+// SessionStore keeps recorded sessions as JSON files in a directory
+type SessionStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewSessionStore creates a store in dir, creating it if needed
+func NewSessionStore(dir string) (*SessionStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create sessions directory: %w", err)
+	}
+	return &SessionStore{dir: dir}, nil
+}
+
+// Save writes a session to disk, with a summary beside it so sessions can
+// be listed without reading their samples
+func (s *SessionStore) Save(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := writeJSONFile(s.path(session.ID), session); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	if err := writeJSONFile(s.summaryPath(session.ID), session.Summary()); err != nil {
+		return fmt.Errorf("failed to write session summary: %w", err)
+	}
+	return nil
+}
+
+// Load reads a session by id. The id "last" loads the most recent
+// completed session.
+func (s *SessionStore) Load(id string) (*Session, error) {
+	if id == "last" {
+		return s.Latest()
+	}
+
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, ErrSessionNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %w", id, err)
+	}
+	return &session, nil
+}
+
+// Latest returns the most recent completed session
+func (s *SessionStore) Latest() (*Session, error) {
+	summaries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, summary := range summaries {
+		if summary.Completed {
+			return s.Load(summary.ID)
+		}
+	}
+	return nil, ErrSessionNotFound
+}
+
+// List returns summaries of every stored session, newest first. Only the
+// summaries are read; sessions recorded before summaries were kept are
+// read once and given one.
+func (s *SessionStore) List() ([]SessionSummary, error) {
+	s.mu.Lock()
+	entries, err := os.ReadDir(s.dir)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+
+	var summaries []SessionSummary
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".json" || strings.HasSuffix(name, summarySuffix) {
+			continue
+		}
+		id := strings.TrimSuffix(name, ".json")
+
+		var summary *SessionSummary
+		if names[id+summarySuffix] {
+			summary, err = s.loadSummary(id)
+		} else {
+			summary, err = s.summarize(id)
+		}
+		if err != nil {
+			continue
+		}
+		summaries = append(summaries, *summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].StartedAt.After(summaries[j].StartedAt)
+	})
+	return summaries, nil
+}
+
+// Prune deletes all but the newest keep sessions. The most recent
+// completed session is always kept so "last" can still be raced.
+func (s *SessionStore) Prune(keep int) error {
+	summaries, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	kept, keptCompleted := 0, false
+	for _, summary := range summaries {
+		if kept < keep || (summary.Completed && !keptCompleted) {
+			kept++
+			keptCompleted = keptCompleted || summary.Completed
+			continue
+		}
+		if err := s.remove(summary.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove deletes a session and its summary
+func (s *SessionStore) remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, path := range []string{s.path(id), s.summaryPath(id)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete session %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// loadSummary reads the summary saved beside a session
+func (s *SessionStore) loadSummary(id string) (*SessionSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.summaryPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session summary: %w", err)
+	}
+
+	var summary SessionSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("failed to parse session summary %s: %w", id, err)
+	}
+	return &summary, nil
+}
+
+// summarize reads a whole session that has no summary and saves one for
+// it
+func (s *SessionStore) summarize(id string) (*SessionSummary, error) {
+	session, err := s.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	summary := session.Summary()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := writeJSONFile(s.summaryPath(id), summary); err != nil {
+		log.Printf("Failed to save summary of session %s: %v", id, err)
+	}
+	return &summary, nil
+}
+
+func (s *SessionStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// summarySuffix ends the name of the summary saved beside each session.
+// Session ids never contain dots, so it can't be mistaken for a session.
+const summarySuffix = ".summary.json"
+
+func (s *SessionStore) summaryPath(id string) string {
+	return filepath.Join(s.dir, id+summarySuffix)
+}
+
+// writeJSONFile writes v to path through a temp file, so a crash never
+// leaves a partial file
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// newSessionID returns a sortable id for a session started at t on the
+// erg with the given serial
+func newSessionID(t time.Time, serial string) string {
+	id := t.UTC().Format("20060102T150405Z")
+	if serial != "" {
+		id += "-" + serial
+	}
+	return id
+}
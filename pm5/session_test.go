@@ -0,0 +1,134 @@
+package pm5
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessionStoreListReadsSummaries(t *testing.T) {
+	store, err := NewSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2026, 10, 1, 7, 0, 0, 0, time.UTC)
+	sessions := []*Session{
+		{ID: newSessionID(start, ""), StartedAt: start, Distance: 2000, Completed: true,
+			Samples: []SessionSample{{Time: 1, Distance: 5}}},
+		{ID: newSessionID(start.Add(time.Hour), ""), StartedAt: start.Add(time.Hour), Distance: 500},
+	}
+	for _, session := range sessions {
+		if err := store.Save(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Listing only reads the summaries, so it doesn't notice a session
+	// file it can't parse
+	if err := os.WriteFile(store.path(sessions[1].ID), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("got %d summaries, want 2", len(summaries))
+	}
+	if summaries[0].ID != sessions[1].ID || summaries[1].ID != sessions[0].ID {
+		t.Fatalf("summaries not newest first: %s, %s", summaries[0].ID, summaries[1].ID)
+	}
+
+	latest, err := store.Load("last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.ID != sessions[0].ID || len(latest.Samples) != 1 {
+		t.Fatalf("last loaded %s with %d samples, want the completed session", latest.ID, len(latest.Samples))
+	}
+}
+
+func TestSessionStoreSummarizesOldSessions(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A session recorded before summaries were kept
+	start := time.Date(2026, 9, 1, 7, 0, 0, 0, time.UTC)
+	session := &Session{ID: newSessionID(start, "430123456"), StartedAt: start, Distance: 1000, Completed: true}
+	data, err := json.Marshal(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, session.ID+".json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].ID != session.ID || summaries[0].Distance != 1000 {
+		t.Fatalf("got summaries %+v", summaries)
+	}
+	if _, err := os.Stat(store.summaryPath(session.ID)); err != nil {
+		t.Fatalf("summary was not saved: %v", err)
+	}
+
+	if _, err := store.Load("missing"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Load of a missing session returned %v", err)
+	}
+}
+
+func TestSessionStorePrune(t *testing.T) {
+	store, err := NewSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Oldest first: only the oldest session was completed
+	start := time.Date(2026, 10, 1, 7, 0, 0, 0, time.UTC)
+	var ids []string
+	for i := 0; i < 5; i++ {
+		at := start.Add(time.Duration(i) * time.Hour)
+		session := &Session{ID: newSessionID(at, ""), StartedAt: at, Completed: i == 0}
+		if err := store.Save(session); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, session.ID)
+	}
+
+	if err := store.Prune(2); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, summary := range summaries {
+		got = append(got, summary.ID)
+	}
+	// The newest two, and the completed one "last" races
+	if want := []string{ids[4], ids[3], ids[0]}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("kept %v, want %v", got, want)
+	}
+
+	for _, id := range ids[1:3] {
+		if _, err := store.Load(id); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("pruned session %s loaded with %v", id, err)
+		}
+		if _, err := os.Stat(store.summaryPath(id)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("pruned session %s kept its summary", id)
+		}
+	}
+}
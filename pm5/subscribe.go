@@ -0,0 +1,54 @@
+package pm5
+
+// statsBufferSize is the queue size for each stats subscriber
+const statsBufferSize = 64
+
+// SubscribeStats returns a channel receiving every WorkoutStats read from
+// the device, and a function to cancel the subscription. Slow subscribers
+// miss samples rather than stalling the monitor.
+func (m *Manager) SubscribeStats() (<-chan *WorkoutStats, func()) {
+	ch := make(chan *WorkoutStats, statsBufferSize)
+
+	m.subMu.Lock()
+	if m.subscribers == nil {
+		m.subscribers = make(map[chan *WorkoutStats]struct{})
+	}
+	m.subscribers[ch] = struct{}{}
+	m.subMu.Unlock()
+
+	cancel := func() {
+		m.subMu.Lock()
+		defer m.subMu.Unlock()
+		if _, ok := m.subscribers[ch]; ok {
+			delete(m.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return ch, cancel
+}
+
+// publishStats delivers stats to every subscriber
+func (m *Manager) publishStats(stats *WorkoutStats) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+
+	for ch := range m.subscribers {
+		select {
+		case ch <- stats:
+		default:
+			// Subscriber is behind, drop this sample for it
+		}
+	}
+}
+
+// closeSubscribers ends every subscription
+func (m *Manager) closeSubscribers() {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+
+	for ch := range m.subscribers {
+		delete(m.subscribers, ch)
+		close(ch)
+	}
+}
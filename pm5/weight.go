@@ -0,0 +1,47 @@
+package pm5
+
+import (
+	"fmt"
+	"math"
+)
+
+// Plausible rower weights, in kilograms
+const (
+	minWeight = 25.0
+	maxWeight = 250.0
+)
+
+// SetWeight sets the rower's weight in kilograms, used to add watts per
+// kilogram to workout_stats. 0 clears it.
+func (m *Manager) SetWeight(kg float64) error {
+	if kg != 0 && (kg < minWeight || kg > maxWeight) {
+		return fmt.Errorf("weight must be between %g and %g kg", minWeight, maxWeight)
+	}
+
+	m.mu.Lock()
+	m.weight = kg
+	m.mu.Unlock()
+	return nil
+}
+
+// Weight returns the rower's weight in kilograms, or 0 if it isn't set
+func (m *Manager) Weight() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.weight
+}
+
+// applyWeight adds watts per kilogram to stats
+func (m *Manager) applyWeight(stats *WorkoutStats) {
+	weight := m.Weight()
+	if weight == 0 {
+		return
+	}
+	stats.WattsPerKg = round2(float64(stats.Power) / weight)
+	stats.AvgWattsPerKg = round2(float64(stats.AvgPower) / weight)
+}
+
+// round2 rounds to two decimal places
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
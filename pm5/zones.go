@@ -0,0 +1,176 @@
+package pm5
+
+import (
+	"fmt"
+)
+
+// maxZoneSampleGap bounds the time credited to a zone between two samples,
+// so a stalled poll isn't counted as time in the last zone seen
+const maxZoneSampleGap = 5.0 // seconds
+
+// Zone is a training zone as served by the API. Min is inclusive and Max
+// exclusive; a Max of zero leaves the top zone open-ended.
+type Zone struct {
+	Zone int    `json:"zone"`
+	Name string `json:"name"`
+	Min  int    `json:"min"`
+	Max  int    `json:"max"`
+}
+
+// Zones are the rower's heart rate and power zones
+type Zones struct {
+	HeartRate []Zone `json:"heart_rate"`
+	Power     []Zone `json:"power"`
+}
+
+// TimeInZone is the seconds spent in each zone, indexed from zone 1
+type TimeInZone struct {
+	HeartRate []float64 `json:"heart_rate"`
+	Power     []float64 `json:"power"`
+}
+
+// WorkoutSummary is broadcast when a workout ends
+type WorkoutSummary struct {
+	SessionID     string      `json:"session_id,omitempty"`
+	WorkoutType   string      `json:"workout_type"`
+	Distance      float64     `json:"distance"`     // meters
+	ElapsedTime   float64     `json:"elapsed_time"` // seconds
+	AvgPace       float64     `json:"avg_pace"`     // seconds per 500m
+	AvgPower      uint32      `json:"avg_power"`    // watts
+	AvgStrokeRate byte        `json:"avg_stroke_rate"`
+	AvgHeartRate  byte        `json:"avg_heart_rate"`
+	Calories      uint32      `json:"calories"`
+	AvgWattsPerKg float64     `json:"avg_watts_per_kg,omitempty"`
+	TimeInZone    *TimeInZone `json:"time_in_zone,omitempty"`
+
+	// The piece of an interval workout that ended, numbered from 1
+	Interval  int `json:"interval,omitempty"`
+	Intervals int `json:"intervals,omitempty"`
+}
+
+// validateZones checks zones are numbered from 1 with ascending bounds
+func validateZones(kind string, zones []Zone) error {
+	for i, zone := range zones {
+		if zone.Zone != i+1 {
+			return fmt.Errorf("%s zones must be numbered from 1 in order", kind)
+		}
+		if zone.Max != 0 && zone.Max <= zone.Min {
+			return fmt.Errorf("%s zone %d must have max above min", kind, zone.Zone)
+		}
+		if i > 0 && zone.Min < zones[i-1].Min {
+			return fmt.Errorf("%s zones must be in ascending order", kind)
+		}
+	}
+	return nil
+}
+
+// zoneFor returns the zone value falls in, or 0 if none
+func zoneFor(zones []Zone, value int) int {
+	if value <= 0 {
+		return 0
+	}
+	for _, zone := range zones {
+		if value >= zone.Min && (zone.Max == 0 || value < zone.Max) {
+			return zone.Zone
+		}
+	}
+	return 0
+}
+
+// SetZones sets the zones workout_stats are annotated with. nil clears
+// them.
+func (m *Manager) SetZones(zones *Zones) error {
+	if zones != nil {
+		if err := validateZones("heart rate", zones.HeartRate); err != nil {
+			return err
+		}
+		if err := validateZones("power", zones.Power); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	m.zones = zones
+	m.mu.Unlock()
+	return nil
+}
+
+// Zones returns the zones in use, or nil if none are set
+func (m *Manager) Zones() *Zones {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.zones
+}
+
+// applyZones annotates stats with the current zones and credits the time
+// since the previous sample to them. Called from the monitor goroutine.
+func (m *Manager) applyZones(stats *WorkoutStats) {
+	zones := m.Zones()
+	if zones == nil {
+		return
+	}
+
+	stats.HeartRateZone = zoneFor(zones.HeartRate, int(stats.HeartRate))
+	stats.PowerZone = zoneFor(zones.Power, int(stats.Power))
+
+	if m.zoneTime == nil {
+		m.zoneTime = &TimeInZone{}
+	}
+	m.zoneTime.HeartRate = grow(m.zoneTime.HeartRate, len(zones.HeartRate))
+	m.zoneTime.Power = grow(m.zoneTime.Power, len(zones.Power))
+
+	elapsed := stats.ElapsedTime - m.zoneElapsed
+	m.zoneElapsed = stats.ElapsedTime
+	if elapsed <= 0 || elapsed > maxZoneSampleGap {
+		return
+	}
+
+	if stats.HeartRateZone > 0 {
+		m.zoneTime.HeartRate[stats.HeartRateZone-1] += elapsed
+	}
+	if stats.PowerZone > 0 {
+		m.zoneTime.Power[stats.PowerZone-1] += elapsed
+	}
+}
+
+// resetZoneTime starts accumulating time in zone for a new workout
+func (m *Manager) resetZoneTime() {
+	m.zoneTime = nil
+	m.zoneElapsed = 0
+}
+
+// broadcastSummary broadcasts the summary of the workout that just ended.
+// session is the recording saved for it, if any.
+func (m *Manager) broadcastSummary(session *Session) {
+	stats := m.LatestStats()
+	if stats == nil {
+		return
+	}
+
+	summary := &WorkoutSummary{
+		WorkoutType:   stats.WorkoutType,
+		Distance:      stats.Distance,
+		ElapsedTime:   stats.ElapsedTime,
+		AvgPace:       stats.AvgPace,
+		AvgPower:      stats.AvgPower,
+		AvgStrokeRate: stats.AvgStrokeRate,
+		AvgHeartRate:  stats.AvgHeartRate,
+		Calories:      stats.Calories,
+		AvgWattsPerKg: stats.AvgWattsPerKg,
+		TimeInZone:    m.zoneTime,
+	}
+	if session != nil {
+		summary.SessionID = session.ID
+	}
+	summary.Interval, summary.Intervals = m.intervalPosition()
+
+	m.BroadcastJSON("workout_summary", summary)
+}
+
+// grow extends counts to n entries
+func grow(counts []float64, n int) []float64 {
+	for len(counts) < n {
+		counts = append(counts, 0)
+	}
+	return counts
+}
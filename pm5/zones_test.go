@@ -0,0 +1,93 @@
+package pm5
+
+import (
+	"testing"
+
+	"github.com/danhigham/ergometer.live/broadcast"
+)
+
+// testZones are heart rate zones of a 190 bpm maximum and 2k watt zones
+// of a 300W test, as the API serves them
+var testZones = &Zones{
+	HeartRate: []Zone{{1, "Recovery", 95, 114}, {2, "Endurance", 114, 133}, {3, "Tempo", 133, 152}, {4, "Threshold", 152, 171}, {5, "Maximum", 171, 0}},
+	Power:     []Zone{{1, "UT2", 0, 180}, {2, "UT1", 180, 210}, {3, "AT", 210, 240}, {4, "TR", 240, 315}, {5, "AN", 315, 0}},
+}
+
+func TestZoneFor(t *testing.T) {
+	tests := []struct {
+		zones []Zone
+		value int
+		want  int
+	}{
+		// No data
+		{testZones.HeartRate, 0, 0},
+		// Below the first zone
+		{testZones.HeartRate, 94, 0},
+		// Min is inclusive and max exclusive
+		{testZones.HeartRate, 95, 1},
+		{testZones.HeartRate, 113, 1},
+		{testZones.HeartRate, 114, 2},
+		{testZones.HeartRate, 170, 4},
+		// The top zone is open-ended
+		{testZones.HeartRate, 171, 5},
+		{testZones.HeartRate, 230, 5},
+		{testZones.Power, 1, 1},
+		{testZones.Power, 239, 3},
+		{testZones.Power, 240, 4},
+		{testZones.Power, 900, 5},
+		{nil, 150, 0},
+	}
+	for _, tt := range tests {
+		if got := zoneFor(tt.zones, tt.value); got != tt.want {
+			t.Errorf("zoneFor(%v, %d) = %d, want %d", tt.zones, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidateZones(t *testing.T) {
+	tests := []struct {
+		name  string
+		zones []Zone
+		valid bool
+	}{
+		{"served zones", testZones.HeartRate, true},
+		{"none", nil, true},
+		{"numbered from 2", []Zone{{Zone: 2, Min: 0, Max: 100}}, false},
+		{"out of order", []Zone{{Zone: 1, Min: 100, Max: 150}, {Zone: 2, Min: 50, Max: 100}}, false},
+		{"max not above min", []Zone{{Zone: 1, Min: 100, Max: 100}}, false},
+		{"open-ended", []Zone{{Zone: 1, Min: 0, Max: 100}, {Zone: 2, Min: 100}}, true},
+	}
+	for _, tt := range tests {
+		if err := validateZones("test", tt.zones); (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestApplyZones(t *testing.T) {
+	m := newManager(broadcast.NewHub(), DefaultOptions())
+	if err := m.SetZones(testZones); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stats := range []*WorkoutStats{
+		{ElapsedTime: 1, HeartRate: 120, Power: 200},  // 1s since the start in HR 2 and power 2
+		{ElapsedTime: 3, HeartRate: 120, Power: 200},  // 2s in HR 2 and power 2
+		{ElapsedTime: 4, HeartRate: 160, Power: 250},  // 1s in HR 4 and power 4
+		{ElapsedTime: 14, HeartRate: 160, Power: 250}, // a stall: not credited
+		{ElapsedTime: 15, HeartRate: 0, Power: 320},   // 1s in power 5 only
+	} {
+		m.applyZones(stats)
+	}
+
+	want := TimeInZone{
+		HeartRate: []float64{0, 2 + 1, 0, 1, 0},
+		Power:     []float64{0, 2 + 1, 0, 1, 1},
+	}
+	got := m.zoneTime
+	for i := range want.HeartRate {
+		if got.HeartRate[i] != want.HeartRate[i] || got.Power[i] != want.Power[i] {
+			t.Fatalf("time in zone %+v, want %+v", got, want)
+		}
+	}
+}
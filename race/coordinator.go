@@ -0,0 +1,544 @@
+package race
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danhigham/ergometer.live/broadcast"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// DefaultCountdown is used when a start request doesn't give one
+	DefaultCountdown = 10 * time.Second
+
+	// MaxCountdown bounds how long a start may be scheduled ahead
+	MaxCountdown = 5 * time.Minute
+
+	// stateInterval is how often race_state is sent during a race
+	stateInterval = 500 * time.Millisecond
+
+	// slowestPace and raceGrace set the default time limit: a race ends
+	// once every lane could have rowed it at slowestPace per 500m, plus
+	// raceGrace
+	slowestPace = 4 * time.Minute
+	raceGrace   = 2 * time.Minute
+)
+
+// Coordinator runs races between lanes connected over WebSocket. Lanes are
+// remote socket servers (or simulated ergs) that join, receive a
+// synchronised start and stream their progress; every connection receives
+// the combined race_state.
+type Coordinator struct {
+	hub      *broadcast.Hub
+	upgrader websocket.Upgrader
+
+	mu        sync.Mutex
+	lanes     map[*broadcast.Client]*LaneState
+	nextLane  int
+	raceID    string
+	status    string
+	distance  uint32
+	startedAt time.Time
+	startTmr  *time.Timer
+	limitTmr  *time.Timer
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewCoordinator creates a Coordinator accepting connections with upgrader
+func NewCoordinator(upgrader websocket.Upgrader) *Coordinator {
+	c := &Coordinator{
+		hub:      broadcast.NewHub(),
+		upgrader: upgrader,
+		lanes:    make(map[*broadcast.Client]*LaneState),
+		status:   StatusWaiting,
+		stop:     make(chan struct{}),
+	}
+
+	c.hub.SetMessageHandler(c.handleMessage)
+	c.hub.SetDisconnectHandler(c.handleDisconnect)
+
+	return c
+}
+
+// Run starts the coordinator's hub and periodic state updates
+func (c *Coordinator) Run() {
+	go c.hub.Run()
+
+	ticker := time.NewTicker(stateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			active := c.status == StatusCountdown || c.status == StatusRacing
+			c.mu.Unlock()
+
+			if active {
+				c.broadcastState()
+			}
+
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// ServeHTTP upgrades a lane or spectator connection
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := c.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Race WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	client := broadcast.NewClient(c.hub, conn)
+	c.hub.Register(client)
+	client.Run()
+
+	// Spectators get the current state straight away
+	c.sendState(client)
+}
+
+// Shutdown stops the coordinator and closes all lane connections
+func (c *Coordinator) Shutdown(ctx context.Context) error {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+
+		c.mu.Lock()
+		c.stopTimers()
+		c.mu.Unlock()
+	})
+	return c.hub.Shutdown(ctx)
+}
+
+// handleMessage processes a message from a lane
+func (c *Coordinator) handleMessage(client *broadcast.Client, message []byte) {
+	var env Envelope
+	if err := json.Unmarshal(message, &env); err != nil {
+		sendError(client, "Invalid message format")
+		return
+	}
+
+	switch env.Type {
+	case MsgJoin:
+		var req JoinRequest
+		if err := json.Unmarshal(env.Data, &req); err != nil {
+			sendError(client, "Invalid join request")
+			return
+		}
+		c.join(client, req)
+
+	case MsgStartRace:
+		if !c.joined(client) {
+			sendError(client, "Join the race before starting one")
+			return
+		}
+		var req StartRequest
+		if err := json.Unmarshal(env.Data, &req); err != nil {
+			sendError(client, "Invalid start request")
+			return
+		}
+		if err := c.start(req); err != nil {
+			sendError(client, err.Error())
+		}
+
+	case MsgCancelRace:
+		if !c.joined(client) {
+			sendError(client, "Join the race before cancelling it")
+			return
+		}
+		if err := c.cancel(); err != nil {
+			sendError(client, err.Error())
+		}
+
+	case MsgProgress, MsgFinished:
+		var progress Progress
+		if err := json.Unmarshal(env.Data, &progress); err != nil {
+			sendError(client, "Invalid progress update")
+			return
+		}
+		if err := c.progress(client, progress, env.Type == MsgFinished); err != nil {
+			sendError(client, err.Error())
+		}
+
+	default:
+		sendError(client, "Unknown message type: "+env.Type)
+	}
+}
+
+// join registers a lane
+func (c *Coordinator) join(client *broadcast.Client, req JoinRequest) {
+	c.mu.Lock()
+	if _, ok := c.lanes[client]; ok {
+		c.mu.Unlock()
+		sendError(client, "Already joined")
+		return
+	}
+
+	if c.status == StatusCountdown || c.status == StatusRacing {
+		c.mu.Unlock()
+		sendError(client, "A race is in progress, join once it has finished")
+		return
+	}
+
+	c.nextLane++
+	lane := &LaneState{
+		Lane:      c.nextLane,
+		Name:      req.Name,
+		Serial:    req.Serial,
+		Connected: true,
+	}
+	if lane.Name == "" {
+		lane.Name = fmt.Sprintf("Lane %d", lane.Lane)
+	}
+	c.lanes[client] = lane
+	raceID := c.raceID
+	c.mu.Unlock()
+
+	log.Printf("Race lane %d joined: %s", lane.Lane, lane.Name)
+
+	send(client, MsgJoined, JoinedResponse{Lane: lane.Lane, RaceID: raceID})
+	c.broadcastState()
+}
+
+// joined reports whether client has joined as a lane. Spectators can
+// watch but not start or cancel races.
+func (c *Coordinator) joined(client *broadcast.Client) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.lanes[client]
+	return ok
+}
+
+// start begins the countdown for a new race
+func (c *Coordinator) start(req StartRequest) error {
+	if req.Distance == 0 {
+		return fmt.Errorf("distance is required")
+	}
+
+	countdown := time.Duration(req.CountdownSeconds) * time.Second
+	if countdown <= 0 {
+		countdown = DefaultCountdown
+	}
+	if countdown > MaxCountdown {
+		return fmt.Errorf("countdown may be at most %v", MaxCountdown)
+	}
+
+	limit := time.Duration(req.TimeLimitSeconds) * time.Second
+	if limit <= 0 {
+		limit = time.Duration(req.Distance)*slowestPace/500 + raceGrace
+	}
+
+	c.mu.Lock()
+	if c.status == StatusCountdown || c.status == StatusRacing {
+		c.mu.Unlock()
+		return fmt.Errorf("a race is already in progress")
+	}
+
+	// Drop lanes that left since the last race and reset the rest
+	for client, lane := range c.lanes {
+		if !lane.Connected {
+			delete(c.lanes, client)
+			continue
+		}
+		*lane = LaneState{Lane: lane.Lane, Name: lane.Name, Serial: lane.Serial, Connected: true}
+	}
+
+	if len(c.lanes) == 0 {
+		c.mu.Unlock()
+		return fmt.Errorf("no lanes have joined")
+	}
+
+	now := time.Now()
+	c.raceID = now.UTC().Format("20060102T150405Z")
+	c.status = StatusCountdown
+	c.distance = req.Distance
+	c.startedAt = now.Add(countdown)
+	raceID := c.raceID
+	c.startTmr = time.AfterFunc(countdown, func() {
+		c.mu.Lock()
+		if c.raceID == raceID && c.status == StatusCountdown {
+			c.status = StatusRacing
+			// Every lane may have left during the countdown
+			c.checkComplete()
+		}
+		c.mu.Unlock()
+		c.broadcastState()
+	})
+	c.limitTmr = time.AfterFunc(countdown+limit, func() {
+		c.mu.Lock()
+		if c.raceID != raceID || c.status != StatusRacing {
+			c.mu.Unlock()
+			return
+		}
+		for _, lane := range c.lanes {
+			if !lane.Finished {
+				lane.DNF = true
+			}
+		}
+		c.end(StatusFinished)
+		c.mu.Unlock()
+
+		log.Printf("Race %s: time limit of %v reached", raceID, limit)
+		c.broadcastState()
+	})
+	c.mu.Unlock()
+
+	log.Printf("Race %s: %dm starting in %v", raceID, req.Distance, countdown)
+
+	c.broadcast(MsgRaceStart, RaceStart{
+		RaceID:      raceID,
+		Distance:    req.Distance,
+		CountdownMs: countdown.Milliseconds(),
+	})
+	c.broadcastState()
+	return nil
+}
+
+// cancel stops the race in progress
+func (c *Coordinator) cancel() error {
+	c.mu.Lock()
+	if c.status != StatusCountdown && c.status != StatusRacing {
+		c.mu.Unlock()
+		return fmt.Errorf("no race is in progress")
+	}
+	c.end(StatusCancelled)
+	raceID := c.raceID
+	c.mu.Unlock()
+
+	log.Printf("Race %s cancelled", raceID)
+	c.broadcastState()
+	return nil
+}
+
+// progress records a lane's position, finishing it once it reaches the
+// race distance. A finish short of the distance is an error. Finish times
+// are taken on the coordinator's race clock: a lane's own elapsed time
+// only starts at its first stroke, so a late starter would gain the time
+// they sat out. The hub handles each message on its own goroutine, so
+// samples can arrive out of order; one behind the lane's last is dropped.
+func (c *Coordinator) progress(client *broadcast.Client, p Progress, finished bool) error {
+	c.mu.Lock()
+
+	lane, ok := c.lanes[client]
+	if !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("join the race before sending progress")
+	}
+
+	if c.status != StatusRacing || lane.Finished {
+		c.mu.Unlock()
+		return nil
+	}
+
+	if !finished && (p.Distance < lane.Distance || p.ElapsedTime < lane.ElapsedTime) {
+		c.mu.Unlock()
+		return nil
+	}
+
+	lane.Distance = p.Distance
+	lane.ElapsedTime = p.ElapsedTime
+	lane.Pace = p.Pace
+	lane.StrokeRate = p.StrokeRate
+	lane.Power = p.Power
+
+	distance := c.distance
+	if p.Distance < float64(distance) {
+		c.mu.Unlock()
+		if finished {
+			return fmt.Errorf("finished at %.0fm, short of the %dm race", p.Distance, distance)
+		}
+		return nil
+	}
+
+	lane.Finished = true
+	lane.FinishTime = raceTime(c.startedAt, time.Now())
+	lane.Distance = float64(distance)
+	c.assignPlaces()
+	c.checkComplete()
+	log.Printf("Race %s: lane %d finished in %.1fs", c.raceID, lane.Lane, lane.FinishTime)
+	c.mu.Unlock()
+
+	c.broadcastState()
+	return nil
+}
+
+// handleDisconnect removes a lane from the lobby, or marks it as not
+// finishing if a race is under way
+func (c *Coordinator) handleDisconnect(client *broadcast.Client) {
+	c.mu.Lock()
+	lane, ok := c.lanes[client]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+
+	log.Printf("Race lane %d left: %s", lane.Lane, lane.Name)
+
+	if c.status == StatusCountdown || c.status == StatusRacing {
+		lane.Connected = false
+		lane.DNF = !lane.Finished
+		c.checkComplete()
+	} else {
+		delete(c.lanes, client)
+	}
+	c.mu.Unlock()
+
+	c.broadcastState()
+}
+
+// assignPlaces orders finished lanes by finish time. Must hold c.mu.
+func (c *Coordinator) assignPlaces() {
+	var finished []*LaneState
+	for _, lane := range c.lanes {
+		if lane.Finished {
+			finished = append(finished, lane)
+		}
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		if finished[i].FinishTime == finished[j].FinishTime {
+			return finished[i].Lane < finished[j].Lane
+		}
+		return finished[i].FinishTime < finished[j].FinishTime
+	})
+
+	for i, lane := range finished {
+		lane.Place = i + 1
+	}
+}
+
+// checkComplete ends the race once every connected lane has finished. A
+// race every lane left without finishing is cancelled. Must hold c.mu.
+func (c *Coordinator) checkComplete() {
+	if c.status != StatusRacing {
+		return
+	}
+
+	finishers := 0
+	for _, lane := range c.lanes {
+		if lane.Connected && !lane.Finished {
+			return
+		}
+		if lane.Finished {
+			finishers++
+		}
+	}
+
+	if finishers == 0 {
+		c.end(StatusCancelled)
+		log.Printf("Race %s cancelled, every lane left", c.raceID)
+		return
+	}
+	c.end(StatusFinished)
+	log.Printf("Race %s finished", c.raceID)
+}
+
+// end sets the final status of the race and stops its timers. Must hold
+// c.mu.
+func (c *Coordinator) end(status string) {
+	c.status = status
+	c.stopTimers()
+}
+
+// stopTimers stops the start and time limit timers. Must hold c.mu.
+func (c *Coordinator) stopTimers() {
+	if c.startTmr != nil {
+		c.startTmr.Stop()
+	}
+	if c.limitTmr != nil {
+		c.limitTmr.Stop()
+	}
+}
+
+// State returns a snapshot of the race
+func (c *Coordinator) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := State{
+		RaceID:   c.raceID,
+		Status:   c.status,
+		Distance: c.distance,
+		Lanes:    make([]LaneState, 0, len(c.lanes)),
+		Results:  []Result{},
+	}
+
+	if c.status == StatusRacing || c.status == StatusFinished {
+		startedAt := c.startedAt
+		state.StartedAt = &startedAt
+	}
+
+	for _, lane := range c.lanes {
+		state.Lanes = append(state.Lanes, *lane)
+		if lane.Finished {
+			state.Results = append(state.Results, Result{
+				Place:      lane.Place,
+				Lane:       lane.Lane,
+				Name:       lane.Name,
+				Serial:     lane.Serial,
+				FinishTime: lane.FinishTime,
+			})
+		}
+	}
+
+	sort.Slice(state.Lanes, func(i, j int) bool { return state.Lanes[i].Lane < state.Lanes[j].Lane })
+	sort.Slice(state.Results, func(i, j int) bool { return state.Results[i].Place < state.Results[j].Place })
+
+	return state
+}
+
+// raceTime returns the seconds on the race clock from startedAt to now, to
+// the hundredth as the PM5 shows them
+func raceTime(startedAt, now time.Time) float64 {
+	return math.Round(now.Sub(startedAt).Seconds()*100) / 100
+}
+
+// broadcastState sends the race state to every connection
+func (c *Coordinator) broadcastState() {
+	c.broadcast(MsgRaceState, c.State())
+}
+
+// sendState sends the race state to a single connection
+func (c *Coordinator) sendState(client *broadcast.Client) {
+	send(client, MsgRaceState, c.State())
+}
+
+// broadcast sends a message to every connection
+func (c *Coordinator) broadcast(msgType string, data interface{}) {
+	message, err := encode(msgType, data)
+	if err != nil {
+		log.Printf("Failed to marshal race message: %v", err)
+		return
+	}
+	c.hub.Broadcast(message)
+}
+
+// send sends a message to a single connection
+func send(client *broadcast.Client, msgType string, data interface{}) {
+	message, err := encode(msgType, data)
+	if err != nil {
+		log.Printf("Failed to marshal race message: %v", err)
+		return
+	}
+	client.Send(message)
+}
+
+// sendError sends an error message to a connection
+func sendError(client *broadcast.Client, message string) {
+	send(client, MsgError, map[string]string{
+		"message": message,
+		"code":    "ERROR",
+	})
+}
@@ -0,0 +1,433 @@
+package race
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danhigham/ergometer.live/pm5"
+	"github.com/gorilla/websocket"
+)
+
+// newTestCoordinator runs a Coordinator behind a test server and returns
+// the URL lanes join it at
+func newTestCoordinator(t *testing.T) (*Coordinator, string) {
+	t.Helper()
+
+	c := NewCoordinator(websocket.Upgrader{})
+	go c.Run()
+	srv := httptest.NewServer(c)
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c.Shutdown(ctx)
+		srv.Close()
+	})
+
+	return c, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// startLanes connects a lane on a simulated erg for each pace, sending the
+// race states each receives to states
+func startLanes(t *testing.T, ctx context.Context, url string, paces []float64, states chan<- State) []*Lane {
+	t.Helper()
+
+	lanes := make([]*Lane, len(paces))
+	for i, pace := range paces {
+		lanes[i] = NewLane(url, fmt.Sprintf("Sim %d", i+1), NewSimulatedErg(pace, 28), func(msgType string, data interface{}) {
+			raw, ok := data.(json.RawMessage)
+			if msgType != MsgRaceState || !ok {
+				return
+			}
+			var state State
+			if err := json.Unmarshal(raw, &state); err != nil {
+				t.Errorf("invalid race_state: %v", err)
+				return
+			}
+			select {
+			case states <- state:
+			default:
+			}
+		})
+		go lanes[i].Run(ctx)
+	}
+	return lanes
+}
+
+// waitFor polls the coordinator's state until ok accepts it
+func waitFor(t *testing.T, c *Coordinator, what string, ok func(State) bool) State {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		state := c.State()
+		if ok(state) {
+			return state
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s, state %+v", what, state)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// waitForStatus waits for a race_state with status sent to the lanes
+func waitForStatus(t *testing.T, states <-chan State, status string) State {
+	t.Helper()
+
+	timeout := time.After(15 * time.Second)
+	for {
+		select {
+		case state := <-states:
+			if state.Status == status {
+				return state
+			}
+		case <-timeout:
+			t.Fatalf("no race_state with status %s", status)
+		}
+	}
+}
+
+func TestCoordinatorRace(t *testing.T) {
+	c, url := newTestCoordinator(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	states := make(chan State, 256)
+	lanes := startLanes(t, ctx, url, []float64{80, 100, 120}, states)
+	waitFor(t, c, "lanes to join", func(s State) bool { return len(s.Lanes) == 3 })
+
+	if err := lanes[0].RequestStart(StartRequest{Distance: 20, CountdownSeconds: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForStatus(t, states, StatusRacing)
+	state := waitForStatus(t, states, StatusFinished)
+
+	if state.Distance != 20 || state.StartedAt == nil {
+		t.Fatalf("finished state %+v", state)
+	}
+	if len(state.Results) != 3 {
+		t.Fatalf("got %d results, want 3", len(state.Results))
+	}
+	for i, result := range state.Results {
+		if want := fmt.Sprintf("Sim %d", i+1); result.Name != want || result.Place != i+1 {
+			t.Fatalf("result %d is %s in place %d, want %s", i, result.Name, result.Place, want)
+		}
+		if i > 0 && result.FinishTime < state.Results[i-1].FinishTime {
+			t.Fatalf("results not ordered by finish time: %+v", state.Results)
+		}
+	}
+	for _, lane := range state.Lanes {
+		if !lane.Finished || lane.DNF || lane.Distance != 20 {
+			t.Fatalf("lane %+v did not finish the race", lane)
+		}
+	}
+}
+
+func TestCoordinatorCancel(t *testing.T) {
+	c, url := newTestCoordinator(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	states := make(chan State, 256)
+	lanes := startLanes(t, ctx, url, []float64{100, 100}, states)
+	waitFor(t, c, "lanes to join", func(s State) bool { return len(s.Lanes) == 2 })
+
+	if err := lanes[0].RequestStart(StartRequest{Distance: 1000, CountdownSeconds: 1}); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, states, StatusRacing)
+
+	if err := lanes[1].RequestCancel(); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, states, StatusCancelled)
+
+	// A new race can start straight away
+	if err := c.start(StartRequest{Distance: 20, CountdownSeconds: 1}); err != nil {
+		t.Fatalf("start after cancel: %v", err)
+	}
+	state := waitForStatus(t, states, StatusFinished)
+	if len(state.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(state.Results))
+	}
+}
+
+func TestCoordinatorLanesLeaveDuringCountdown(t *testing.T) {
+	c, url := newTestCoordinator(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	states := make(chan State, 256)
+	startLanes(t, ctx, url, []float64{100}, states)
+	waitFor(t, c, "lane to join", func(s State) bool { return len(s.Lanes) == 1 })
+
+	if err := c.start(StartRequest{Distance: 500, CountdownSeconds: 1}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	state := waitFor(t, c, "race to be cancelled", func(s State) bool { return s.Status == StatusCancelled })
+	if !state.Lanes[0].DNF || state.Lanes[0].Connected {
+		t.Fatalf("lane that left is %+v", state.Lanes[0])
+	}
+
+	// The coordinator takes new lanes and races again
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	startLanes(t, ctx, url, []float64{100}, states)
+	waitFor(t, c, "new lane to join", func(s State) bool {
+		for _, lane := range s.Lanes {
+			if lane.Connected {
+				return true
+			}
+		}
+		return false
+	})
+	if err := c.start(StartRequest{Distance: 500, CountdownSeconds: 1}); err != nil {
+		t.Fatalf("start after a cancelled race: %v", err)
+	}
+}
+
+// testLane is a lane connection driven by the test
+type testLane struct {
+	t        *testing.T
+	conn     *websocket.Conn
+	messages chan Envelope
+}
+
+// dialLane connects a test lane and joins it to the coordinator
+func dialLane(t *testing.T, url, name string) *testLane {
+	t.Helper()
+	return dialLaneAs(t, url, JoinRequest{Name: name})
+}
+
+// dialLaneAs connects a test lane and joins it with req
+func dialLaneAs(t *testing.T, url string, req JoinRequest) *testLane {
+	t.Helper()
+
+	l := dialSpectator(t, url)
+	l.send(MsgJoin, req)
+	l.expect(MsgJoined, nil)
+	return l
+}
+
+// dialSpectator connects to the coordinator without joining
+func dialSpectator(t *testing.T, url string) *testLane {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	l := &testLane{t: t, conn: conn, messages: make(chan Envelope, 256)}
+	go func() {
+		defer close(l.messages)
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			for _, line := range bytes.Split(message, []byte{'\n'}) {
+				var env Envelope
+				if json.Unmarshal(line, &env) == nil {
+					l.messages <- env
+				}
+			}
+		}
+	}()
+	return l
+}
+
+// send writes a message to the coordinator
+func (l *testLane) send(msgType string, data interface{}) {
+	l.t.Helper()
+
+	message, err := encode(msgType, data)
+	if err != nil {
+		l.t.Fatal(err)
+	}
+	if err := l.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		l.t.Fatal(err)
+	}
+}
+
+// expect waits for a message of msgType that ok accepts, which may be nil
+func (l *testLane) expect(msgType string, ok func(json.RawMessage) bool) json.RawMessage {
+	l.t.Helper()
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case env, open := <-l.messages:
+			if !open {
+				l.t.Fatalf("connection closed waiting for %s", msgType)
+			}
+			if env.Type == msgType && (ok == nil || ok(env.Data)) {
+				return env.Data
+			}
+		case <-timeout:
+			l.t.Fatalf("timed out waiting for %s", msgType)
+		}
+	}
+}
+
+// hasStatus accepts a race_state with status
+func hasStatus(status string) func(json.RawMessage) bool {
+	return func(data json.RawMessage) bool {
+		var state State
+		return json.Unmarshal(data, &state) == nil && state.Status == status
+	}
+}
+
+func TestCoordinatorTimeLimit(t *testing.T) {
+	_, url := newTestCoordinator(t)
+
+	fast := dialLane(t, url, "Fast")
+	slow := dialLane(t, url, "Slow")
+
+	fast.send(MsgStartRace, StartRequest{Distance: 100, CountdownSeconds: 1, TimeLimitSeconds: 1})
+	fast.expect(MsgRaceState, hasStatus(StatusRacing))
+
+	// A finish short of the distance is refused
+	slow.send(MsgFinished, Progress{Distance: 40, ElapsedTime: 10})
+	slow.expect(MsgError, nil)
+
+	fast.send(MsgFinished, Progress{Distance: 100, ElapsedTime: 20})
+
+	var state State
+	if err := json.Unmarshal(fast.expect(MsgRaceState, hasStatus(StatusFinished)), &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Results) != 1 || state.Results[0].Name != "Fast" {
+		t.Fatalf("results %+v, want only Fast", state.Results)
+	}
+	for _, lane := range state.Lanes {
+		if lane.Name == "Slow" && (lane.Finished || !lane.DNF || lane.Distance != 40) {
+			t.Fatalf("slow lane %+v, want a DNF at 40m", lane)
+		}
+		if lane.Name == "Fast" && (!lane.Finished || lane.DNF || lane.Place != 1) {
+			t.Fatalf("fast lane %+v, want the winner", lane)
+		}
+	}
+}
+
+func TestCoordinatorTimesFinishesOnTheRaceClock(t *testing.T) {
+	c, url := newTestCoordinator(t)
+
+	lane := dialLane(t, url, "Late")
+	lane.send(MsgStartRace, StartRequest{Distance: 100, CountdownSeconds: 1})
+	lane.expect(MsgRaceState, hasStatus(StatusRacing))
+
+	// The lane starts late, so its PM5's own clock reads well under the
+	// time since the start
+	time.Sleep(300 * time.Millisecond)
+	lane.send(MsgFinished, Progress{Distance: 100, ElapsedTime: 0.05})
+
+	state := waitFor(t, c, "the lane to finish", func(s State) bool { return s.Status == StatusFinished })
+	if got := state.Results[0].FinishTime; got < 0.3 {
+		t.Fatalf("finish time %.2fs, want at least the 0.3s since the start", got)
+	}
+}
+
+func TestCoordinatorKeepsLaneSerials(t *testing.T) {
+	c, url := newTestCoordinator(t)
+
+	lane := dialLaneAs(t, url, JoinRequest{Name: "Alice", Serial: "430123456"})
+	lane.send(MsgStartRace, StartRequest{Distance: 100, CountdownSeconds: 1})
+	lane.expect(MsgRaceState, hasStatus(StatusRacing))
+	lane.send(MsgFinished, Progress{Distance: 100})
+
+	state := waitFor(t, c, "the lane to finish", func(s State) bool { return s.Status == StatusFinished })
+	if state.Lanes[0].Serial != "430123456" || state.Results[0].Serial != "430123456" {
+		t.Errorf("lane %+v and result %+v, want serial 430123456", state.Lanes[0], state.Results[0])
+	}
+}
+
+func TestCoordinatorDropsOutOfOrderProgress(t *testing.T) {
+	c, url := newTestCoordinator(t)
+
+	lane := dialLane(t, url, "Alice")
+	lane.send(MsgStartRace, StartRequest{Distance: 500, CountdownSeconds: 1})
+	lane.expect(MsgRaceState, hasStatus(StatusRacing))
+
+	lane.send(MsgProgress, Progress{Distance: 120, ElapsedTime: 30})
+	waitFor(t, c, "the first sample", func(s State) bool { return s.Lanes[0].Distance == 120 })
+
+	// Samples from before the last one are dropped, whichever field shows
+	// it, and a later one is still applied
+	lane.send(MsgProgress, Progress{Distance: 100, ElapsedTime: 31})
+	lane.send(MsgProgress, Progress{Distance: 125, ElapsedTime: 29})
+	lane.send(MsgProgress, Progress{Distance: 130, ElapsedTime: 32})
+
+	state := waitFor(t, c, "the latest sample", func(s State) bool { return s.Lanes[0].Distance != 120 })
+	if got := state.Lanes[0]; got.Distance != 130 || got.ElapsedTime != 32 {
+		t.Fatalf("lane %+v, want the latest sample at 130m", got)
+	}
+}
+
+func TestCoordinatorSpectatorsCannotControlRaces(t *testing.T) {
+	c, url := newTestCoordinator(t)
+
+	lane := dialLane(t, url, "Alice")
+	spectator := dialSpectator(t, url)
+
+	spectator.send(MsgStartRace, StartRequest{Distance: 500, CountdownSeconds: 1})
+	spectator.expect(MsgError, nil)
+	if status := c.State().Status; status == StatusCountdown || status == StatusRacing {
+		t.Fatalf("spectator started a race: status %s", status)
+	}
+
+	lane.send(MsgStartRace, StartRequest{Distance: 500, CountdownSeconds: 1})
+	lane.expect(MsgRaceState, hasStatus(StatusCountdown))
+
+	spectator.send(MsgCancelRace, nil)
+	spectator.expect(MsgError, nil)
+	lane.expect(MsgRaceState, hasStatus(StatusRacing))
+}
+
+// staleErg is an erg whose stats show a sample left over from the last
+// workout before the PM5 resets for the race
+type staleErg struct {
+	stats chan *pm5.WorkoutStats
+}
+
+func (e *staleErg) StartWorkout(ctx context.Context, params *pm5.WorkoutParams) error {
+	e.stats <- &pm5.WorkoutStats{Distance: 2000, ElapsedTime: 420}
+	e.stats <- &pm5.WorkoutStats{Distance: 1, ElapsedTime: 0.4}
+	return nil
+}
+
+func (e *staleErg) SubscribeStats() (<-chan *pm5.WorkoutStats, func()) {
+	return e.stats, func() {}
+}
+
+func TestLaneIgnoresSamplesFromBeforeTheReset(t *testing.T) {
+	c, url := newTestCoordinator(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lane := NewLane(url, "Stale", &staleErg{stats: make(chan *pm5.WorkoutStats, 4)}, nil)
+	go lane.Run(ctx)
+	waitFor(t, c, "lane to join", func(s State) bool { return len(s.Lanes) == 1 })
+
+	if err := c.start(StartRequest{Distance: 100, CountdownSeconds: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	state := waitFor(t, c, "the reset sample", func(s State) bool { return s.Lanes[0].Distance == 1 || s.Lanes[0].Finished })
+	if state.Lanes[0].Finished {
+		t.Fatalf("lane %+v finished on the last workout's sample", state.Lanes[0])
+	}
+}
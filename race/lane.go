@@ -0,0 +1,324 @@
+package race
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/danhigham/ergometer.live/pm5"
+	"github.com/danhigham/ergometer.live/reconnect"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a message to the coordinator
+	writeWait = 10 * time.Second
+
+	// A sample no further than this into its workout shows the PM5 has
+	// reset for the race; earlier samples may be left over from the last
+	// workout
+	resetDistance = 20 // meters
+	resetTime     = 5  // seconds
+)
+
+// Erg is a rowing machine a lane can race on
+type Erg interface {
+	StartWorkout(ctx context.Context, params *pm5.WorkoutParams) error
+	SubscribeStats() (<-chan *pm5.WorkoutStats, func())
+}
+
+// NotifyFunc forwards race messages to the lane's local clients
+type NotifyFunc func(msgType string, data interface{})
+
+// Lane connects an erg to a remote Coordinator, starting its workout on
+// the coordinator's countdown and streaming progress until it finishes
+type Lane struct {
+	url    string
+	name   string
+	erg    Erg
+	notify NotifyFunc
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+
+	// The race currently being rowed, if any
+	raceID     string
+	raceCancel context.CancelFunc
+}
+
+// NewLane creates a lane that joins the coordinator at url under name.
+// notify may be nil.
+func NewLane(url, name string, erg Erg, notify NotifyFunc) *Lane {
+	if notify == nil {
+		notify = func(string, interface{}) {}
+	}
+	return &Lane{
+		url:    url,
+		name:   name,
+		erg:    erg,
+		notify: notify,
+	}
+}
+
+// Run keeps the lane connected to the coordinator until ctx is done
+func (l *Lane) Run(ctx context.Context) {
+	reconnect.Run(ctx, "Race lane", l.serve, nil)
+}
+
+// RequestStart asks the coordinator to start a race
+func (l *Lane) RequestStart(req StartRequest) error {
+	return l.send(MsgStartRace, req)
+}
+
+// RequestCancel asks the coordinator to cancel the race in progress
+func (l *Lane) RequestCancel() error {
+	return l.send(MsgCancelRace, nil)
+}
+
+// serve runs a single connection to the coordinator
+func (l *Lane) serve(ctx context.Context) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, l.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to race coordinator: %w", err)
+	}
+
+	l.mu.Lock()
+	l.conn = conn
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		l.conn = nil
+		l.mu.Unlock()
+		l.cancelRace()
+		conn.Close()
+	}()
+
+	// Close the connection when ctx ends to unblock the read loop
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "lane shutting down"),
+				time.Now().Add(writeWait))
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	join := JoinRequest{Name: l.name}
+	if erg, ok := l.erg.(LocalErg); ok {
+		if info := erg.DeviceInfo(); info != nil {
+			join.Serial = info.Serial
+		}
+	}
+	if err := l.send(MsgJoin, join); err != nil {
+		return err
+	}
+
+	log.Printf("Connected to race coordinator at %s", l.url)
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		// The coordinator's hub may batch several messages per frame
+		for _, line := range bytes.Split(message, []byte{'\n'}) {
+			if len(line) > 0 {
+				l.handleMessage(ctx, line)
+			}
+		}
+	}
+}
+
+// handleMessage processes a message from the coordinator
+func (l *Lane) handleMessage(ctx context.Context, message []byte) {
+	var env Envelope
+	if err := json.Unmarshal(message, &env); err != nil {
+		log.Printf("Invalid message from race coordinator: %v", err)
+		return
+	}
+
+	switch env.Type {
+	case MsgJoined:
+		var joined JoinedResponse
+		if err := json.Unmarshal(env.Data, &joined); err == nil {
+			log.Printf("Joined race coordinator as lane %d", joined.Lane)
+		}
+
+	case MsgRaceStart:
+		var start RaceStart
+		if err := json.Unmarshal(env.Data, &start); err != nil {
+			log.Printf("Invalid race start: %v", err)
+			return
+		}
+
+		raceCtx := l.newRace(ctx, start.RaceID)
+		go l.row(raceCtx, start)
+
+	case MsgRaceState:
+		// Stop rowing a race that was cancelled or timed out
+		var state State
+		if err := json.Unmarshal(env.Data, &state); err == nil &&
+			(state.Status == StatusCancelled || state.Status == StatusFinished) {
+			l.endRace(state.RaceID)
+		}
+		l.notify(MsgRaceState, env.Data)
+
+	case MsgError:
+		var data map[string]string
+		json.Unmarshal(env.Data, &data)
+		log.Printf("Race coordinator error: %s", data["message"])
+		l.notify(MsgError, env.Data)
+	}
+}
+
+// newRace cancels any race being rowed and returns a context for a new one
+func (l *Lane) newRace(ctx context.Context, raceID string) context.Context {
+	l.cancelRace()
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	l.mu.Lock()
+	l.raceID = raceID
+	l.raceCancel = cancel
+	l.mu.Unlock()
+	return raceCtx
+}
+
+// endRace stops rowing raceID if it is the race being rowed
+func (l *Lane) endRace(raceID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.raceCancel != nil && l.raceID == raceID {
+		l.raceCancel()
+		l.raceCancel = nil
+		l.raceID = ""
+	}
+}
+
+// cancelRace stops rowing the current race, if any
+func (l *Lane) cancelRace() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.raceCancel != nil {
+		l.raceCancel()
+		l.raceCancel = nil
+	}
+	l.raceID = ""
+}
+
+// row counts down, starts the workout and streams progress until the
+// lane finishes or the race is cancelled. Samples are ignored until the
+// PM5 shows the race's workout has begun, so a sample left over from the
+// last workout can't finish the race at the start.
+func (l *Lane) row(ctx context.Context, start RaceStart) {
+	startAt := time.Now().Add(time.Duration(start.CountdownMs) * time.Millisecond)
+
+	if !countdown(ctx, startAt, func(remaining int) {
+		l.notify(MsgCountdown, Countdown{
+			RaceID:    start.RaceID,
+			Remaining: remaining,
+			Distance:  start.Distance,
+		})
+	}) {
+		return
+	}
+
+	// Subscribe before starting so no early samples are missed
+	stats, unsubscribe := l.erg.SubscribeStats()
+	defer unsubscribe()
+
+	err := l.erg.StartWorkout(ctx, &pm5.WorkoutParams{
+		WorkoutType: "fixed_distance",
+		Distance:    start.Distance,
+	})
+	if err != nil {
+		log.Printf("Failed to start race workout: %v", err)
+		l.notify(MsgError, map[string]string{"message": "Failed to start race workout: " + err.Error()})
+		return
+	}
+
+	reset := false
+	for {
+		select {
+		case s, ok := <-stats:
+			if !ok {
+				return
+			}
+
+			if !reset {
+				if s.Distance > resetDistance || s.ElapsedTime > resetTime {
+					continue
+				}
+				reset = true
+			}
+
+			progress := Progress{
+				Distance:    s.Distance,
+				ElapsedTime: s.ElapsedTime,
+				Pace:        s.Pace,
+				StrokeRate:  s.StrokeRate,
+				Power:       s.Power,
+			}
+
+			if s.Distance >= float64(start.Distance) {
+				l.send(MsgFinished, progress)
+				return
+			}
+			l.send(MsgProgress, progress)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// send writes a message to the coordinator
+func (l *Lane) send(msgType string, data interface{}) error {
+	message, err := encode(msgType, data)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return fmt.Errorf("not connected to race coordinator")
+	}
+
+	l.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return l.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+// countdown calls tick once per second with the whole seconds remaining
+// until startAt, ending with 0. It returns false if ctx ends first.
+func countdown(ctx context.Context, startAt time.Time, tick func(remaining int)) bool {
+	for {
+		remaining := time.Until(startAt)
+		if remaining <= 0 {
+			tick(0)
+			return true
+		}
+
+		seconds := int(math.Ceil(remaining.Seconds()))
+		tick(seconds)
+
+		// Sleep until the next whole second boundary before the start
+		wait := remaining - time.Duration(seconds-1)*time.Second
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,514 @@
+package race
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danhigham/ergometer.live/pm5"
+)
+
+// staleSampleSlack is how far a sample's elapsed time may run ahead of the
+// race clock before it is treated as left over from before the start
+const staleSampleSlack = time.Second
+
+// LocalErg is an erg attached to this server that can race locally
+type LocalErg interface {
+	Erg
+	DeviceInfo() *pm5.DeviceInfo
+}
+
+// LocalRace races every erg attached to one server against each other.
+// The workout is programmed on all ergs when the countdown begins so
+// rowers can sit ready, then issued again on every erg in parallel at
+// zero. Each PM5's clock only starts at its rower's first stroke, so
+// finishes are timed on the race clock from zero instead, and fixed_time
+// races end for everyone when the race clock reaches the time. Any stroke
+// during the countdown is a false start.
+type LocalRace struct {
+	ergs   []LocalErg
+	notify NotifyFunc
+
+	mu        sync.Mutex
+	raceID    string
+	status    string
+	params    pm5.WorkoutParams
+	startedAt time.Time
+	lanes     []*LaneState
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// NewLocalRace creates a race between ergs. notify receives the race
+// messages for local clients and may be nil.
+func NewLocalRace(ergs []LocalErg, notify NotifyFunc) *LocalRace {
+	if notify == nil {
+		notify = func(string, interface{}) {}
+	}
+	return &LocalRace{
+		ergs:   ergs,
+		notify: notify,
+		status: StatusWaiting,
+	}
+}
+
+// Start programs params on every erg and starts them together after the
+// countdown. Only fixed_distance and fixed_time workouts can be raced.
+func (r *LocalRace) Start(params *pm5.WorkoutParams, countdownSeconds int) error {
+	switch {
+	case params.WorkoutType == "fixed_distance" && params.Distance > 0:
+	case params.WorkoutType == "fixed_time" && params.Time > 0:
+	default:
+		return fmt.Errorf("races need a fixed_distance or fixed_time workout")
+	}
+
+	countdown := time.Duration(countdownSeconds) * time.Second
+	if countdown <= 0 {
+		countdown = DefaultCountdown
+	}
+	if countdown > MaxCountdown {
+		return fmt.Errorf("countdown may be at most %v", MaxCountdown)
+	}
+
+	// Race the ergs that are connected right now
+	var ergs []LocalErg
+	var lanes []*LaneState
+	for _, erg := range r.ergs {
+		info := erg.DeviceInfo()
+		if info == nil || !info.Connected {
+			continue
+		}
+		ergs = append(ergs, erg)
+		lanes = append(lanes, &LaneState{
+			Lane:      len(lanes) + 1,
+			Name:      fmt.Sprintf("Erg %d", len(lanes)+1),
+			Serial:    info.Serial,
+			Connected: true,
+		})
+	}
+	if len(ergs) == 0 {
+		return fmt.Errorf("no ergs are connected")
+	}
+
+	r.mu.Lock()
+	if r.status == StatusCountdown || r.status == StatusRacing {
+		r.mu.Unlock()
+		return fmt.Errorf("a race is already in progress")
+	}
+
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	r.raceID = now.UTC().Format("20060102T150405Z")
+	r.status = StatusCountdown
+	r.params = pm5.WorkoutParams{
+		WorkoutType:   params.WorkoutType,
+		Distance:      params.Distance,
+		Time:          params.Time,
+		SplitDistance: params.SplitDistance,
+		SplitTime:     params.SplitTime,
+	}
+	r.startedAt = now.Add(countdown)
+	r.lanes = lanes
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	raceID := r.raceID
+	startAt := r.startedAt
+	done := r.done
+	r.mu.Unlock()
+
+	// Subscribe before programming so early strokes are seen
+	streams := make([]<-chan *pm5.WorkoutStats, len(ergs))
+	unsubscribes := make([]func(), len(ergs))
+	for i, erg := range ergs {
+		streams[i], unsubscribes[i] = erg.SubscribeStats()
+	}
+
+	if err := startAll(ctx, ergs, &r.params); err != nil {
+		for _, unsubscribe := range unsubscribes {
+			unsubscribe()
+		}
+		cancel()
+		r.mu.Lock()
+		r.status = StatusCancelled
+		r.mu.Unlock()
+		close(done)
+		return err
+	}
+
+	log.Printf("Local race %s: %d ergs starting in %v", raceID, len(ergs), countdown)
+
+	go r.run(ctx, raceID, ergs, streams, unsubscribes, startAt, done)
+	return nil
+}
+
+// Cancel stops the race in progress, if any. The workouts stay programmed
+// on the ergs.
+func (r *LocalRace) Cancel() bool {
+	r.mu.Lock()
+	if r.status != StatusCountdown && r.status != StatusRacing {
+		r.mu.Unlock()
+		return false
+	}
+	r.status = StatusCancelled
+	r.cancel()
+	raceID := r.raceID
+	r.mu.Unlock()
+
+	log.Printf("Local race %s cancelled", raceID)
+	r.notify(MsgRaceState, r.State())
+	return true
+}
+
+// Shutdown cancels any race in progress and waits for it to stop
+func (r *LocalRace) Shutdown(ctx context.Context) error {
+	r.Cancel()
+
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// State returns the current race state
+func (r *LocalRace) State() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := State{
+		RaceID:   r.raceID,
+		Status:   r.status,
+		Distance: r.params.Distance,
+		Time:     r.params.Time,
+		Lanes:    make([]LaneState, 0, len(r.lanes)),
+		Results:  []Result{},
+	}
+
+	if r.status == StatusRacing || r.status == StatusFinished {
+		startedAt := r.startedAt
+		state.StartedAt = &startedAt
+	}
+
+	for _, lane := range r.lanes {
+		state.Lanes = append(state.Lanes, *lane)
+		if lane.Finished {
+			state.Results = append(state.Results, Result{
+				Place:      lane.Place,
+				Lane:       lane.Lane,
+				Name:       lane.Name,
+				Serial:     lane.Serial,
+				FinishTime: lane.FinishTime,
+				Distance:   r.resultDistance(lane),
+				FalseStart: lane.FalseStart,
+			})
+		}
+	}
+
+	sort.Slice(state.Results, func(i, j int) bool {
+		return state.Results[i].Place < state.Results[j].Place
+	})
+	return state
+}
+
+// run drives a race from the countdown to the last finisher
+func (r *LocalRace) run(ctx context.Context, raceID string, ergs []LocalErg, streams []<-chan *pm5.WorkoutStats, unsubscribes []func(), startAt time.Time, done chan struct{}) {
+	defer close(done)
+
+	var wg sync.WaitGroup
+	defer func() {
+		for _, unsubscribe := range unsubscribes {
+			unsubscribe()
+		}
+		wg.Wait()
+	}()
+
+	for i, stream := range streams {
+		wg.Add(1)
+		go func(lane int, stream <-chan *pm5.WorkoutStats) {
+			defer wg.Done()
+			for s := range stream {
+				r.sample(raceID, lane, s)
+			}
+		}(i, stream)
+	}
+
+	r.mu.Lock()
+	params := r.params
+	r.mu.Unlock()
+
+	if !countdown(ctx, startAt, func(remaining int) {
+		r.notify(MsgCountdown, Countdown{
+			RaceID:    raceID,
+			Remaining: remaining,
+			Distance:  params.Distance,
+			Time:      params.Time,
+		})
+	}) {
+		return
+	}
+
+	// Issue the start on every erg at once; this also resets any erg that
+	// was rowed during the countdown
+	r.mu.Lock()
+	if r.raceID == raceID && r.status == StatusCountdown {
+		r.status = StatusRacing
+		r.startedAt = time.Now()
+	}
+	r.mu.Unlock()
+
+	if err := startAll(ctx, ergs, &params); err != nil {
+		log.Printf("Local race %s: %v", raceID, err)
+		r.notify(MsgError, map[string]string{"message": "Failed to start race: " + err.Error()})
+	}
+	r.notify(MsgRaceState, r.State())
+
+	ticker := time.NewTicker(stateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if r.dropLanes(raceID, ergs) {
+				log.Printf("Local race %s ended, ergs disconnected", raceID)
+			}
+			if r.timeUp(raceID) {
+				log.Printf("Local race %s finished, time is up", raceID)
+			}
+			state := r.State()
+			r.notify(MsgRaceState, state)
+			if state.Status != StatusRacing {
+				return
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sample applies a stats update from one lane
+func (r *LocalRace) sample(raceID string, index int, s *pm5.WorkoutStats) {
+	r.mu.Lock()
+
+	if r.raceID != raceID || index >= len(r.lanes) {
+		r.mu.Unlock()
+		return
+	}
+	lane := r.lanes[index]
+
+	switch r.status {
+	case StatusCountdown:
+		if lane.FalseStart || (s.Distance <= 0 && s.StrokeRate == 0) {
+			r.mu.Unlock()
+			return
+		}
+		lane.FalseStart = true
+		falseStart := FalseStart{RaceID: raceID, Lane: lane.Lane, Name: lane.Name, Serial: lane.Serial}
+		r.mu.Unlock()
+
+		log.Printf("Local race %s: false start by lane %d (%s)", raceID, lane.Lane, lane.Serial)
+		r.notify(MsgFalseStart, falseStart)
+		return
+
+	case StatusRacing:
+	default:
+		r.mu.Unlock()
+		return
+	}
+
+	// Ignore samples from the workout a false starter began early
+	if s.ElapsedTime > (time.Since(r.startedAt) + staleSampleSlack).Seconds() {
+		r.mu.Unlock()
+		return
+	}
+
+	// Lanes that dropped out stay out even if their erg comes back
+	if lane.Finished || !lane.Connected {
+		r.mu.Unlock()
+		return
+	}
+
+	lane.Distance = s.Distance
+	lane.ElapsedTime = s.ElapsedTime
+	lane.Pace = s.Pace
+	lane.StrokeRate = s.StrokeRate
+	lane.Power = s.Power
+
+	elapsed := raceTime(r.startedAt, time.Now())
+	finished := false
+	switch r.params.WorkoutType {
+	case "fixed_distance":
+		if s.Distance >= float64(r.params.Distance) {
+			lane.Finished = true
+			lane.FinishTime = elapsed
+			lane.Distance = float64(r.params.Distance)
+		}
+	case "fixed_time":
+		// A late starter's PM5 runs on past the race's time
+		if s.ElapsedTime >= float64(r.params.Time) || elapsed >= float64(r.params.Time) {
+			lane.Finished = true
+			lane.FinishTime = float64(r.params.Time)
+		}
+	}
+
+	if lane.Finished {
+		r.assignPlaces()
+		finished = r.checkComplete()
+		log.Printf("Local race %s: lane %d finished (%.0fm in %.1fs)", raceID, lane.Lane, lane.Distance, lane.FinishTime)
+	}
+	r.mu.Unlock()
+
+	if finished {
+		log.Printf("Local race %s finished", raceID)
+		r.notify(MsgRaceState, r.State())
+	}
+}
+
+// dropLanes marks the lanes of ergs that have disconnected as not
+// finishing and reports whether that ended the race
+func (r *LocalRace) dropLanes(raceID string, ergs []LocalErg) bool {
+	connected := make([]bool, len(ergs))
+	for i, erg := range ergs {
+		info := erg.DeviceInfo()
+		connected[i] = info != nil && info.Connected
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.raceID != raceID || r.status != StatusRacing {
+		return false
+	}
+
+	dropped := false
+	for i, lane := range r.lanes {
+		if i >= len(connected) || connected[i] || !lane.Connected || lane.Finished {
+			continue
+		}
+		lane.Connected = false
+		lane.DNF = true
+		dropped = true
+		log.Printf("Local race %s: lane %d (%s) disconnected", raceID, lane.Lane, lane.Serial)
+	}
+
+	return dropped && r.checkComplete()
+}
+
+// timeUp finishes the lanes of a fixed_time race still rowing once the
+// race clock reaches its time, at their last distance, and reports whether
+// that ended the race
+func (r *LocalRace) timeUp(raceID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.raceID != raceID || r.status != StatusRacing || r.params.WorkoutType != "fixed_time" ||
+		raceTime(r.startedAt, time.Now()) < float64(r.params.Time) {
+		return false
+	}
+
+	for _, lane := range r.lanes {
+		if lane.Connected && !lane.Finished {
+			lane.Finished = true
+			lane.FinishTime = float64(r.params.Time)
+		}
+	}
+	r.assignPlaces()
+	return r.checkComplete()
+}
+
+// assignPlaces orders finished lanes by finish time, or by distance for
+// fixed_time races. Must hold r.mu.
+func (r *LocalRace) assignPlaces() {
+	var finished []*LaneState
+	for _, lane := range r.lanes {
+		if lane.Finished {
+			finished = append(finished, lane)
+		}
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		a, b := finished[i], finished[j]
+		if a.FinishTime != b.FinishTime {
+			return a.FinishTime < b.FinishTime
+		}
+		if a.Distance != b.Distance {
+			return a.Distance > b.Distance
+		}
+		return a.Lane < b.Lane
+	})
+
+	for i, lane := range finished {
+		lane.Place = i + 1
+	}
+}
+
+// checkComplete ends the race once every lane still connected has
+// finished and reports whether it did. A race no lane finished is
+// cancelled. Must hold r.mu.
+func (r *LocalRace) checkComplete() bool {
+	finishers := 0
+	for _, lane := range r.lanes {
+		if lane.Connected && !lane.Finished {
+			return false
+		}
+		if lane.Finished {
+			finishers++
+		}
+	}
+
+	if finishers == 0 {
+		r.status = StatusCancelled
+	} else {
+		r.status = StatusFinished
+	}
+	return true
+}
+
+// resultDistance returns the distance shown in a lane's result. Must hold
+// r.mu.
+func (r *LocalRace) resultDistance(lane *LaneState) float64 {
+	if r.params.WorkoutType == "fixed_time" {
+		return lane.Distance
+	}
+	return 0
+}
+
+// startAll starts params on every erg in parallel
+func startAll(ctx context.Context, ergs []LocalErg, params *pm5.WorkoutParams) error {
+	errs := make([]error, len(ergs))
+
+	var wg sync.WaitGroup
+	for i, erg := range ergs {
+		wg.Add(1)
+		go func(i int, erg LocalErg) {
+			defer wg.Done()
+
+			startCtx, cancel := context.WithTimeout(ctx, pm5.DefaultControlTimeout)
+			defer cancel()
+
+			if err := erg.StartWorkout(startCtx, params); err != nil {
+				serial := ""
+				if info := erg.DeviceInfo(); info != nil {
+					serial = info.Serial
+				}
+				errs[i] = fmt.Errorf("erg %s: %w", serial, err)
+			}
+		}(i, erg)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
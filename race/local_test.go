@@ -0,0 +1,279 @@
+package race
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danhigham/ergometer.live/pm5"
+)
+
+// fakeErg is a LocalErg whose stats and connection the test controls
+type fakeErg struct {
+	serial string
+
+	mu          sync.Mutex
+	connected   bool
+	subscribers map[chan *pm5.WorkoutStats]struct{}
+}
+
+func newFakeErg(serial string) *fakeErg {
+	return &fakeErg{
+		serial:      serial,
+		connected:   true,
+		subscribers: make(map[chan *pm5.WorkoutStats]struct{}),
+	}
+}
+
+func (e *fakeErg) StartWorkout(ctx context.Context, params *pm5.WorkoutParams) error {
+	return nil
+}
+
+func (e *fakeErg) SubscribeStats() (<-chan *pm5.WorkoutStats, func()) {
+	ch := make(chan *pm5.WorkoutStats, 16)
+
+	e.mu.Lock()
+	e.subscribers[ch] = struct{}{}
+	e.mu.Unlock()
+
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.subscribers[ch]; ok {
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (e *fakeErg) DeviceInfo() *pm5.DeviceInfo {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return &pm5.DeviceInfo{Serial: e.serial, Connected: e.connected}
+}
+
+// row publishes a stats sample
+func (e *fakeErg) row(distance, elapsed float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subscribers {
+		ch <- &pm5.WorkoutStats{Distance: distance, ElapsedTime: elapsed, StrokeRate: 30}
+	}
+}
+
+// disconnect drops the erg
+func (e *fakeErg) disconnect() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.connected = false
+}
+
+// startLocalRace starts a 100m race between ergs and waits for the gun
+func startLocalRace(t *testing.T, ergs ...*fakeErg) *LocalRace {
+	t.Helper()
+
+	r := newLocalRace(t, nil, ergs...)
+	if err := r.Start(&pm5.WorkoutParams{WorkoutType: "fixed_distance", Distance: 100}, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitForLocal(t, r, StatusRacing)
+	return r
+}
+
+// newLocalRace creates a race between ergs, shut down when the test ends
+func newLocalRace(t *testing.T, notify NotifyFunc, ergs ...*fakeErg) *LocalRace {
+	t.Helper()
+
+	localErgs := make([]LocalErg, len(ergs))
+	for i, erg := range ergs {
+		localErgs[i] = erg
+	}
+
+	r := NewLocalRace(localErgs, notify)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		r.Shutdown(ctx)
+	})
+	return r
+}
+
+// waitForLocal polls the race until it reaches status
+func waitForLocal(t *testing.T, r *LocalRace, status string) State {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state := r.State()
+		if state.Status == status {
+			return state
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s, state %+v", status, state)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// waitForLane polls the race until ok accepts the lane at index
+func waitForLane(t *testing.T, r *LocalRace, index int, ok func(LaneState) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lane := r.State().Lanes[index]
+		if ok(lane) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for lane %+v", lane)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestLocalRaceDroppedErg(t *testing.T) {
+	first, second := newFakeErg("430000001"), newFakeErg("430000002")
+	r := startLocalRace(t, first, second)
+
+	second.row(30, 0.1)
+	waitForLane(t, r, 1, func(lane LaneState) bool { return lane.Distance == 30 })
+	second.disconnect()
+	waitForLane(t, r, 1, func(lane LaneState) bool { return lane.DNF })
+
+	// Strokes from the erg once it's back don't count
+	second.row(100, 0.2)
+	first.row(100, 0.3)
+
+	state := waitForLocal(t, r, StatusFinished)
+	if len(state.Results) != 1 || state.Results[0].Serial != first.serial {
+		t.Fatalf("results %+v, want only the first erg", state.Results)
+	}
+	dropped := state.Lanes[1]
+	if dropped.Connected || dropped.Finished || dropped.Distance != 30 {
+		t.Fatalf("dropped lane %+v, want a DNF at 30m", dropped)
+	}
+}
+
+func TestLocalRaceAllErgsDropped(t *testing.T) {
+	first, second := newFakeErg("430000001"), newFakeErg("430000002")
+	r := startLocalRace(t, first, second)
+
+	first.disconnect()
+	second.disconnect()
+
+	state := waitForLocal(t, r, StatusCancelled)
+	for _, lane := range state.Lanes {
+		if !lane.DNF {
+			t.Fatalf("lane %+v not marked DNF", lane)
+		}
+	}
+	if err := r.Start(&pm5.WorkoutParams{WorkoutType: "fixed_distance", Distance: 100}, 1); err == nil {
+		t.Fatal("started a race with no ergs connected")
+	}
+}
+
+func TestLocalRaceFalseStart(t *testing.T) {
+	early, onTime := newFakeErg("430000001"), newFakeErg("430000002")
+
+	falseStarts := make(chan FalseStart, 4)
+	r := newLocalRace(t, func(msgType string, data interface{}) {
+		if msgType == MsgFalseStart {
+			falseStarts <- data.(FalseStart)
+		}
+	}, early, onTime)
+
+	if err := r.Start(&pm5.WorkoutParams{WorkoutType: "fixed_distance", Distance: 100}, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stroke during the countdown is reported once
+	early.row(3, 0.5)
+	early.row(6, 1)
+	select {
+	case falseStart := <-falseStarts:
+		if falseStart.Lane != 1 || falseStart.Serial != early.serial {
+			t.Fatalf("false start %+v, want lane 1", falseStart)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no false start reported")
+	}
+	waitForLane(t, r, 0, func(lane LaneState) bool { return lane.FalseStart })
+
+	waitForLocal(t, r, StatusRacing)
+
+	// A sample from the workout begun early is ignored after the gun
+	early.row(100, 30)
+	onTime.row(100, 0.2)
+	waitForLane(t, r, 1, func(lane LaneState) bool { return lane.Finished })
+	if lane := r.State().Lanes[0]; lane.Finished {
+		t.Fatalf("false starter %+v finished on the early workout's sample", lane)
+	}
+
+	early.row(100, 0.3)
+	state := waitForLocal(t, r, StatusFinished)
+	if len(state.Results) != 2 || state.Results[1].Serial != early.serial || !state.Results[1].FalseStart {
+		t.Fatalf("results %+v, want the false starter second and flagged", state.Results)
+	}
+	if state.Results[0].FalseStart {
+		t.Fatalf("on-time starter flagged: %+v", state.Results[0])
+	}
+	select {
+	case falseStart := <-falseStarts:
+		t.Fatalf("false start %+v reported again", falseStart)
+	default:
+	}
+}
+
+func TestLocalRaceOrdersFinishesOnTheRaceClock(t *testing.T) {
+	prompt, late := newFakeErg("430000001"), newFakeErg("430000002")
+	r := startLocalRace(t, prompt, late)
+
+	prompt.row(100, 0.3)
+	waitForLane(t, r, 0, func(lane LaneState) bool { return lane.Finished })
+
+	// The late starter's PM5 shows a faster time, having started later
+	time.Sleep(200 * time.Millisecond)
+	late.row(100, 0.1)
+
+	state := waitForLocal(t, r, StatusFinished)
+	if len(state.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(state.Results))
+	}
+	first, second := state.Results[0], state.Results[1]
+	if first.Serial != prompt.serial || first.Place != 1 || second.Serial != late.serial || second.Place != 2 {
+		t.Fatalf("results %+v, want the prompt starter first", state.Results)
+	}
+	if second.FinishTime-first.FinishTime < 0.2 {
+		t.Fatalf("finish times %.2fs and %.2fs, want at least 0.2s apart", first.FinishTime, second.FinishTime)
+	}
+}
+
+func TestLocalRaceFixedTimeEndsOnTheRaceClock(t *testing.T) {
+	near, far, idle := newFakeErg("430000001"), newFakeErg("430000002"), newFakeErg("430000003")
+	r := newLocalRace(t, nil, near, far, idle)
+
+	if err := r.Start(&pm5.WorkoutParams{WorkoutType: "fixed_time", Time: 1}, 1); err != nil {
+		t.Fatal(err)
+	}
+	waitForLocal(t, r, StatusRacing)
+
+	// None of the PM5s reach the time on their own clocks
+	near.row(4, 0.5)
+	far.row(6, 0.5)
+
+	state := waitForLocal(t, r, StatusFinished)
+	if len(state.Results) != 3 {
+		t.Fatalf("got %d results, want 3", len(state.Results))
+	}
+	want := []string{far.serial, near.serial, idle.serial}
+	for i, result := range state.Results {
+		if result.Serial != want[i] || result.FinishTime != 1 {
+			t.Errorf("result %d is %+v, want %s at 1s", i, result, want[i])
+		}
+	}
+	if state.Results[0].Distance != 6 {
+		t.Errorf("winner's distance %.0fm, want 6m", state.Results[0].Distance)
+	}
+}
@@ -0,0 +1,142 @@
+package race
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Message types exchanged between lanes and the coordinator
+const (
+	// Lane -> coordinator
+	MsgJoin       = "join"
+	MsgStartRace  = "start_race"
+	MsgCancelRace = "cancel_race"
+	MsgProgress   = "progress"
+	MsgFinished   = "finished"
+
+	// Coordinator -> lane
+	MsgJoined    = "joined"
+	MsgRaceStart = "race_start"
+	MsgRaceState = "race_state"
+	MsgError     = "error"
+
+	// Lane or local race -> local WebSocket clients
+	MsgCountdown  = "race_countdown"
+	MsgFalseStart = "race_false_start"
+)
+
+// Race statuses
+const (
+	StatusWaiting   = "waiting"
+	StatusCountdown = "countdown"
+	StatusRacing    = "racing"
+	StatusFinished  = "finished"
+	StatusCancelled = "cancelled"
+)
+
+// Envelope is the wire format shared with the socket server's messages
+type Envelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// JoinRequest registers a lane with the coordinator
+type JoinRequest struct {
+	Name   string `json:"name"`
+	Serial string `json:"serial,omitempty"`
+}
+
+// JoinedResponse confirms a lane's registration
+type JoinedResponse struct {
+	Lane   int    `json:"lane"`
+	RaceID string `json:"race_id"`
+}
+
+// StartRequest asks the coordinator to start a race
+type StartRequest struct {
+	Distance         uint32 `json:"distance"`                     // meters
+	CountdownSeconds int    `json:"countdown_seconds"`            // defaults to DefaultCountdown
+	TimeLimitSeconds int    `json:"time_limit_seconds,omitempty"` // defaults to a limit for the distance
+}
+
+// RaceStart tells lanes to program the race and start after the countdown.
+// The countdown is relative so lanes don't depend on synchronised clocks.
+type RaceStart struct {
+	RaceID      string `json:"race_id"`
+	Distance    uint32 `json:"distance"`
+	CountdownMs int64  `json:"countdown_ms"`
+}
+
+// Progress is a lane's position during a race
+type Progress struct {
+	Distance    float64 `json:"distance"`     // meters
+	ElapsedTime float64 `json:"elapsed_time"` // seconds
+	Pace        float64 `json:"pace"`         // seconds per 500m
+	StrokeRate  byte    `json:"stroke_rate"`
+	Power       uint32  `json:"power"`
+}
+
+// Countdown is broadcast to a lane's local clients once per second
+type Countdown struct {
+	RaceID    string `json:"race_id"`
+	Remaining int    `json:"remaining"` // seconds, 0 = go
+	Distance  uint32 `json:"distance"`
+	Time      uint32 `json:"time,omitempty"` // seconds, for fixed_time races
+}
+
+// FalseStart reports a lane that rowed before the start of a local race
+type FalseStart struct {
+	RaceID string `json:"race_id"`
+	Lane   int    `json:"lane"`
+	Name   string `json:"name"`
+	Serial string `json:"serial,omitempty"`
+}
+
+// LaneState is a lane's entry in the combined race state
+type LaneState struct {
+	Lane        int     `json:"lane"`
+	Name        string  `json:"name"`
+	Serial      string  `json:"serial,omitempty"`
+	Connected   bool    `json:"connected"`
+	Distance    float64 `json:"distance"`
+	ElapsedTime float64 `json:"elapsed_time"`
+	Pace        float64 `json:"pace"`
+	StrokeRate  byte    `json:"stroke_rate"`
+	Power       uint32  `json:"power"`
+	Finished    bool    `json:"finished"`
+	FinishTime  float64 `json:"finish_time,omitempty"` // seconds on the race clock
+	Place       int     `json:"place,omitempty"`
+	FalseStart  bool    `json:"false_start,omitempty"`
+	DNF         bool    `json:"dnf,omitempty"` // did not finish
+}
+
+// Result is a finishing position
+type Result struct {
+	Place      int     `json:"place"`
+	Lane       int     `json:"lane"`
+	Name       string  `json:"name"`
+	Serial     string  `json:"serial,omitempty"`
+	FinishTime float64 `json:"finish_time"`        // seconds on the race clock
+	Distance   float64 `json:"distance,omitempty"` // meters, for fixed_time races
+	FalseStart bool    `json:"false_start,omitempty"`
+}
+
+// State is the combined race state sent to every lane
+type State struct {
+	RaceID    string      `json:"race_id"`
+	Status    string      `json:"status"`
+	Distance  uint32      `json:"distance"`
+	Time      uint32      `json:"time,omitempty"` // seconds, for fixed_time races
+	StartedAt *time.Time  `json:"started_at,omitempty"`
+	Lanes     []LaneState `json:"lanes"`
+	Results   []Result    `json:"results"`
+}
+
+// encode marshals a message envelope
+func encode(msgType string, data interface{}) ([]byte, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{Type: msgType, Data: raw})
+}
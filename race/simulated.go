@@ -0,0 +1,126 @@
+package race
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/danhigham/ergometer.live/pm5"
+)
+
+// simulatedInterval matches the monitor's active polling rate
+const simulatedInterval = 100 * time.Millisecond
+
+// SimulatedErg rows fixed_distance workouts at a set pace without a
+// device, so races can be tested with several lanes on one machine
+type SimulatedErg struct {
+	pace       float64 // seconds per 500m
+	strokeRate byte
+
+	mu          sync.Mutex
+	subscribers map[chan *pm5.WorkoutStats]struct{}
+	cancel      context.CancelFunc
+}
+
+// NewSimulatedErg creates an erg rowing at pace seconds per 500m
+func NewSimulatedErg(pace float64, strokeRate byte) *SimulatedErg {
+	return &SimulatedErg{
+		pace:        pace,
+		strokeRate:  strokeRate,
+		subscribers: make(map[chan *pm5.WorkoutStats]struct{}),
+	}
+}
+
+// StartWorkout starts rowing the workout in the background
+func (e *SimulatedErg) StartWorkout(ctx context.Context, params *pm5.WorkoutParams) error {
+	if params.WorkoutType != "fixed_distance" || params.Distance == 0 {
+		return fmt.Errorf("simulated erg only rows fixed_distance workouts")
+	}
+
+	e.mu.Lock()
+	if e.cancel != nil {
+		e.cancel()
+	}
+	rowCtx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.mu.Unlock()
+
+	go e.row(rowCtx, float64(params.Distance))
+	return nil
+}
+
+// SubscribeStats implements Erg
+func (e *SimulatedErg) SubscribeStats() (<-chan *pm5.WorkoutStats, func()) {
+	ch := make(chan *pm5.WorkoutStats, 64)
+
+	e.mu.Lock()
+	e.subscribers[ch] = struct{}{}
+	e.mu.Unlock()
+
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if _, ok := e.subscribers[ch]; ok {
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Stop ends any workout in progress
+func (e *SimulatedErg) Stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cancel != nil {
+		e.cancel()
+		e.cancel = nil
+	}
+}
+
+// row produces stats in real time until distance is reached
+func (e *SimulatedErg) row(ctx context.Context, distance float64) {
+	ticker := time.NewTicker(simulatedInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	rowed := 0.0
+
+	for rowed < distance {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		// Vary the pace by up to 3% either way
+		pace := e.pace * (1 + (rand.Float64()-0.5)*0.06)
+		rowed += 500 / pace * simulatedInterval.Seconds()
+		if rowed > distance {
+			rowed = distance
+		}
+
+		e.publish(&pm5.WorkoutStats{
+			ElapsedTime: time.Since(start).Seconds(),
+			Distance:    rowed,
+			Pace:        pace,
+			Power:       uint32(2.8 / ((pace / 500) * (pace / 500) * (pace / 500))),
+			StrokeRate:  e.strokeRate,
+			WorkoutType: "FixedDistanceSplits",
+		})
+	}
+}
+
+// publish delivers stats to every subscriber
+func (e *SimulatedErg) publish(stats *pm5.WorkoutStats) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for ch := range e.subscribers {
+		select {
+		case ch <- stats:
+		default:
+		}
+	}
+}
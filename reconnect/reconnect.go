@@ -0,0 +1,79 @@
+// Package reconnect keeps long-lived outbound connections, such as race
+// lanes, coach sources and the relay, open with exponential backoff.
+package reconnect
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// Backoff bounds between connection attempts
+const (
+	MinDelay = 2 * time.Second
+	MaxDelay = 30 * time.Second
+)
+
+// ErrDone is returned by a serve function to stop reconnecting
+var ErrDone = errors.New("done reconnecting")
+
+// ServeFunc runs a single connection until it ends
+type ServeFunc func(ctx context.Context) error
+
+// WaitFunc waits out delay before the next attempt, reporting false to
+// stop reconnecting
+type WaitFunc func(ctx context.Context, delay time.Duration) bool
+
+// Run calls serve until ctx is done or serve returns ErrDone, logging each
+// connection's error under what. Attempts are spaced from MinDelay,
+// doubling up to MaxDelay, and the delay starts over after a connection
+// that stayed up longer than MaxDelay. wait may be nil to just sleep.
+func Run(ctx context.Context, what string, serve ServeFunc, wait WaitFunc) {
+	if wait == nil {
+		wait = Sleep
+	}
+	delay := MinDelay
+
+	for {
+		start := time.Now()
+		err := serve(ctx)
+		if errors.Is(err, ErrDone) {
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("%s disconnected: %v", what, err)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Reset the backoff after a connection that stayed up a while
+		if time.Since(start) > MaxDelay {
+			delay = MinDelay
+		}
+
+		if !wait(ctx, delay) {
+			return
+		}
+
+		delay *= 2
+		if delay > MaxDelay {
+			delay = MaxDelay
+		}
+	}
+}
+
+// Sleep waits for delay, reporting false if ctx is done first
+func Sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,76 @@
+package reconnect
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunBacksOff(t *testing.T) {
+	var delays []time.Duration
+	attempts := 0
+
+	Run(context.Background(), "test", func(ctx context.Context) error {
+		attempts++
+		if attempts == 7 {
+			return ErrDone
+		}
+		return errors.New("connection refused")
+	}, func(ctx context.Context, delay time.Duration) bool {
+		delays = append(delays, delay)
+		return true
+	})
+
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second}
+	if attempts != 7 || len(delays) != len(want) {
+		t.Fatalf("%d attempts with delays %v, want 7 with %v", attempts, delays, want)
+	}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Errorf("delay %d is %v, want %v", i, delays[i], want[i])
+		}
+	}
+}
+
+func TestRunStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+
+	// Cancelling ctx stops Run without waiting
+	Run(ctx, "test", func(ctx context.Context) error {
+		attempts++
+		cancel()
+		return ctx.Err()
+	}, func(ctx context.Context, delay time.Duration) bool {
+		t.Error("waited after ctx was done")
+		return true
+	})
+	if attempts != 1 {
+		t.Errorf("%d attempts after ctx was done, want 1", attempts)
+	}
+
+	// So does the wait reporting false
+	attempts = 0
+	Run(context.Background(), "test", func(ctx context.Context) error {
+		attempts++
+		return nil
+	}, func(ctx context.Context, delay time.Duration) bool {
+		return false
+	})
+	if attempts != 1 {
+		t.Errorf("%d attempts after the wait stopped, want 1", attempts)
+	}
+}
+
+func TestSleep(t *testing.T) {
+	if !Sleep(context.Background(), time.Millisecond) {
+		t.Error("Sleep stopped early")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if Sleep(ctx, time.Hour) {
+		t.Error("Sleep didn't stop when ctx was done")
+	}
+}
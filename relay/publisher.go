@@ -0,0 +1,139 @@
+// Package relay publishes the socket server's live stream to the REST API,
+// which fans it out to the user's remote viewers and coaches. The socket
+// server dials out, so it needn't be reachable from the internet.
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danhigham/ergometer.live/reconnect"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a message to the API
+	writeWait = 10 * time.Second
+
+	// Time allowed between pings from the API before the connection is
+	// considered dead
+	pingWait = 90 * time.Second
+)
+
+// Source is the stream a Publisher relays, normally the server's hub
+type Source interface {
+	Subscribe() (<-chan []byte, func())
+}
+
+// Publisher keeps a connection to the API open and relays every message
+// broadcast by its source over it
+type Publisher struct {
+	url    string
+	key    string
+	source Source
+}
+
+// NewPublisher creates a publisher relaying source to the API's publish
+// WebSocket at url, authenticated with a relay key
+func NewPublisher(url, key string, source Source) *Publisher {
+	return &Publisher{url: url, key: key, source: source}
+}
+
+// Run keeps the publisher connected until ctx is done or the source shuts
+// down. Messages broadcast while disconnected are dropped.
+func (p *Publisher) Run(ctx context.Context) {
+	messages, unsubscribe := p.source.Subscribe()
+	defer unsubscribe()
+
+	reconnect.Run(ctx, "Relay", func(ctx context.Context) error {
+		return p.serve(ctx, messages)
+	}, func(ctx context.Context, delay time.Duration) bool {
+		return wait(ctx, messages, delay)
+	})
+}
+
+// wait discards messages until delay has passed, reporting false if ctx is
+// done or the source shuts down first
+func wait(ctx context.Context, messages <-chan []byte, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case _, ok := <-messages:
+			if !ok {
+				return false
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// serve runs a single connection to the API, relaying messages over it
+func (p *Publisher) serve(ctx context.Context, messages <-chan []byte) error {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+p.key)
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, p.url, header)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return errors.New("relay key was rejected; create a new one through the API")
+		}
+		return fmt.Errorf("failed to connect to %s: %w", p.url, err)
+	}
+	defer conn.Close()
+
+	log.Printf("Relaying live stream to %s", p.url)
+
+	// The API only sends pings and close frames; reading handles them and
+	// notices when the connection drops
+	conn.SetReadDeadline(time.Now().Add(pingWait))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(pingWait))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+	})
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				closeConn(conn, "socket server shutting down")
+				return reconnect.ErrDone
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return err
+			}
+
+		case err := <-readErr:
+			return err
+
+		case <-ctx.Done():
+			closeConn(conn, "socket server shutting down")
+			return ctx.Err()
+		}
+	}
+}
+
+// closeConn sends a close frame before the connection is closed
+func closeConn(conn *websocket.Conn, reason string) {
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, reason),
+		time.Now().Add(writeWait))
+}
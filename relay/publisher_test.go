@@ -0,0 +1,99 @@
+package relay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testSource is a source whose messages the test sends
+type testSource struct {
+	messages chan []byte
+}
+
+func (s *testSource) Subscribe() (<-chan []byte, func()) {
+	return s.messages, func() {}
+}
+
+func TestPublisherRelaysMessages(t *testing.T) {
+	received := make(chan string, 4)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer relay_test" {
+			http.Error(w, "Invalid relay key", http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseGoingAway) {
+					received <- "closed"
+				}
+				return
+			}
+			received <- string(message)
+		}
+	}))
+	defer srv.Close()
+
+	source := &testSource{messages: make(chan []byte)}
+	p := NewPublisher("ws"+strings.TrimPrefix(srv.URL, "http"), "relay_test", source)
+
+	done := make(chan struct{})
+	go func() {
+		p.Run(context.Background())
+		close(done)
+	}()
+
+	source.messages <- []byte(`{"type":"workout_stats"}`)
+	select {
+	case message := <-received:
+		if message != `{"type":"workout_stats"}` {
+			t.Errorf("API received %s", message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("API received nothing")
+	}
+
+	// Closing the source ends the relay rather than reconnecting
+	close(source.messages)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run didn't return after the source closed")
+	}
+	select {
+	case message := <-received:
+		if message != "closed" {
+			t.Errorf("API received %s, want a going away close", message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("API wasn't told the relay ended")
+	}
+}
+
+func TestPublisherKeyRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Invalid relay key", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	source := &testSource{messages: make(chan []byte)}
+	p := NewPublisher("ws"+strings.TrimPrefix(srv.URL, "http"), "relay_deleted", source)
+
+	err := p.serve(context.Background(), source.messages)
+	if err == nil || !strings.Contains(err.Error(), "relay key was rejected") {
+		t.Errorf("serve returned %v, want the key rejected", err)
+	}
+}
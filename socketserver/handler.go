@@ -1,33 +1,163 @@
 package socketserver
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
+	"strings"
+	"sync"
 )
 
-// serveHome serves the home page and static files
-func serveHome(w http.ResponseWriter, r *http.Request) {
+// LayeredFS looks a file up in each file system in turn, returning the
+// first match
+type LayeredFS []fs.FS
+
+// Open implements fs.FS
+func (l LayeredFS) Open(name string) (fs.File, error) {
+	for _, fsys := range l {
+		f, err := fsys.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// staticHandler serves the home page and static files
+type staticHandler struct {
+	fsys fs.FS
+
+	// Cached ETags keyed by file path; only used for immutable file systems
+	etags     sync.Map
+	cacheTags bool
+}
+
+// newStaticHandler creates a handler serving files from fsys. ETags are
+// cached when the files can't change underneath us (embedded assets).
+func newStaticHandler(fsys fs.FS, immutable bool) *staticHandler {
+	return &staticHandler{
+		fsys:      fsys,
+		cacheTags: immutable,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Printf("%s %s", r.Method, r.URL.Path)
 
-	// Get the web directory path (relative to where the server is run)
-	webDir := "web"
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
 	// Determine which file to serve
-	var filePath string
-	if r.URL.Path == "/" {
-		filePath = filepath.Join(webDir, "index.html")
-	} else {
-		filePath = filepath.Join(webDir, r.URL.Path)
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
 	}
 
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	// Dotfiles, such as the placeholder keeping ui/dist in git, aren't
+	// part of the site
+	if hidden(name) {
 		http.NotFound(w, r)
 		return
 	}
 
-	// Serve the file
-	http.ServeFile(w, r, filePath)
+	if !h.serveFile(w, r, name) {
+		// SPA fallback: unknown routes without a file extension are client
+		// side routes, so hand them to the app's index page
+		if path.Ext(name) != "" || !h.serveFile(w, r, "index.html") {
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// hidden reports whether any segment of name is a dotfile
+func hidden(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// serveFile writes the named file, returning false if it doesn't exist
+func (h *staticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) bool {
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return true
+		}
+		content = strings.NewReader(string(data))
+	}
+
+	etag, err := h.etag(name, content)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return true
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl(name))
+
+	// Embedded files have a zero modification time, in which case
+	// ServeContent relies on the ETag alone for conditional requests
+	http.ServeContent(w, r, name, info.ModTime(), content)
+	return true
+}
+
+// etag returns a strong ETag for the file content
+func (h *staticHandler) etag(name string, content io.ReadSeeker) (string, error) {
+	if h.cacheTags {
+		if tag, ok := h.etags.Load(name); ok {
+			return tag.(string), nil
+		}
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	tag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	if h.cacheTags {
+		h.etags.Store(name, tag)
+	}
+	return tag, nil
+}
+
+// cacheControl returns the caching policy for a file. Vite emits content
+// hashed file names under assets/, which can be cached indefinitely;
+// everything else must be revalidated so new deployments are picked up.
+func cacheControl(name string) string {
+	if strings.HasPrefix(name, "assets/") {
+		return "public, max-age=31536000, immutable"
+	}
+	return "no-cache"
 }
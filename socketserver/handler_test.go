@@ -0,0 +1,111 @@
+package socketserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+// testAssets is a built UI: the index page and a content hashed bundle
+func testAssets() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":           {Data: []byte("<html></html>")},
+		"assets/index-abc1.js": {Data: []byte("console.log(1)")},
+		".gitkeep":             {Data: []byte{}},
+		".well-known/config":   {Data: []byte("{}")},
+	}
+}
+
+// getFile requests path from h with an optional If-None-Match header
+func getFile(h http.Handler, path, ifNoneMatch string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if ifNoneMatch != "" {
+		r.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestStaticHandlerCaching(t *testing.T) {
+	h := newStaticHandler(testAssets(), true)
+
+	tests := []struct {
+		path         string
+		status       int
+		body         string
+		cacheControl string
+	}{
+		{"/", http.StatusOK, "<html></html>", "no-cache"},
+		{"/assets/index-abc1.js", http.StatusOK, "console.log(1)", "public, max-age=31536000, immutable"},
+		// Client side routes get the index page
+		{"/history", http.StatusOK, "<html></html>", "no-cache"},
+		{"/assets/missing.js", http.StatusNotFound, "404 page not found\n", ""},
+		// Dotfiles aren't served, nor replaced by the index page
+		{"/.gitkeep", http.StatusNotFound, "404 page not found\n", ""},
+		{"/.well-known/config", http.StatusNotFound, "404 page not found\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := getFile(h, tt.path, "")
+			if w.Code != tt.status || w.Body.String() != tt.body {
+				t.Fatalf("got %d %q, want %d %q", w.Code, w.Body.String(), tt.status, tt.body)
+			}
+			if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Cache-Control %q, want %q", got, tt.cacheControl)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			etag := w.Header().Get("ETag")
+			if etag == "" {
+				t.Fatal("no ETag")
+			}
+			if w := getFile(h, tt.path, etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+				t.Errorf("revalidating got %d %q, want 304 with no body", w.Code, w.Body.String())
+			}
+			if w := getFile(h, tt.path, `"stale"`); w.Code != http.StatusOK {
+				t.Errorf("stale ETag got %d, want 200", w.Code)
+			}
+		})
+	}
+}
+
+func TestStaticHandlerETagChanges(t *testing.T) {
+	assets := testAssets()
+	embedded := newStaticHandler(assets, true)
+	disk := newStaticHandler(assets, false)
+
+	before := getFile(embedded, "/", "").Header().Get("ETag")
+	if got := getFile(disk, "/", "").Header().Get("ETag"); got != before {
+		t.Fatalf("ETags %s and %s differ for the same content", before, got)
+	}
+
+	// Files on disk change during development; embedded ones can't, so
+	// their ETags stay cached
+	assets["index.html"] = &fstest.MapFile{Data: []byte("<html>new</html>")}
+	if got := getFile(disk, "/", before); got.Code != http.StatusOK || got.Header().Get("ETag") == before {
+		t.Errorf("changed file got %d with ETag %s, want 200 with a new ETag", got.Code, got.Header().Get("ETag"))
+	}
+	if got := getFile(embedded, "/", "").Header().Get("ETag"); got != before {
+		t.Errorf("embedded ETag changed from %s to %s", before, got)
+	}
+}
+
+func TestStaticHandlerMethods(t *testing.T) {
+	h := newStaticHandler(testAssets(), true)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("POST got %d, Allow %q, want 405 with GET, HEAD", w.Code, w.Header().Get("Allow"))
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("ETag") == "" {
+		t.Errorf("HEAD got %d %q, want 200 with an ETag and no body", w.Code, w.Body.String())
+	}
+}
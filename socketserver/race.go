@@ -0,0 +1,83 @@
+package socketserver
+
+import (
+	"github.com/danhigham/ergometer.live/broadcast"
+	"github.com/danhigham/ergometer.live/race"
+)
+
+// handleStartRace asks the race coordinator to start a race
+func handleStartRace(lane *race.Lane, client *broadcast.Client, data map[string]interface{}) {
+	if lane == nil {
+		sendError(client, "Not connected to a race coordinator")
+		return
+	}
+
+	distance, ok := data["distance"].(float64)
+	if !ok || distance <= 0 {
+		sendError(client, "distance is required")
+		return
+	}
+
+	req := race.StartRequest{Distance: uint32(distance)}
+	if countdownVal, ok := data["countdown_seconds"].(float64); ok {
+		req.CountdownSeconds = int(countdownVal)
+	}
+	if limitVal, ok := data["time_limit_seconds"].(float64); ok {
+		req.TimeLimitSeconds = int(limitVal)
+	}
+
+	if err := lane.RequestStart(req); err != nil {
+		sendError(client, "Failed to start race: "+err.Error())
+		return
+	}
+
+	sendSuccess(client, "start_race", "Race start requested")
+}
+
+// handleCancelRace asks the race coordinator to cancel the race in
+// progress
+func handleCancelRace(lane *race.Lane, client *broadcast.Client) {
+	if lane == nil {
+		sendError(client, "Not connected to a race coordinator")
+		return
+	}
+
+	if err := lane.RequestCancel(); err != nil {
+		sendError(client, "Failed to cancel race: "+err.Error())
+		return
+	}
+
+	sendSuccess(client, "cancel_race", "Race cancel requested")
+}
+
+// handleStartLocalRace starts a race between the ergs attached to this
+// server
+func handleStartLocalRace(localRace *race.LocalRace, client *broadcast.Client, data map[string]interface{}) {
+	params, err := parseWorkoutParams(data)
+	if err != nil {
+		sendError(client, err.Error())
+		return
+	}
+
+	countdown := 0
+	if countdownVal, ok := data["countdown_seconds"].(float64); ok {
+		countdown = int(countdownVal)
+	}
+
+	if err := localRace.Start(params, countdown); err != nil {
+		sendError(client, "Failed to start race: "+err.Error())
+		return
+	}
+
+	sendSuccess(client, "start_local_race", "Race countdown started")
+}
+
+// handleCancelLocalRace cancels the local race in progress
+func handleCancelLocalRace(localRace *race.LocalRace, client *broadcast.Client) {
+	if !localRace.Cancel() {
+		sendError(client, "No race is in progress")
+		return
+	}
+
+	sendSuccess(client, "cancel_local_race", "Race cancelled")
+}
@@ -1,55 +1,393 @@
 package socketserver
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 
+	"github.com/danhigham/ergometer.live/apiclient"
 	"github.com/danhigham/ergometer.live/broadcast"
+	"github.com/danhigham/ergometer.live/coach"
+	"github.com/danhigham/ergometer.live/config"
+	"github.com/danhigham/ergometer.live/metrics"
 	"github.com/danhigham/ergometer.live/pm5"
+	"github.com/danhigham/ergometer.live/race"
+	"github.com/danhigham/ergometer.live/relay"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to read request headers
+	readHeaderTimeout = 10 * time.Second
+
+	// Time allowed to read a request; WebSocket connections manage their
+	// own deadlines once upgraded
+	readTimeout = 30 * time.Second
+
+	// Time allowed to write a response
+	writeTimeout = 30 * time.Second
+
+	// Time to keep idle keep-alive connections open
+	idleTimeout = 120 * time.Second
 )
 
 // Server represents the HTTP/WebSocket server
 type Server struct {
-	hub     *broadcast.Hub
-	manager *pm5.Manager
-	addr    string
+	hub      *broadcast.Hub
+	managers []*pm5.Manager
+	manager  *pm5.Manager // the default device, managers[0]
+	cfg      *config.Config
+	upgrader websocket.Upgrader
+	assets   fs.FS
+	http     *http.Server
+
+	// Networked racing, both optional
+	coordinator *race.Coordinator
+	lane        *race.Lane
+	laneCancel  context.CancelFunc
+
+	// Races between the ergs attached to this server
+	localRace *race.LocalRace
+
+	// Coach crew feed, optional
+	coach *coach.Feed
+
+	// REST API client, nil when no API is configured
+	api *apiclient.Client
+
+	// Spectator shares of the ergs' live stats
+	spectators       *spectators
+	spectatorsCancel context.CancelFunc
+
+	// Relay of the live stream through the REST API, optional
+	relay       *relay.Publisher
+	relayCancel context.CancelFunc
+	relayDone   chan struct{}
+
+	// Guards the background services' cancel functions between Start and
+	// Shutdown
+	mu      sync.Mutex
+	started bool
+	stopped bool
 }
 
-// NewServer creates a new Server instance
-func NewServer(addr string) *Server {
-	hub := broadcast.NewHub()
-	manager := pm5.GetManager(hub)
+// NewServer creates a new Server instance. assets holds the static files
+// to serve unless cfg.StaticDir points at a directory on disk.
+func NewServer(cfg *config.Config, assets fs.FS) *Server {
+	hub := broadcast.NewHubWithOptions(broadcast.Options{
+		BroadcastBufferSize: cfg.BroadcastBufferSize,
+		InboundBufferSize:   cfg.InboundBufferSize,
+		ClientBufferSize:    cfg.ClientBufferSize,
+	})
+	opts := pm5.Options{
+		DeviceSerial:       cfg.DeviceSerial,
+		Debug:              cfg.Debug,
+		PollIntervalActive: cfg.PollIntervalActive,
+		PollIntervalIdle:   cfg.PollIntervalIdle,
+		PollIntervalCheck:  cfg.PollIntervalCheck,
+		SessionsDir:        cfg.SessionsDir,
+		MaxSessions:        cfg.MaxSessions,
+	}
+
+	var managers []*pm5.Manager
+	if cfg.AllDevices {
+		managers = pm5.NewManagers(hub, opts)
+	} else {
+		managers = []*pm5.Manager{pm5.NewManager(hub, opts)}
+	}
+	manager := managers[0]
+
+	s := &Server{
+		hub:      hub,
+		managers: managers,
+		manager:  manager,
+		cfg:      cfg,
+		upgrader: newUpgrader(cfg),
+		assets:   assets,
+	}
 
 	// Set message handler for inbound client messages
-	hub.SetMessageHandler(func(client *broadcast.Client, message []byte) {
-		handleClientMessage(manager, client, message)
-	})
+	hub.SetMessageHandler(s.handleClientMessage)
+
+	if cfg.RaceCoordinator {
+		s.coordinator = race.NewCoordinator(s.upgrader)
+	}
+	if cfg.RaceJoin != "" {
+		s.lane = race.NewLane(cfg.RaceJoin, cfg.RaceName, manager, manager.BroadcastJSON)
+	}
+
+	ergs := make([]race.LocalErg, len(managers))
+	for i, m := range managers {
+		ergs[i] = m
+	}
+	s.localRace = race.NewLocalRace(ergs, s.broadcastJSON)
 
-	return &Server{
-		hub:     hub,
-		manager: manager,
-		addr:    addr,
+	if cfg.APIURL != "" {
+		s.api = apiclient.New(cfg.APIURL)
 	}
+	s.spectators = newSpectators(hub, s.api, cfg.RelayKey)
+	if cfg.RelayKey != "" {
+		s.relay = relay.NewPublisher(cfg.RelayURL(), cfg.RelayKey, hub)
+	}
+
+	if cfg.Coach {
+		s.coach = coach.NewFeed(s.upgrader, s.coachSources()...)
+	}
+
+	s.http = &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	return s
+}
+
+// routes sets up the HTTP routes
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		client := serveWs(s.hub, &s.upgrader, s.spectators, w, r)
+
+		// Clients that connect with a token are offered today's planned
+		// session straight away; spectators aren't sent anything but stats
+		spectator := r.URL.Query().Get("share") != ""
+		if token := r.URL.Query().Get("token"); client != nil && token != "" && !spectator && s.api != nil {
+			go sendPlannedSessions(s.api, client, token, r.URL.Query().Get("date"), false)
+		}
+	})
+	sources := make([]metrics.Source, len(s.managers))
+	for i, m := range s.managers {
+		sources[i] = m
+	}
+	mux.Handle("/metrics", metrics.Handler(metrics.NewCollector(sources...)))
+	if s.coordinator != nil {
+		mux.Handle("/race", s.coordinator)
+	}
+	if s.coach != nil {
+		mux.Handle("/coach", s.coach)
+	}
+	mux.Handle("/", s.staticFiles())
+	return mux
 }
 
-// Start starts the HTTP server and hub
+// Start starts the hub and serves HTTP until Shutdown is called, in which
+// case it returns nil. Start returns nil straight away if Shutdown has
+// already been called.
 func (s *Server) Start() error {
-	// Start the hub
+	if !s.startBackground() {
+		return nil
+	}
+
+	var err error
+	if s.cfg.TLSEnabled() {
+		log.Printf("Server starting on %s (TLS)", s.cfg.ListenAddr)
+		err = s.http.ListenAndServeTLS(s.cfg.TLSCert, s.cfg.TLSKey)
+	} else {
+		log.Printf("Server starting on %s", s.cfg.ListenAddr)
+		err = s.http.ListenAndServe()
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// startBackground starts the hub and the optional background services,
+// reporting false if the server has already been shut down
+func (s *Server) startBackground() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return false
+	}
+	s.started = true
+
 	go s.hub.Run()
 
-	// Setup HTTP routes
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		serveWs(s.hub, w, r)
-	})
-	http.HandleFunc("/", serveHome)
+	if s.coordinator != nil {
+		go s.coordinator.Run()
+	}
+
+	if s.coach != nil {
+		go s.coach.Run()
+	}
+
+	spectatorsCtx, cancel := context.WithCancel(context.Background())
+	s.spectatorsCancel = cancel
+	go s.spectators.run(spectatorsCtx)
+
+	if s.lane != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.laneCancel = cancel
+		go s.lane.Run(ctx)
+	}
 
-	log.Printf("Server starting on %s", s.addr)
-	return http.ListenAndServe(s.addr, nil)
+	if s.relay != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.relayCancel = cancel
+		s.relayDone = make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+			s.relay.Run(ctx)
+		}(s.relayDone)
+	}
+
+	return true
+}
+
+// staticFiles returns the handler for the web UI, serving from disk when a
+// static directory is configured for development
+func (s *Server) staticFiles() http.Handler {
+	if s.cfg.StaticDir != "" {
+		log.Printf("Serving static files from %s", s.cfg.StaticDir)
+		return newStaticHandler(os.DirFS(s.cfg.StaticDir), false)
+	}
+	return newStaticHandler(s.assets, true)
 }
 
-// Shutdown gracefully shuts down the server
-func (s *Server) Shutdown() {
+// Shutdown gracefully shuts down the server. It stops accepting
+// connections, lets the manager close out any workout in progress, then
+// sends every WebSocket client a close frame. It returns ctx's error if
+// the deadline passes before everything has stopped. Shutdown may be
+// called before Start, which then doesn't start anything.
+func (s *Server) Shutdown(ctx context.Context) error {
 	log.Println("Shutting down server...")
-	s.manager.Shutdown()
-	s.hub.Shutdown()
+
+	s.mu.Lock()
+	started := s.started
+	s.stopped = true
+	s.mu.Unlock()
+
+	// Stop the listener and drain in-flight HTTP requests; hijacked
+	// WebSocket connections are closed by the hub below
+	httpErr := s.http.Shutdown(ctx)
+
+	var managerErrs []error
+	if !started {
+		// Nothing is running but the managers
+		for _, m := range s.managers {
+			managerErrs = append(managerErrs, m.Shutdown(ctx))
+		}
+		return errors.Join(httpErr, errors.Join(managerErrs...))
+	}
+
+	if s.laneCancel != nil {
+		s.laneCancel()
+	}
+	s.spectatorsCancel()
+
+	var raceErr error
+	if s.coordinator != nil {
+		raceErr = s.coordinator.Shutdown(ctx)
+	}
+
+	var coachErr error
+	if s.coach != nil {
+		coachErr = s.coach.Shutdown(ctx)
+	}
+
+	localRaceErr := s.localRace.Shutdown(ctx)
+
+	for _, m := range s.managers {
+		managerErrs = append(managerErrs, m.Shutdown(ctx))
+	}
+	hubErr := s.hub.Shutdown(ctx)
+
+	// The relay finishes once it has forwarded the hub's last messages
+	var relayErr error
+	if s.relayCancel != nil {
+		select {
+		case <-s.relayDone:
+		case <-ctx.Done():
+			relayErr = ctx.Err()
+		}
+		s.relayCancel()
+	}
+
+	return errors.Join(httpErr, raceErr, coachErr, localRaceErr, errors.Join(managerErrs...), hubErr, relayErr)
+}
+
+// coachSources returns the rowers for the coach feed: every local erg,
+// named from coach_sources where listed, followed by the remote servers
+func (s *Server) coachSources() []coach.Source {
+	// Validate has already checked the list
+	configured, _ := s.cfg.CoachSourceList()
+
+	names := make(map[string]string)
+	var sources []coach.Source
+	for _, c := range configured {
+		if c.URL != "" {
+			continue
+		}
+		names[c.Serial] = c.Name
+	}
+
+	for i, m := range s.managers {
+		name := fmt.Sprintf("Erg %d", i+1)
+		if info := m.DeviceInfo(); info != nil {
+			if configuredName, ok := names[info.Serial]; ok {
+				name = configuredName
+				delete(names, info.Serial)
+			}
+		}
+		sources = append(sources, coach.NewLocalSource(name, m))
+	}
+
+	for serial, name := range names {
+		log.Printf("Coach source %s: no PM5 with serial %s is connected", name, serial)
+	}
+
+	for _, c := range configured {
+		if c.URL != "" {
+			sources = append(sources, coach.NewRemoteSource(c.Name, c.URL))
+		}
+	}
+	return sources
+}
+
+// managerFor returns the manager for the device named by data's optional
+// serial, or the default device when none is given
+func (s *Server) managerFor(data map[string]interface{}) (*pm5.Manager, error) {
+	serial, _ := data["serial"].(string)
+	if serial == "" {
+		return s.manager, nil
+	}
+
+	for _, m := range s.managers {
+		if info := m.DeviceInfo(); info != nil && info.Serial == serial {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("no PM5 with serial %s is connected", serial)
+}
+
+// broadcastJSON sends a message that isn't tied to one device to every
+// client
+func (s *Server) broadcastJSON(messageType string, data interface{}) {
+	msg := map[string]interface{}{
+		"type":      messageType,
+		"data":      data,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+
+	jsonData, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+
+	s.hub.Broadcast(jsonData)
 }
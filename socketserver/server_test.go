@@ -0,0 +1,137 @@
+package socketserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/danhigham/ergometer.live/config"
+	"github.com/gorilla/websocket"
+)
+
+// testConfig returns a configuration for a server on a free local port
+// with no devices or API
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := config.Default()
+	cfg.ListenAddr = addr
+	cfg.SessionsDir = ""
+	cfg.DeviceSerial = "test-no-such-erg"
+	return cfg
+}
+
+// dialUntilUp connects a WebSocket client, retrying while the server
+// starts listening
+func dialUntilUp(t *testing.T, addr string) *websocket.Conn {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+		if err == nil {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server never came up: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerStartShutdownRepeatedly(t *testing.T) {
+	assets := fstest.MapFS{"index.html": {Data: []byte("<html></html>")}}
+
+	for i := 0; i < 5; i++ {
+		cfg := testConfig(t)
+		srv := NewServer(cfg, assets)
+
+		started := make(chan error, 1)
+		go func() { started <- srv.Start() }()
+
+		conn := dialUntilUp(t, cfg.ListenAddr)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := srv.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("run %d: Shutdown returned %v", i, err)
+		}
+
+		select {
+		case err := <-started:
+			if err != nil {
+				t.Fatalf("run %d: Start returned %v", i, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("run %d: Start did not return after Shutdown", i)
+		}
+
+		// Connected clients are sent a close frame
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+					t.Fatalf("run %d: client got %v, want a going away close", i, err)
+				}
+				break
+			}
+		}
+		conn.Close()
+
+		// The port is free again
+		ln, err := net.Listen("tcp", cfg.ListenAddr)
+		if err != nil {
+			t.Fatalf("run %d: listener still open: %v", i, err)
+		}
+		ln.Close()
+	}
+}
+
+func TestServerShutdownBeforeStart(t *testing.T) {
+	srv := NewServer(testConfig(t), fstest.MapFS{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown before Start returned %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start after Shutdown returned %v", err)
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	cfg := testConfig(t)
+	srv := NewServer(cfg, fstest.MapFS{})
+
+	started := make(chan error, 1)
+	go func() { started <- srv.Start() }()
+	dialUntilUp(t, cfg.ListenAddr).Close()
+
+	// A deadline that has already passed is reported rather than waited
+	// past
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := srv.Shutdown(ctx)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatalf("Shutdown returned %v", err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Shutdown")
+	}
+}
@@ -0,0 +1,392 @@
+package socketserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danhigham/ergometer.live/apiclient"
+	"github.com/danhigham/ergometer.live/broadcast"
+	"github.com/danhigham/ergometer.live/pm5"
+)
+
+const (
+	// Spectator share lifetimes
+	defaultShareLifetime = 3 * time.Hour
+	maxShareLifetime     = 7 * 24 * time.Hour
+
+	// maxShareLabelLength bounds a share's label
+	maxShareLabelLength = 100
+
+	// shareTokenBytes is the entropy of a spectator share token
+	shareTokenBytes = 18
+
+	// shareRecheckInterval is how often shares minted through the API are
+	// checked for revocation
+	shareRecheckInterval = time.Minute
+
+	// rejectedShareTTL is how long the API's rejection of a token is
+	// remembered, so retrying spectators don't each reach the API
+	rejectedShareTTL = 30 * time.Second
+
+	// maxRejectedShares bounds the rejected tokens remembered
+	maxRejectedShares = 10000
+)
+
+var (
+	// errShareNotFound is returned for an unknown or revoked share token
+	errShareNotFound = errors.New("share not found")
+
+	// errShareExpired is returned for an expired share token
+	errShareExpired = errors.New("share has expired")
+)
+
+// spectatorTypes are the broadcasts spectators receive: live stats and the
+// workout's progress, but nothing about the device or the rower's account
+var spectatorTypes = map[string]bool{
+	"workout_stats":       true,
+	"workout_state":       true,
+	"workout_started":     true,
+	"workout_ended":       true,
+	"workout_summary":     true,
+	"interval_started":    true,
+	"interval_rest":       true,
+	"intervals_completed": true,
+	"intervals_stopped":   true,
+	"pace_boat":           true,
+}
+
+// spectatorShare lets anyone with its token watch one erg's live stats
+type spectatorShare struct {
+	Token     string    `json:"token"`
+	Serial    string    `json:"serial"` // the PM5 shared
+	Label     string    `json:"label,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Path      string    `json:"path"` // WebSocket path spectators connect to
+
+	remote bool // minted through the API rather than on this server
+}
+
+// access returns what the share grants its spectators: read-only access to
+// the shared erg's stats until the share expires
+func (sh *spectatorShare) access() broadcast.Access {
+	return broadcast.Access{
+		ReadOnly:  true,
+		Filter:    spectatorFilter(sh.Serial),
+		Grant:     sh.Token,
+		ExpiresAt: sh.ExpiresAt,
+	}
+}
+
+// rejectedShare is a token the API rejected, remembered for a while
+type rejectedShare struct {
+	err   error // errShareNotFound or errShareExpired
+	until time.Time
+}
+
+// spectators holds the spectator shares minted on this server, and caches
+// those minted through the API so they can be checked for revocation.
+// Shares minted through the API are only accepted with a relay key, which
+// the API checks belongs to the user who minted them.
+type spectators struct {
+	hub      *broadcast.Hub
+	api      *apiclient.Client // nil when no API is configured
+	relayKey string            // empty when none is configured
+
+	mu       sync.Mutex
+	shares   map[string]*spectatorShare
+	rejected map[string]rejectedShare // tokens the API recently rejected
+}
+
+// newSpectators creates an empty set of shares whose spectators watch hub,
+// checking shares minted through api with relayKey
+func newSpectators(hub *broadcast.Hub, api *apiclient.Client, relayKey string) *spectators {
+	return &spectators{
+		hub:      hub,
+		api:      api,
+		relayKey: relayKey,
+		shares:   make(map[string]*spectatorShare),
+		rejected: make(map[string]rejectedShare),
+	}
+}
+
+// create mints a share of the erg with serial lasting lifetime
+func (s *spectators) create(serial, label string, lifetime time.Duration) (*spectatorShare, error) {
+	if lifetime <= 0 || lifetime > maxShareLifetime {
+		return nil, fmt.Errorf("expires_in_minutes must be between 1 and %d", int(maxShareLifetime.Minutes()))
+	}
+	if len(label) > maxShareLabelLength {
+		return nil, fmt.Errorf("label must be at most %d characters", maxShareLabelLength)
+	}
+
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate share token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	share := &spectatorShare{
+		Token:     token,
+		Serial:    serial,
+		Label:     label,
+		ExpiresAt: time.Now().Add(lifetime).UTC(),
+		Path:      "/ws?share=" + token,
+	}
+
+	s.mu.Lock()
+	s.shares[token] = share
+	s.mu.Unlock()
+	return share, nil
+}
+
+// list returns the unexpired shares minted on this server, soonest to
+// expire first
+func (s *spectators) list() []*spectatorShare {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	shares := make([]*spectatorShare, 0, len(s.shares))
+	for _, share := range s.shares {
+		if !share.remote && now.Before(share.ExpiresAt) {
+			shares = append(shares, share)
+		}
+	}
+
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].ExpiresAt.Before(shares[j].ExpiresAt)
+	})
+	return shares
+}
+
+// revoke deletes a share minted on this server and disconnects its
+// spectators, reporting whether there was one. Shares minted through the
+// API are revoked there.
+func (s *spectators) revoke(token string) bool {
+	s.mu.Lock()
+	share, ok := s.shares[token]
+	if ok && !share.remote {
+		delete(s.shares, token)
+	}
+	s.mu.Unlock()
+
+	if !ok || share.remote {
+		return false
+	}
+	s.hub.Revoke(token)
+	return true
+}
+
+// resolve returns the unexpired share for token, asking the API about
+// tokens not minted here when a relay key is configured. It returns
+// errShareNotFound or errShareExpired for tokens that can't be used,
+// remembering those the API rejects for rejectedShareTTL.
+func (s *spectators) resolve(ctx context.Context, token string) (*spectatorShare, error) {
+	s.mu.Lock()
+	share, ok := s.shares[token]
+	rejected, wasRejected := s.rejected[token]
+	s.mu.Unlock()
+
+	if !ok {
+		if s.api == nil || s.relayKey == "" {
+			return nil, errShareNotFound
+		}
+		if wasRejected && time.Now().Before(rejected.until) {
+			return nil, rejected.err
+		}
+
+		remote, err := s.api.SpectatorShare(ctx, s.relayKey, token)
+		switch {
+		case errors.Is(err, apiclient.ErrNotFound):
+			s.reject(token, errShareNotFound)
+			return nil, errShareNotFound
+		case errors.Is(err, apiclient.ErrExpired):
+			s.reject(token, errShareExpired)
+			return nil, errShareExpired
+		case err != nil:
+			return nil, err
+		}
+
+		share = &spectatorShare{
+			Token:     token,
+			Serial:    remote.Serial,
+			Label:     remote.Label,
+			ExpiresAt: remote.ExpiresAt,
+			Path:      "/ws?share=" + token,
+			remote:    true,
+		}
+		s.mu.Lock()
+		s.shares[token] = share
+		s.mu.Unlock()
+	}
+
+	if !time.Now().Before(share.ExpiresAt) {
+		return nil, errShareExpired
+	}
+	return share, nil
+}
+
+// reject remembers the API rejected token with err, making room by
+// forgetting older rejections when maxRejectedShares are remembered
+func (s *spectators) reject(token string, err error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.rejected) >= maxRejectedShares {
+		s.pruneRejected(now)
+		for other := range s.rejected {
+			if len(s.rejected) < maxRejectedShares {
+				break
+			}
+			delete(s.rejected, other)
+		}
+	}
+	s.rejected[token] = rejectedShare{err: err, until: now.Add(rejectedShareTTL)}
+}
+
+// pruneRejected forgets rejections that have lapsed by now. s.mu must be
+// held.
+func (s *spectators) pruneRejected(now time.Time) {
+	for token, rejected := range s.rejected {
+		if !now.Before(rejected.until) {
+			delete(s.rejected, token)
+		}
+	}
+}
+
+// run forgets expired shares and checks those minted through the API
+// haven't been revoked until ctx is done
+func (s *spectators) run(ctx context.Context) {
+	ticker := time.NewTicker(shareRecheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.recheck(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recheck forgets expired shares, whose spectators the hub disconnects,
+// and disconnects the spectators of shares revoked through the API
+func (s *spectators) recheck(ctx context.Context) {
+	now := time.Now()
+	var remote []string
+
+	s.mu.Lock()
+	for token, share := range s.shares {
+		switch {
+		case !now.Before(share.ExpiresAt):
+			delete(s.shares, token)
+		case share.remote:
+			remote = append(remote, token)
+		}
+	}
+	s.pruneRejected(now)
+	s.mu.Unlock()
+
+	for _, token := range remote {
+		reqCtx, cancel := context.WithTimeout(ctx, pm5.DefaultControlTimeout)
+		_, err := s.api.SpectatorShare(reqCtx, s.relayKey, token)
+		cancel()
+
+		if !errors.Is(err, apiclient.ErrNotFound) && !errors.Is(err, apiclient.ErrExpired) {
+			// Keep watching through API outages
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Failed to recheck spectator share: %v", err)
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		delete(s.shares, token)
+		s.mu.Unlock()
+		s.reject(token, errShareNotFound)
+		s.hub.Revoke(token)
+		log.Println("Spectator share revoked through the API, disconnecting its spectators")
+	}
+}
+
+// spectatorFilter returns a broadcast filter passing spectators the
+// spectatorTypes messages from the erg with serial, without the serial
+func spectatorFilter(serial string) func([]byte) []byte {
+	return func(message []byte) []byte {
+		var msg map[string]json.RawMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			return nil
+		}
+
+		var msgType, msgSerial string
+		if err := json.Unmarshal(msg["type"], &msgType); err != nil || !spectatorTypes[msgType] {
+			return nil
+		}
+		if err := json.Unmarshal(msg["serial"], &msgSerial); err != nil || msgSerial != serial {
+			return nil
+		}
+		delete(msg, "serial")
+
+		filtered, err := json.Marshal(msg)
+		if err != nil {
+			return nil
+		}
+		return filtered
+	}
+}
+
+// handleCreateShare mints a spectator share of a device's live stats
+func handleCreateShare(spectators *spectators, manager *pm5.Manager, client *broadcast.Client, data map[string]interface{}) {
+	info := manager.DeviceInfo()
+	if info == nil || info.Serial == "" {
+		sendError(client, "No PM5 is connected to share")
+		return
+	}
+
+	lifetime := defaultShareLifetime
+	if minutes, ok := data["expires_in_minutes"].(float64); ok {
+		lifetime = time.Duration(minutes * float64(time.Minute))
+	}
+	label, _ := data["label"].(string)
+
+	share, err := spectators.create(info.Serial, label, lifetime)
+	if err != nil {
+		sendError(client, "Failed to create share: "+err.Error())
+		return
+	}
+
+	log.Printf("Created spectator share of %s until %s", share.Serial, share.ExpiresAt.Format(time.RFC3339))
+	sendMessage(client, "share_created", share)
+}
+
+// handleListShares sends the spectator shares minted on this server
+func handleListShares(spectators *spectators, client *broadcast.Client) {
+	sendMessage(client, "shares", spectators.list())
+}
+
+// handleRevokeShare revokes a spectator share minted on this server,
+// disconnecting its spectators
+func handleRevokeShare(spectators *spectators, client *broadcast.Client, data map[string]interface{}) {
+	token, _ := data["token"].(string)
+	if token == "" {
+		sendError(client, "token is required")
+		return
+	}
+
+	if !spectators.revoke(token) {
+		sendError(client, "Share not found; shares created through the API are revoked there")
+		return
+	}
+
+	sendSuccess(client, "revoke_share", "Share revoked")
+}
@@ -0,0 +1,225 @@
+package socketserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danhigham/ergometer.live/apiclient"
+	"github.com/danhigham/ergometer.live/broadcast"
+)
+
+// testRelayKey is the relay key test socket servers resolve shares with
+const testRelayKey = "relay_test"
+
+// shareAPI stands in for the API's spectator share lookups
+type shareAPI struct {
+	mu      sync.Mutex
+	lookups map[string]int
+	shares  map[string]time.Time // token to expiry
+	expired map[string]bool
+	foreign map[string]bool // shares another user minted
+}
+
+func newShareAPI(t *testing.T) (*shareAPI, *apiclient.Client) {
+	t.Helper()
+
+	api := &shareAPI{
+		lookups: make(map[string]int),
+		shares:  make(map[string]time.Time),
+		expired: make(map[string]bool),
+		foreign: make(map[string]bool),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Path[len("/api/v1/spectate/"):]
+
+		api.mu.Lock()
+		defer api.mu.Unlock()
+
+		api.lookups[token]++
+		switch expiresAt, ok := api.shares[token]; {
+		case r.Header.Get("Authorization") != "Bearer "+testRelayKey:
+			http.Error(w, "Invalid relay key", http.StatusUnauthorized)
+		case api.foreign[token]:
+			http.NotFound(w, r)
+		case api.expired[token]:
+			w.WriteHeader(http.StatusGone)
+		case ok:
+			json.NewEncoder(w).Encode(apiclient.SpectatorShare{Serial: "430123456", ExpiresAt: expiresAt})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return api, apiclient.New(srv.URL)
+}
+
+// newTestSpectators creates spectator shares watching a running hub,
+// resolving shares minted through api with relayKey
+func newTestSpectators(t *testing.T, api *apiclient.Client, relayKey string) *spectators {
+	t.Helper()
+
+	hub := broadcast.NewHub()
+	go hub.Run()
+	t.Cleanup(func() { hub.Shutdown(context.Background()) })
+	return newSpectators(hub, api, relayKey)
+}
+
+func (a *shareAPI) lookupsOf(token string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lookups[token]
+}
+
+func TestResolveRemembersRejectedTokens(t *testing.T) {
+	api, client := newShareAPI(t)
+	api.expired["old"] = true
+	s := newTestSpectators(t, client, testRelayKey)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		if _, err := s.resolve(ctx, "unknown"); err != errShareNotFound {
+			t.Fatalf("resolve of an unknown token returned %v", err)
+		}
+		if _, err := s.resolve(ctx, "old"); err != errShareExpired {
+			t.Fatalf("resolve of an expired token returned %v", err)
+		}
+	}
+	if n, m := api.lookupsOf("unknown"), api.lookupsOf("old"); n != 1 || m != 1 {
+		t.Errorf("API asked %d and %d times, want once each", n, m)
+	}
+
+	// Once the rejection lapses the API is asked again
+	s.mu.Lock()
+	for token, rejected := range s.rejected {
+		rejected.until = time.Now()
+		s.rejected[token] = rejected
+	}
+	s.mu.Unlock()
+	api.mu.Lock()
+	api.shares["unknown"] = time.Now().Add(time.Hour)
+	api.mu.Unlock()
+
+	share, err := s.resolve(ctx, "unknown")
+	if err != nil || share.Serial != "430123456" || !share.remote {
+		t.Fatalf("resolve returned %+v, %v after the token was minted", share, err)
+	}
+	if n := api.lookupsOf("unknown"); n != 2 {
+		t.Errorf("API asked %d times, want 2", n)
+	}
+}
+
+func TestRecheckRemembersRevokedShares(t *testing.T) {
+	api, client := newShareAPI(t)
+	api.shares["shared"] = time.Now().Add(time.Hour)
+	s := newTestSpectators(t, client, testRelayKey)
+	ctx := context.Background()
+
+	if _, err := s.resolve(ctx, "shared"); err != nil {
+		t.Fatal(err)
+	}
+
+	api.mu.Lock()
+	delete(api.shares, "shared")
+	api.mu.Unlock()
+	s.recheck(ctx)
+
+	if _, err := s.resolve(ctx, "shared"); err != errShareNotFound {
+		t.Fatalf("resolve of a revoked share returned %v", err)
+	}
+	if n := api.lookupsOf("shared"); n != 2 {
+		t.Errorf("API asked %d times, want a lookup and a recheck", n)
+	}
+}
+
+func TestRejectedSharesBounded(t *testing.T) {
+	s := newTestSpectators(t, nil, "")
+
+	for i := 0; i < maxRejectedShares+10; i++ {
+		s.reject(fmt.Sprintf("token-%d", i), errShareNotFound)
+	}
+	if n := len(s.rejected); n > maxRejectedShares {
+		t.Errorf("%d rejections remembered, want at most %d", n, maxRejectedShares)
+	}
+}
+
+func TestResolveOnlyAcceptsTheRelayKeyOwnersShares(t *testing.T) {
+	api, client := newShareAPI(t)
+	api.shares["theirs"] = time.Now().Add(time.Hour)
+	api.foreign["theirs"] = true
+	api.shares["mine"] = time.Now().Add(time.Hour)
+	ctx := context.Background()
+
+	s := newTestSpectators(t, client, testRelayKey)
+	if _, err := s.resolve(ctx, "theirs"); err != errShareNotFound {
+		t.Errorf("resolve of another user's share returned %v", err)
+	}
+	if _, err := s.resolve(ctx, "mine"); err != nil {
+		t.Errorf("resolve of the key owner's share returned %v", err)
+	}
+
+	// Without a relay key the API isn't asked at all
+	s = newTestSpectators(t, client, "")
+	if _, err := s.resolve(ctx, "mine"); err != errShareNotFound {
+		t.Errorf("resolve without a relay key returned %v", err)
+	}
+	if n := api.lookupsOf("mine"); n != 1 {
+		t.Errorf("API asked %d times, want once", n)
+	}
+}
+
+func TestSpectatorFilter(t *testing.T) {
+	filter := spectatorFilter("430123456")
+
+	tests := []struct {
+		name    string
+		message string
+		want    string // empty when withheld
+	}{
+		{
+			name:    "stats of the shared erg",
+			message: `{"type":"workout_stats","serial":"430123456","data":{"distance":250}}`,
+			want:    `{"data":{"distance":250},"type":"workout_stats"}`,
+		},
+		{
+			name:    "stats of another erg",
+			message: `{"type":"workout_stats","serial":"430999999","data":{"distance":250}}`,
+		},
+		{
+			name:    "message without a serial",
+			message: `{"type":"workout_stats","data":{"distance":250}}`,
+		},
+		{
+			name:    "device details",
+			message: `{"type":"device_info","serial":"430123456","data":{"battery":80}}`,
+		},
+		{
+			name:    "planned sessions",
+			message: `{"type":"planned_sessions","serial":"430123456","data":[]}`,
+		},
+		{
+			name:    "not JSON",
+			message: `workout_stats`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := filter([]byte(tt.message))
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("filter passed %s", got)
+				}
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("filter returned %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,23 +1,47 @@
 package socketserver
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 
 	"github.com/danhigham/ergometer.live/broadcast"
+	"github.com/danhigham/ergometer.live/config"
 	"github.com/danhigham/ergometer.live/pm5"
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow all origins for development
-		// TODO: Restrict to your domain in production
-		return true
-	},
+// newUpgrader creates a WebSocket upgrader honouring the configured buffer
+// sizes and allowed origins
+func newUpgrader(cfg *config.Config) websocket.Upgrader {
+	origins := cfg.Origins()
+
+	return websocket.Upgrader{
+		ReadBufferSize:  cfg.ReadBufferSize,
+		WriteBufferSize: cfg.WriteBufferSize,
+		CheckOrigin: func(r *http.Request) bool {
+			// No configured origins means any origin is allowed
+			if len(origins) == 0 {
+				return true
+			}
+
+			// Non-browser clients don't send an Origin header
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true
+			}
+
+			for _, allowed := range origins {
+				if origin == allowed {
+					return true
+				}
+			}
+			return false
+		},
+	}
 }
 
 // ClientMessage represents a message received from a WebSocket client
@@ -26,23 +50,45 @@ type ClientMessage struct {
 	Data map[string]interface{} `json:"data,omitempty"`
 }
 
-// serveWs handles websocket requests from clients
-func serveWs(hub *broadcast.Hub, w http.ResponseWriter, r *http.Request) {
+// serveWs handles websocket requests from clients, returning the new
+// client or nil if the upgrade failed. Requests with a share token are
+// spectators: the token is checked before upgrading, and they get
+// read-only access to the shared erg's stats until it expires.
+func serveWs(hub *broadcast.Hub, upgrader *websocket.Upgrader, spectators *spectators, w http.ResponseWriter, r *http.Request) *broadcast.Client {
+	var access broadcast.Access
+	if token := r.URL.Query().Get("share"); token != "" {
+		share, err := spectators.resolve(r.Context(), token)
+		switch {
+		case errors.Is(err, errShareNotFound):
+			http.Error(w, "Share link not found", http.StatusNotFound)
+			return nil
+		case errors.Is(err, errShareExpired):
+			http.Error(w, "Share link has expired", http.StatusGone)
+			return nil
+		case err != nil:
+			log.Printf("Failed to check spectator share: %v", err)
+			http.Error(w, "Failed to check share link", http.StatusBadGateway)
+			return nil
+		}
+		access = share.access()
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
-		return
+		return nil
 	}
 
-	client := broadcast.NewClient(hub, conn)
+	client := broadcast.NewClientWithAccess(hub, conn, access)
 	hub.Register(client)
 
 	// Start the client's read and write pumps
 	client.Run()
+	return client
 }
 
 // handleClientMessage processes messages received from WebSocket clients
-func handleClientMessage(manager *pm5.Manager, client *broadcast.Client, message []byte) {
+func (s *Server) handleClientMessage(client *broadcast.Client, message []byte) {
 	var msg ClientMessage
 	if err := json.Unmarshal(message, &msg); err != nil {
 		log.Printf("Failed to unmarshal client message: %v", err)
@@ -52,6 +98,45 @@ func handleClientMessage(manager *pm5.Manager, client *broadcast.Client, message
 
 	log.Printf("Received message type: %s", msg.Type)
 
+	switch msg.Type {
+	case "start_workout", "stop_workout", "get_status", "list_sessions", "set_zones", "set_weight", "save_workout", "start_template", "create_share":
+		manager, err := s.managerFor(msg.Data)
+		if err != nil {
+			sendError(client, err.Error())
+			return
+		}
+		s.handleDeviceMessage(manager, client, msg)
+
+	case "start_race":
+		handleStartRace(s.lane, client, msg.Data)
+
+	case "cancel_race":
+		handleCancelRace(s.lane, client)
+
+	case "start_local_race":
+		handleStartLocalRace(s.localRace, client, msg.Data)
+
+	case "cancel_local_race":
+		handleCancelLocalRace(s.localRace, client)
+
+	case "list_shares":
+		handleListShares(s.spectators, client)
+
+	case "revoke_share":
+		handleRevokeShare(s.spectators, client, msg.Data)
+
+	case "get_planned_session":
+		token, _ := msg.Data["token"].(string)
+		date, _ := msg.Data["date"].(string)
+		sendPlannedSessions(s.api, client, token, date, true)
+
+	default:
+		sendError(client, "Unknown message type: "+msg.Type)
+	}
+}
+
+// handleDeviceMessage processes a message addressed to a single device
+func (s *Server) handleDeviceMessage(manager *pm5.Manager, client *broadcast.Client, msg ClientMessage) {
 	switch msg.Type {
 	case "start_workout":
 		handleStartWorkout(manager, client, msg.Data)
@@ -62,21 +147,54 @@ func handleClientMessage(manager *pm5.Manager, client *broadcast.Client, message
 	case "get_status":
 		handleGetStatus(manager, client)
 
-	default:
-		sendError(client, "Unknown message type: "+msg.Type)
+	case "list_sessions":
+		handleListSessions(manager, client)
+
+	case "set_zones":
+		handleSetZones(manager, client, msg.Data)
+
+	case "set_weight":
+		handleSetWeight(s.api, manager, client, msg.Data)
+
+	case "save_workout":
+		handleSaveWorkout(s.api, manager, client, msg.Data)
+
+	case "start_template":
+		handleStartTemplate(s.api, manager, client, msg.Data)
+
+	case "create_share":
+		handleCreateShare(s.spectators, manager, client, msg.Data)
 	}
 }
 
 // handleStartWorkout processes a start_workout request
 func handleStartWorkout(manager *pm5.Manager, client *broadcast.Client, data map[string]interface{}) {
+	params, err := parseWorkoutParams(data)
+	if err != nil {
+		sendError(client, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pm5.DefaultControlTimeout)
+	defer cancel()
+
+	if err := manager.StartWorkout(ctx, params); err != nil {
+		sendError(client, "Failed to start workout: "+err.Error())
+		return
+	}
+
+	sendSuccess(client, "start_workout", "Workout started successfully")
+}
+
+// parseWorkoutParams reads workout parameters from a client message
+func parseWorkoutParams(data map[string]interface{}) (*pm5.WorkoutParams, error) {
 	params := &pm5.WorkoutParams{}
 
 	// Parse workout type
 	if workoutType, ok := data["workout_type"].(string); ok {
 		params.WorkoutType = workoutType
 	} else {
-		sendError(client, "workout_type is required")
-		return
+		return nil, errors.New("workout_type is required")
 	}
 
 	// Parse distance (for fixed_distance)
@@ -99,31 +217,51 @@ func handleStartWorkout(manager *pm5.Manager, client *broadcast.Client, data map
 		params.SplitTime = uint32(splitTime)
 	}
 
-	// Send control request to manager
-	resp, err := manager.SendControl("start_workout", params)
-	if err != nil {
-		sendError(client, "Failed to send start command: "+err.Error())
-		return
+	// Parse intervals (for intervals)
+	if intervals, ok := data["intervals"].([]interface{}); ok {
+		for i, item := range intervals {
+			interval, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("interval %d must be an object", i+1)
+			}
+			var parsed pm5.Interval
+			if distance, ok := interval["distance"].(float64); ok {
+				parsed.Distance = uint32(distance)
+			}
+			if timeVal, ok := interval["time"].(float64); ok {
+				parsed.Time = uint32(timeVal)
+			}
+			if rest, ok := interval["rest"].(float64); ok {
+				parsed.Rest = uint32(rest)
+			}
+			params.Intervals = append(params.Intervals, parsed)
+		}
 	}
 
-	if !resp.Success {
-		sendError(client, "Failed to start workout: "+resp.Error.Error())
-		return
+	// Parse optional pace boat (recorded session or target split)
+	if paceBoat, ok := data["pace_boat"].(map[string]interface{}); ok {
+		params.PaceBoat = &pm5.PaceBoatParams{}
+		if sessionID, ok := paceBoat["session_id"].(string); ok {
+			params.PaceBoat.SessionID = sessionID
+		}
+		if targetPace, ok := paceBoat["target_pace"].(float64); ok {
+			params.PaceBoat.TargetPace = targetPace
+		}
+		if params.PaceBoat.SessionID == "" && params.PaceBoat.TargetPace <= 0 {
+			return nil, errors.New("pace_boat requires session_id or target_pace")
+		}
 	}
 
-	sendSuccess(client, "start_workout", "Workout started successfully")
+	return params, nil
 }
 
 // handleStopWorkout processes a stop_workout request
 func handleStopWorkout(manager *pm5.Manager, client *broadcast.Client) {
-	resp, err := manager.SendControl("stop_workout", nil)
-	if err != nil {
-		sendError(client, "Failed to send stop command: "+err.Error())
-		return
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), pm5.DefaultControlTimeout)
+	defer cancel()
 
-	if !resp.Success {
-		sendError(client, "Failed to stop workout: "+resp.Error.Error())
+	if err := manager.StopWorkout(ctx); err != nil {
+		sendError(client, "Failed to stop workout: "+err.Error())
 		return
 	}
 
@@ -132,18 +270,64 @@ func handleStopWorkout(manager *pm5.Manager, client *broadcast.Client) {
 
 // handleGetStatus processes a get_status request
 func handleGetStatus(manager *pm5.Manager, client *broadcast.Client) {
-	resp, err := manager.SendControl("get_status", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), pm5.DefaultControlTimeout)
+	defer cancel()
+
+	info, err := manager.Status(ctx)
 	if err != nil {
 		sendError(client, "Failed to get status: "+err.Error())
 		return
 	}
 
-	if !resp.Success {
-		sendError(client, "Failed to get status")
+	sendStatus(client, info)
+}
+
+// handleListSessions processes a list_sessions request
+func handleListSessions(manager *pm5.Manager, client *broadcast.Client) {
+	sessions, err := manager.Sessions()
+	if err != nil {
+		sendError(client, "Failed to list sessions: "+err.Error())
+		return
+	}
+
+	msg := map[string]interface{}{
+		"type": "sessions",
+		"data": sessions,
+	}
+
+	data, _ := json.Marshal(msg)
+	client.Send(data)
+}
+
+// handleSetZones processes a set_zones request. data holds the zones
+// returned by the API; without any zones the current ones are cleared.
+func handleSetZones(manager *pm5.Manager, client *broadcast.Client, data map[string]interface{}) {
+	_, hasHeartRate := data["heart_rate"]
+	_, hasPower := data["power"]
+	if !hasHeartRate && !hasPower {
+		manager.SetZones(nil)
+		sendSuccess(client, "set_zones", "Zones cleared")
+		return
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		sendError(client, "Invalid zones")
+		return
+	}
+
+	var zones pm5.Zones
+	if err := json.Unmarshal(raw, &zones); err != nil {
+		sendError(client, "Invalid zones: "+err.Error())
 		return
 	}
 
-	sendStatus(client, resp.Data)
+	if err := manager.SetZones(&zones); err != nil {
+		sendError(client, "Invalid zones: "+err.Error())
+		return
+	}
+
+	sendSuccess(client, "set_zones", "Zones set")
 }
 
 // sendError sends an error message to a client
@@ -174,6 +358,21 @@ func sendSuccess(client *broadcast.Client, action, message string) {
 	client.Send(data)
 }
 
+// sendMessage sends a message of a type to a client
+func sendMessage(client *broadcast.Client, messageType string, data interface{}) {
+	msg := map[string]interface{}{
+		"type": messageType,
+		"data": data,
+	}
+
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to marshal message: %v", err)
+		return
+	}
+	client.Send(encoded)
+}
+
 // sendStatus sends a status response to a client
 func sendStatus(client *broadcast.Client, statusData interface{}) {
 	msg := map[string]interface{}{
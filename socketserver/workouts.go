@@ -0,0 +1,183 @@
+package socketserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/danhigham/ergometer.live/apiclient"
+	"github.com/danhigham/ergometer.live/broadcast"
+	"github.com/danhigham/ergometer.live/pm5"
+)
+
+// handleSaveWorkout saves a recorded session to the REST API as the
+// signed-in user, then announces any personal bests it set to every
+// client
+func handleSaveWorkout(api *apiclient.Client, manager *pm5.Manager, client *broadcast.Client, data map[string]interface{}) {
+	if api == nil {
+		sendError(client, "No API is configured for saving workouts")
+		return
+	}
+
+	token, _ := data["token"].(string)
+	if token == "" {
+		sendError(client, "token is required")
+		return
+	}
+
+	sessionID, _ := data["session_id"].(string)
+	if sessionID == "" {
+		sessionID = "last"
+	}
+
+	session, err := manager.Session(sessionID)
+	if err != nil {
+		sendError(client, "Failed to load session: "+err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pm5.DefaultControlTimeout)
+	defer cancel()
+
+	saved, err := api.SaveWorkout(ctx, token, session)
+	if errors.Is(err, apiclient.ErrAlreadySaved) {
+		sendError(client, "Session "+session.ID+" has already been saved")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save session %s: %v", session.ID, err)
+		sendError(client, "Failed to save workout: "+err.Error())
+		return
+	}
+
+	log.Printf("Saved session %s as workout %s (%d personal bests)", session.ID, saved.Workout.ID, len(saved.PersonalBests))
+
+	manager.BroadcastJSON("workout_saved", map[string]string{
+		"session_id": session.ID,
+		"workout_id": saved.Workout.ID,
+	})
+	for _, record := range saved.PersonalBests {
+		manager.BroadcastJSON("personal_best", record)
+	}
+}
+
+// handleSetWeight sets the rower's weight for watts per kilogram, either
+// given in kilograms as weight or read from the profile of the user
+// identified by token. A weight of 0 clears it.
+func handleSetWeight(api *apiclient.Client, manager *pm5.Manager, client *broadcast.Client, data map[string]interface{}) {
+	weight, hasWeight := data["weight"].(float64)
+	token, _ := data["token"].(string)
+
+	if !hasWeight {
+		if api == nil || token == "" {
+			sendError(client, "weight or token is required, and token needs an API to be configured")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), pm5.DefaultControlTimeout)
+		defer cancel()
+
+		profile, err := api.Profile(ctx, token)
+		if err != nil {
+			sendError(client, "Failed to fetch profile: "+err.Error())
+			return
+		}
+		if profile.Weight == 0 {
+			sendError(client, "Profile has no weight")
+			return
+		}
+		weight = profile.Weight
+	}
+
+	if err := manager.SetWeight(weight); err != nil {
+		sendError(client, "Invalid weight: "+err.Error())
+		return
+	}
+
+	if weight == 0 {
+		sendSuccess(client, "set_weight", "Weight cleared")
+		return
+	}
+	sendSuccess(client, "set_weight", fmt.Sprintf("Weight set to %g kg", weight))
+}
+
+// handleStartTemplate fetches one of the signed-in user's workout
+// templates, or one shared with a team given by team_id, from the REST API
+// and starts it
+func handleStartTemplate(api *apiclient.Client, manager *pm5.Manager, client *broadcast.Client, data map[string]interface{}) {
+	if api == nil {
+		sendError(client, "No API is configured for workout templates")
+		return
+	}
+
+	token, _ := data["token"].(string)
+	templateID, _ := data["template_id"].(string)
+	teamID, _ := data["team_id"].(string)
+	if token == "" || templateID == "" {
+		sendError(client, "token and template_id are required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pm5.DefaultControlTimeout)
+	defer cancel()
+
+	template, err := api.Template(ctx, token, teamID, templateID)
+	if errors.Is(err, apiclient.ErrNotFound) {
+		sendError(client, "Template "+templateID+" not found")
+		return
+	}
+	if err != nil {
+		sendError(client, "Failed to fetch template: "+err.Error())
+		return
+	}
+
+	if err := manager.StartWorkout(ctx, &template.WorkoutParams); err != nil {
+		sendError(client, "Failed to start workout: "+err.Error())
+		return
+	}
+
+	log.Printf("Started template %q (%s)", template.Name, template.ID)
+	sendSuccess(client, "start_template", "Started "+template.Name)
+}
+
+// sendPlannedSessions sends the sessions the user's training plans have
+// due on date (today when empty) so the rower only has to press start.
+// On connect nothing is sent when no sessions are due or the API can't be
+// reached; an explicit request always gets a reply.
+func sendPlannedSessions(api *apiclient.Client, client *broadcast.Client, token, date string, requested bool) {
+	if api == nil || token == "" {
+		if requested {
+			sendError(client, "token is required and an API must be configured")
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pm5.DefaultControlTimeout)
+	defer cancel()
+
+	sessions, err := api.TodaysSessions(ctx, token, date)
+	if err != nil {
+		log.Printf("Failed to fetch planned sessions: %v", err)
+		if requested {
+			sendError(client, "Failed to fetch planned sessions: "+err.Error())
+		}
+		return
+	}
+	if len(sessions) == 0 && !requested {
+		return
+	}
+
+	msg, err := json.Marshal(map[string]interface{}{
+		"type":      "planned_session",
+		"data":      map[string]interface{}{"sessions": sessions},
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("Failed to marshal planned sessions: %v", err)
+		return
+	}
+	client.Send(msg)
+}